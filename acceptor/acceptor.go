@@ -2,8 +2,12 @@ package acceptor
 
 import (
     "fmt"
+    "sync"
+    "time"
+    "github/paxoscluster/guard"
     "github/paxoscluster/proposal"
     "github/paxoscluster/replicatedlog"
+    "github/paxoscluster/trace"
 )
 
 /*
@@ -12,49 +16,116 @@ import (
 type AcceptorRole struct {
     roleId uint64
     log *replicatedlog.Log
+    tracer *trace.Recorder
+    progress func(uint64, int)
+    owners *proposal.Owners
+    revocations map[uint64]int
+    store RevocationStore
+    claimed func(uint64, int)
+    admission func() error
+    exclude sync.Mutex
 }
 
 // Constructor for AcceptorRole
 func Construct(roleId uint64, log *replicatedlog.Log) *AcceptorRole {
-    this := AcceptorRole{roleId, log}
-    return &this
+    newAcceptorRole := AcceptorRole {
+        roleId: roleId,
+        log: log,
+    }
+    return &newAcceptorRole
+}
+
+// Records every message this acceptor handles; must be set before the acceptor is served
+func (this *AcceptorRole) SetTracer(tracer *trace.Recorder) {
+    this.tracer = tracer
+}
+
+// Calls progress with the proposer's roleId and first unchosen index on every accept request,
+// so the node can tell how far behind it is; must be set before the acceptor is served
+func (this *AcceptorRole) SetProgress(progress func(uint64, int)) {
+    this.progress = progress
 }
 
-// Request sent out by proposer during prepare phase
+// Request sent out by proposer during prepare phase; Round identifies the broadcast and is
+// echoed in the reply. Timeout is how long the proposer waits for the reply, zero if unbounded;
+// the request is refused without a promise being recorded once it passes
 type PrepareReq struct {
     ProposalId proposal.Id
     Index int
+    Round uint64
+    Timeout time.Duration
 }
 
-// Response sent by acceptors during prepare phase
+// Response sent by acceptors during prepare phase; a refusal names the proposal promised instead
 type PrepareResp struct {
     PromiseAccepted bool
     AcceptedProposalId proposal.Id
-    AcceptedValue string
+    AcceptedValue []byte
     NoMoreAccepted bool
     RoleId uint64
+    Round uint64
+    PromisedId proposal.Id
+}
+
+func (this *AcceptorRole) Prepare(req *PrepareReq, reply *PrepareResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.Prepare", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.Prepare", req, reply, func() error { return this.prepare(req, reply) })
 }
 
-func (this *AcceptorRole) Prepare(req *PrepareReq, reply *PrepareResp) error {
+func (this *AcceptorRole) prepare(req *PrepareReq, reply *PrepareResp) error {
+    started := time.Now()
+    err := this.admit()
+    if err != nil { return err }
+    err = this.checkIndex(req.Index)
+    if err != nil { return err }
+    if this.log.IsCorrupt(req.Index) {
+        return fmt.Errorf("[ ACCEPTOR %d ] Entry %d is corrupt", this.roleId, req.Index)
+    }
+    if this.log.IsCompacted(req.Index) {
+        return fmt.Errorf("[ ACCEPTOR %d ] Entry %d is compacted", this.roleId, req.Index)
+    }
+
+    // In Mencius mode or with fast rounds, a prepare revokes the slot from proposals which
+    // skip the prepare phase before its entry is read
+    if this.revocations != nil {
+        this.exclude.Lock()
+        defer this.exclude.Unlock()
+        err := this.checkDeadline(started, req.Timeout, req.Index)
+        if err != nil { return err }
+        err = this.revoke(req.Index)
+        if err != nil { return err }
+    }
+
     minProposalId := this.log.GetMinProposalId()
     fmt.Println("[ ACCEPTOR", this.roleId, "] Prepare: considering proposal", req.ProposalId, 
                 "vs", minProposalId, "for index", req.Index)
-    logEntry := this.log.GetEntryAt(req.Index)
+    logEntry, err := this.log.GetEntryAt(req.Index)
+    if err != nil { return err }
     reply.PromiseAccepted = req.ProposalId.IsGreaterThan(minProposalId)
     reply.AcceptedProposalId = logEntry.AcceptedProposalId
     reply.AcceptedValue = logEntry.Value
     reply.NoMoreAccepted = this.log.NoMoreAcceptedPast(req.Index)
     reply.RoleId = this.roleId
+    reply.Round = req.Round
+    if !reply.PromiseAccepted {
+        reply.PromisedId = minProposalId
+    }
+    err = this.checkDeadline(started, req.Timeout, req.Index)
+    if err != nil { return err }
     this.log.UpdateMinProposalId(req.ProposalId)
     return nil
 }
 
-// Request sent out by proposer during proposal phase
+// Request sent out by proposer during proposal phase; Round identifies the broadcast and is
+// echoed in the reply. Timeout is how long the proposer waits for the reply, zero if unbounded;
+// the request is refused without the value being recorded once it passes
 type ProposalReq struct {
     ProposalId proposal.Id
     Index int
-    Value string
+    Value []byte
     FirstUnchosenIndex int
+    Round uint64
+    Timeout time.Duration
 }
 
 // Response sent by acceptors during proposal phase
@@ -62,11 +133,31 @@ type ProposalResp struct {
     AcceptedId proposal.Id
     RoleId uint64
     FirstUnchosenIndex int
+    Round uint64
+}
+
+func (this *AcceptorRole) Accept(proposal *ProposalReq, reply *ProposalResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.Accept", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.Accept", proposal, reply, func() error { return this.accept(proposal, reply) })
 }
 
-func (this *AcceptorRole) Accept(proposal *ProposalReq, reply *ProposalResp) error {
+func (this *AcceptorRole) accept(proposal *ProposalReq, reply *ProposalResp) error {
+    started := time.Now()
+    err := this.admit()
+    if err != nil { return err }
+    err = this.checkIndex(proposal.Index)
+    if err != nil { return err }
+    if this.log.IsCorrupt(proposal.Index) {
+        return fmt.Errorf("[ ACCEPTOR %d ] Entry %d is corrupt", this.roleId, proposal.Index)
+    }
+    if this.log.IsCompacted(proposal.Index) {
+        return fmt.Errorf("[ ACCEPTOR %d ] Entry %d is compacted", this.roleId, proposal.Index)
+    }
+
     fmt.Println("[ ACCEPTOR", this.roleId, "] Proposal: considering proposal", proposal.ProposalId,
-                "of", proposal.Value, "for index", proposal.Index)
+                "of", string(proposal.Value), "for index", proposal.Index)
+    err = this.checkDeadline(started, proposal.Timeout, proposal.Index)
+    if err != nil { return err }
     this.log.MarkAsAccepted(proposal.ProposalId, proposal.FirstUnchosenIndex)
     minProposalId := this.log.GetMinProposalId()
     if proposal.ProposalId.IsGreaterThan(minProposalId) || proposal.ProposalId == minProposalId {
@@ -75,17 +166,143 @@ func (this *AcceptorRole) Accept(proposal *ProposalReq, reply *ProposalResp) err
     reply.AcceptedId = minProposalId
     reply.RoleId = this.roleId
     reply.FirstUnchosenIndex = this.log.GetFirstUnchosenIndex()
+    reply.Round = proposal.Round
+    if this.progress != nil {
+        this.progress(proposal.ProposalId.RoleId, proposal.FirstUnchosenIndex)
+    }
     return nil
 }
 
+// Notification of a chosen value, sent to nodes missing it
 type SuccessNotify struct {
     Index int
-    Value string
+    Value []byte
+    Checksum uint32
+}
+
+func (this *AcceptorRole) Success(info *SuccessNotify, reply *int) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.Success", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.Success", info, reply, func() error { return this.success(info, reply) })
 }
 
-func (this *AcceptorRole) Success(info *SuccessNotify, reply *int) error {
-    fmt.Println("[ ACCEPTOR", this.roleId, "] Success: marking", info.Index, "as", info.Value)
+func (this *AcceptorRole) success(info *SuccessNotify, reply *int) error {
+    err := this.checkIndex(info.Index)
+    if err != nil { return err }
+    if replicatedlog.Checksum(info.Value) != info.Checksum {
+        return fmt.Errorf("[ ACCEPTOR %d ] Checksum mismatch for entry %d", this.roleId, info.Index)
+    }
+
+    fmt.Println("[ ACCEPTOR", this.roleId, "] Success: marking", info.Index, "as", string(info.Value))
     this.log.SetEntryAt(info.Index, info.Value, proposal.Chosen())
+    if this.claimed != nil {
+        this.claimed(this.owners.Owner(info.Index), info.Index)
+    }
+    *reply = this.log.GetFirstUnchosenIndex()
+    return nil
+}
+
+// Notification of chosen values for the contiguous entries starting at Start
+type SuccessBatchNotify struct {
+    Start int
+    Values [][]byte
+    Checksums []uint32
+}
+
+func (this *AcceptorRole) SuccessBatch(info *SuccessBatchNotify, reply *int) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.SuccessBatch", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.SuccessBatch", info, reply, func() error { return this.successBatch(info, reply) })
+}
+
+func (this *AcceptorRole) successBatch(info *SuccessBatchNotify, reply *int) error {
+    if len(info.Values) != len(info.Checksums) {
+        return fmt.Errorf("[ ACCEPTOR %d ] Batch of %d values carries %d checksums", this.roleId, len(info.Values), len(info.Checksums))
+    }
+    err := this.checkIndex(info.Start)
+    if err == nil {
+        err = this.checkIndex(info.Start+len(info.Values))
+    }
+    if err != nil { return err }
+    for offset, value := range info.Values {
+        if replicatedlog.Checksum(value) != info.Checksums[offset] {
+            return fmt.Errorf("[ ACCEPTOR %d ] Checksum mismatch for entry %d", this.roleId, info.Start+offset)
+        }
+    }
+
+    fmt.Println("[ ACCEPTOR", this.roleId, "] Success: marking", len(info.Values), "entries from", info.Start)
+    for offset, value := range info.Values {
+        this.log.SetEntryAt(info.Start+offset, value, proposal.Chosen())
+    }
     *reply = this.log.GetFirstUnchosenIndex()
     return nil
 }
+
+// Request for up to Max chosen entries starting at From
+type FetchEntriesReq struct {
+    From int
+    Max int
+}
+
+// Contiguous chosen entries starting at Start, which may be fewer than requested; empty if
+// the entry at From is not chosen on this node
+type FetchEntriesResp struct {
+    Start int
+    Values [][]byte
+    Checksums []uint32
+}
+
+func (this *AcceptorRole) FetchEntries(req *FetchEntriesReq, reply *FetchEntriesResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.FetchEntries", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.FetchEntries", req, reply, func() error { return this.fetchEntries(req, reply) })
+}
+
+func (this *AcceptorRole) fetchEntries(req *FetchEntriesReq, reply *FetchEntriesResp) error {
+    if req.From < 0 || req.Max <= 0 {
+        return fmt.Errorf("[ ACCEPTOR %d ] Invalid fetch of %d entries from %d", this.roleId, req.Max, req.From)
+    }
+
+    reply.Start = req.From
+    to := this.log.GetFirstUnchosenIndex()
+    if to > req.From+req.Max {
+        to = req.From+req.Max
+    }
+    if to <= req.From {
+        return nil
+    }
+    entries, err := this.log.ReadEntries(req.From, to, true)
+    if err != nil { return err }
+    for _, entry := range entries {
+        reply.Values = append(reply.Values, entry.Value)
+        reply.Checksums = append(reply.Checksums, replicatedlog.Checksum(entry.Value))
+    }
+    return nil
+}
+
+// Request for a chosen log entry, used to replace a corrupt copy
+type FetchReq struct {
+    Index int
+}
+
+// Chosen log entry; Chosen is false if this node does not know the chosen value
+type FetchResp struct {
+    Chosen bool
+    Value []byte
+    Checksum uint32
+}
+
+func (this *AcceptorRole) Fetch(req *FetchReq, reply *FetchResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.Fetch", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.Fetch", req, reply, func() error { return this.fetch(req, reply) })
+}
+
+func (this *AcceptorRole) fetch(req *FetchReq, reply *FetchResp) error {
+    err := this.checkIndex(req.Index)
+    if err != nil { return err }
+    logEntry, err := this.log.GetEntryAt(req.Index)
+    if err != nil { return err }
+    reply.Chosen = logEntry.AcceptedProposalId == proposal.Chosen() && !this.log.IsCorrupt(req.Index) && !this.log.IsCompacted(req.Index)
+    if reply.Chosen {
+        reply.Value = logEntry.Value
+        reply.Checksum = replicatedlog.Checksum(logEntry.Value)
+    }
+    return nil
+}
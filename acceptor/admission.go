@@ -0,0 +1,61 @@
+package acceptor
+
+import (
+    "fmt"
+    "time"
+    "strings"
+)
+
+// Marks the wait within a retry-after error message
+const retryAfterMarker = "; retry after "
+
+// Refusal of a prepare or accept request by an acceptor shedding load, as more updates were
+// waiting on its storage than it admits. Nothing was recorded; the proposer should not retry
+// before After. The wait survives transmission as an RPC error string; see ParseRetryAfter
+type RetryAfterError struct {
+    RoleId uint64
+    Pending int64
+    After time.Duration
+}
+
+func (this *RetryAfterError) Error() string {
+    return fmt.Sprintf("[ ACCEPTOR %d ] Failure: overloaded with %d pending updates%s%v", this.RoleId, this.Pending, retryAfterMarker, this.After)
+}
+
+// Extracts the wait asked for by an acceptor shedding load from the error it returned
+func ParseRetryAfter(err error) (time.Duration, bool) {
+    if err == nil { return 0, false }
+    message := err.Error()
+    separator := strings.Index(message, retryAfterMarker)
+    if separator < 0 { return 0, false }
+    after, parseErr := time.ParseDuration(message[separator+len(retryAfterMarker):])
+    if parseErr != nil { return 0, false }
+    return after, true
+}
+
+// Reports whether an error, possibly received over RPC, refused a request to shed load
+func IsRetryAfter(err error) bool {
+    _, shed := ParseRetryAfter(err)
+    return shed
+}
+
+// Sheds load once more than limit updates are pending on storage, asking proposers to wait
+// for the smoothed latency of an update before retrying; must be set before the acceptor is served
+func (this *AcceptorRole) SetAdmission(limit uint64, pending func() int64, health func() (time.Duration, bool)) {
+    this.admission = func() error {
+        queued := pending()
+        if queued <= int64(limit) { return nil }
+        after, _ := health()
+        if after < time.Millisecond {
+            after = time.Millisecond
+        }
+        acceptorStats.Add("shed", 1)
+        return &RetryAfterError{this.roleId, queued, after}
+    }
+}
+
+// Refuses a request while shedding load
+func (this *AcceptorRole) admit() error {
+    if this.admission == nil { return nil }
+    return this.admission()
+}
@@ -0,0 +1,19 @@
+package acceptor
+
+import (
+    "fmt"
+)
+
+// Furthest past the end of its log an acceptor takes an entry; the log is extended to hold
+// every entry before it, so a request far past any real proposal, from a faulty peer, could
+// otherwise exhaust memory. A node lagging further behind catches up before accepting
+const maxIndexGap = 1 << 20
+
+// Fails a request for a negative entry, or one too far past the end of the log
+func (this *AcceptorRole) checkIndex(index int) error {
+    if index < 0 || index > this.log.GetLength()+maxIndexGap {
+        acceptorStats.Add("invalidIndex", 1)
+        return fmt.Errorf("[ ACCEPTOR %d ] Invalid entry %d", this.roleId, index)
+    }
+    return nil
+}
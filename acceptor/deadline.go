@@ -0,0 +1,28 @@
+package acceptor
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "strings"
+    "github/paxoscluster/metrics"
+)
+
+var acceptorStats = metrics.Group("acceptor")
+
+// Rejection of a request the acceptor reached only after its proposer stopped waiting for the
+// reply; nothing was recorded, sparing the storage writes
+var ErrExpired = errors.New("Failure: request deadline passed")
+
+// Fails a request whose timeout, counted from when its handler started, has passed; a zero
+// timeout never passes. The proposer's own wait also spans the network, so the check is lenient
+func (this *AcceptorRole) checkDeadline(started time.Time, timeout time.Duration, index int) error {
+    if timeout <= 0 || time.Since(started) < timeout { return nil }
+    acceptorStats.Add("expired", 1)
+    return fmt.Errorf("[ ACCEPTOR %d ] %v for entry %d", this.roleId, ErrExpired, index)
+}
+
+// Reports whether an error, possibly received over RPC, rejected an expired request
+func IsExpired(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrExpired.Error())
+}
@@ -0,0 +1,67 @@
+package acceptor
+
+import (
+    "fmt"
+    "bytes"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/proposal"
+)
+
+// Enables fast rounds: any proposer may have a value accepted in an unused slot without a
+// prepare phase, until a prepare for the slot revokes fast rounds there and in every earlier
+// slot. Revocations recovered from the store are passed in; must be set before the acceptor
+// is served
+func (this *AcceptorRole) SetFastRounds(revocations map[uint64]int, store RevocationStore) {
+    this.revocations = revocations
+    this.store = store
+}
+
+// Request sent by a proposer in a fast round; Round identifies the broadcast and is echoed
+// in the reply
+type FastReq struct {
+    Index int
+    Value []byte
+    Round uint64
+}
+
+// Response to a fast round; the value is refused if the slot was revoked or already holds
+// another value
+type FastResp struct {
+    Accepted bool
+    RoleId uint64
+    Round uint64
+}
+
+func (this *AcceptorRole) AcceptFast(req *FastReq, reply *FastResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.AcceptFast", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.AcceptFast", req, reply, func() error { return this.acceptFast(req, reply) })
+}
+
+func (this *AcceptorRole) acceptFast(req *FastReq, reply *FastResp) error {
+    if this.revocations == nil || this.owners != nil {
+        return fmt.Errorf("[ ACCEPTOR %d ] Fast rounds are disabled", this.roleId)
+    }
+    err := this.checkIndex(req.Index)
+    if err != nil { return err }
+
+    // Revocations are checked under the same lock as they are recorded by prepare
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    fastId := proposal.FastId()
+    revokedThrough, revoked := this.revocations[fastId.RoleId]
+    logEntry, err := this.log.GetEntryAt(req.Index)
+    if err != nil { return err }
+    switch {
+    case revoked && req.Index <= revokedThrough:
+    case this.log.IsCorrupt(req.Index):
+    case logEntry.AcceptedProposalId == fastId && !bytes.Equal(logEntry.Value, req.Value):
+        // An acceptor accepts at most one value per slot in a fast round
+    default:
+        reply.Accepted = this.log.SetOwnedEntryAt(req.Index, req.Value, fastId)
+    }
+    fmt.Println("[ ACCEPTOR", this.roleId, "] Fast round: accepted", reply.Accepted, "for index", req.Index)
+    reply.RoleId = this.roleId
+    reply.Round = req.Round
+    return nil
+}
@@ -0,0 +1,111 @@
+package acceptor
+
+import (
+    "bytes"
+    "testing"
+    "encoding/gob"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Encodes requests as FuzzRequest reads them, after the byte picking the acceptor's mode
+func fuzzInput(mode byte, requests ...interface{}) []byte {
+    var buffer bytes.Buffer
+    buffer.WriteByte(mode)
+    encoder := gob.NewEncoder(&buffer)
+    for _, request := range requests {
+        kind := uint8(0)
+        switch request.(type) {
+        case *ProposalReq: kind = 1
+        case *SuccessNotify: kind = 2
+        case *SuccessBatchNotify: kind = 3
+        case *FetchEntriesReq: kind = 4
+        case *FetchReq: kind = 5
+        case *OwnedReq: kind = 6
+        case *FastReq: kind = 7
+        }
+        encoder.Encode(kind)
+        encoder.Encode(request)
+    }
+    return buffer.Bytes()
+}
+
+// The first byte picks plain, Mencius, or fast round mode; the rest is a gob stream of request
+// kinds, each followed by its request, which are served in turn by a fresh acceptor. Handlers
+// are called beneath their panic guards, so any panic is a failure
+func FuzzRequest(f *testing.F) {
+    id := proposal.Id{RoleId: 2, Sequence: 1<<proposal.RoleIdBits | 2}
+    value := []byte("a")
+    f.Add(fuzzInput(0,
+        &PrepareReq{ProposalId: id, Index: 0},
+        &ProposalReq{ProposalId: id, Index: 0, Value: value},
+        &SuccessNotify{Index: 0, Value: value, Checksum: replicatedlog.Checksum(value)},
+        &FetchEntriesReq{},
+    ))
+    f.Add(fuzzInput(1, &OwnedReq{RoleId: 2, Entries: []OwnedEntry{{Index: 1, Value: value}}}, &PrepareReq{ProposalId: id, Index: 1}))
+    f.Add(fuzzInput(2, &FastReq{Index: 0, Value: value}, &PrepareReq{ProposalId: id, Index: 0}, &FastReq{Index: 0, Value: value}))
+
+    storage := recovery.ConstructMemoryStorage()
+    disk, err := recovery.ConstructManagerWithStorage(".", storage)
+    if err != nil { f.Fatal(err) }
+    f.Fuzz(func(t *testing.T, data []byte) {
+        if len(data) == 0 { return }
+        storage.Reset()
+        disk.Invalidate()
+        state, err := disk.Recover(1)
+        if err != nil { t.Fatal(err) }
+        log := replicatedlog.ConstructLog(1, state, disk, nil)
+        log.EnableInvariants()
+        acceptorRole := Construct(1, log)
+        switch data[0] % 3 {
+        case 1:
+            acceptorRole.SetOwnership(proposal.ConstructOwners([]uint64{1, 2, 3}), state.Revocations, disk)
+        case 2:
+            acceptorRole.SetFastRounds(state.Revocations, disk)
+        }
+
+        decoder := gob.NewDecoder(bytes.NewReader(data[1:]))
+        for {
+            var kind uint8
+            if decoder.Decode(&kind) != nil { break }
+            var err error
+            var index int
+            switch kind % 8 {
+            case 0:
+                var req PrepareReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.prepare(&req, &PrepareResp{}) }
+            case 1:
+                var req ProposalReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.accept(&req, &ProposalResp{}) }
+            case 2:
+                var req SuccessNotify
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.success(&req, &index) }
+            case 3:
+                var req SuccessBatchNotify
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.successBatch(&req, &index) }
+            case 4:
+                var req FetchEntriesReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.fetchEntries(&req, &FetchEntriesResp{}) }
+            case 5:
+                var req FetchReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.fetch(&req, &FetchResp{}) }
+            case 6:
+                var req OwnedReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.acceptOwned(&req, &OwnedResp{}) }
+            case 7:
+                var req FastReq
+                err = decoder.Decode(&req)
+                if err == nil { acceptorRole.acceptFast(&req, &FastResp{}) }
+            }
+            if err != nil { break }
+        }
+    })
+}
@@ -0,0 +1,128 @@
+package acceptor
+
+import (
+    "fmt"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/proposal"
+)
+
+// Durable record of the Mencius slots revoked from each owner
+type RevocationStore interface {
+    UpdateRevocations(roleId uint64, revocations map[uint64]int) error
+}
+
+// Enables Mencius mode: each owner may propose in its own slots without a prepare phase until
+// a prepare for one of them revokes it and every earlier slot of the same owner. Revocations
+// recovered from the store are passed in; must be set before the acceptor is served
+func (this *AcceptorRole) SetOwnership(owners *proposal.Owners, revocations map[uint64]int, store RevocationStore) {
+    this.owners = owners
+    this.revocations = revocations
+    this.store = store
+}
+
+// Calls claimed with the owner and index of every owned slot accepted or learned chosen, so the
+// node can skip its own slots which others have passed; must be set before the acceptor is served
+func (this *AcceptorRole) SetClaims(claimed func(uint64, int)) {
+    this.claimed = claimed
+}
+
+// Value proposed by the owner of a slot
+type OwnedEntry struct {
+    Index int
+    Value []byte
+}
+
+// Request sent by an owner proposing in its own slots; Round identifies the broadcast and is
+// echoed in the reply
+type OwnedReq struct {
+    RoleId uint64
+    Entries []OwnedEntry
+    Round uint64
+}
+
+// Response to an owned proposal; an entry is refused if its slot was revoked or already holds
+// another value, and RevokedThrough is the owner's highest revoked slot, or -1 if none
+type OwnedResp struct {
+    Accepted []bool
+    RevokedThrough int
+    RoleId uint64
+    Round uint64
+}
+
+// Reports whether all count entries of the request were accepted
+func (this *OwnedResp) AcceptedAll(count int) bool {
+    if len(this.Accepted) != count { return false }
+    for _, accepted := range this.Accepted {
+        if !accepted { return false }
+    }
+    return true
+}
+
+func (this *AcceptorRole) AcceptOwned(req *OwnedReq, reply *OwnedResp) (err error) {
+    defer guard.Recover("ACCEPTOR", this.roleId, "AcceptorRole.AcceptOwned", &err)
+    return this.tracer.Trace(this.roleId, "AcceptorRole.AcceptOwned", req, reply, func() error { return this.acceptOwned(req, reply) })
+}
+
+func (this *AcceptorRole) acceptOwned(req *OwnedReq, reply *OwnedResp) error {
+    if this.owners == nil {
+        return fmt.Errorf("[ ACCEPTOR %d ] Not in Mencius mode", this.roleId)
+    }
+    for _, entry := range req.Entries {
+        err := this.checkIndex(entry.Index)
+        if err != nil { return err }
+        if this.owners.Owner(entry.Index) != req.RoleId {
+            return fmt.Errorf("[ ACCEPTOR %d ] Role %d does not own entry %d", this.roleId, req.RoleId, entry.Index)
+        }
+    }
+
+    // Revocations are checked under the same lock as they are recorded by prepare
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    revokedThrough, revoked := this.revocations[req.RoleId]
+    if !revoked {
+        revokedThrough = -1
+    }
+    ownedId := proposal.OwnedId(req.RoleId)
+    reply.Accepted = make([]bool, len(req.Entries))
+    for offset, entry := range req.Entries {
+        if entry.Index <= revokedThrough || this.log.IsCorrupt(entry.Index) {
+            continue
+        }
+        reply.Accepted[offset] = this.log.SetOwnedEntryAt(entry.Index, entry.Value, ownedId)
+        if reply.Accepted[offset] && this.claimed != nil {
+            this.claimed(req.RoleId, entry.Index)
+        }
+    }
+    fmt.Println("[ ACCEPTOR", this.roleId, "] Owned proposal: role", req.RoleId, "accepted", reply.Accepted)
+    reply.RevokedThrough = revokedThrough
+    reply.RoleId = this.roleId
+    reply.Round = req.Round
+    return nil
+}
+
+// Revokes the owner's slots up to and including index ahead of a prepare for it, so the owner
+// cannot have its value accepted there once this acceptor has replied; in fast rounds, the
+// slots are revoked from every proposer. exclude MUST be locked
+func (this *AcceptorRole) revoke(index int) error {
+    owner := proposal.FastId().RoleId
+    if this.owners != nil {
+        owner = this.owners.Owner(index)
+    }
+    revokedThrough, revoked := this.revocations[owner]
+    if revoked && revokedThrough >= index {
+        return nil
+    }
+
+    this.revocations[owner] = index
+    err := this.store.UpdateRevocations(this.roleId, this.revocations)
+    if err != nil {
+        this.revocations[owner] = revokedThrough
+        if !revoked {
+            delete(this.revocations, owner)
+        }
+        return err
+    }
+    fmt.Println("[ ACCEPTOR", this.roleId, "] Revoked slots of role", owner, "through", index)
+    return nil
+}
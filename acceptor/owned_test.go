@@ -0,0 +1,117 @@
+package acceptor
+
+import (
+    "sync"
+    "testing"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Starts an acceptor in Mencius mode among roles 1 to 3, over durable state kept in memory
+func constructOwnedAcceptor(t *testing.T) (*AcceptorRole, *[][2]int) {
+    disk, err := recovery.ConstructManagerWithStorage(".", recovery.ConstructMemoryStorage())
+    if err != nil { t.Fatal(err) }
+    state, err := disk.Recover(1)
+    if err != nil { t.Fatal(err) }
+    acceptorRole := Construct(1, replicatedlog.ConstructLog(1, state, disk, nil))
+    acceptorRole.SetOwnership(proposal.ConstructOwners([]uint64{1, 2, 3}), state.Revocations, disk)
+    claims := make([][2]int, 0)
+    acceptorRole.SetClaims(func(owner uint64, index int) { claims = append(claims, [2]int{int(owner), index}) })
+    return acceptorRole, &claims
+}
+
+func acceptOwnedAt(t *testing.T, acceptorRole *AcceptorRole, owner uint64, index int, value string) OwnedResp {
+    var reply OwnedResp
+    err := acceptorRole.AcceptOwned(&OwnedReq{RoleId: owner, Entries: []OwnedEntry{{Index: index, Value: []byte(value)}}}, &reply)
+    if err != nil { t.Fatal(err) }
+    return reply
+}
+
+func prepareAt(t *testing.T, acceptorRole *AcceptorRole, sequence int64, index int) PrepareResp {
+    var reply PrepareResp
+    err := acceptorRole.Prepare(&PrepareReq{ProposalId: proposal.Id{RoleId: 3, Sequence: sequence}, Index: index}, &reply)
+    if err != nil { t.Fatal(err) }
+    return reply
+}
+
+// An owner's slot is claimed only if its value was accepted there
+func TestAcceptOwnedClaimsAccepted(t *testing.T) {
+    acceptorRole, claims := constructOwnedAcceptor(t)
+
+    reply := acceptOwnedAt(t, acceptorRole, 2, 1, "a")
+    if !reply.AcceptedAll(1) || reply.RevokedThrough != -1 || len(*claims) != 1 || (*claims)[0] != [2]int{2, 1} {
+        t.Errorf("Owner's value accepted %v, revoked through %d, claims %v", reply.Accepted, reply.RevokedThrough, *claims)
+    }
+
+    var count int
+    err := acceptorRole.Success(&SuccessNotify{Index: 4, Value: []byte("b"), Checksum: replicatedlog.Checksum([]byte("b"))}, &count)
+    if err != nil { t.Fatal(err) }
+    *claims = (*claims)[:0]
+    reply = acceptOwnedAt(t, acceptorRole, 2, 4, "c")
+    if reply.AcceptedAll(1) || len(*claims) != 0 {
+        t.Errorf("Owner's value accepted %v over a chosen value, claims %v", reply.Accepted, *claims)
+    }
+
+    var refused OwnedResp
+    err = acceptorRole.AcceptOwned(&OwnedReq{RoleId: 2, Entries: []OwnedEntry{{Index: 2, Value: []byte("d")}}}, &refused)
+    if err == nil {
+        t.Errorf("Role 2 proposed in a slot of role 3")
+    }
+}
+
+// Once a prepare revokes an owner's slot, the owner's value is refused there and in each of
+// its earlier slots, but not in later ones
+func TestPrepareRevokesOwnedSlots(t *testing.T) {
+    acceptorRole, claims := constructOwnedAcceptor(t)
+
+    promise := prepareAt(t, acceptorRole, 10, 4)
+    if !promise.PromiseAccepted || promise.AcceptedProposalId != proposal.Default() {
+        t.Fatalf("Prepare of an unused slot promised %v with %v accepted", promise.PromiseAccepted, promise.AcceptedProposalId)
+    }
+    for _, index := range []int{1, 4} {
+        reply := acceptOwnedAt(t, acceptorRole, 2, index, "a")
+        if reply.AcceptedAll(1) || reply.RevokedThrough != 4 {
+            t.Errorf("Owner's value accepted %v in revoked slot %d, revoked through %d", reply.Accepted, index, reply.RevokedThrough)
+        }
+    }
+    if len(*claims) != 0 {
+        t.Errorf("Revoked slots were claimed: %v", *claims)
+    }
+    reply := acceptOwnedAt(t, acceptorRole, 2, 7, "a")
+    if !reply.AcceptedAll(1) {
+        t.Errorf("Owner's value refused in slot 7 after slot 4 was revoked")
+    }
+}
+
+// An owner's accept and a revoking prepare for the same slot race; whichever wins, the value
+// the owner had accepted is never hidden from the revoking proposer
+func TestRevocationRace(t *testing.T) {
+    acceptorRole, _ := constructOwnedAcceptor(t)
+
+    for round := 0; round < 100; round++ {
+        index := 3*round + 1
+        var owned OwnedResp
+        var promise PrepareResp
+        var racing sync.WaitGroup
+        racing.Add(2)
+        go func() {
+            defer racing.Done()
+            owned = acceptOwnedAt(t, acceptorRole, 2, index, "a")
+        }()
+        go func() {
+            defer racing.Done()
+            promise = prepareAt(t, acceptorRole, int64(10+round), index)
+        }()
+        racing.Wait()
+
+        accepted := owned.AcceptedAll(1)
+        seen := promise.AcceptedProposalId == proposal.OwnedId(2) && string(promise.AcceptedValue) == "a"
+        if accepted != seen {
+            t.Fatalf("Slot %d: owner's value accepted %v, but seen by the revoking prepare %v", index, accepted, seen)
+        }
+        if !accepted && owned.RevokedThrough < index {
+            t.Errorf("Slot %d: owner refused but revoked only through %d", index, owned.RevokedThrough)
+        }
+    }
+}
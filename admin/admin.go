@@ -0,0 +1,560 @@
+package admin
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "context"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/learner"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/replicatedlog"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/journal"
+)
+
+// Request carrying only a bearer token
+type TokenReq struct {
+    Token string
+}
+
+/*
+ * Client Role
+ */
+type ClientRole struct {
+    proposer *proposer.ProposerRole
+    log *replicatedlog.Log
+    authorizer *Authorizer
+    // Replicate requests in progress which carry a RequestId, by client and RequestId
+    pending map[string]*pendingRequest
+    exclude sync.Mutex
+}
+
+// A nil authorizer permits every request
+func ConstructClientRole(proposerRole *proposer.ProposerRole, log *replicatedlog.Log, authorizer *Authorizer) *ClientRole {
+    newClientRole := ClientRole {
+        proposer: proposerRole,
+        log: log,
+        authorizer: authorizer,
+        pending: make(map[string]*pendingRequest),
+    }
+    return &newClientRole
+}
+
+// Request to replicate a value; Priority defaults to interactive. ClientId and TraceId are
+// recorded with the value; an authenticated client is identified by its token holder instead.
+// Sequence numbers the client's commands from 1 so retries are applied at most once; zero
+// leaves the command unnumbered. A request with a RequestId may be cancelled by a Cancel
+// request naming it. A nonzero Timeout is the time left before the client stops waiting; the
+// request is abandoned then, and rejected at once if proposals have recently taken longer
+type ReplicateReq struct {
+    Token string
+    Value []byte
+    Priority proposer.Priority
+    Session Session
+    ClientId string
+    TraceId string
+    Sequence uint64
+    RequestId string
+    Timeout time.Duration
+}
+
+// Returns the metadata recorded with the value of a request made by holder
+func (this *ReplicateReq) metadata(holder string) hooks.Metadata {
+    clientId := this.ClientId
+    if len(holder) != 0 {
+        clientId = holder
+    }
+    return hooks.Metadata{ClientId: clientId, TraceId: this.TraceId, Sequence: this.Sequence}
+}
+
+// Session token for read-your-writes consistency, carrying the index after the last entry
+// written in the session. Reads in the session wait for a node to apply up to it; the zero
+// Session constrains nothing
+type Session struct {
+    Next int
+}
+
+// Returns the later of two sessions
+func (this Session) Merge(other Session) Session {
+    if other.Next > this.Next {
+        return other
+    }
+    return this
+}
+
+// Replicates a value as Replicate, returning the session advanced past the write
+func (this *ClientRole) ReplicateInSession(req *ReplicateReq, reply *Session) (err error) {
+    return this.ReplicateInSessionWithContext(context.Background(), req, reply)
+}
+
+// Replicates a value as ReplicateInSession until the context is cancelled; see
+// ProposerRole.ReplicateWithContext
+func (this *ClientRole) ReplicateInSessionWithContext(ctx context.Context, req *ReplicateReq, reply *Session) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.ReplicateInSession", &err)
+    holder, err := this.authorizer.Authorize(req.Token, PermissionPropose)
+    if err != nil { return err }
+    err = this.replicate(ctx, req, holder)
+    if err != nil { return err }
+
+    // Chosen values are committed in order, so the write lies within the commit index
+    *reply = req.Session.Merge(Session{this.log.GetCommitIndex()+1})
+    return nil
+}
+
+func (this *ClientRole) Replicate(req *ReplicateReq, reply *[]byte) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.Replicate", &err)
+    holder, err := this.authorizer.Authorize(req.Token, PermissionPropose)
+    if err != nil { return err }
+    *reply = req.Value
+    return this.replicate(context.Background(), req, holder)
+}
+
+// Replicated value in progress, cancelled by a Cancel request naming it
+type pendingRequest struct {
+    cancel context.CancelFunc
+}
+
+// Replicates the value of a request made by holder, tracking it for cancellation if it
+// carries a RequestId
+func (this *ClientRole) replicate(ctx context.Context, req *ReplicateReq, holder string) error {
+    err := this.proposer.CheckReady()
+    if err != nil { return err }
+    metadata := req.metadata(holder)
+    if req.Timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+        defer cancel()
+    }
+    if len(req.RequestId) != 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithCancel(ctx)
+        key := metadata.ClientId + "/" + req.RequestId
+        request := &pendingRequest{cancel}
+        this.exclude.Lock()
+        this.pending[key] = request
+        this.exclude.Unlock()
+        defer func() {
+            cancel()
+            this.exclude.Lock()
+            // A retry under the same RequestId may have replaced this request
+            if this.pending[key] == request {
+                delete(this.pending, key)
+            }
+            this.exclude.Unlock()
+        }()
+    }
+    return this.proposer.ReplicateWithContext(ctx, req.Value, req.Priority, metadata)
+}
+
+// Request to cancel a replicate request in progress; ClientId must match the request's, and
+// is taken from the token holder if authenticated
+type CancelReq struct {
+    Token string
+    ClientId string
+    RequestId string
+}
+
+// Cancels a replicate request in progress, best effort as ProposerRole.ReplicateWithContext
+// describes; the cancelled request fails with context.Canceled. Replies whether the request
+// was in progress
+func (this *ClientRole) Cancel(req *CancelReq, reply *bool) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.Cancel", &err)
+    holder, err := this.authorizer.Authorize(req.Token, PermissionPropose)
+    if err != nil { return err }
+    clientId := req.ClientId
+    if len(holder) != 0 {
+        clientId = holder
+    }
+
+    this.exclude.Lock()
+    request, pending := this.pending[clientId + "/" + req.RequestId]
+    this.exclude.Unlock()
+    if pending {
+        request.cancel()
+    }
+    *reply = pending
+    return nil
+}
+
+// Request to read committed entries; waits up to Wait for the entry at From to be committed.
+// A nonzero MaxStaleness lets a follower serve the read only if its state is staler than the
+// leader's by at most that bound; otherwise any node serves it from its own state. A node
+// serving a read in a Session first waits to apply the session's writes
+type ReadReq struct {
+    Token string
+    From int
+    Max int
+    Wait time.Duration
+    MaxStaleness time.Duration
+    Session Session
+}
+
+// Longest a read waits for a node to apply the writes of its session
+const sessionWait = time.Second
+
+// Returns up to Max committed entries starting at From; the reply is empty if none were
+// committed within the wait, allowing clients to tail the log by polling
+func (this *ClientRole) Read(req *ReadReq, reply *[]replicatedlog.CommittedEntry) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.Read", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionRead)
+    if err != nil { return err }
+    err = this.proposer.CheckReady()
+    if err != nil { return err }
+    if req.MaxStaleness > 0 {
+        err = this.proposer.CheckStaleness(req.MaxStaleness)
+        if err != nil { return err }
+    }
+    if req.Session.Next > 0 {
+        sessionCtx, sessionCancel := context.WithTimeout(context.Background(), sessionWait)
+        err = this.proposer.WaitForSession(sessionCtx, req.Session.Next)
+        sessionCancel()
+        if err != nil { return err }
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), req.Wait)
+    defer cancel()
+    if this.log.WaitForIndex(ctx, req.From) != nil {
+        *reply = nil
+        return nil
+    }
+
+    to := this.log.GetCommitIndex()+1
+    if req.Max > 0 && to > req.From+req.Max {
+        to = req.From+req.Max
+    }
+    entries, err := this.log.ReadEntries(req.From, to, true)
+    if err != nil { return err }
+
+    *reply = make([]replicatedlog.CommittedEntry, 0, len(entries))
+    for _, entry := range entries {
+        *reply = append(*reply, this.log.SplitEntry(entry))
+    }
+    return nil
+}
+
+// State of this node as seen by clients
+type StatusResp struct {
+    RoleId uint64
+    LeaderId uint64
+    LeaderAddress string
+    CommitIndex int
+    AppliedIndex int
+    Members map[uint64]string
+    // Members demoted to learners, which are counted in no quorum
+    Learners []uint64
+    // Smoothed latency of the node's storage updates, and whether its storage is degraded
+    StorageLatency time.Duration
+    StorageDegraded bool
+    // Whether the node is draining for shutdown, refusing client proposals
+    Draining bool
+    // Whether the node is in read-only mode, refusing client proposals but serving reads
+    ReadOnly bool
+    // Links between members found to carry traffic in one direction only
+    AsymmetricLinks []clusterpeers.AsymmetricLink
+    // Stage of the node since it started; it refuses proposals and reads until serving
+    State string
+}
+
+func (this *ClientRole) Status(req *TokenReq, reply *StatusResp) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.Status", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionRead)
+    if err != nil { return err }
+
+    reply.RoleId = this.proposer.GetRoleId()
+    reply.LeaderId, reply.LeaderAddress = this.proposer.GetLeader()
+    reply.CommitIndex = this.log.GetCommitIndex()
+    reply.AppliedIndex = this.log.GetAppliedIndex()
+    reply.Members = this.proposer.GetMembership()
+    reply.Learners = this.proposer.GetLearners()
+    reply.StorageLatency, reply.StorageDegraded = this.proposer.GetStorageHealth()
+    reply.Draining = this.proposer.IsDraining()
+    reply.ReadOnly = this.proposer.IsReadOnly()
+    reply.AsymmetricLinks = this.proposer.GetAsymmetricLinks()
+    reply.State = this.proposer.GetState().String()
+    return nil
+}
+
+/*
+ * Admin Role
+ */
+type AdminRole struct {
+    roleId uint64
+    proposer *proposer.ProposerRole
+    cluster *clusterpeers.Cluster
+    disk *recovery.Manager
+    streamer *learner.Streamer
+    authorizer *Authorizer
+    audit *AuditLog
+    journal *journal.Journal
+    reload func() error
+}
+
+func ConstructAdminRole(roleId uint64, proposerRole *proposer.ProposerRole, cluster *clusterpeers.Cluster,
+                        disk *recovery.Manager, streamer *learner.Streamer, authorizer *Authorizer, audit *AuditLog,
+                        events *journal.Journal) *AdminRole {
+    newAdminRole := AdminRole {
+        roleId: roleId,
+        proposer: proposerRole,
+        cluster: cluster,
+        disk: disk,
+        streamer: streamer,
+        authorizer: authorizer,
+        audit: audit,
+        journal: events,
+    }
+    return &newAdminRole
+}
+
+// Reloads the node's settings from their file; without it, Reload requests fail
+func (this *AdminRole) SetReload(reload func() error) {
+    this.reload = reload
+}
+
+// Reloads the tunables of the node from the file its settings were loaded from, as SIGHUP
+// does, without restarting it; fails without effect if any other setting changed there
+func (this *AdminRole) Reload(req *TokenReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.Reload", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMaintenance)
+    if err == nil && this.reload == nil {
+        err = fmt.Errorf("Role %d cannot reload its settings", this.roleId)
+    }
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "reloading settings")
+        err = this.reload()
+    }
+    this.audit.Record(name, "Reload", fmt.Sprintf("role %d", this.roleId), err)
+    *reply = err == nil
+    return err
+}
+
+// Request to change the address of a peer
+type UpdatePeerAddressReq struct {
+    Token string
+    RoleId uint64
+    Address string
+}
+
+func (this *AdminRole) UpdatePeerAddress(req *UpdatePeerAddressReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.UpdatePeerAddress", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMembership)
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "updating address of peer", req.RoleId, "to", req.Address)
+        err = this.cluster.UpdatePeerAddress(req.RoleId, req.Address)
+    }
+    this.audit.Record(name, "UpdatePeerAddress", fmt.Sprintf("role %d to %s", req.RoleId, req.Address), err)
+    *reply = err == nil
+    return err
+}
+
+// Re-seals this node's state files with the current encryption key
+func (this *AdminRole) RotateKeys(req *TokenReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.RotateKeys", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMaintenance)
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "rotating encryption keys")
+        err = this.disk.RotateKeys(this.roleId)
+    }
+    this.audit.Record(name, "RotateKeys", "", err)
+    *reply = err == nil
+    return err
+}
+
+// Stops the proposer role on this node
+func (this *AdminRole) Terminate(req *TokenReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.Terminate", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMaintenance)
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "terminating proposer")
+        terminate := true
+        err = this.proposer.Terminate(&terminate, reply)
+    }
+    this.audit.Record(name, "Terminate", "", err)
+    return err
+}
+
+// Request to force the membership down to the surviving nodes; Unsafe must be set to
+// acknowledge that chosen values may be lost
+type ForceReconfigureReq struct {
+    Token string
+    Survivors []uint64
+    Unsafe bool
+}
+
+// Rewrites this node's membership to the survivors, persisting it across restarts. Must be
+// invoked on every survivor. UNSAFE: only for clusters which have permanently lost a majority
+func (this *AdminRole) ForceReconfigure(req *ForceReconfigureReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.ForceReconfigure", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionUnsafeRecovery)
+    if err == nil && !req.Unsafe {
+        err = fmt.Errorf("Forced reconfiguration is unsafe and must be explicitly acknowledged")
+    }
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "] ******************************************************")
+        fmt.Println("[ ADMIN", this.roleId, "] WARNING:", name, "is forcing membership to", req.Survivors)
+        fmt.Println("[ ADMIN", this.roleId, "] WARNING: chosen values may be lost; do not restart removed nodes")
+        fmt.Println("[ ADMIN", this.roleId, "] ******************************************************")
+        // Persisted first, so a node restarting after the change cannot revert to the old membership
+        err = this.cluster.CheckSurvivors(req.Survivors)
+        if err == nil {
+            err = this.disk.UpdateMembership(this.roleId, req.Survivors)
+        }
+        if err == nil {
+            err = this.cluster.RestrictMembership(req.Survivors)
+        }
+    }
+    this.audit.Record(name, "ForceReconfigure", fmt.Sprintf("survivors %v", req.Survivors), err)
+    *reply = err == nil
+    return err
+}
+
+// Request to move this group to new members, each started as a learner beforehand; Wait
+// bounds the transfer of the log, and defaults to a minute
+type MigrateReq struct {
+    Token string
+    Members map[uint64]string
+    Wait time.Duration
+}
+
+// Streams the log to the new members and seals it, after which this group refuses client
+// values. Must be invoked on the leader
+func (this *AdminRole) Migrate(req *MigrateReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.Migrate", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMembership)
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "migrating group to", req.Members)
+        wait := req.Wait
+        if wait <= 0 {
+            wait = time.Minute
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), wait)
+        err = this.streamer.Migrate(ctx, req.Members)
+        cancel()
+    }
+    this.audit.Record(name, "Migrate", fmt.Sprintf("members %v", req.Members), err)
+    *reply = err == nil
+    return err
+}
+
+// Request to drain a node before it is stopped; RoleId must name the node serving the request.
+// Wait bounds the drain, and defaults to a minute
+type DrainReq struct {
+    Token string
+    RoleId uint64
+    Wait time.Duration
+}
+
+// Refuses further client proposals on this node, moves leadership away, and waits until the
+// node has finished its admitted proposals and applied every committed entry; replies true
+// once it is safe to stop the process
+func (this *AdminRole) Drain(req *DrainReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.Drain", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMaintenance)
+    if err == nil && req.RoleId != this.roleId {
+        err = fmt.Errorf("Drain of role %d must be requested of that node, not role %d", req.RoleId, this.roleId)
+    }
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "draining node")
+        wait := req.Wait
+        if wait <= 0 {
+            wait = time.Minute
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), wait)
+        err = this.proposer.Drain(ctx)
+        cancel()
+    }
+    this.audit.Record(name, "Drain", fmt.Sprintf("role %d", req.RoleId), err)
+    *reply = err == nil
+    return err
+}
+
+// Request to put a node into read-only mode, or take it out; RoleId must name the node
+// serving the request
+type ReadOnlyReq struct {
+    Token string
+    RoleId uint64
+    Enabled bool
+}
+
+// Takes write traffic off this node before maintenance, or restores it: while read-only the
+// node refuses client proposals with a hint naming the leader, moves leadership away, and
+// serves reads within their staleness bounds, remaining a voting member
+func (this *AdminRole) SetReadOnly(req *ReadOnlyReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.SetReadOnly", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMaintenance)
+    if err == nil && req.RoleId != this.roleId {
+        err = fmt.Errorf("Read-only mode of role %d must be set on that node, not role %d", req.RoleId, this.roleId)
+    }
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "setting read-only mode", req.Enabled)
+        this.proposer.SetReadOnly(req.Enabled)
+    }
+    this.audit.Record(name, "SetReadOnly", fmt.Sprintf("role %d enabled %t", req.RoleId, req.Enabled), err)
+    *reply = err == nil
+    return err
+}
+
+// Request to demote a member to a learner, or promote a learner back to a voter; Wait bounds
+// the change, and defaults to a minute
+type ChangeRoleReq struct {
+    Token string
+    RoleId uint64
+    Role clusterpeers.MemberRole
+    Wait time.Duration
+}
+
+// Changes the role of a member through the log, so a flaky node may be kept out of quorums
+// during an investigation without being removed. Must be invoked on the leader
+func (this *AdminRole) ChangeRole(req *ChangeRoleReq, reply *bool) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.ChangeRole", &err)
+    name, err := this.authorizer.Authorize(req.Token, PermissionMembership)
+    if err == nil {
+        fmt.Println("[ ADMIN", this.roleId, "]", name, "changing role", req.RoleId, "to", req.Role)
+        wait := req.Wait
+        if wait <= 0 {
+            wait = time.Minute
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), wait)
+        err = this.proposer.ChangeRole(ctx, req.RoleId, req.Role)
+        cancel()
+    }
+    this.audit.Record(name, "ChangeRole", fmt.Sprintf("role %d to %v", req.RoleId, req.Role), err)
+    *reply = err == nil
+    return err
+}
+
+// Returns the RPC statistics this node has collected for each of its peers
+func (this *AdminRole) PeerStats(req *TokenReq, reply *[]clusterpeers.PeerStats) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.PeerStats", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionAudit)
+    if err != nil { return err }
+    *reply = this.cluster.PeerStats()
+    return nil
+}
+
+// Returns the audit log of administrative operations performed on this node
+func (this *AdminRole) ReadAudit(req *TokenReq, reply *[]AuditRecord) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.ReadAudit", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionAudit)
+    if err != nil { return err }
+    *reply, err = this.audit.Read()
+    return err
+}
+
+// Request for the consensus events recorded after the given sequence number
+type ReadJournalReq struct {
+    Token string
+    After uint64
+}
+
+// Returns the recent consensus events this node has recorded, oldest first; empty if the
+// journal is disabled
+func (this *AdminRole) ReadJournal(req *ReadJournalReq, reply *[]journal.Event) (err error) {
+    defer guard.Recover("ADMIN", this.roleId, "AdminRole.ReadJournal", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionAudit)
+    if err != nil { return err }
+    *reply = this.journal.Read(req.After)
+    return nil
+}
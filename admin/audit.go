@@ -0,0 +1,89 @@
+package admin
+
+import (
+    "os"
+    "fmt"
+    "sync"
+    "time"
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "encoding/csv"
+)
+
+// Administrative operation recorded in the audit log
+type AuditRecord struct {
+    Time time.Time
+    Identity string
+    Operation string
+    Detail string
+    Outcome string
+}
+
+// Append-only record of administrative operations, stored as CSV rows of
+// time, initiator identity, operation, detail, and outcome
+type AuditLog struct {
+    fileName string
+    exclude sync.Mutex
+}
+
+func ConstructAuditLog(fileName string) (*AuditLog, error) {
+    err := os.MkdirAll(filepath.Dir(fileName), 0700)
+    if err != nil { return nil, err }
+    newAuditLog := AuditLog{fileName: fileName}
+    return &newAuditLog, nil
+}
+
+// Appends an operation and its outcome; err is nil if the operation succeeded
+func (this *AuditLog) Record(identity string, operation string, detail string, err error) {
+    if len(identity) == 0 {
+        identity = "unknown"
+    }
+    outcome := "ok"
+    if err != nil {
+        outcome = err.Error()
+    }
+
+    var row bytes.Buffer
+    writer := csv.NewWriter(&row)
+    writer.Write([]string{time.Now().UTC().Format(time.RFC3339Nano), identity, operation, detail, outcome})
+    writer.Flush()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    auditFile, err := os.OpenFile(this.fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        fmt.Println("[ AUDIT ] Failed to record", operation, "by", identity, ":", err)
+        return
+    }
+    defer auditFile.Close()
+    _, err = auditFile.Write(row.Bytes())
+    if err == nil {
+        err = auditFile.Sync()
+    }
+    if err != nil {
+        fmt.Println("[ AUDIT ] Failed to record", operation, "by", identity, ":", err)
+    }
+}
+
+// Returns every recorded operation, oldest first
+func (this *AuditLog) Read() ([]AuditRecord, error) {
+    this.exclude.Lock()
+    data, err := ioutil.ReadFile(this.fileName)
+    this.exclude.Unlock()
+    if os.IsNotExist(err) { return nil, nil }
+    if err != nil { return nil, err }
+
+    auditFileReader := csv.NewReader(bytes.NewReader(data))
+    auditFileReader.FieldsPerRecord = 5
+    rows, err := auditFileReader.ReadAll()
+    if err != nil { return nil, err }
+
+    records := make([]AuditRecord, 0, len(rows))
+    for _, row := range rows {
+        recorded, err := time.Parse(time.RFC3339Nano, row[0])
+        if err != nil { return nil, err }
+        records = append(records, AuditRecord{recorded, row[1], row[2], row[3], row[4]})
+    }
+    return records, nil
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+    "fmt"
+    "bytes"
+    "io/ioutil"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/csv"
+    "encoding/hex"
+)
+
+// Permissions required by client and administrative operations
+const (
+    PermissionPropose = "propose"
+    PermissionRead = "read"
+    PermissionMembership = "membership"
+    PermissionMaintenance = "maintenance"
+    PermissionAudit = "audit"
+    PermissionDebug = "debug"
+    PermissionUnsafeRecovery = "unsafe-recovery"
+)
+
+// Permissions granted to each role a token may hold
+var rolePermissions = map[string][]string {
+    "client": {PermissionPropose, PermissionRead},
+    "operator": {PermissionPropose, PermissionRead, PermissionMembership, PermissionMaintenance, PermissionAudit,
+                 PermissionDebug},
+    "administrator": {PermissionPropose, PermissionRead, PermissionMembership, PermissionMaintenance, PermissionAudit,
+                      PermissionDebug, PermissionUnsafeRecovery},
+}
+
+// Bearer token holder
+type identity struct {
+    name string
+    digest []byte
+    role string
+}
+
+// Checks bearer tokens against a file of "name,sha256(token),role" records; only digests
+// are stored so the file does not reveal tokens. The file is re-read on each check, so
+// tokens can be issued and revoked without restarting the node
+type Authorizer struct {
+    fileName string
+}
+
+func ConstructAuthorizer(fileName string) *Authorizer {
+    newAuthorizer := Authorizer{fileName}
+    return &newAuthorizer
+}
+
+// Returns the name of the token's holder if its role grants the permission; a nil
+// Authorizer grants every permission to an anonymous holder
+func (this *Authorizer) Authorize(token string, permission string) (string, error) {
+    if this == nil { return "", nil }
+
+    identities, err := this.read()
+    if err != nil { return "", err }
+
+    digest := sha256.Sum256([]byte(token))
+    for _, holder := range identities {
+        if subtle.ConstantTimeCompare(digest[:], holder.digest) != 1 {
+            continue
+        }
+        for _, granted := range rolePermissions[holder.role] {
+            if granted == permission {
+                return holder.name, nil
+            }
+        }
+        return holder.name, fmt.Errorf("Permission denied: %s lacks %s permission", holder.name, permission)
+    }
+    return "", fmt.Errorf("Permission denied: invalid token")
+}
+
+func (this *Authorizer) read() ([]identity, error) {
+    data, err := ioutil.ReadFile(this.fileName)
+    if err != nil { return nil, err }
+    tokensFileReader := csv.NewReader(bytes.NewReader(data))
+    tokensFileReader.FieldsPerRecord = 3
+    records, err := tokensFileReader.ReadAll()
+    if err != nil { return nil, err }
+
+    var identities []identity = nil
+    for _, record := range records {
+        digest, err := hex.DecodeString(record[1])
+        if err != nil { return nil, err }
+        if _, exists := rolePermissions[record[2]]; !exists {
+            return nil, fmt.Errorf("Unknown role %s in tokens file", record[2])
+        }
+        identities = append(identities, identity{record[0], digest, record[2]})
+    }
+    return identities, nil
+}
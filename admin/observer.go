@@ -0,0 +1,88 @@
+package admin
+
+import (
+    "time"
+    "context"
+    "github/paxoscluster/guard"
+)
+
+// Longest an observation waits before looking again for a change other than a commit
+const observeInterval = 50*time.Millisecond
+
+// State of the cluster as one node sees it, reported to observers: monitoring processes which
+// follow the cluster through the client protocol, but never vote or store data
+type Observation struct {
+    RoleId uint64
+    LeaderId uint64
+    LeaderAddress string
+    // Heartbeats the node has received from voters, and when it last heard from the leader
+    Heartbeats uint64
+    LeaderContact time.Time
+    Members map[uint64]string
+    Learners []uint64
+    CommitIndex int
+    AppliedIndex int
+}
+
+// Request for the node's state once it differs from Last, the state the observer holds;
+// waits up to Wait for a change, then replies with the state as it stands
+type ObserveReq struct {
+    Token string
+    Last Observation
+    Wait time.Duration
+}
+
+// Reports heartbeats, membership and role changes, and commit-index updates to an observer by
+// long polling; an observer passes each reply back as Last to wait for the next change
+func (this *ClientRole) Observe(req *ObserveReq, reply *Observation) (err error) {
+    defer guard.Recover("CLIENT", this.proposer.GetRoleId(), "ClientRole.Observe", &err)
+    _, err = this.authorizer.Authorize(req.Token, PermissionRead)
+    if err != nil { return err }
+
+    deadline := time.Now().Add(req.Wait)
+    for {
+        *reply = this.observe()
+        remaining := deadline.Sub(time.Now())
+        if reply.differs(&req.Last) || remaining <= 0 {
+            return nil
+        }
+        if remaining > observeInterval {
+            remaining = observeInterval
+        }
+        // Wakes at once on the next commit
+        ctx, cancel := context.WithTimeout(context.Background(), remaining)
+        this.log.WaitForIndex(ctx, reply.CommitIndex+1)
+        cancel()
+    }
+}
+
+// Returns this node's current state
+func (this *ClientRole) observe() Observation {
+    observation := Observation {
+        RoleId: this.proposer.GetRoleId(),
+        Members: this.proposer.GetMembership(),
+        Learners: this.proposer.GetLearners(),
+        CommitIndex: this.log.GetCommitIndex(),
+        AppliedIndex: this.log.GetAppliedIndex(),
+    }
+    observation.LeaderId, observation.LeaderAddress = this.proposer.GetLeader()
+    observation.Heartbeats, observation.LeaderContact = this.proposer.GetHeartbeats()
+    return observation
+}
+
+// Reports whether an observation differs from another
+func (this *Observation) differs(other *Observation) bool {
+    if this.RoleId != other.RoleId || this.LeaderId != other.LeaderId || this.Heartbeats != other.Heartbeats ||
+       !this.LeaderContact.Equal(other.LeaderContact) || this.CommitIndex != other.CommitIndex ||
+       this.AppliedIndex != other.AppliedIndex || len(this.Members) != len(other.Members) ||
+       len(this.Learners) != len(other.Learners) {
+        return true
+    }
+    for roleId, address := range this.Members {
+        if other.Members[roleId] != address { return true }
+    }
+    for index := range this.Learners {
+        if this.Learners[index] != other.Learners[index] { return true }
+    }
+    return false
+}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bytes"
+    "encoding/gob"
+    "encoding/json"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Sealed run of consecutive committed entries
+type segment struct {
+    Start int
+    Entries [][]byte
+}
+
+// Archived segment as listed in the manifest; End is exclusive
+type SegmentInfo struct {
+    Start int `json:"start"`
+    End int `json:"end"`
+    Key string `json:"key"`
+    Checksum uint32 `json:"checksum"`
+}
+
+// Index of the segments archived for a role, in order and without gaps
+type Manifest struct {
+    RoleId uint64 `json:"roleId"`
+    Segments []SegmentInfo `json:"segments"`
+}
+
+// Returns the index following the last archived entry
+func (this *Manifest) End() int {
+    if len(this.Segments) == 0 {
+        return 0
+    }
+    return this.Segments[len(this.Segments)-1].End
+}
+
+// Ships the committed log of a role to object storage in segments of a fixed number of
+// entries, each written before the manifest which lists it
+type Archiver struct {
+    roleId uint64
+    log *replicatedlog.Log
+    store ObjectStore
+    prefix string
+    segmentSize int
+}
+
+func ConstructArchiver(roleId uint64, log *replicatedlog.Log, store ObjectStore, prefix string, segmentSize int) *Archiver {
+    newArchiver := Archiver {
+        roleId: roleId,
+        log: log,
+        store: store,
+        prefix: prefix,
+        segmentSize: segmentSize,
+    }
+    return &newArchiver
+}
+
+// Archives newly sealed segments at the given interval
+func (this *Archiver) Run(interval time.Duration) {
+    for {
+        err := this.ArchiveSealed()
+        if err != nil {
+            fmt.Println("[ ARCHIVE", this.roleId, "] Archiving failed:", err)
+        }
+        time.Sleep(interval)
+    }
+}
+
+// Uploads every complete segment of committed entries not yet archived
+func (this *Archiver) ArchiveSealed() error {
+    manifest, err := ReadManifest(this.store, this.prefix, this.roleId)
+    if err != nil { return err }
+
+    for {
+        start := manifest.End()
+        end := start + this.segmentSize
+        if end > this.log.GetCommitIndex()+1 {
+            return nil
+        }
+
+        entries, err := this.log.ReadEntries(start, end, true)
+        if err != nil { return err }
+        sealed := segment{Start: start}
+        for _, entry := range entries {
+            sealed.Entries = append(sealed.Entries, entry.Value)
+        }
+        var buffer bytes.Buffer
+        err = gob.NewEncoder(&buffer).Encode(&sealed)
+        if err != nil { return err }
+
+        key := fmt.Sprintf("%s/%d/segments/%012d-%012d", this.prefix, this.roleId, start, end)
+        err = this.store.Put(key, buffer.Bytes())
+        if err != nil { return err }
+
+        manifest.Segments = append(manifest.Segments, SegmentInfo{start, end, key, replicatedlog.Checksum(buffer.Bytes())})
+        data, err := json.Marshal(manifest)
+        if err != nil { return err }
+        err = this.store.Put(manifestKey(this.prefix, this.roleId), data)
+        if err != nil { return err }
+        fmt.Println("[ ARCHIVE", this.roleId, "] Archived entries", start, "to", end-1)
+    }
+}
+
+func manifestKey(prefix string, roleId uint64) string {
+    return fmt.Sprintf("%s/%d/manifest.json", prefix, roleId)
+}
+
+// Reads the manifest of a role's archive; an archive which does not exist yet is empty
+func ReadManifest(store ObjectStore, prefix string, roleId uint64) (*Manifest, error) {
+    data, err := store.Get(manifestKey(prefix, roleId))
+    if os.IsNotExist(err) {
+        return &Manifest{RoleId: roleId}, nil
+    }
+    if err != nil { return nil, err }
+
+    var manifest Manifest
+    err = json.Unmarshal(data, &manifest)
+    if err != nil { return nil, err }
+    return &manifest, nil
+}
+
+// Rebuilds the log of a node which has never run from the archive of sourceRoleId, through
+// the entry at index; the node starts with those entries chosen. Restoring each node of a
+// cluster to the same index rebuilds the whole cluster at that point in time
+func Restore(store ObjectStore, prefix string, sourceRoleId uint64, index int, roleId uint64, disk *recovery.Manager) error {
+    existing, _, _, err := disk.RecoverLog(roleId)
+    if err != nil { return err }
+    if len(existing) != 0 {
+        return fmt.Errorf("Role %d already has %d log entries; archives restore only empty nodes", roleId, len(existing))
+    }
+
+    manifest, err := ReadManifest(store, prefix, sourceRoleId)
+    if err != nil { return err }
+    if index >= manifest.End() {
+        return fmt.Errorf("Index %d is not archived; archive ends at %d", index, manifest.End()-1)
+    }
+
+    var values [][]byte = nil
+    for _, info := range manifest.Segments {
+        if info.Start > index { break }
+
+        data, err := store.Get(info.Key)
+        if err != nil { return err }
+        if replicatedlog.Checksum(data) != info.Checksum {
+            return fmt.Errorf("Archived segment %s is corrupt", info.Key)
+        }
+        var sealed segment
+        err = gob.NewDecoder(bytes.NewReader(data)).Decode(&sealed)
+        if err != nil { return err }
+        if sealed.Start != len(values) {
+            return fmt.Errorf("Archived segment %s starts at %d, expected %d", info.Key, sealed.Start, len(values))
+        }
+        values = append(values, sealed.Entries...)
+    }
+    values = values[:index+1]
+
+    ids := make([]proposal.Id, len(values))
+    for position := range ids {
+        ids[position] = proposal.Chosen()
+    }
+    err = disk.WriteLog(roleId, values, ids)
+    if err != nil { return err }
+    fmt.Println("[ ARCHIVE", roleId, "] Restored entries 0 to", index, "from archive of role", sourceRoleId)
+    return nil
+}
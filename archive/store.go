@@ -0,0 +1,165 @@
+package archive
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bytes"
+    "strings"
+    "net/http"
+    "io/ioutil"
+    "path/filepath"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "github/paxoscluster/config"
+)
+
+// Object storage holding archived segments and manifests
+type ObjectStore interface {
+    Put(key string, data []byte) error
+    // Returns an error satisfying os.IsNotExist if the object does not exist
+    Get(key string) ([]byte, error)
+}
+
+// Creates the store selected by the archive settings, or returns nil if archiving is disabled
+func ConstructStore(settings config.ArchiveConfig) ObjectStore {
+    if len(settings.Directory) != 0 {
+        return ConstructDirectoryStore(settings.Directory)
+    }
+    if len(settings.Bucket) != 0 {
+        return ConstructS3Store(settings.Endpoint, settings.Bucket, settings.Region,
+                                os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+    }
+    return nil
+}
+
+// Store in an S3-compatible bucket, addressed path-style so that self-hosted stores work
+type S3Store struct {
+    endpoint string
+    bucket string
+    region string
+    accessKey string
+    secretKey string
+    client *http.Client
+}
+
+func ConstructS3Store(endpoint string, bucket string, region string, accessKey string, secretKey string) *S3Store {
+    newS3Store := S3Store {
+        endpoint: strings.TrimSuffix(endpoint, "/"),
+        bucket: bucket,
+        region: region,
+        accessKey: accessKey,
+        secretKey: secretKey,
+        client: &http.Client{Timeout: time.Minute},
+    }
+    return &newS3Store
+}
+
+func (this *S3Store) Put(key string, data []byte) error {
+    _, err := this.do("PUT", key, data)
+    return err
+}
+
+func (this *S3Store) Get(key string) ([]byte, error) {
+    return this.do("GET", key, nil)
+}
+
+// Sends a request signed with AWS Signature Version 4
+func (this *S3Store) do(method string, key string, body []byte) ([]byte, error) {
+    path := "/" + uriEncode(this.bucket) + "/" + uriEncode(key)
+    request, err := http.NewRequest(method, this.endpoint + path, bytes.NewReader(body))
+    if err != nil { return nil, err }
+
+    now := time.Now().UTC()
+    amzDate := now.Format("20060102T150405Z")
+    scope := now.Format("20060102") + "/" + this.region + "/s3/aws4_request"
+    payloadHash := sha256Hex(body)
+    request.Header.Set("x-amz-date", amzDate)
+    request.Header.Set("x-amz-content-sha256", payloadHash)
+
+    canonicalRequest := strings.Join([]string {
+        method,
+        path,
+        "",
+        "host:" + request.URL.Host,
+        "x-amz-content-sha256:" + payloadHash,
+        "x-amz-date:" + amzDate,
+        "",
+        "host;x-amz-content-sha256;x-amz-date",
+        payloadHash,
+    }, "\n")
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+    signingKey := []byte("AWS4" + this.secretKey)
+    for _, part := range []string{now.Format("20060102"), this.region, "s3", "aws4_request"} {
+        signingKey = hmacSha256(signingKey, part)
+    }
+    signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+    request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=" + this.accessKey + "/" + scope +
+                       ", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + signature)
+
+    response, err := this.client.Do(request)
+    if err != nil { return nil, err }
+    defer response.Body.Close()
+    data, err := ioutil.ReadAll(response.Body)
+    if err != nil { return nil, err }
+
+    if response.StatusCode == http.StatusNotFound {
+        return nil, &os.PathError{Op: method, Path: key, Err: os.ErrNotExist}
+    }
+    if response.StatusCode/100 != 2 {
+        return nil, fmt.Errorf("%s %s: %s", method, key, response.Status)
+    }
+    return data, nil
+}
+
+// Percent-encodes all but unreserved characters and path separators, as signing requires
+func uriEncode(path string) string {
+    var encoded strings.Builder
+    for _, character := range []byte(path) {
+        if ('A' <= character && character <= 'Z') || ('a' <= character && character <= 'z') ||
+            ('0' <= character && character <= '9') || strings.IndexByte("-_.~/", character) >= 0 {
+            encoded.WriteByte(character)
+        } else {
+            fmt.Fprintf(&encoded, "%%%02X", character)
+        }
+    }
+    return encoded.String()
+}
+
+func sha256Hex(data []byte) string {
+    digest := sha256.Sum256(data)
+    return hex.EncodeToString(digest[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// Store in a local directory, such as a mounted network filesystem
+type DirectoryStore struct {
+    directory string
+}
+
+func ConstructDirectoryStore(directory string) *DirectoryStore {
+    newDirectoryStore := DirectoryStore{directory}
+    return &newDirectoryStore
+}
+
+func (this *DirectoryStore) Put(key string, data []byte) error {
+    fileName := filepath.Join(this.directory, filepath.FromSlash(key))
+    err := os.MkdirAll(filepath.Dir(fileName), 0700)
+    if err != nil { return err }
+
+    // Writes through a temporary file so readers never observe a partial object
+    err = ioutil.WriteFile(fileName + ".tmp", data, 0600)
+    if err != nil { return err }
+    return os.Rename(fileName + ".tmp", fileName)
+}
+
+func (this *DirectoryStore) Get(key string) ([]byte, error) {
+    return ioutil.ReadFile(filepath.Join(this.directory, filepath.FromSlash(key)))
+}
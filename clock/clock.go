@@ -0,0 +1,137 @@
+package clock
+
+import (
+    "sort"
+    "sync"
+    "time"
+    "math/rand"
+)
+
+// Source of time for timing-dependent protocol code, so tests can advance time virtually
+type Clock interface {
+    Now() time.Time
+    After(duration time.Duration) <-chan time.Time
+    Sleep(duration time.Duration)
+}
+
+// Source of randomness, so tests can reproduce randomized behaviour from a seed
+type Rand interface {
+    Int63n(n int64) int64
+    Float64() float64
+}
+
+// Clock following real time
+type realClock struct{}
+
+func Real() Clock {
+    return realClock{}
+}
+
+func (this realClock) Now() time.Time { return time.Now() }
+func (this realClock) After(duration time.Duration) <-chan time.Time { return time.After(duration) }
+func (this realClock) Sleep(duration time.Duration) { time.Sleep(duration) }
+
+// Returns the clock, or the real clock if none was injected
+func OrReal(clock Clock) Clock {
+    if clock == nil {
+        return Real()
+    }
+    return clock
+}
+
+// Random source safe for concurrent use
+type lockedRand struct {
+    source *rand.Rand
+    exclude sync.Mutex
+}
+
+func NewRand(seed int64) Rand {
+    newLockedRand := lockedRand{source: rand.New(rand.NewSource(seed))}
+    return &newLockedRand
+}
+
+// Returns the random source, or one seeded from the time if none was injected
+func OrRandom(random Rand) Rand {
+    if random == nil {
+        return NewRand(time.Now().UnixNano())
+    }
+    return random
+}
+
+func (this *lockedRand) Int63n(n int64) int64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    return this.source.Int63n(n)
+}
+
+func (this *lockedRand) Float64() float64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    return this.source.Float64()
+}
+
+// Clock which stands still until advanced, firing timers in order as time passes
+type Virtual struct {
+    now time.Time
+    timers []timer
+    exclude sync.Mutex
+}
+
+// Pending timer of a virtual clock
+type timer struct {
+    at time.Time
+    fire chan time.Time
+}
+
+func ConstructVirtual(start time.Time) *Virtual {
+    newVirtual := Virtual{now: start}
+    return &newVirtual
+}
+
+func (this *Virtual) Now() time.Time {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    return this.now
+}
+
+func (this *Virtual) After(duration time.Duration) <-chan time.Time {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    fire := make(chan time.Time, 1)
+    if duration <= 0 {
+        fire <- this.now
+        return fire
+    }
+    this.timers = append(this.timers, timer{this.now.Add(duration), fire})
+    return fire
+}
+
+func (this *Virtual) Sleep(duration time.Duration) {
+    <- this.After(duration)
+}
+
+// Moves time forward, firing every timer due by the new time in order of expiry
+func (this *Virtual) Advance(duration time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    target := this.now.Add(duration)
+    sort.SliceStable(this.timers, func(i, j int) bool { return this.timers[i].at.Before(this.timers[j].at) })
+    fired := 0
+    for _, pending := range this.timers {
+        if pending.at.After(target) { break }
+        this.now = pending.at
+        pending.fire <- this.now
+        fired++
+    }
+    this.timers = this.timers[fired:]
+    this.now = target
+}
+
+// Returns the number of timers waiting to fire, so tests can tell when goroutines have blocked
+func (this *Virtual) Pending() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    return len(this.timers)
+}
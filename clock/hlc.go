@@ -0,0 +1,66 @@
+package clock
+
+import (
+    "sync"
+    "time"
+)
+
+// Reading of a hybrid logical clock: wall time in nanoseconds, with a counter ordering
+// readings which share a wall time
+type Timestamp struct {
+    Wall int64
+    Logical uint32
+}
+
+// Reports whether this reading precedes other
+func (this Timestamp) Before(other Timestamp) bool {
+    return this.Wall < other.Wall || (this.Wall == other.Wall && this.Logical < other.Logical)
+}
+
+// Returns the reading immediately following this one
+func (this Timestamp) Next() Timestamp {
+    return Timestamp{this.Wall, this.Logical+1}
+}
+
+// Returns the wall time of the reading
+func (this Timestamp) Time() time.Time {
+    return time.Unix(0, this.Wall)
+}
+
+// Hybrid logical clock: readings follow the wall clock where it advances, but never go
+// backwards, and always follow every reading observed from elsewhere. Readings thus respect
+// causality while staying close to wall time
+type Hybrid struct {
+    clock Clock
+    last Timestamp
+    exclude sync.Mutex
+}
+
+func NewHybrid(clock Clock) *Hybrid {
+    newHybrid := Hybrid{clock: OrReal(clock)}
+    return &newHybrid
+}
+
+// Returns a reading later than every reading returned or observed before
+func (this *Hybrid) Now() Timestamp {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    wall := this.clock.Now().UnixNano()
+    if wall > this.last.Wall {
+        this.last = Timestamp{wall, 0}
+    } else {
+        this.last = this.last.Next()
+    }
+    return this.last
+}
+
+// Advances the clock past a reading taken elsewhere
+func (this *Hybrid) Observe(remote Timestamp) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.last.Before(remote) {
+        this.last = remote
+    }
+}
@@ -0,0 +1,65 @@
+package clusterpeers
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "strings"
+    "github/paxoscluster/config"
+)
+
+// Splits an address into its network and network-specific target; addresses
+// prefixed with unix:// name a Unix domain socket, those prefixed with quic:// a QUIC
+// host:port pair, and all others are TCP host:port pairs
+func splitAddress(address string) (string, string) {
+    if strings.HasPrefix(address, config.UnixScheme) {
+        return "unix", strings.TrimPrefix(address, config.UnixScheme)
+    }
+    if strings.HasPrefix(address, config.QuicScheme) {
+        return "quic", strings.TrimPrefix(address, config.QuicScheme)
+    }
+    return "tcp", address
+}
+
+// Resolves a host:port address to an IP address, preferring IPv4 on dual-stack hosts
+func resolveAddress(host string) (string, error) {
+    network, target := splitAddress(host)
+    if network == "unix" {
+        return host, nil
+    }
+    scheme := strings.TrimSuffix(host, target)
+
+    hostname, port, err := net.SplitHostPort(target)
+    if err != nil { return "", err }
+    if net.ParseIP(hostname) != nil {
+        return host, nil
+    }
+
+    ips, err := net.LookupIP(hostname)
+    if err != nil { return "", err }
+    if len(ips) == 0 {
+        return "", fmt.Errorf("No addresses found for %s", hostname)
+    }
+    ip := ips[0]
+    for _, candidate := range ips {
+        if candidate.To4() != nil {
+            ip = candidate
+            break
+        }
+    }
+    return scheme+net.JoinHostPort(ip.String(), port), nil
+}
+
+// Returns the IPv4 and IPv6 addresses of the current machine
+func localAddresses() (map[string]bool, error) {
+    name, err := os.Hostname()
+    if err != nil { return nil, err }
+    ipInfo, err := net.LookupIP(name)
+    if err != nil { return nil, err }
+
+    addresses := make(map[string]bool)
+    for _, ip := range ipInfo {
+        addresses[ip.String()] = true
+    }
+    return addresses, nil
+}
@@ -0,0 +1,241 @@
+package clusterpeers
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "crypto/rand"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/binary"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+)
+
+// Counts authenticated connections and rejected connections and messages
+var authenticationStats = metrics.Group("authentication")
+
+// Largest message accepted on an authenticated connection
+const maxFrameSize = 1 << 26
+
+// Keys used to authenticate connections; a nil authenticator disables authentication
+type authenticator struct {
+    secret []byte
+    keys map[uint64][]byte
+}
+
+func constructAuthenticator(settings config.AuthenticationConfig) (*authenticator, error) {
+    if len(settings.Secret) == 0 && len(settings.Keys) == 0 {
+        return nil, nil
+    }
+
+    secret, err := hex.DecodeString(settings.Secret)
+    if err != nil { return nil, err }
+    keys := make(map[uint64][]byte)
+    for roleId, encoded := range settings.Keys {
+        keys[roleId], err = hex.DecodeString(encoded)
+        if err != nil { return nil, err }
+    }
+
+    newAuthenticator := authenticator{secret, keys}
+    return &newAuthenticator, nil
+}
+
+// Returns the key for connections opened by the given role, falling back to the shared secret
+func (this *authenticator) key(roleId uint64) ([]byte, error) {
+    if key, exists := this.keys[roleId]; exists {
+        return key, nil
+    }
+    if len(this.secret) == 0 {
+        return nil, fmt.Errorf("No key for role %d", roleId)
+    }
+    return this.secret, nil
+}
+
+// Computes HMAC-SHA256 over a label and message parts
+func computeMac(key []byte, label string, parts ...[]byte) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(label))
+    for _, part := range parts {
+        mac.Write(part)
+    }
+    return mac.Sum(nil)
+}
+
+// Identifies this role to the server and proves knowledge of its key in a challenge-response
+// exchange, after which every message is authenticated with a key unique to the connection
+func authenticateClient(connection net.Conn, auth *authenticator, roleId uint64, timeout time.Duration) (net.Conn, error) {
+    if auth == nil {
+        return connection, nil
+    }
+    key, err := auth.key(roleId)
+    if err != nil { return nil, err }
+
+    connection.SetDeadline(time.Now().Add(timeout))
+    defer connection.SetDeadline(time.Time{})
+
+    // Sends roleId and client nonce
+    hello := make([]byte, 8+16)
+    binary.BigEndian.PutUint64(hello, roleId)
+    _, err = rand.Read(hello[8:])
+    if err != nil { return nil, err }
+    _, err = connection.Write(hello)
+    if err != nil { return nil, err }
+
+    // Verifies server knows the key
+    challenge := make([]byte, 16+sha256.Size)
+    _, err = io.ReadFull(connection, challenge)
+    if err != nil { return nil, err }
+    serverNonce := challenge[:16]
+    if !hmac.Equal(challenge[16:], computeMac(key, "server", hello, serverNonce)) {
+        return nil, fmt.Errorf("Server failed authentication")
+    }
+
+    _, err = connection.Write(computeMac(key, "client", hello, serverNonce))
+    if err != nil { return nil, err }
+
+    return constructAuthenticatedConn(connection, computeMac(key, "session", hello, serverNonce), 'c', 's'), nil
+}
+
+// Role a connection was authenticated as. Without authentication any role claimed is taken at
+// its word; a role authenticated by the shared secret may be any holder of it, so is not
+// trusted to be the peer of that roleId
+type principal struct {
+    roleId uint64
+    unauthenticated bool
+    shared bool
+}
+
+// Reports whether a role the remote names itself is the one it authenticated as
+func (this principal) admits(roleId uint64) bool {
+    return this.unauthenticated || roleId == this.roleId
+}
+
+// Reports whether requests to the given peer may be sent over the connection: the remote must
+// be a member, and have proved knowledge of that member's own key
+func (this principal) vouchesFor(roleId uint64) bool {
+    return roleId != 0 && this.admits(roleId) && !this.shared
+}
+
+// Verifies the connecting role knows its key, returning the role it authenticated as; failures
+// are counted and the connection rejected
+func authenticateServer(connection net.Conn, auth *authenticator, timeout time.Duration) (net.Conn, principal, error) {
+    if auth == nil {
+        return connection, principal{unauthenticated: true}, nil
+    }
+
+    connection.SetDeadline(time.Now().Add(timeout))
+    defer connection.SetDeadline(time.Time{})
+
+    hello := make([]byte, 8+16)
+    _, err := io.ReadFull(connection, hello)
+    if err != nil { return nil, principal{}, err }
+    roleId := binary.BigEndian.Uint64(hello)
+    key, err := auth.key(roleId)
+    if err != nil {
+        authenticationStats.Add("rejectedConnections", 1)
+        return nil, principal{}, err
+    }
+    _, keyed := auth.keys[roleId]
+
+    serverNonce := make([]byte, 16)
+    _, err = rand.Read(serverNonce)
+    if err != nil { return nil, principal{}, err }
+    _, err = connection.Write(append(serverNonce, computeMac(key, "server", hello, serverNonce)...))
+    if err != nil { return nil, principal{}, err }
+
+    // Clients which cannot verify the server's key abandon the handshake
+    response := make([]byte, sha256.Size)
+    _, err = io.ReadFull(connection, response)
+    if err != nil || !hmac.Equal(response, computeMac(key, "client", hello, serverNonce)) {
+        authenticationStats.Add("rejectedConnections", 1)
+        return nil, principal{}, fmt.Errorf("Client failed authentication")
+    }
+
+    authenticationStats.Add("authenticatedConnections", 1)
+    authenticated := constructAuthenticatedConn(connection, computeMac(key, "session", hello, serverNonce), 's', 'c')
+    return authenticated, principal{roleId: roleId, shared: !keyed}, nil
+}
+
+// Connection on which every message is framed with an HMAC over its direction and sequence
+// number, so messages cannot be forged, replayed, reordered, or reflected
+type authenticatedConn struct {
+    net.Conn
+    key []byte
+    sendLabel byte
+    receiveLabel byte
+    sendSequence uint64
+    receiveSequence uint64
+    pending []byte
+    exclude sync.Mutex
+}
+
+func constructAuthenticatedConn(connection net.Conn, key []byte, sendLabel byte, receiveLabel byte) *authenticatedConn {
+    newConnection := authenticatedConn {
+        Conn: connection,
+        key: key,
+        sendLabel: sendLabel,
+        receiveLabel: receiveLabel,
+    }
+    return &newConnection
+}
+
+// Computes the MAC of a frame sent in the given direction
+func (this *authenticatedConn) frameMac(label byte, sequence uint64, payload []byte) []byte {
+    header := make([]byte, 9)
+    header[0] = label
+    binary.BigEndian.PutUint64(header[1:], sequence)
+    return computeMac(this.key, "frame", header, payload)
+}
+
+// Sends data as a single frame of length, payload, and MAC
+func (this *authenticatedConn) Write(data []byte) (int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if len(data) > maxFrameSize {
+        return 0, fmt.Errorf("Message of %d bytes exceeds maximum frame size", len(data))
+    }
+
+    frame := make([]byte, 4, 4+len(data)+sha256.Size)
+    binary.BigEndian.PutUint32(frame, uint32(len(data)))
+    frame = append(frame, data...)
+    frame = append(frame, this.frameMac(this.sendLabel, this.sendSequence, data)...)
+    this.sendSequence++
+
+    _, err := this.Conn.Write(frame)
+    if err != nil { return 0, err }
+    return len(data), nil
+}
+
+// Returns data from verified frames; a frame failing verification closes the connection
+func (this *authenticatedConn) Read(data []byte) (int, error) {
+    for len(this.pending) == 0 {
+        header := make([]byte, 4)
+        _, err := io.ReadFull(this.Conn, header)
+        if err != nil { return 0, err }
+        length := binary.BigEndian.Uint32(header)
+        if length > maxFrameSize {
+            authenticationStats.Add("rejectedMessages", 1)
+            return 0, fmt.Errorf("Frame of %d bytes exceeds maximum frame size", length)
+        }
+
+        frame := make([]byte, int(length)+sha256.Size)
+        _, err = io.ReadFull(this.Conn, frame)
+        if err != nil { return 0, err }
+        payload := frame[:length]
+        if !hmac.Equal(frame[length:], this.frameMac(this.receiveLabel, this.receiveSequence, payload)) {
+            authenticationStats.Add("rejectedMessages", 1)
+            return 0, fmt.Errorf("Message failed authentication")
+        }
+        this.receiveSequence++
+        this.pending = payload
+    }
+
+    read := copy(data, this.pending)
+    this.pending = this.pending[read:]
+    return read, nil
+}
@@ -0,0 +1,132 @@
+package clusterpeers
+
+import (
+    "net"
+    "time"
+    "testing"
+    "net/rpc"
+    "github/paxoscluster/config"
+)
+
+type echoService struct{}
+
+func (this *echoService) Echo(req *string, reply *string) error {
+    *reply = *req
+    return nil
+}
+
+// Dials the server as a node which authenticates as one role and then names itself another
+func dialAs(connection net.Conn, auth *authenticator, authenticatedAs uint64, named uint64) {
+    authenticated, err := authenticateClient(connection, auth, authenticatedAs, time.Second)
+    if err != nil { return }
+    negotiated, _, err := negotiateClient(authenticated, FeatureIdentity | FeatureMultiplex, time.Second)
+    if err != nil { return }
+    spoofing := transport{roleId: named, timeouts: config.ConstructLiveTimeouts(config.Default().Timeouts)}
+    spoofing.exchangeIdentity(negotiated, true, principal{unauthenticated: true})
+}
+
+// A connection is used for requests to a peer only if it authenticated with that peer's own
+// key; the shared secret, and a key of another role, prove nothing about the role named
+func TestAcceptChecksAuthenticatedRole(t *testing.T) {
+    settings := config.AuthenticationConfig{Secret: "00112233", Keys: map[uint64]string{2: "2222", 3: "3333"}}
+    auth, err := constructAuthenticator(settings)
+    if err != nil { t.Fatal(err) }
+
+    cases := []struct {
+        name string
+        authenticatedAs uint64
+        named uint64
+        accepted bool
+        adopted bool
+    }{
+        {"own key", 2, 2, true, true},
+        {"another role's key", 3, 2, false, false},
+        {"shared secret as a client", 0, 2, false, false},
+        {"shared secret as a member", 4, 4, true, false},
+        {"shared secret naming no role", 0, 0, true, false},
+    }
+    for _, test := range cases {
+        adopted := uint64(0)
+        server := transport {
+            roleId: 1,
+            auth: auth,
+            timeouts: config.ConstructLiveTimeouts(config.Default().Timeouts),
+            handler: rpc.NewServer(),
+            inbound: func(roleId uint64, connection *rpc.Client, agreed capabilities) { adopted = roleId },
+            epoch: func() int { return 0 },
+        }
+        client, local := net.Pipe()
+        go dialAs(client, auth, test.authenticatedAs, test.named)
+        _, _, err := server.accept(local)
+        if accepted := err == nil; accepted != test.accepted {
+            t.Errorf("%s: accepted %v, expected %v: %v", test.name, accepted, test.accepted, err)
+        }
+        if (adopted != 0) != test.adopted {
+            t.Errorf("%s: adopted the connection as role %d", test.name, adopted)
+        }
+        client.Close()
+        local.Close()
+    }
+}
+
+func TestCheckIdentityNamesAuthenticatedRole(t *testing.T) {
+    local := transport{roleId: 1, instance: 1, clusterId: "a"}
+    cases := []struct {
+        name string
+        remote identity
+        authenticated principal
+        accepted bool
+    }{
+        {"own key", identity{2, 5, "a"}, principal{roleId: 2}, true},
+        {"shared secret", identity{4, 5, "a"}, principal{roleId: 4, shared: true}, true},
+        {"another role's key", identity{2, 5, "a"}, principal{roleId: 3}, false},
+        {"client naming a member", identity{2, 5, "a"}, principal{roleId: 0, shared: true}, false},
+        {"unauthenticated", identity{2, 5, "a"}, principal{unauthenticated: true}, true},
+        {"this node's roleId", identity{1, 5, "a"}, principal{roleId: 1}, false},
+        {"another cluster", identity{2, 5, "b"}, principal{roleId: 2}, false},
+    }
+    for _, test := range cases {
+        err := local.checkIdentity(test.remote, test.authenticated)
+        if accepted := err == nil; accepted != test.accepted {
+            t.Errorf("%s: accepted %v, expected %v: %v", test.name, accepted, test.accepted, err)
+        }
+    }
+}
+
+// Clients reach the client listener without any peer key, and cannot use the peer listener
+// of a cluster whose peers authenticate
+func TestClientListenerHoldsNoPeerKey(t *testing.T) {
+    peers := map[uint64]string{1: reserveAddress(t), 2: reserveAddress(t)}
+    settings := upgradeSettings(1, peers)
+    settings.Authentication.Secret = "00112233"
+    settings.Client.Address = reserveAddress(t)
+    handler := rpc.NewServer()
+    handler.RegisterName("Echo", &echoService{})
+    cluster, _, _, err := ConstructCluster(settings, nil)
+    if err == nil {
+        err = cluster.Listen(handler)
+    }
+    if err == nil {
+        err = ServeClients(1, settings.Client.Address, settings, handler)
+    }
+    if err != nil { t.Fatal(err) }
+
+    client, err := Dial(settings.Client.Address, settings)
+    if err != nil { t.Fatal(err) }
+    defer client.Close()
+    var reply string
+    err = client.Call("Echo.Echo", "hello", &reply)
+    if err != nil || reply != "hello" {
+        t.Errorf("Client listener answered %q: %v", reply, err)
+    }
+
+    // A server which never answers the hello is taken to predate it, so the call fails instead
+    peer, err := Dial(peers[1], settings)
+    if err == nil {
+        err = peer.Call("Echo.Echo", "hello", &reply)
+        peer.Close()
+    }
+    if err == nil {
+        t.Errorf("Client was served by the peer listener without the peer secret")
+    }
+}
@@ -1,135 +1,340 @@
 package clusterpeers
 
 import (
-    "os"
     "fmt"
     "sync"
-    "time"
     "net"
     "net/rpc"
-    "github/paxoscluster/recovery"
+    "time"
+    "sync/atomic"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/config"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/journal"
     "github/paxoscluster/acceptor"
 )
 
+// Peers of this node and the connections to them. The membership is an immutable snapshot
+// swapped atomically, each peer's connection state has its own lock, and exclude guards only
+// the promise lease, upgrade state, follower states and progress, heartbeat pacing, and membership changes;
+// no lock is held across network calls, so a slow peer holds up neither broadcasts nor
+// membership reads
 type Cluster struct {
     roleId uint64
-    nodes map[uint64]Peer
+    membership atomic.Value
     registerBadConnection chan uint64
-    skipPromiseCount uint64
-    disk *recovery.Manager
+    lease promiseLease
+    timeouts *config.LiveTimeouts
+    quarantine config.QuarantineConfig
+    transport *transport
+    local *acceptor.AcceptorRole
+    events *hooks.Hooks
+    journal *journal.Journal
+    clock clock.Clock
+    random clock.Rand
+    latency *latencyTracker
+    rounds uint64
+    upgrade UpgradeState
+    followers map[uint64]FollowerState
+    // First unchosen index last reported by each member, and whether successes skip those past a value
+    progress map[uint64]int
+    laggingFanout bool
+    // Last entry whose role change has been applied, and where applied changes are persisted
+    rolesIndex int
+    storeRoles func(int, []uint64) error
+    // Leader priority of each member, zero if unlisted
+    priorities map[uint64]uint64
+    // Topology label of each member, and when each peer across a WAN was last sent a heartbeat
+    labels map[uint64]string
+    pulses map[uint64]time.Time
+    reachability *reachability
     exclude sync.Mutex
 }
 
+// Member of the cluster; roleId never changes, and the connection state is guarded by exclude
 type Peer struct {
     roleId uint64
+    host string
     address string
     comm *rpc.Client
-    requirePromise bool
+    capabilities capabilities
+    // Set while the peer has been unreachable past the quarantine threshold
+    quarantined bool
+    // Set when comm is a multiplexed connection the peer dialed
+    inbound bool
+    exclude sync.Mutex
 }
 
+// Reply of one peer to a request; Error is set instead of Data if the peer failed to answer.
+// Broadcasts hand callers typed results instead; see PromiseResult
 type Response struct {
     Data interface{}
+    RoleId uint64
+    Error error
+    // Broadcast the reply answers, if any
+    source *round
 }
 
-func ConstructCluster(roleId uint64, disk *recovery.Manager) (*Cluster, uint64, string, error) {
-    addresses, err := disk.RetrieveAddresses()
-    if err != nil { return nil, 0, "", err }
+func ConstructCluster(settings *config.Config, events *hooks.Hooks) (*Cluster, uint64, string, error) {
+    roleId := settings.RoleId
+    addresses := settings.Peers
+    var err error
+
+    // Resolves membership from DNS; unresolved members are connected once discovered
+    if len(settings.Discovery.Name) != 0 {
+        discovered, err := LookupPeers(settings.Discovery.Name)
+        if err != nil { return nil, 0, "", err }
+        addresses = make(map[uint64]string)
+        for id := uint64(1); id <= settings.Discovery.Size; id++ {
+            addresses[id] = discovered[id]
+        }
+    }
 
     // Builds peers map
-    peers := make(map[uint64]Peer)
-    for id, address := range addresses {
+    peers := make(map[uint64]*Peer)
+    for id, host := range addresses {
+        address := host
+        if len(host) != 0 {
+            address, err = resolveAddress(host)
+            if err != nil { return nil, 0, "", err }
+        }
         newPeer := Peer {
             roleId: id,
+            host: host,
             address: address,
             comm: nil,
-            requirePromise: true,
         }
-        peers[id] = newPeer
+        peers[id] = &newPeer
     }
 
     // Auto-detects roleId
     if roleId == 0 {
-        // Finds IPv4 address of current machine
-        name, err := os.Hostname()
+        // Finds IPv4 and IPv6 addresses of current machine
+        thisAddresses, err := localAddresses()
         if err != nil { return nil, 0, "", err }
-        ipInfo, err := net.LookupIP(name)
-        if err != nil { return nil, 0, "", err }
-        thisAddress := ""
-        for _, ip := range ipInfo {
-            ipv4 := ip.To4()
-            if ipv4 != nil {
-                thisAddress = ip.String()
-                break
-            }
-        }
 
-        // Matches address to roleId
-        for id, fullAddress := range addresses {
-            ip, _, err := net.SplitHostPort(fullAddress)
+        // Matches address to roleId; Unix domain sockets cannot identify a machine
+        for id, peer := range peers {
+            network, target := splitAddress(peer.address)
+            if len(peer.address) == 0 || network == "unix" { continue }
+            ip, _, err := net.SplitHostPort(target)
             if err != nil { return nil, 0, "", err }
-            if thisAddress == ip {
+            if thisAddresses[ip] {
                 roleId = id
                 break
             }
         }
 
         if roleId == 0 {
-            return nil, 0, "", fmt.Errorf("Could not find address %s in peers table", thisAddress)
+            return nil, 0, "", fmt.Errorf("Could not find any address of this machine in peers table")
         }
     }
 
+    transport, err := constructTransport(settings, roleId)
+    if err != nil { return nil, 0, "", err }
+
     newCluster := Cluster {
         roleId: roleId,
-        nodes: peers,
         registerBadConnection: make(chan uint64, 16),
-        skipPromiseCount: 0,
-        disk: disk,
+        lease: constructPromiseLease(),
+        // Shared with the transport, whose handshakes are bounded by the RPC timeout
+        timeouts: transport.timeouts,
+        quarantine: settings.Quarantine,
+        transport: transport,
+        events: events,
+        clock: clock.OrReal(settings.Clock),
+        random: clock.OrRandom(settings.Rand),
+        latency: constructLatencyTracker(),
+        followers: make(map[uint64]FollowerState),
+        progress: make(map[uint64]int),
+        laggingFanout: settings.CatchUp.Fanout == "lagging",
+        rolesIndex: -1,
+        priorities: settings.Priorities,
+        labels: settings.Labels,
+        pulses: make(map[uint64]time.Time),
+        reachability: constructReachability(),
+    }
+    transport.inbound = newCluster.adopt
+    newCluster.membership.Store(&membership{peers: peers, learners: make(map[uint64]bool), quorum: settings.Quorum, epoch: -1})
+    transport.epoch = newCluster.GetEpoch
+    if events != nil {
+        events.OnApplyEntry(newCluster.observeRoleChange)
     }
 
-    address := newCluster.nodes[newCluster.roleId].address
+    address := newCluster.GetPeerAddress(newCluster.roleId)
+    if len(address) == 0 {
+        return nil, 0, "", fmt.Errorf("Address of role %d could not be discovered", roleId)
+    }
 
     go newCluster.connectionManager()
+    if len(settings.Discovery.Name) != 0 {
+        go newCluster.discoverPeers(settings.Discovery.Name, settings.Discovery.Interval)
+    }
 
     return &newCluster, newCluster.roleId, address, nil
 }
 
 // Sets server to listen on this node's port
 func (this *Cluster) Listen(handler *rpc.Server) error {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
+    address := this.GetPeerAddress(this.roleId)
+
+    // Peers dialed by this node send their requests back over the same connection
+    this.transport.handler = handler
+    return serve(this.roleId, this.transport, address, handler)
+}
+
+// Serves client and administrative requests arriving at the specified address, apart from
+// peer traffic; see constructClientTransport
+func ServeClients(roleId uint64, address string, settings *config.Config, handler *rpc.Server) error {
+    transport, err := constructClientTransport(settings, roleId, true)
+    if err != nil { return err }
+    return serve(roleId, transport, address, handler)
+}
 
-    // Listens on specified address
-    ln, err := net.Listen("tcp", this.nodes[this.roleId].address)
+// Serves requests arriving at the specified address using the transport settings of the
+// configuration, for nodes such as learners which are not cluster members
+func Serve(roleId uint64, address string, settings *config.Config, handler *rpc.Server) error {
+    transport, err := constructTransport(settings, roleId)
+    if err != nil { return err }
+    return serve(roleId, transport, address, handler)
+}
+
+// Opens an RPC connection to a node which is not a cluster member, such as a learner
+func (this *Cluster) DialNode(address string) (*rpc.Client, error) {
+    connection, _, err := this.transport.dial(address)
+    return connection, err
+}
+
+func serve(roleId uint64, transport *transport, address string, handler *rpc.Server) error {
+    ln, err := transport.listen(address)
     if err != nil { return err }
 
-    fmt.Println("[ NETWORK", this.roleId, "] Listening on", this.nodes[this.roleId].address)
+    fmt.Println("[ NETWORK", roleId, "] Listening on", address)
 
     // Dispatches connection processing loop
     go func() {
         for {
             connection, err := ln.Accept()
             if err != nil { continue }
-            go handler.ServeConn(connection)
+            go func() {
+                prepared, agreed, err := transport.accept(connection)
+                if err != nil {
+                    connection.Close()
+                    return
+                }
+                transport.serveConn(handler, prepared, agreed)
+            }()
         }
     }()
 
     return nil
 }
 
+// Returns the address of the specified peer, or an empty string if it is not a member
+func (this *Cluster) GetPeerAddress(roleId uint64) string {
+    peer := this.members().peers[roleId]
+    if peer == nil { return "" }
+    peer.exclude.Lock()
+    defer peer.exclude.Unlock()
+
+    return peer.address
+}
+
+// Reports whether the connection to a peer supports an optional feature; messages relying
+// on a feature must not be sent to peers without it
+func (this *Cluster) PeerSupports(roleId uint64, feature uint64) bool {
+    if roleId == this.roleId {
+        return true
+    }
+    peer := this.members().peers[roleId]
+    if peer == nil { return false }
+    _, agreed := peer.connection()
+    return agreed.supports(feature)
+}
+
+// Reports whether this node holds a connection to a peer, and so knows the features it supports
+func (this *Cluster) IsConnected(roleId uint64) bool {
+    if roleId == this.roleId {
+        return true
+    }
+    peer := this.members().peers[roleId]
+    if peer == nil { return false }
+    comm, _ := peer.connection()
+    return comm != nil
+}
+
+// Takes reloaded timeouts, which bound requests and handshakes from now on
+func (this *Cluster) SetTimeouts(timeouts config.Timeouts) {
+    this.timeouts.Set(timeouts)
+}
+
 // Initializes connections to cluster peers
 func (this *Cluster) Connect() {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
-    for roleId, peer := range this.nodes {
-        connection, err := rpc.Dial("tcp", peer.address)
+    for roleId, peer := range this.members().peers {
+        connection, agreed, err := this.transport.dial(peer.getAddress())
+        if err == nil {
+            err = this.checkPeer(roleId, agreed)
+            if err != nil {
+                fmt.Println("[ NETWORK", this.roleId, "] Refusing connection:", err)
+                connection.Close()
+            }
+        }
         if err != nil {
             this.registerBadConnection <- roleId
         } else {
-            peer.comm = connection
-            this.nodes[roleId] = peer
+            this.install(peer, connection, agreed, false)
         }
     }
+    this.updateUpgradeState()
+}
+
+// Sends requests to a peer over a connection it dialed, if multiplexed; see install
+func (this *Cluster) adopt(roleId uint64, connection *rpc.Client, agreed capabilities) {
+    peer := this.members().peers[roleId]
+    if peer == nil || roleId == this.roleId { return }
+    err := this.checkInstance(peer, agreed)
+    if err != nil {
+        fmt.Println("[ NETWORK", this.roleId, "] Refusing connection:", err)
+        connection.Close()
+        return
+    }
+    if this.install(peer, connection, agreed, true) {
+        fmt.Println("[ NETWORK", this.roleId, "] Sharing connection dialed by", roleId)
+        this.updateUpgradeState()
+    }
+}
+
+// Uses a connection to send requests to a peer, closing the one it replaces; reports whether
+// it was used. When both peers dial each other over multiplexed connections, both keep the
+// one dialed by the lower roleId and close the other, so each pair shares one connection
+func (this *Cluster) install(peer *Peer, connection *rpc.Client, agreed capabilities, inbound bool) bool {
+    peer.exclude.Lock()
+    if peer.comm != nil && peer.inbound != inbound {
+        dialer := this.roleId
+        if peer.inbound {
+            dialer = peer.roleId
+        }
+        if dialer < this.roleId || (dialer == this.roleId && this.roleId < peer.roleId) {
+            peer.exclude.Unlock()
+            connection.Close()
+            return false
+        }
+    }
+    if peer.comm != nil {
+        peer.comm.Close()
+    }
+    peer.comm = connection
+    peer.capabilities = agreed
+    peer.inbound = inbound
+    lifted := peer.quarantined
+    peer.quarantined = false
+    peer.exclude.Unlock()
+
+    if lifted {
+        this.lift(peer.roleId)
+    }
+    this.latency.connect(peer.roleId)
+    return true
 }
 
 // Triages connection complaints, organizes repair attempts
@@ -153,92 +358,124 @@ func (this *Cluster) connectionManager() {
 
 // Attempts to re-connect to the specified role
 func (this *Cluster) establishConnection(roleId uint64, connectionEstablished chan<- uint64) {
-    this.exclude.Lock()
-    peer := this.nodes[roleId]
-    this.exclude.Unlock()
-
+    since := this.clock.Now()
     for {
-        connection, err := rpc.Dial("tcp", peer.address)
+        // Reads address on each attempt, as it may be changed by peer discovery or DNS
+        peer := this.members().peers[roleId]
+        if peer == nil { return }
+
+        address, err := resolveAddress(peer.getHost())
         if err != nil {
-            time.Sleep(time.Second)
+            this.awaitRetry(roleId, since)
             continue
         }
 
-        this.exclude.Lock()
-        peer = this.nodes[roleId] 
-        peer.comm = connection
-        this.nodes[roleId] = peer
+        connection, agreed, err := this.transport.dial(address)
+        if err != nil {
+            this.awaitRetry(roleId, since)
+            continue
+        }
+        err = this.checkPeer(roleId, agreed)
+        if err != nil {
+            fmt.Println("[ NETWORK", this.roleId, "] Refusing connection:", err)
+            connection.Close()
+            this.awaitRetry(roleId, since)
+            continue
+        }
+        if agreed.version < ProtocolVersion {
+            fmt.Println("[ NETWORK", this.roleId, "] Peer", roleId, "speaks protocol version", agreed.version)
+        }
+
+        if this.members().peers[roleId] != peer {
+            connection.Close()
+            return
+        }
+        peer.exclude.Lock()
+        if peer.address != address {
+            fmt.Println("[ NETWORK", this.roleId, "] Peer", roleId, "resolved to new address", address)
+            peer.address = address
+        }
+        peer.exclude.Unlock()
+        // Connections made as members start up are not reconnections
+        reconnect := this.latency.hasAnswered(roleId)
+        if this.install(peer, connection, agreed, false) && reconnect {
+            this.journal.Record(this.roleId, journal.PeerReconnected, "Reconnected to peer %d at %s, %v after the connection failed",
+                                roleId, address, this.clock.Now().Sub(since).Round(time.Millisecond))
+        }
+        this.updateUpgradeState()
         connectionEstablished <- roleId
-        this.exclude.Unlock()
         return
     }
 }
 
 // Returns number of peers in cluster
 func (this *Cluster) GetPeerCount() uint64 {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
-    return uint64(len(this.nodes))
+    return uint64(len(this.members().peers))
 }
 
-// Returns number of peers from which no promise is required
-func (this *Cluster) GetSkipPromiseCount() uint64 {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
-    return this.skipPromiseCount
+// Returns number of peers required to form a quorum
+func (this *Cluster) GetQuorumSize() uint64 {
+    return this.members().quorumSize()
 }
 
-// Mark whether a promise is required from a node before sending accept requests
-func (this *Cluster) SetPromiseRequirement(roleId uint64, required bool) {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
-    peer := this.nodes[roleId]
+// Returns number of peers required to choose a value in a fast round: large enough that any
+// quorum holds a majority of the acceptors of a value which a fast quorum may have chosen
+func (this *Cluster) GetFastQuorumSize() uint64 {
+    members := this.members()
+    return FastQuorumSize(uint64(len(members.peers)), members.quorumSize())
+}
 
-    // Value will be updated; therefore, update skipPromiseCount
-    if peer.requirePromise != required {
-        if required {
-            this.skipPromiseCount--
-        } else {
-            this.skipPromiseCount++
-        }
+// Returns the fast quorum size of a cluster of the given size and quorum size
+func FastQuorumSize(peerCount uint64, quorumSize uint64) uint64 {
+    fastQuorumSize := (2*peerCount-quorumSize)/2+1
+    if fastQuorumSize < quorumSize {
+        fastQuorumSize = quorumSize
     }
-
-    peer.requirePromise = required
-    this.nodes[roleId] = peer
+    if fastQuorumSize > peerCount {
+        fastQuorumSize = peerCount
+    }
+    return fastQuorumSize
 }
 
-// Sends pulse to all nodes in the cluster
+// Sends pulse to all nodes in the cluster due one, recording the follower state of those which
+// reply with it
 func (this *Cluster) BroadcastHeartbeat(roleId uint64) {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
-    peerCount := len(this.nodes)
-    endpoint := make(chan *rpc.Call, peerCount)
-    for _, peer := range this.nodes {
-        if peer.comm != nil {
+    members := this.members()
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    sent := make(map[uint64]bool)
+    for _, peer := range members.peers {
+        if !this.heartbeatDue(peer.roleId) { continue }
+        sent[peer.roleId] = true
+        comm, agreed := peer.connection()
+        if comm == nil { continue }
+        if agreed.supports(FeatureFollowerState) {
+            var reply FollowerState
+            this.goRemote(peer.roleId, comm, "ProposerRole.HeartbeatState", &roleId, &reply, endpoint)
+        } else {
             var reply uint64
-            peer.comm.Go("ProposerRole.Heartbeat", &roleId, &reply, endpoint)
+            this.goRemote(peer.roleId, comm, "ProposerRole.Heartbeat", &roleId, &reply, endpoint)
         }
     }
 
     // Records nodes which return the heartbeat signal
     received := make(map[uint64]bool)
     failures := false
+    peerCount := len(sent)
     replyCount := 0
+    wait := this.heartbeatWait(sent)
     for replyCount < peerCount {
         select {
         case reply := <- endpoint:
-            if reply.Error == nil {
-                id := *reply.Reply.(*uint64)
-                received[id] = true 
-            } else {
+            if reply.Error != nil {
                 failures = true
+            } else if state, ok := reply.Reply.(*FollowerState); ok {
+                received[state.RoleId] = true
+                this.observeFollower(*state)
+            } else {
+                received[*reply.Reply.(*uint64)] = true
             }
             replyCount++
-        case <- time.After(time.Second/2):
+        case <- this.clock.After(wait):
             failures = true
             replyCount = peerCount
         }
@@ -246,35 +483,37 @@ func (this *Cluster) BroadcastHeartbeat(roleId uint64) {
     
     // Registers bad connections if reply was not received
     if failures {
-        for roleId := range this.nodes {
+        for roleId := range sent {
             if !received[roleId] {
-                peer := this.nodes[roleId]
-                if !peer.requirePromise {
-                    this.skipPromiseCount--
-                }
-                peer.requirePromise = true
-                this.nodes[roleId] = peer
+                this.RevokePromise(roleId)
                 this.registerBadConnection <- roleId
             }
         }
     }
+    this.observeHeartbeats(sent, received)
 }
 
-// Broadcasts a prepare phase request to the cluster
-func (this *Cluster) BroadcastPrepareRequest(request acceptor.PrepareReq) (uint64, <-chan Response) {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
+// Broadcasts a prepare phase request to the voters whose promises to the proposal are not held;
+// returns the number of peers sent the request and the number whose promises are held, read
+// together so a promise recorded meanwhile by a concurrent round is not counted twice
+func (this *Cluster) broadcastPrepare(request acceptor.PrepareReq) (uint64, uint64, <-chan Response) {
+    members := this.members()
     peerCount := uint64(0)
-    nodeCount := uint64(len(this.nodes))
-    endpoint := make(chan *rpc.Call, nodeCount)
-
-    if this.skipPromiseCount < nodeCount/2+1 {
-        for _, peer := range this.nodes {
-            if peer.requirePromise && peer.comm != nil {
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    promised := this.getPromised(request.ProposalId)
+
+    current := this.beginRound()
+    request.Round = current.id
+    // Safety rests on each acceptor refusing accepts below its promise, not on the lease: a
+    // promise held past the acceptor's later one only costs a refused accept
+    if uint64(len(promised)) < members.quorumSize() {
+        for _, peer := range this.rankPeers(members) {
+            if !promised[peer.roleId] && !members.learners[peer.roleId] {
                 var response acceptor.PrepareResp
-                peer.comm.Go("AcceptorRole.Prepare", &request, &response, endpoint)
-                peerCount++
+                if this.sendRanked(peer, "AcceptorRole.Prepare", &request, &response, endpoint) {
+                    current.expect(&response, peer.roleId)
+                    peerCount++
+                }
             } 
         }
     } else {
@@ -283,53 +522,123 @@ func (this *Cluster) BroadcastPrepareRequest(request acceptor.PrepareReq) (uint6
 
 
     responses := make(chan Response, peerCount)
-    go this.wrapReply(peerCount, endpoint, responses)
-    return peerCount, responses 
+    go this.wrapReply(current, peerCount, endpoint, responses)
+    return peerCount, uint64(len(promised)), responses 
 }
 
 // Broadcasts a proposal phase request to the cluster
-func (this *Cluster) BroadcastProposalRequest(request acceptor.ProposalReq, filter map[uint64]bool) (uint64, <-chan Response) {
-    this.exclude.Lock()
-    defer this.exclude.Unlock()
-
+func (this *Cluster) broadcastAccept(request acceptor.ProposalReq, filter map[uint64]bool) (uint64, <-chan Response) {
+    members := this.members()
     peerCount := uint64(0)
-    endpoint := make(chan *rpc.Call, len(this.nodes)) 
-    for roleId, peer := range this.nodes {
-        if !filter[roleId] && peer.comm != nil {
+    endpoint := make(chan *rpc.Call, len(members.peers)) 
+    current := this.beginRound()
+    request.Round = current.id
+    for _, peer := range this.rankPeers(members) {
+        if !filter[peer.roleId] {
             var response acceptor.ProposalResp
-            peer.comm.Go("AcceptorRole.Accept", &request, &response, endpoint)
-            peerCount++
+            if this.sendRanked(peer, "AcceptorRole.Accept", &request, &response, endpoint) {
+                current.expect(&response, peer.roleId)
+                peerCount++
+            }
         }
     }
 
     responses := make(chan Response, peerCount)
-    go this.wrapReply(peerCount, endpoint, responses)
+    go this.wrapReply(current, peerCount, endpoint, responses)
     return peerCount, responses 
 }
 
+// Broadcasts an owner's proposal for its own Mencius slots. The local acceptor accepts first,
+// so a restarted owner finds every slot it claimed in its own log and never reuses one
+func (this *Cluster) broadcastOwned(request acceptor.OwnedReq) (uint64, <-chan Response) {
+    members := this.members()
+    peerCount := uint64(0)
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    current := this.beginRound()
+    request.Round = current.id
+    if this.local != nil {
+        var response acceptor.OwnedResp
+        call := rpc.Call {
+            ServiceMethod: "AcceptorRole.AcceptOwned",
+            Args: &request,
+            Reply: &response,
+            Done: endpoint,
+        }
+        call.Error = this.invokeLocal(call.ServiceMethod, &request, &response)
+        if call.Error != nil || !response.AcceptedAll(len(request.Entries)) {
+            // Remote acceptors may not accept what the local acceptor refused
+            responses := make(chan Response, 1)
+            responses <- Response{&response, this.roleId, call.Error, nil}
+            close(responses)
+            return 1, responses
+        }
+        current.expect(&response, this.roleId)
+        endpoint <- &call
+        peerCount++
+    }
+    for _, peer := range this.rankPeers(members) {
+        if peer.roleId == this.roleId { continue }
+        var response acceptor.OwnedResp
+        if this.sendRanked(peer, "AcceptorRole.AcceptOwned", &request, &response, endpoint) {
+            current.expect(&response, peer.roleId)
+            peerCount++
+        }
+    }
+
+    responses := make(chan Response, peerCount)
+    go this.wrapReply(current, peerCount, endpoint, responses)
+    return peerCount, responses
+}
+
+// Broadcasts a value proposed in a fast round
+func (this *Cluster) broadcastFast(request acceptor.FastReq) (uint64, <-chan Response) {
+    members := this.members()
+    peerCount := uint64(0)
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    current := this.beginRound()
+    request.Round = current.id
+    for _, peer := range this.rankPeers(members) {
+        var response acceptor.FastResp
+        if this.sendRanked(peer, "AcceptorRole.AcceptFast", &request, &response, endpoint) {
+            current.expect(&response, peer.roleId)
+            peerCount++
+        }
+    }
+
+    responses := make(chan Response, peerCount)
+    go this.wrapReply(current, peerCount, endpoint, responses)
+    return peerCount, responses
+}
+
 // Directly notifies a specific node of a chosen value
-func (this *Cluster) NotifyOfSuccess(roleId uint64, info acceptor.SuccessNotify) <-chan Response {
+func (this *Cluster) notifyOfSuccess(roleId uint64, info acceptor.SuccessNotify) <-chan Response {
+    peerCount := uint64(0)
     endpoint := make(chan *rpc.Call, 1)
+    current := this.beginRound()
     var firstUnchosenIndex int
-    this.nodes[roleId].comm.Go("AcceptorRole.Success", &info, &firstUnchosenIndex, endpoint)
+    if this.send(this.members().peers[roleId], "AcceptorRole.Success", &info, &firstUnchosenIndex, endpoint) {
+        current.expect(&firstUnchosenIndex, roleId)
+        peerCount++
+    }
 
-    response := make(chan Response)
-    go this.wrapReply(1, endpoint, response)
+    response := make(chan Response, 1)
+    go this.wrapReply(current, peerCount, endpoint, response)
     return response
 }
 
-// Wraps RPC return data to remove direct dependency of caller on net/rpc and improve testability
-func (this *Cluster) wrapReply(peerCount uint64, endpoint <-chan *rpc.Call, forward chan<- Response) {
-    replyCount := uint64(0)
-    for replyCount < peerCount {
-        select {
-        case reply := <- endpoint:
-            if reply.Error == nil {
-                forward <- Response{reply.Reply}
-            }
-            replyCount++
-        case <- time.After(2*time.Second):
-            return
-        }
+// Directly notifies a specific node of chosen values for a range of entries; the node must
+// support FeatureSuccessBatch
+func (this *Cluster) notifyOfSuccessBatch(roleId uint64, info acceptor.SuccessBatchNotify) <-chan Response {
+    peerCount := uint64(0)
+    endpoint := make(chan *rpc.Call, 1)
+    current := this.beginRound()
+    var firstUnchosenIndex int
+    if this.send(this.members().peers[roleId], "AcceptorRole.SuccessBatch", &info, &firstUnchosenIndex, endpoint) {
+        current.expect(&firstUnchosenIndex, roleId)
+        peerCount++
     }
+
+    response := make(chan Response, 1)
+    go this.wrapReply(current, peerCount, endpoint, response)
+    return response
 }
@@ -0,0 +1,123 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "errors"
+    "sync"
+    "strings"
+    "net/rpc"
+    "sync/atomic"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/metrics"
+)
+
+var replyStats = metrics.Group("replies")
+
+// Failure of a peer to answer a request before the broadcast timed out
+var ErrTimeout = errors.New("Failure: peer did not reply in time")
+
+// Replies expected from one broadcast. The round ID is carried in prepare and accept
+// requests and echoed by acceptors, so a reply can be matched to the broadcast it answers.
+// A round is abandoned once its caller has decided the outcome, and its stragglers ignored
+type round struct {
+    id uint64
+    senders map[interface{}]uint64
+    abandoned chan struct{}
+    once sync.Once
+}
+
+// Begins a broadcast round
+func (this *Cluster) beginRound() *round {
+    newRound := round {
+        id: atomic.AddUint64(&this.rounds, 1),
+        senders: make(map[interface{}]uint64),
+        abandoned: make(chan struct{}),
+    }
+    return &newRound
+}
+
+// Stops collecting replies for the round; safe to call more than once, or on no round
+func (this *round) abandon() {
+    if this == nil { return }
+    this.once.Do(func() { close(this.abandoned) })
+}
+
+// Records that a reply is expected from a peer; must be called before the round's replies
+// are collected
+func (this *round) expect(reply interface{}, roleId uint64) {
+    this.senders[reply] = roleId
+}
+
+// Returns the round echoed in a reply; zero if the reply does not carry one, as from
+// acceptors which predate round IDs
+func echoedRound(reply interface{}) uint64 {
+    switch reply := reply.(type) {
+    case *acceptor.PrepareResp:
+        return reply.Round
+    case *acceptor.ProposalResp:
+        return reply.Round
+    case *acceptor.OwnedResp:
+        return reply.Round
+    case *acceptor.FastResp:
+        return reply.Round
+    }
+    return 0
+}
+
+// Wraps RPC return data to remove direct dependency of caller on net/rpc and improve testability.
+// Forwards at most one reply per peer, discarding replies which were not expected or which
+// echo a different round, so late replies can never be counted toward another quorum. Peers
+// whose calls fail are forwarded with the error, and peers yet to answer when the broadcast
+// times out with ErrTimeout; forward is then closed, so callers never wait on a dead round.
+// An abandoned round closes forward at once, without reporting the peers yet to answer.
+// forward must be buffered for every peer
+func (this *Cluster) wrapReply(current *round, peerCount uint64, endpoint <-chan *rpc.Call, forward chan<- Response) {
+    defer guard.Recover("NETWORK", this.roleId, "Cluster.wrapReply", nil)
+    defer close(forward)
+    answered := make(map[uint64]bool)
+    replyCount := uint64(0)
+    for replyCount < peerCount {
+        select {
+        case reply := <- endpoint:
+            roleId, expected := current.senders[reply.Reply]
+            if !expected {
+                replyStats.Add("stray", 1)
+                continue
+            }
+            if answered[roleId] {
+                replyStats.Add("duplicate", 1)
+                continue
+            }
+            answered[roleId] = true
+            replyCount++
+            if reply.Error != nil {
+                forward <- Response{nil, roleId, reply.Error, current}
+                continue
+            }
+            echoed := echoedRound(reply.Reply)
+            if echoed != 0 && echoed != current.id {
+                fmt.Println("[ NETWORK", this.roleId, "] Discarding reply from", roleId, "to round", echoed, "in round", current.id)
+                replyStats.Add("stale", 1)
+                continue
+            }
+            forward <- Response{reply.Reply, roleId, nil, current}
+        case <- current.abandoned:
+            replyStats.Add("abandoned", int64(peerCount-replyCount))
+            return
+        case <- this.clock.After(2*this.roundTimeouts().Rpc):
+            for _, roleId := range current.senders {
+                if !answered[roleId] {
+                    replyStats.Add("timeout", 1)
+                    forward <- Response{nil, roleId, ErrTimeout, current}
+                }
+            }
+            return
+        }
+    }
+}
+
+// Reports whether an error is a peer's failure to reply in time
+func IsTimeout(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrTimeout.Error())
+}
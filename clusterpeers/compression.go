@@ -0,0 +1,114 @@
+package clusterpeers
+
+import (
+    "io"
+    "net"
+    "sync"
+    "time"
+    "expvar"
+    "compress/flate"
+    "github/paxoscluster/metrics"
+)
+
+// Compression algorithms negotiated when a connection is opened
+const (
+    compressionNone byte = 0
+    compressionFlate byte = 1
+)
+
+// Compression ratio is compressedBytes/uncompressedBytes; CPU cost is reported in nanoseconds
+var compressionStats = metrics.Group("compression")
+
+func init() {
+    compressionStats.Set("ratio", expvar.Func(func() interface{} {
+        return metrics.Ratio(compressionStats, "compressedBytes", "uncompressedBytes")
+    }))
+}
+
+// Maps a configured algorithm name to its wire identifier
+func compressionAlgorithm(name string) byte {
+    if name == "flate" {
+        return compressionFlate
+    }
+    return compressionNone
+}
+
+func wrapCompression(connection net.Conn, algorithm byte) net.Conn {
+    if algorithm != compressionFlate {
+        return connection
+    }
+
+    counter := countingWriter{connection}
+    writer, _ := flate.NewWriter(counter, flate.BestSpeed)
+    source := timedReader{connection, 0}
+    newConnection := compressedConn {
+        Conn: connection,
+        source: &source,
+        reader: flate.NewReader(&source),
+        writer: writer,
+    }
+    return &newConnection
+}
+
+// Connection which compresses each message written and decompresses the incoming stream
+type compressedConn struct {
+    net.Conn
+    source *timedReader
+    reader io.ReadCloser
+    writer *flate.Writer
+    exclude sync.Mutex
+}
+
+// Compresses a message, flushing so the peer can decode it without waiting for more data
+func (this *compressedConn) Write(data []byte) (int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    start := time.Now()
+    written, err := this.writer.Write(data)
+    if err == nil {
+        err = this.writer.Flush()
+    }
+    compressionStats.Add("compressNanoseconds", int64(time.Since(start)))
+    compressionStats.Add("uncompressedBytes", int64(written))
+    return written, err
+}
+
+// Decompresses incoming data; time spent waiting on the network is excluded from the CPU cost
+func (this *compressedConn) Read(data []byte) (int, error) {
+    start := time.Now()
+    waited := this.source.waiting
+    read, err := this.reader.Read(data)
+    elapsed := time.Since(start) - (this.source.waiting - waited)
+    compressionStats.Add("decompressNanoseconds", int64(elapsed))
+    return read, err
+}
+
+func (this *compressedConn) Close() error {
+    this.reader.Close()
+    return this.Conn.Close()
+}
+
+// Records time spent blocked reading compressed data from the network
+type timedReader struct {
+    reader io.Reader
+    waiting time.Duration
+}
+
+func (this *timedReader) Read(data []byte) (int, error) {
+    start := time.Now()
+    read, err := this.reader.Read(data)
+    this.waiting += time.Since(start)
+    return read, err
+}
+
+// Counts bytes written to the network after compression
+type countingWriter struct {
+    writer io.Writer
+}
+
+func (this countingWriter) Write(data []byte) (int, error) {
+    written, err := this.writer.Write(data)
+    compressionStats.Add("compressedBytes", int64(written))
+    return written, err
+}
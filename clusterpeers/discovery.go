@@ -0,0 +1,89 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "net"
+    "time"
+    "strconv"
+    "strings"
+)
+
+// Resolves peer addresses from a DNS SRV name. Each target's roleId is one more than
+// the ordinal suffix of its first label (e.g. pxs-0.pxs.default.svc resolves to role 1),
+// matching the stable names given to StatefulSet pods
+func LookupPeers(name string) (map[uint64]string, error) {
+    _, records, err := net.LookupSRV("", "", name)
+    if err != nil { return nil, err }
+
+    addresses := make(map[uint64]string)
+    for _, record := range records {
+        roleId, err := ordinalRoleId(record.Target)
+        if err != nil { return nil, err }
+
+        // Resolves target to an IP so address changes are visible between lookups
+        host := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+        addresses[roleId], err = resolveAddress(host)
+        if err != nil { return nil, err }
+    }
+
+    return addresses, nil
+}
+
+// Derives a roleId from the ordinal suffix of a hostname
+func ordinalRoleId(target string) (uint64, error) {
+    label := strings.SplitN(target, ".", 2)[0]
+    separator := strings.LastIndex(label, "-")
+    ordinal, err := strconv.ParseUint(label[separator+1:], 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("SRV target %s has no ordinal suffix", target)
+    }
+    return ordinal+1, nil
+}
+
+// Periodically re-resolves the SRV name, redirecting connections to peers whose address changed
+func (this *Cluster) discoverPeers(name string, interval time.Duration) {
+    for {
+        this.clock.Sleep(interval)
+
+        addresses, err := LookupPeers(name)
+        if err != nil {
+            fmt.Println("[ NETWORK", this.roleId, "] Peer discovery failed:", err)
+            continue
+        }
+
+        for roleId, address := range addresses {
+            peer := this.members().peers[roleId]
+            if peer == nil {
+                fmt.Println("[ NETWORK", this.roleId, "] Ignoring discovered peer", roleId, "outside membership")
+            } else if peer.getAddress() != address {
+                this.UpdatePeerAddress(roleId, address)
+            }
+        }
+    }
+}
+
+// Changes the address of a peer and reconnects to it; the address may be a hostname,
+// which is re-resolved on every reconnection attempt
+func (this *Cluster) UpdatePeerAddress(roleId uint64, host string) error {
+    address, err := resolveAddress(host)
+    if err != nil { return err }
+
+    peer := this.members().peers[roleId]
+    if peer == nil {
+        return fmt.Errorf("Role %d is not a member of the cluster", roleId)
+    }
+    peer.exclude.Lock()
+    fmt.Println("[ NETWORK", this.roleId, "] Peer", roleId, "moved from", peer.address, "to", address)
+    comm := peer.comm
+    peer.comm = nil
+    peer.host = host
+    peer.address = address
+    peer.exclude.Unlock()
+    if comm != nil {
+        comm.Close()
+    }
+
+    this.events.MembershipChange(roleId, address)
+    this.registerBadConnection <- roleId
+    return nil
+}
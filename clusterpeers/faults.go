@@ -0,0 +1,153 @@
+package clusterpeers
+
+import (
+    "sync"
+    "time"
+    "net/rpc"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/metrics"
+)
+
+var faultStats = metrics.Group("faults")
+
+// Faults injected into messages sent to peers by every cluster in this process; in-process
+// clusters share it, while separate processes each control the messages they send
+var Faults = constructFaultInjector()
+
+// Direction of travel between two roles
+type link struct {
+    from uint64
+    to uint64
+}
+
+// Drops, delays, and reorders messages between peers to rehearse network failures. Faults
+// apply to requests as they are sent; dropped requests are never answered, so callers see
+// the same timeouts a real network failure would cause
+type FaultInjector struct {
+    partitions map[link]bool
+    delays map[link]time.Duration
+    dropRate float64
+    reorderWindow time.Duration
+    random clock.Rand
+    exclude sync.Mutex
+}
+
+func constructFaultInjector() *FaultInjector {
+    newFaultInjector := FaultInjector {
+        partitions: make(map[link]bool),
+        delays: make(map[link]time.Duration),
+        random: clock.NewRand(time.Now().UnixNano()),
+    }
+    return &newFaultInjector
+}
+
+// Replaces the source deciding which messages are dropped and how long they are held, so a
+// run of faults can be reproduced from a seed
+func (this *FaultInjector) SetRand(random clock.Rand) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.random = random
+}
+
+// Cuts all messages between two roles in both directions
+func (this *FaultInjector) Partition(a uint64, b uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.partitions[link{a, b}] = true
+    this.partitions[link{b, a}] = true
+}
+
+// Cuts messages from one role to another, leaving those in the other direction, to rehearse
+// an asymmetric partition
+func (this *FaultInjector) Cut(from uint64, to uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.partitions[link{from, to}] = true
+}
+
+// Restores messages between two roles in both directions
+func (this *FaultInjector) Heal(a uint64, b uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    delete(this.partitions, link{a, b})
+    delete(this.partitions, link{b, a})
+}
+
+// Holds messages from one role to another for the given duration; zero removes the delay
+func (this *FaultInjector) Delay(from uint64, to uint64, delay time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if delay <= 0 {
+        delete(this.delays, link{from, to})
+    } else {
+        this.delays[link{from, to}] = delay
+    }
+}
+
+// Drops each message with the given probability; zero stops dropping
+func (this *FaultInjector) Drop(rate float64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.dropRate = rate
+}
+
+// Holds each message for a random time within the window, so later messages may overtake
+// earlier ones; zero stops reordering
+func (this *FaultInjector) Reorder(window time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.reorderWindow = window
+}
+
+// Removes every fault
+func (this *FaultInjector) Reset() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.partitions = make(map[link]bool)
+    this.delays = make(map[link]time.Duration)
+    this.dropRate = 0
+    this.reorderWindow = 0
+}
+
+// Decides the fate of a message, returning whether it is delivered and how long it is held
+func (this *FaultInjector) apply(from uint64, to uint64) (bool, time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.partitions[link{from, to}] || (this.dropRate > 0 && this.random.Float64() < this.dropRate) {
+        faultStats.Add("dropped", 1)
+        return false, 0
+    }
+    delay := this.delays[link{from, to}]
+    if this.reorderWindow > 0 {
+        delay += time.Duration(this.random.Int63n(int64(this.reorderWindow)))
+    }
+    if delay > 0 {
+        faultStats.Add("delayed", 1)
+    }
+    return true, delay
+}
+
+// Starts an RPC to a peer subject to any injected faults
+func (this *Cluster) goRemote(roleId uint64, comm *rpc.Client, serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) {
+    start := this.clock.Now()
+    this.latency.issue(roleId)
+    deliver, delay := Faults.apply(this.roleId, roleId)
+    if !deliver { return }
+    if delay == 0 {
+        this.timeCall(roleId, comm, start, serviceMethod, args, reply, done)
+        return
+    }
+    go func() {
+        this.clock.Sleep(delay)
+        this.timeCall(roleId, comm, start, serviceMethod, args, reply, done)
+    }()
+}
@@ -0,0 +1,61 @@
+package clusterpeers
+
+import (
+    "sort"
+    "time"
+    "github/paxoscluster/proposal"
+)
+
+// State a node reports in reply to heartbeats, as last heard by this node
+type FollowerState struct {
+    RoleId uint64
+    // Greatest index chosen and applied to the node's log
+    CommitIndex int
+    AppliedIndex int
+    // Entries found corrupt in the node's storage and not yet repaired
+    CorruptEntries int
+    // Proposals the node holds in flight, a measure of its load
+    InFlight int
+    // Whether the node's storage is degraded, so it should not lead
+    StorageDegraded bool
+    // Proposal the node's acceptor has promised, which renews the leader's promise lease
+    MinProposalId proposal.Id
+    // Members whose heartbeats the node hears, and those its own heartbeats fail to reach; see
+    // Cluster.AsymmetricLinks
+    Heard []uint64
+    Unanswered []uint64
+    // Time this node received the state; zero in replies as sent
+    Received time.Time
+}
+
+// Records a heartbeat reply carrying follower state
+func (this *Cluster) observeFollower(state FollowerState) {
+    state.Received = this.clock.Now()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.followers[state.RoleId] = state
+    this.advance(state.RoleId, state.CommitIndex+1)
+    this.renewPromise(state.RoleId, state.MinProposalId)
+}
+
+// Returns the state last reported by every member other than this node which has answered a
+// heartbeat, ordered by roleId. States are kept when a peer stops answering, so Received tells
+// how current each is. Members report state only while heartbeats are broadcast, not with gossip,
+// and only if they support FeatureFollowerState
+func (this *Cluster) FollowerStates() []FollowerState {
+    members := this.members()
+
+    this.exclude.Lock()
+    states := make([]FollowerState, 0, len(this.followers))
+    for roleId, state := range this.followers {
+        if _, exists := members.peers[roleId]; exists && roleId != this.roleId {
+            states = append(states, state)
+        }
+    }
+    this.exclude.Unlock()
+
+    sort.Slice(states, func(i, j int) bool { return states[i].RoleId < states[j].RoleId })
+    return states
+}
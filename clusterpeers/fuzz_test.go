@@ -0,0 +1,123 @@
+package clusterpeers
+
+import (
+    "net"
+    "sync"
+    "time"
+    "bytes"
+    "testing"
+    "net/rpc"
+    "github/paxoscluster/config"
+)
+
+// Connection whose peer sends fixed bytes and ignores every reply
+type fuzzConn struct {
+    reader *bytes.Reader
+}
+
+func (this *fuzzConn) Read(data []byte) (int, error) { return this.reader.Read(data) }
+func (this *fuzzConn) Write(data []byte) (int, error) { return len(data), nil }
+func (this *fuzzConn) Close() error { return nil }
+func (this *fuzzConn) LocalAddr() net.Addr { return &net.IPAddr{} }
+func (this *fuzzConn) RemoteAddr() net.Addr { return &net.IPAddr{} }
+func (this *fuzzConn) SetDeadline(deadline time.Time) error { return nil }
+func (this *fuzzConn) SetReadDeadline(deadline time.Time) error { return nil }
+func (this *fuzzConn) SetWriteDeadline(deadline time.Time) error { return nil }
+
+// Connection recording every byte written to it
+type recordingConn struct {
+    net.Conn
+    written bytes.Buffer
+    exclude sync.Mutex
+}
+
+func (this *recordingConn) Write(data []byte) (int, error) {
+    this.exclude.Lock()
+    this.written.Write(data)
+    this.exclude.Unlock()
+    return this.Conn.Write(data)
+}
+
+// Message served by fuzzService, with the kinds of fields peer requests carry
+type FuzzMessage struct {
+    Index int
+    Value []byte
+    Values [][]byte
+    Peers map[uint64]string
+}
+
+type fuzzService struct {}
+
+func (this *fuzzService) Echo(req *FuzzMessage, reply *FuzzMessage) error {
+    *reply = *req
+    return nil
+}
+
+// Returns the transport of the node under test, serving fuzzService as the acceptor
+func fuzzTransport(roleId uint64, compression byte) transport {
+    server := rpc.NewServer()
+    server.RegisterName("AcceptorRole", &fuzzService{})
+    return transport {
+        roleId: roleId,
+        instance: 1,
+        clusterId: "fuzz",
+        compression: compression,
+        timeouts: config.ConstructLiveTimeouts(config.Default().Timeouts),
+        handler: server,
+        // The client created for the reverse channel reads it, so its frames are not left waiting
+        inbound: func(roleId uint64, connection *rpc.Client, agreed capabilities) {},
+        epoch: func() int { return 0 },
+    }
+}
+
+// Returns the bytes a peer sends to connect and make one request
+func recordConnection(t testing.TB) []byte {
+    client, server := net.Pipe()
+    defer client.Close()
+    defer server.Close()
+    local := fuzzTransport(1, compressionNone)
+    go func() {
+        negotiated, agreed, err := local.accept(server)
+        if err == nil {
+            local.serveConn(local.handler, negotiated, agreed)
+        }
+    }()
+
+    remote := fuzzTransport(2, compressionNone)
+    recorded := &recordingConn{Conn: client}
+    negotiated, agreed, err := negotiateClient(recorded, remote.features(), time.Second)
+    if err == nil {
+        _, err = remote.exchangeIdentity(negotiated, true, principal{unauthenticated: true})
+    }
+    if err != nil { t.Fatal(err) }
+    session := constructMuxSession(negotiated)
+    request := FuzzMessage{Index: 1, Value: []byte("a"), Values: [][]byte{[]byte("b")}, Peers: map[uint64]string{1: "localhost:10000"}}
+    var reply FuzzMessage
+    err = remote.client(session.channel(forwardChannel), agreed).Call("AcceptorRole.Echo", &request, &reply)
+    if err != nil { t.Fatal(err) }
+
+    recorded.exclude.Lock()
+    defer recorded.exclude.Unlock()
+    return append([]byte{}, recorded.written.Bytes()...)
+}
+
+// Serves data as the bytes a peer sends after connecting, through the version and feature
+// handshake, the identity exchange, multiplexing, and stamped RPCs; the first byte picks
+// whether flate compression is offered
+func FuzzConnection(f *testing.F) {
+    recorded := recordConnection(f)
+    f.Add(append([]byte{0}, recorded...))
+    f.Add(append([]byte{1}, recorded...))
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        if len(data) == 0 { return }
+        compression := compressionNone
+        if data[0]&1 != 0 {
+            compression = compressionFlate
+        }
+        local := fuzzTransport(1, compression)
+        negotiated, agreed, err := local.accept(&fuzzConn{bytes.NewReader(data[1:])})
+        if err != nil { return }
+        local.serveConn(local.handler, negotiated, agreed)
+    })
+}
@@ -0,0 +1,409 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "net/rpc"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+)
+
+var gossipStats = metrics.Group("gossip")
+
+// Most changes carried by one message besides the sender's own state
+const maxPiggyback = 16
+
+// Liveness of a member as known through gossip
+type MemberState int
+
+const (
+    MemberAlive MemberState = iota
+    // Failed to answer a direct and indirect probe; still counted as live until its
+    // suspicion expires unless it refutes it first
+    MemberSuspect
+    MemberDead
+)
+
+func (this MemberState) String() string {
+    switch this {
+    case MemberAlive:
+        return "alive"
+    case MemberSuspect:
+        return "suspect"
+    }
+    return "dead"
+}
+
+// State of a member disseminated by gossip. Incarnation orders claims about the member:
+// only the member raises it, to refute suspicion, and it begins at the member's start time so
+// a restarted member supersedes claims about its previous run
+type GossipMember struct {
+    RoleId uint64
+    Incarnation uint64
+    State MemberState
+    Address string
+    Version uint16
+    Features uint64
+}
+
+// Reports whether a claim about a member overrides the known one
+func (this GossipMember) overrides(known GossipMember) bool {
+    switch this.State {
+    case MemberAlive:
+        return this.Incarnation > known.Incarnation
+    case MemberSuspect:
+        return this.Incarnation > known.Incarnation || (this.Incarnation == known.Incarnation && known.State == MemberAlive)
+    }
+    return this.Incarnation >= known.Incarnation && known.State != MemberDead
+}
+
+// Probe sent to a member, carrying recent gossip; Target names the member to probe on the
+// sender's behalf, or is zero for a direct probe
+type GossipReq struct {
+    From uint64
+    Target uint64
+    Updates []GossipMember
+}
+
+type GossipResp struct {
+    Acked bool
+    Updates []GossipMember
+}
+
+// Known state of a member, with the gossip still to be spread about it
+type gossipEntry struct {
+    member GossipMember
+    suspected time.Time
+    transmits int
+}
+
+// SWIM failure detector and dissemination layer. Each interval a node probes one member in
+// turn, asking others to probe it indirectly if it does not answer; a member answering
+// neither is suspected, and declared dead unless it refutes the suspicion in time. Changes of
+// state, address, and protocol version ride on probes, so each node exchanges a constant
+// number of messages per interval however large the cluster
+type Gossip struct {
+    cluster *Cluster
+    roleId uint64
+    settings config.GossipConfig
+    members map[uint64]*gossipEntry
+    order []uint64
+    next int
+    random clock.Rand
+    clock clock.Clock
+    exclude sync.Mutex
+}
+
+// Constructor for Gossip over the members of a cluster; register it with the peer handler as
+// "GossipRole" on every node, then Run it
+func ConstructGossip(cluster *Cluster, settings config.GossipConfig) *Gossip {
+    newGossip := Gossip {
+        cluster: cluster,
+        roleId: cluster.roleId,
+        settings: settings,
+        members: make(map[uint64]*gossipEntry),
+        random: cluster.random,
+        clock: cluster.clock,
+    }
+    for roleId, peer := range cluster.members().peers {
+        newGossip.members[roleId] = &gossipEntry{member: GossipMember{RoleId: roleId, Address: peer.getHost()}}
+    }
+
+    self := newGossip.members[newGossip.roleId]
+    self.member.Incarnation = uint64(newGossip.clock.Now().UnixNano())
+    self.member.Version = ProtocolVersion
+    self.member.Features = cluster.transport.features()
+    self.transmits = newGossip.transmitLimit()
+    return &newGossip
+}
+
+// Probes a member every interval
+func (this *Gossip) Run() {
+    for {
+        start := this.clock.Now()
+        this.probe()
+        this.expireSuspicions()
+        if elapsed := this.clock.Now().Sub(start); elapsed < this.settings.Interval {
+            this.clock.Sleep(this.settings.Interval-elapsed)
+        }
+    }
+}
+
+// Returns the highest ranked of the voters not known to be dead, which the node should follow
+// as leader
+func (this *Gossip) GetHighestLive() uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    highest := uint64(0)
+    for roleId, entry := range this.members {
+        if entry.member.State != MemberDead && this.cluster.Outranks(roleId, highest) && this.cluster.IsVoter(roleId) {
+            highest = roleId
+        }
+    }
+    return highest
+}
+
+// Returns the state of every member, in order of roleId
+func (this *Gossip) GetMembers() []GossipMember {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    members := make([]GossipMember, 0, len(this.members))
+    for _, entry := range this.members {
+        members = append(members, entry.member)
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].RoleId < members[j].RoleId })
+    return members
+}
+
+// Answers a probe, probing the target first if asked to on another member's behalf
+func (this *Gossip) Ping(req *GossipReq, reply *GossipResp) error {
+    this.merge(req.Updates)
+    reply.Acked = true
+    if req.Target != 0 && req.Target != this.roleId {
+        gossipStats.Add("indirectProbes", 1)
+        reply.Acked = this.ping(req.Target, 0, this.settings.Interval/2) == nil
+    }
+    reply.Updates = this.piggyback()
+    return nil
+}
+
+// Probes the next member in turn, directly and then through others
+func (this *Gossip) probe() {
+    target := this.nextTarget()
+    if target == 0 { return }
+    gossipStats.Add("probes", 1)
+    err := this.ping(target, 0, this.settings.Interval/2)
+    if err == nil { return }
+    this.cluster.reportUnreachable(target)
+
+    helpers := this.pickHelpers(target)
+    acks := make(chan bool, len(helpers))
+    for _, helper := range helpers {
+        go func(helper uint64) {
+            acks <- this.ping(helper, target, this.settings.Interval/2) == nil
+        }(helper)
+    }
+    for range helpers {
+        if <- acks { return }
+    }
+    this.suspect(target)
+}
+
+// Sends a probe to a member, merging the gossip it returns; fails unless acknowledged
+func (this *Gossip) ping(roleId uint64, target uint64, timeout time.Duration) error {
+    req := GossipReq{From: this.roleId, Target: target, Updates: this.piggyback()}
+    var reply GossipResp
+    err := this.cluster.call(roleId, "GossipRole.Ping", &req, &reply, timeout)
+    if err != nil { return err }
+    this.merge(reply.Updates)
+    if !reply.Acked {
+        return fmt.Errorf("Role %d could not reach role %d", roleId, target)
+    }
+    if target == 0 {
+        this.confirm(roleId)
+    }
+    return nil
+}
+
+// Returns the next member to probe, visiting members in a random order reshuffled each round
+func (this *Gossip) nextTarget() uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.next >= len(this.order) {
+        // Members joining the cluster since the last round are probed from this one
+        for roleId, peer := range this.cluster.members().peers {
+            if this.members[roleId] == nil {
+                this.members[roleId] = &gossipEntry{member: GossipMember{RoleId: roleId, Address: peer.getHost()}}
+            }
+        }
+        this.order = this.order[:0]
+        for roleId := range this.members {
+            if roleId != this.roleId {
+                this.order = append(this.order, roleId)
+            }
+        }
+        shuffle(this.random, this.order)
+        this.next = 0
+    }
+    if len(this.order) == 0 { return 0 }
+    target := this.order[this.next]
+    this.next++
+    return target
+}
+
+// Returns up to the configured number of live members, other than the target, to probe it
+func (this *Gossip) pickHelpers(target uint64) []uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    var candidates []uint64 = nil
+    for roleId, entry := range this.members {
+        if roleId != this.roleId && roleId != target && entry.member.State == MemberAlive {
+            candidates = append(candidates, roleId)
+        }
+    }
+    shuffle(this.random, candidates)
+    if uint64(len(candidates)) > this.settings.Indirect {
+        candidates = candidates[:this.settings.Indirect]
+    }
+    return candidates
+}
+
+// Marks a member suspect after failed probes
+func (this *Gossip) suspect(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    entry := this.members[roleId]
+    if entry == nil || entry.member.State != MemberAlive { return }
+    fmt.Println("[ GOSSIP", this.roleId, "] Suspecting role", roleId)
+    gossipStats.Add("suspicions", 1)
+    entry.member.State = MemberSuspect
+    entry.suspected = this.clock.Now()
+    entry.transmits = this.transmitLimit()
+}
+
+// Clears a suspicion this node holds of a member which answered it directly. A member
+// declared dead is sent the claim again so it refutes it, its refutation spreading the news
+// to the rest
+func (this *Gossip) confirm(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    entry := this.members[roleId]
+    if entry == nil { return }
+    switch entry.member.State {
+    case MemberSuspect:
+        entry.member.State = MemberAlive
+    case MemberDead:
+        entry.transmits = this.transmitLimit()
+    }
+}
+
+// Declares dead the members whose suspicion has expired
+func (this *Gossip) expireSuspicions() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for roleId, entry := range this.members {
+        if entry.member.State == MemberSuspect && this.clock.Now().Sub(entry.suspected) >= this.settings.Suspicion {
+            fmt.Println("[ GOSSIP", this.roleId, "] Declaring role", roleId, "dead")
+            gossipStats.Add("deaths", 1)
+            entry.member.State = MemberDead
+            entry.transmits = this.transmitLimit()
+        }
+    }
+}
+
+// Applies gossip received from another member. Claims that this node is suspect or dead are
+// refuted with a greater incarnation, and a newly learned address is used to reconnect
+func (this *Gossip) merge(updates []GossipMember) {
+    var moved []GossipMember = nil
+    this.exclude.Lock()
+    for _, update := range updates {
+        entry := this.members[update.RoleId]
+        if entry == nil { continue }
+        if update.RoleId == this.roleId {
+            if update.State != MemberAlive && update.Incarnation >= entry.member.Incarnation {
+                entry.member.Incarnation = update.Incarnation+1
+                entry.transmits = this.transmitLimit()
+                gossipStats.Add("refutations", 1)
+            }
+            continue
+        }
+        if !update.overrides(entry.member) { continue }
+        if update.State != entry.member.State {
+            fmt.Println("[ GOSSIP", this.roleId, "] Role", update.RoleId, "is", update.State)
+        }
+        if update.State == MemberSuspect && entry.member.State != MemberSuspect {
+            entry.suspected = this.clock.Now()
+        }
+        if len(update.Address) != 0 && update.Address != entry.member.Address {
+            moved = append(moved, update)
+        }
+        entry.member = update
+        entry.transmits = this.transmitLimit()
+    }
+    this.exclude.Unlock()
+
+    for _, member := range moved {
+        if this.cluster.GetPeerHost(member.RoleId) != member.Address {
+            this.cluster.UpdatePeerAddress(member.RoleId, member.Address)
+        }
+    }
+}
+
+// Returns the gossip to carry on a message: this node's own state, and recent changes each
+// sent a limited number of times
+func (this *Gossip) piggyback() []GossipMember {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    updates := []GossipMember{this.members[this.roleId].member}
+    for roleId, entry := range this.members {
+        if roleId == this.roleId || entry.transmits <= 0 || len(updates) > maxPiggyback { continue }
+        entry.transmits--
+        updates = append(updates, entry.member)
+    }
+    return updates
+}
+
+// Times each change is sent: enough to reach every member with high probability
+func (this *Gossip) transmitLimit() int {
+    limit := 3
+    for size := len(this.members); size > 1; size /= 2 {
+        limit += 3
+    }
+    return limit
+}
+
+// Calls a method on a connected peer, failing after timeout
+func (this *Cluster) call(roleId uint64, serviceMethod string, args interface{}, reply interface{}, timeout time.Duration) error {
+    done := make(chan *rpc.Call, 1)
+    peer := this.members().peers[roleId]
+    if peer == nil || roleId == this.roleId {
+        return fmt.Errorf("Role %d is not connected", roleId)
+    }
+    comm, _ := peer.connection()
+    if comm == nil {
+        return fmt.Errorf("Role %d is not connected", roleId)
+    }
+    this.goRemote(roleId, comm, serviceMethod, args, reply, done)
+
+    select {
+    case call := <- done:
+        return call.Error
+    case <- this.clock.After(timeout):
+        return fmt.Errorf("Timed out calling role %d", roleId)
+    }
+}
+
+// Handles a peer which failed to answer as a failed heartbeat does: promises must again be
+// collected from it, and its connection is re-established
+func (this *Cluster) reportUnreachable(roleId uint64) {
+    if !this.IsMember(roleId) { return }
+    this.RevokePromise(roleId)
+    this.registerBadConnection <- roleId
+}
+
+// Returns the host of a peer as configured or discovered, before resolution
+func (this *Cluster) GetPeerHost(roleId uint64) string {
+    peer := this.members().peers[roleId]
+    if peer == nil { return "" }
+    return peer.getHost()
+}
+
+// Puts roleIds in random order; they are sorted first, so the order depends only on the source
+func shuffle(random clock.Rand, roleIds []uint64) {
+    sort.Slice(roleIds, func(i, j int) bool { return roleIds[i] < roleIds[j] })
+    for i := len(roleIds)-1; i > 0; i-- {
+        j := int(random.Int63n(int64(i)+1))
+        roleIds[i], roleIds[j] = roleIds[j], roleIds[i]
+    }
+}
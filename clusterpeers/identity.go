@@ -0,0 +1,117 @@
+package clusterpeers
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "time"
+    "crypto/rand"
+    "encoding/binary"
+)
+
+// Names the node at each end of a connection, once both ends advertise FeatureIdentity
+type identity struct {
+    roleId uint64
+    // Random number drawn at launch, telling apart two processes claiming the same roleId
+    instance uint64
+    clusterId string
+}
+
+// Draws the instance number of this process
+func drawInstance() (uint64, error) {
+    random := make([]byte, 8)
+    _, err := rand.Read(random)
+    if err != nil { return 0, err }
+    return binary.BigEndian.Uint64(random), nil
+}
+
+// Returns this node's identity
+func (this *transport) identity() identity {
+    return identity{this.roleId, this.instance, this.clusterId}
+}
+
+// Exchanges identities, client first, refusing a peer of another cluster, one claiming this
+// node's roleId, or one naming a role other than the one it authenticated as; returns the
+// identity of the peer
+func (this *transport) exchangeIdentity(connection net.Conn, isClient bool, authenticated principal) (identity, error) {
+    connection.SetDeadline(time.Now().Add(this.timeouts.Get().Rpc))
+    defer connection.SetDeadline(time.Time{})
+
+    local := this.identity()
+    message := make([]byte, 17, 17+len(local.clusterId))
+    binary.BigEndian.PutUint64(message[0:8], local.roleId)
+    binary.BigEndian.PutUint64(message[8:16], local.instance)
+    message[16] = byte(len(local.clusterId))
+    message = append(message, local.clusterId...)
+
+    var remote identity
+    var err error
+    if isClient {
+        _, err = connection.Write(message)
+        if err == nil {
+            remote, err = readIdentity(connection)
+        }
+    } else {
+        remote, err = readIdentity(connection)
+        if err == nil {
+            _, err = connection.Write(message)
+        }
+    }
+    if err != nil { return identity{}, err }
+
+    err = this.checkIdentity(remote, authenticated)
+    if err != nil {
+        fmt.Println("[ NETWORK", this.roleId, "] Refusing connection:", err)
+        return identity{}, err
+    }
+    return remote, nil
+}
+
+func readIdentity(connection net.Conn) (identity, error) {
+    header := make([]byte, 17)
+    _, err := io.ReadFull(connection, header)
+    if err != nil { return identity{}, err }
+    clusterId := make([]byte, header[16])
+    _, err = io.ReadFull(connection, clusterId)
+    if err != nil { return identity{}, err }
+    return identity{binary.BigEndian.Uint64(header[0:8]), binary.BigEndian.Uint64(header[8:16]), string(clusterId)}, nil
+}
+
+// Refuses a peer naming another cluster, another process claiming this node's roleId, or a
+// role other than the one whose key it authenticated with. Nodes without a cluster ID accept
+// any cluster; clients, with roleId 0, may share theirs
+func (this *transport) checkIdentity(remote identity, authenticated principal) error {
+    if len(this.clusterId) != 0 && len(remote.clusterId) != 0 && remote.clusterId != this.clusterId {
+        protocolStats.Add("wrongCluster", 1)
+        return fmt.Errorf("Role %d belongs to cluster %q, not %q", remote.roleId, remote.clusterId, this.clusterId)
+    }
+    if !authenticated.admits(remote.roleId) {
+        authenticationStats.Add("rejectedConnections", 1)
+        return fmt.Errorf("Role %d authenticated as role %d", remote.roleId, authenticated.roleId)
+    }
+    if remote.roleId != 0 && remote.roleId == this.roleId && remote.instance != this.instance {
+        protocolStats.Add("duplicateRole", 1)
+        return fmt.Errorf("Another node also claims roleId %d", remote.roleId)
+    }
+    return nil
+}
+
+// Refuses a connection dialed by a peer from a process other than the one answering at its
+// address: two nodes were launched with one roleId. Until this node reconnects to a restarted
+// peer, the peer's own connections are refused too, and it retries
+func (this *Cluster) checkInstance(peer *Peer, agreed capabilities) error {
+    peer.exclude.Lock()
+    defer peer.exclude.Unlock()
+
+    if peer.comm == nil || peer.inbound || peer.capabilities.instance == 0 || agreed.instance == 0 { return nil }
+    if peer.capabilities.instance == agreed.instance { return nil }
+    protocolStats.Add("duplicateRole", 1)
+    return fmt.Errorf("Role %d connected from a process other than the one at %s", peer.roleId, peer.address)
+}
+
+// Refuses a connection dialed to a peer's address which another role answered
+func (this *Cluster) checkPeer(roleId uint64, agreed capabilities) error {
+    if agreed.roleId == 0 || agreed.roleId == roleId { return nil }
+    protocolStats.Add("wrongRole", 1)
+    return fmt.Errorf("Role %d answered at the address of role %d", agreed.roleId, roleId)
+}
@@ -0,0 +1,78 @@
+package clusterpeers
+
+import (
+    "sort"
+    "time"
+    "net/rpc"
+    "github/paxoscluster/metrics"
+)
+
+var latencyStats = metrics.Group("latency")
+
+// Starts an RPC to a peer issued at start, recording its outcome and folding its response time into the
+// peer's statistics
+func (this *Cluster) timeCall(roleId uint64, comm *rpc.Client, start time.Time, serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) {
+    call := comm.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
+    go func() {
+        select {
+        case <- call.Done:
+        case <- this.clock.After(2*this.peerTimeouts(roleId).Rpc - this.clock.Now().Sub(start)):
+            this.latency.timeout(roleId)
+            <- call.Done
+        }
+        if call.Error == nil {
+            this.latency.observe(roleId, this.clock.Now(), this.clock.Now().Sub(start))
+        } else {
+            this.latency.fail(roleId)
+        }
+        done <- call
+    }()
+}
+
+// Returns the peers ordered fastest first, marking as slow those more than the configured
+// factor slower than the slowest member of the fastest quorum. Peers which have not yet
+// answered are placed first so they are measured, and evicted peers are left out
+func (this *Cluster) rankPeers(members *membership) []*Peer {
+    ranked := make([]*Peer, 0, len(members.peers))
+    for _, peer := range members.peers {
+        if !this.evicted(peer) {
+            ranked = append(ranked, peer)
+        }
+    }
+    means := make(map[uint64]time.Duration)
+    for _, peer := range ranked {
+        if peer.roleId != this.roleId {
+            means[peer.roleId] = this.latency.get(peer.roleId)
+        }
+    }
+    sort.Slice(ranked, func(i, j int) bool {
+        return means[ranked[i].roleId] < means[ranked[j].roleId]
+    })
+
+    // Slow peers exist only if a quorum can be formed without them
+    quorumSize := members.quorumSize()
+    if members.quorum.SlowFactor == 0 || quorumSize == 0 || quorumSize >= uint64(len(ranked)) {
+        return ranked
+    }
+    threshold := means[ranked[quorumSize-1].roleId] * time.Duration(members.quorum.SlowFactor)
+    for index, peer := range ranked {
+        slow := uint64(index) >= quorumSize && threshold > 0 && means[peer.roleId] > threshold
+        this.latency.mark(this.roleId, peer.roleId, slow)
+    }
+    return ranked
+}
+
+// Sends a request to a ranked peer; requests to slow peers are dispatched in the background
+// so a backed-up connection cannot hold up the rest of the broadcast. Returns false if the
+// peer is not connected
+func (this *Cluster) sendRanked(peer *Peer, serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) bool {
+    if peer.roleId == this.roleId || !this.latency.isSlow(peer.roleId) {
+        return this.send(peer, serviceMethod, args, reply, done)
+    }
+    comm, _ := peer.connection()
+    if comm == nil {
+        return false
+    }
+    go this.goRemote(peer.roleId, comm, serviceMethod, args, reply, done)
+    return true
+}
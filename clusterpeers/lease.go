@@ -0,0 +1,125 @@
+package clusterpeers
+
+import (
+    "time"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/proposal"
+)
+
+var leaseStats = metrics.Group("lease")
+
+// Promises this node holds as leader: peers which promised its current proposal while holding
+// no accepted values past the entry prepared, so later entries may be proposed to them under
+// that proposal without a prepare phase. A promise covers only the proposal it was made to;
+// once the proposer moves to another proposal, or leadership changes, it must be sought again.
+// Each promise is granted for the peer's election timeout, and renewed by every heartbeat reply in which
+// the peer's acceptor still holds the proposal; one not renewed in time expires by itself, so a
+// deposed leader cut off from its acceptors soon prepares again. The number of skipped promises
+// is the number unexpired, so it cannot drift from the peers. Acceptors keep no record of the
+// lease: they refuse accepts below their promise regardless, so it bounds only how long a
+// deposed leader skips the prepare phase, never what may be chosen. The lease is an
+// optimisation, and safety rests on the acceptors' proposal checks alone
+type promiseLease struct {
+    proposalId proposal.Id
+    promised map[uint64]time.Time
+}
+
+func constructPromiseLease() promiseLease {
+    return promiseLease{proposalId: proposal.Default(), promised: make(map[uint64]time.Time)}
+}
+
+// Records a peer's promise to a proposal; covering reports whether the peer holds no accepted
+// values past the entry prepared. A promise to a later proposal than the lease's replaces the
+// lease, and one to an earlier proposal is stale and ignored
+func (this *Cluster) RecordPromise(roleId uint64, proposalId proposal.Id, covering bool) {
+    if !this.IsMember(roleId) { return }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if proposalId.IsGreaterThan(this.lease.proposalId) {
+        this.lease = constructPromiseLease()
+        this.lease.proposalId = proposalId
+    } else if proposalId != this.lease.proposalId {
+        return
+    }
+    if covering {
+        this.lease.promised[roleId] = this.clock.Now().Add(this.peerTimeouts(roleId).Election)
+    } else {
+        delete(this.lease.promised, roleId)
+    }
+}
+
+// Renews a peer's promise from the proposal its acceptor reported holding in a heartbeat reply:
+// the grant is extended while that is still the lease's proposal, and revoked once the acceptor
+// has promised a later one. exclude MUST be locked
+func (this *Cluster) renewPromise(roleId uint64, held proposal.Id) {
+    if _, exists := this.lease.promised[roleId]; !exists { return }
+    if held == this.lease.proposalId {
+        this.lease.promised[roleId] = this.clock.Now().Add(this.peerTimeouts(roleId).Election)
+    } else if held.IsGreaterThan(this.lease.proposalId) {
+        leaseStats.Add("superseded", 1)
+        delete(this.lease.promised, roleId)
+    }
+}
+
+// Discards a peer's promise, as when it refuses a proposal or stops answering
+func (this *Cluster) RevokePromise(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    delete(this.lease.promised, roleId)
+}
+
+// Discards every promise, so the next round prepares with every peer; called when leadership
+// changes and whenever the proposer abandons its proposal for a greater one
+func (this *Cluster) ResetPromises() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.lease = constructPromiseLease()
+}
+
+// Returns the number of peers whose promises to a proposal are held
+func (this *Cluster) GetPromiseCount(proposalId proposal.Id) uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.promisesFor(proposalId)
+}
+
+// Returns the number of promises held for a proposal. exclude MUST be locked
+func (this *Cluster) promisesFor(proposalId proposal.Id) uint64 {
+    if proposalId != this.lease.proposalId {
+        return 0
+    }
+    this.expirePromises()
+    return uint64(len(this.lease.promised))
+}
+
+// Discards promises whose grants have run out without renewal. exclude MUST be locked
+func (this *Cluster) expirePromises() {
+    now := this.clock.Now()
+    for roleId, expiry := range this.lease.promised {
+        if !now.Before(expiry) {
+            leaseStats.Add("expired", 1)
+            delete(this.lease.promised, roleId)
+        }
+    }
+}
+
+// Returns a copy of the peers whose promises to a proposal are held, so a round may skip
+// them without holding the cluster lock while it sends
+func (this *Cluster) getPromised(proposalId proposal.Id) map[uint64]bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    promised := make(map[uint64]bool)
+    if proposalId == this.lease.proposalId {
+        this.expirePromises()
+        for roleId := range this.lease.promised {
+            promised[roleId] = true
+        }
+    }
+    return promised
+}
@@ -0,0 +1,117 @@
+package clusterpeers
+
+import (
+    "sync"
+    "time"
+    "testing"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/config"
+    "github/paxoscluster/proposal"
+)
+
+// Builds role 1 of a three member cluster whose peers are never reached, on a virtual clock
+func constructLeaseCluster(t *testing.T) (*Cluster, *clock.Virtual) {
+    virtual := clock.ConstructVirtual(time.Unix(0, 0))
+    settings := config.Default()
+    settings.RoleId = 1
+    settings.Clock = virtual
+    for roleId := uint64(1); roleId <= 3; roleId++ {
+        settings.Peers[roleId] = "127.0.0.1:1"
+    }
+    cluster, _, _, err := ConstructCluster(settings, nil)
+    if err != nil { t.Fatal(err) }
+    return cluster, virtual
+}
+
+func TestPromiseLeaseExpires(t *testing.T) {
+    cluster, virtual := constructLeaseCluster(t)
+    proposalId := proposal.Id{RoleId: 1, Sequence: 1<<proposal.RoleIdBits | 1}
+
+    cluster.RecordPromise(2, proposalId, true)
+    cluster.RecordPromise(3, proposalId, false)
+    if count := cluster.GetPromiseCount(proposalId); count != 1 {
+        t.Fatalf("Expected 1 promise, held %d", count)
+    }
+
+    election := cluster.peerTimeouts(2).Election
+    virtual.Advance(election/2)
+    cluster.exclude.Lock()
+    cluster.renewPromise(2, proposalId)
+    cluster.exclude.Unlock()
+    virtual.Advance(election*3/4)
+    if count := cluster.GetPromiseCount(proposalId); count != 1 {
+        t.Fatalf("Expected the renewed promise to be held, held %d", count)
+    }
+
+    virtual.Advance(election)
+    if count := cluster.GetPromiseCount(proposalId); count != 0 {
+        t.Fatalf("Expected the promise to expire, held %d", count)
+    }
+}
+
+func TestPromiseLeaseSuperseded(t *testing.T) {
+    cluster, _ := constructLeaseCluster(t)
+    earlier := proposal.Id{RoleId: 1, Sequence: 1<<proposal.RoleIdBits | 1}
+    later := proposal.Id{RoleId: 1, Sequence: 2<<proposal.RoleIdBits | 1}
+
+    cluster.RecordPromise(2, earlier, true)
+    cluster.RecordPromise(3, later, true)
+    if count := cluster.GetPromiseCount(earlier); count != 0 {
+        t.Fatalf("Expected a later proposal to replace the lease, held %d for the earlier", count)
+    }
+    cluster.RecordPromise(2, earlier, true)
+    if count := cluster.GetPromiseCount(later); count != 1 {
+        t.Fatalf("Expected a stale promise to be ignored, held %d", count)
+    }
+
+    cluster.exclude.Lock()
+    cluster.renewPromise(3, proposal.Id{RoleId: 2, Sequence: 3<<proposal.RoleIdBits | 2})
+    cluster.exclude.Unlock()
+    if count := cluster.GetPromiseCount(later); count != 0 {
+        t.Fatalf("Expected a promise to a later proposal to revoke the grant, held %d", count)
+    }
+}
+
+// Run with -race: every lease operation takes the cluster lock, and the count never exceeds the
+// peers which could have promised
+func TestPromiseLeaseConcurrent(t *testing.T) {
+    cluster, virtual := constructLeaseCluster(t)
+    const rounds = 2000
+
+    var workers sync.WaitGroup
+    for worker := 0; worker < 4; worker++ {
+        workers.Add(1)
+        go func(worker int) {
+            defer workers.Done()
+            for round := 0; round < rounds; round++ {
+                proposalId := proposal.Id{RoleId: 1, Sequence: int64(round/100+1)<<proposal.RoleIdBits | 1}
+                roleId := uint64(2 + (round+worker)%2)
+                switch (round+worker) % 5 {
+                case 0, 1:
+                    cluster.RecordPromise(roleId, proposalId, round%3 != 0)
+                case 2:
+                    cluster.RevokePromise(roleId)
+                case 3:
+                    if round%50 == 0 {
+                        cluster.ResetPromises()
+                    }
+                case 4:
+                    if count := cluster.GetPromiseCount(proposalId); count > 2 {
+                        t.Errorf("Held %d promises from 2 peers", count)
+                    }
+                    if promised := cluster.getPromised(proposalId); len(promised) > 2 {
+                        t.Errorf("Copied %d promises from 2 peers", len(promised))
+                    }
+                }
+            }
+        }(worker)
+    }
+    workers.Add(1)
+    go func() {
+        defer workers.Done()
+        for round := 0; round < rounds/10; round++ {
+            virtual.Advance(time.Millisecond)
+        }
+    }()
+    workers.Wait()
+}
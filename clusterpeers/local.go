@@ -0,0 +1,69 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "net/rpc"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/journal"
+)
+
+// Registers this node's acceptor so requests addressed to it bypass the network; broadcasts
+// read it without the cluster lock, so it must be registered before connecting to peers
+func (this *Cluster) SetLocalAcceptor(local *acceptor.AcceptorRole) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.local = local
+}
+
+// Records reconnections and quarantines of peers; must be set before connecting to peers
+func (this *Cluster) SetJournal(events *journal.Journal) {
+    this.journal = events
+}
+
+// Sends a request to a peer, completing on done; requests to this node are passed
+// directly to the local acceptor. Returns false if the peer is not connected or not a member
+func (this *Cluster) send(peer *Peer, serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) bool {
+    if peer == nil {
+        return false
+    }
+    if peer.roleId == this.roleId && this.local != nil {
+        call := rpc.Call {
+            ServiceMethod: serviceMethod,
+            Args: args,
+            Reply: reply,
+            Done: done,
+        }
+        go func() {
+            call.Error = this.invokeLocal(serviceMethod, args, reply)
+            done <- &call
+        }()
+        return true
+    }
+
+    comm, _ := peer.connection()
+    if comm == nil {
+        return false
+    }
+    this.goRemote(peer.roleId, comm, serviceMethod, args, reply, done)
+    return true
+}
+
+// Calls the local acceptor method corresponding to an RPC service method
+func (this *Cluster) invokeLocal(serviceMethod string, args interface{}, reply interface{}) error {
+    switch serviceMethod {
+    case "AcceptorRole.Prepare":
+        return this.local.Prepare(args.(*acceptor.PrepareReq), reply.(*acceptor.PrepareResp))
+    case "AcceptorRole.Accept":
+        return this.local.Accept(args.(*acceptor.ProposalReq), reply.(*acceptor.ProposalResp))
+    case "AcceptorRole.AcceptOwned":
+        return this.local.AcceptOwned(args.(*acceptor.OwnedReq), reply.(*acceptor.OwnedResp))
+    case "AcceptorRole.AcceptFast":
+        return this.local.AcceptFast(args.(*acceptor.FastReq), reply.(*acceptor.FastResp))
+    case "AcceptorRole.Success":
+        return this.local.Success(args.(*acceptor.SuccessNotify), reply.(*int))
+    case "AcceptorRole.SuccessBatch":
+        return this.local.SuccessBatch(args.(*acceptor.SuccessBatchNotify), reply.(*int))
+    }
+    return fmt.Errorf("No local handler for %s", serviceMethod)
+}
@@ -0,0 +1,157 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "net/rpc"
+    "github/paxoscluster/config"
+)
+
+// Members of the cluster, those demoted to learners, and the quorum policy over the voters. A
+// snapshot is never modified once stored; membership changes store a new one, so it may be
+// read without the cluster lock
+type membership struct {
+    peers map[uint64]*Peer
+    learners map[uint64]bool
+    quorum config.QuorumPolicy
+    // Index of the log entry of the last role change applied, or -1 if none; every replica
+    // reaches the same epochs in the same order
+    epoch int
+}
+
+// Returns number of peers required to form a quorum of the snapshot's voters
+func (this *membership) quorumSize() uint64 {
+    return this.quorum.QuorumSize(this.voterCount())
+}
+
+// Returns the current membership snapshot
+func (this *Cluster) members() *membership {
+    return this.membership.Load().(*membership)
+}
+
+// Returns the connection to a peer, or nil if there is none, and the features it supports
+func (this *Peer) connection() (*rpc.Client, capabilities) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.comm, this.capabilities
+}
+
+func (this *Peer) getAddress() string {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.address
+}
+
+func (this *Peer) getHost() string {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.host
+}
+
+func (this *Peer) isQuarantined() bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.quarantined
+}
+
+// Reports whether a role belongs to the cluster
+func (this *Cluster) IsMember(roleId uint64) bool {
+    _, exists := this.members().peers[roleId]
+    return exists
+}
+
+// Reports whether a role ranks above another for leadership: it has the higher priority, or
+// the same priority and the higher roleId
+func (this *Cluster) Outranks(roleId uint64, other uint64) bool {
+    if this.priorities[roleId] != this.priorities[other] {
+        return this.priorities[roleId] > this.priorities[other]
+    }
+    return roleId > other
+}
+
+// Returns the number of voters of higher priority than a role, which it defers to in elections
+func (this *Cluster) CountPreferred(roleId uint64) int {
+    members := this.members()
+    count := 0
+    for other := range members.peers {
+        if !members.learners[other] && this.priorities[other] > this.priorities[roleId] {
+            count++
+        }
+    }
+    return count
+}
+
+// Returns the address of every member, keyed by roleId
+func (this *Cluster) GetMembership() map[uint64]string {
+    membership := make(map[uint64]string)
+    for roleId, peer := range this.members().peers {
+        membership[roleId] = peer.getAddress()
+    }
+    return membership
+}
+
+// Checks that a forced membership holds only current members, including this node, so it
+// can be persisted before RestrictMembership applies it
+func (this *Cluster) CheckSurvivors(survivors []uint64) error {
+    _, err := this.survivorSet(this.members(), survivors)
+    return err
+}
+
+func (this *Cluster) survivorSet(current *membership, survivors []uint64) (map[uint64]bool, error) {
+    keep := make(map[uint64]bool)
+    for _, roleId := range survivors {
+        if _, exists := current.peers[roleId]; !exists {
+            return nil, fmt.Errorf("Role %d is not a member of the cluster", roleId)
+        }
+        keep[roleId] = true
+    }
+    if !keep[this.roleId] {
+        return nil, fmt.Errorf("Survivors must include this node, role %d", this.roleId)
+    }
+    return keep, nil
+}
+
+// Removes every member not among the survivors, so quorums are formed from the survivors
+// alone. UNSAFE: values chosen by a majority which did not survive may be lost or replaced.
+// Intended only for recovering a cluster which has permanently lost a majority of its nodes
+func (this *Cluster) RestrictMembership(survivors []uint64) error {
+    this.exclude.Lock()
+
+    current := this.members()
+    keep, err := this.survivorSet(current, survivors)
+    if err != nil {
+        this.exclude.Unlock()
+        return err
+    }
+
+    restricted := membership{peers: make(map[uint64]*Peer), learners: make(map[uint64]bool), quorum: current.quorum, epoch: current.epoch}
+    var removed []*Peer = nil
+    for roleId, peer := range current.peers {
+        if keep[roleId] {
+            restricted.peers[roleId] = peer
+            if current.learners[roleId] {
+                restricted.learners[roleId] = true
+            }
+        } else {
+            delete(this.lease.promised, roleId)
+            removed = append(removed, peer)
+        }
+    }
+    if restricted.quorum.Size > restricted.voterCount() {
+        restricted.quorum.Size = 0
+    }
+    this.membership.Store(&restricted)
+    this.exclude.Unlock()
+
+    for _, peer := range removed {
+        if comm, _ := peer.connection(); comm != nil {
+            comm.Close()
+        }
+        fmt.Println("[ NETWORK", this.roleId, "] WARNING: removed role", peer.roleId, "from membership")
+        this.events.MembershipChange(peer.roleId, "")
+    }
+    return nil
+}
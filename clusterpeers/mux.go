@@ -0,0 +1,145 @@
+package clusterpeers
+
+import (
+    "io"
+    "net"
+    "sync"
+    "time"
+    "encoding/binary"
+)
+
+// Channels of a multiplexed peer connection: requests from the node which dialed it travel
+// on the forward channel, and requests from the node which accepted it on the reverse one
+const (
+    forwardChannel byte = 0
+    reverseChannel byte = 1
+)
+
+// Largest payload of a multiplexed frame; longer writes are split
+const maxMuxFrame = 64*1024
+
+// Peer connection carrying RPCs in both directions, so each pair of peers needs only one.
+// Frames are a channel byte, a payload length, and the payload. Closing either channel
+// closes the connection
+type muxSession struct {
+    connection net.Conn
+    channels [2]*muxChannel
+    closed sync.Once
+    exclude sync.Mutex
+}
+
+// Constructor for muxSession; reads frames until the connection fails
+func constructMuxSession(connection net.Conn) *muxSession {
+    newSession := muxSession {
+        connection: connection,
+    }
+    for id := range newSession.channels {
+        reader, writer := io.Pipe()
+        newSession.channels[id] = &muxChannel{&newSession, byte(id), reader, writer}
+    }
+    go newSession.demultiplex()
+    protocolStats.Add("multiplexedConnections", 1)
+    return &newSession
+}
+
+// Returns one channel of the connection
+func (this *muxSession) channel(id byte) *muxChannel {
+    return this.channels[id]
+}
+
+// Delivers each frame to its channel. Both ends of a channel are net/rpc codecs, which read
+// continuously, so a frame waits on its reader only briefly
+func (this *muxSession) demultiplex() {
+    header := make([]byte, 5)
+    for {
+        _, err := io.ReadFull(this.connection, header)
+        if err == nil && (header[0] > reverseChannel || binary.BigEndian.Uint32(header[1:]) > maxMuxFrame) {
+            err = io.ErrUnexpectedEOF
+        }
+        if err == nil {
+            payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+            _, err = io.ReadFull(this.connection, payload)
+            if err == nil {
+                _, err = this.channels[header[0]].incoming.Write(payload)
+            }
+        }
+        if err != nil {
+            this.close(err)
+            return
+        }
+    }
+}
+
+// Sends data on a channel as one or more frames
+func (this *muxSession) write(id byte, data []byte) (int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    written := 0
+    for written < len(data) {
+        length := len(data)-written
+        if length > maxMuxFrame {
+            length = maxMuxFrame
+        }
+        frame := make([]byte, 5, 5+length)
+        frame[0] = id
+        binary.BigEndian.PutUint32(frame[1:], uint32(length))
+        frame = append(frame, data[written:written+length]...)
+        _, err := this.connection.Write(frame)
+        if err != nil { return written, err }
+        written += length
+    }
+    return written, nil
+}
+
+// Closes the connection, failing reads on both channels
+func (this *muxSession) close(err error) {
+    this.closed.Do(func() {
+        this.connection.Close()
+        for _, channel := range this.channels {
+            channel.incoming.CloseWithError(err)
+        }
+    })
+}
+
+// One direction of RPCs over a multiplexed connection
+type muxChannel struct {
+    session *muxSession
+    id byte
+    reader *io.PipeReader
+    incoming *io.PipeWriter
+}
+
+func (this *muxChannel) Read(data []byte) (int, error) {
+    return this.reader.Read(data)
+}
+
+func (this *muxChannel) Write(data []byte) (int, error) {
+    return this.session.write(this.id, data)
+}
+
+func (this *muxChannel) Close() error {
+    this.session.close(io.EOF)
+    return nil
+}
+
+func (this *muxChannel) LocalAddr() net.Addr {
+    return this.session.connection.LocalAddr()
+}
+
+func (this *muxChannel) RemoteAddr() net.Addr {
+    return this.session.connection.RemoteAddr()
+}
+
+// Deadlines would affect both channels, so they are not supported once multiplexed
+func (this *muxChannel) SetDeadline(deadline time.Time) error {
+    return nil
+}
+
+func (this *muxChannel) SetReadDeadline(deadline time.Time) error {
+    return nil
+}
+
+func (this *muxChannel) SetWriteDeadline(deadline time.Time) error {
+    return nil
+}
@@ -0,0 +1,43 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "net"
+    "sync"
+    "crypto/tls"
+)
+
+// Network carrying connections in place of TCP, such as QUIC, whose streams serve as
+// connections; its implementation secures connections with the given TLS settings, which
+// are never nil. This package ships no QUIC transport, and the standard library has none, so
+// nodes with quic:// addresses must register one, such as a wrapper of an external QUIC
+// library, before launching. Whether connections gain 0-RTT reconnection or stream
+// multiplexing depends entirely on that implementation
+type Network interface {
+    Dial(target string, tlsConfig *tls.Config) (net.Conn, error)
+    Listen(target string, tlsConfig *tls.Config) (net.Listener, error)
+}
+
+var networks = make(map[string]Network)
+var networksExclude sync.Mutex
+
+// Registers the implementation of a network named by an address scheme, such as "quic"
+func RegisterNetwork(name string, network Network) {
+    networksExclude.Lock()
+    defer networksExclude.Unlock()
+
+    networks[name] = network
+}
+
+// Returns the registered implementation of a network, or nil for the built-in networks
+func lookupNetwork(name string) (Network, error) {
+    if name == "tcp" || name == "unix" { return nil, nil }
+
+    networksExclude.Lock()
+    defer networksExclude.Unlock()
+    network, exists := networks[name]
+    if !exists {
+        return nil, fmt.Errorf("No implementation registered for network %s", name)
+    }
+    return network, nil
+}
@@ -0,0 +1,99 @@
+package clusterpeers
+
+import (
+    "net"
+    "time"
+    "testing"
+    "net/rpc"
+    "math/big"
+    "crypto/tls"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "github/paxoscluster/config"
+)
+
+// Network standing in for an external QUIC library: TLS over loopback TCP, counting use
+type loopbackNetwork struct {
+    dialed int
+    listened int
+}
+
+func (this *loopbackNetwork) Dial(target string, tlsConfig *tls.Config) (net.Conn, error) {
+    this.dialed++
+    return tls.Dial("tcp", target, tlsConfig)
+}
+
+func (this *loopbackNetwork) Listen(target string, tlsConfig *tls.Config) (net.Listener, error) {
+    this.listened++
+    return tls.Listen("tcp", target, tlsConfig)
+}
+
+// Returns TLS settings shaped as config.TLSConfig builds them, from a self-signed certificate
+// for the loopback address
+func loopbackTLS(t *testing.T) *tls.Config {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { t.Fatal(err) }
+    template := x509.Certificate {
+        SerialNumber: big.NewInt(1),
+        IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(time.Hour),
+        KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        IsCA: true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+    if err != nil { t.Fatal(err) }
+    certificate, err := x509.ParseCertificate(der)
+    if err != nil { t.Fatal(err) }
+    pool := x509.NewCertPool()
+    pool.AddCert(certificate)
+    return &tls.Config {
+        Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+        RootCAs: pool,
+        ClientCAs: pool,
+        ClientAuth: tls.RequireAndVerifyClientCert,
+    }
+}
+
+// Addresses of a registered network are dialed and served through it, beneath the same
+// handshake and RPCs as TCP; without TLS, or without a registered implementation, they fail
+func TestRegisteredNetwork(t *testing.T) {
+    network := &loopbackNetwork{}
+    RegisterNetwork("quic", network)
+    address := config.QuicScheme + reserveAddress(t)
+    local := transport {
+        roleId: 1,
+        tlsConfig: loopbackTLS(t),
+        timeouts: config.ConstructLiveTimeouts(config.Default().Timeouts),
+    }
+    handler := rpc.NewServer()
+    handler.RegisterName("Echo", &echoService{})
+    err := serve(1, &local, address, handler)
+    if err != nil { t.Fatal(err) }
+
+    client, _, err := local.dial(address)
+    if err != nil { t.Fatal(err) }
+    defer client.Close()
+    var reply string
+    err = client.Call("Echo.Echo", "hello", &reply)
+    if err != nil || reply != "hello" {
+        t.Errorf("Served %q over the registered network: %v", reply, err)
+    }
+    if network.dialed != 1 || network.listened != 1 {
+        t.Errorf("Registered network dialed %d and listened %d times, expected once each", network.dialed, network.listened)
+    }
+
+    plain := transport{roleId: 2, timeouts: local.timeouts}
+    _, _, err = plain.dial(address)
+    if err == nil {
+        t.Errorf("Dialed a registered network without TLS")
+    }
+    _, err = lookupNetwork("sctp")
+    if err == nil {
+        t.Errorf("Found an implementation of an unregistered network")
+    }
+}
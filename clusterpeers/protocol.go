@@ -0,0 +1,179 @@
+package clusterpeers
+
+import (
+    "io"
+    "net"
+    "errors"
+    "time"
+    "encoding/binary"
+    "github/paxoscluster/metrics"
+)
+
+// Version of the peer protocol spoken by this node; nodes predating versioning speak version 1
+const ProtocolVersion uint16 = 2
+
+// Optional capabilities advertised in the connection handshake; a feature is used on a
+// connection only if both ends advertise it
+const (
+    FeatureFlate uint64 = 1 << iota
+    FeatureFetch
+    FeatureSuccessBatch
+    FeatureFetchEntries
+    FeatureMultiplex
+    FeatureFollowerState
+    FeatureIdentity
+    FeatureStamp
+)
+
+// First byte sent by a versioned client. Unversioned nodes open with a compression algorithm
+// byte instead, and answer this one with compressionNone, which versioned nodes recognize.
+// Nodes predating compression send RPCs at once; they close the connection on this byte,
+// which no RPC opens with
+const versionedHello byte = 0x80
+
+// Reported by negotiateClient when the server closed the connection or stayed silent instead
+// of answering the hello, as nodes predating compression do
+var errNoHello = errors.New("Server did not answer the protocol hello")
+
+// Counts connections by the protocol version negotiated
+var protocolStats = metrics.Group("protocol")
+
+// Version and features agreed with a peer, and the roleId and instance it identified as; zero
+// for clients and for peers which did not say
+type capabilities struct {
+    version uint16
+    features uint64
+    roleId uint64
+    instance uint64
+}
+
+func (this capabilities) supports(feature uint64) bool {
+    return this.features&feature != 0
+}
+
+// Capabilities of a node predating versioning, given the compression algorithm it accepted
+func legacyCapabilities(algorithm byte) capabilities {
+    if algorithm == compressionFlate {
+        return capabilities{version: 1, features: FeatureFlate}
+    }
+    return capabilities{version: 1}
+}
+
+// Returns the features this node advertises
+func (this *transport) features() uint64 {
+    features := FeatureFetch | FeatureSuccessBatch | FeatureFetchEntries | FeatureFollowerState | FeatureIdentity | FeatureStamp
+    if this.compression == compressionFlate {
+        features |= FeatureFlate
+    }
+    if this.handler != nil {
+        features |= FeatureMultiplex
+    }
+    return features
+}
+
+// Negotiates the protocol version and features with the server, then applies compression
+func negotiateClient(connection net.Conn, features uint64, timeout time.Duration) (net.Conn, capabilities, error) {
+    connection.SetDeadline(time.Now().Add(timeout))
+    defer connection.SetDeadline(time.Time{})
+
+    _, err := connection.Write([]byte{versionedHello})
+    if err != nil { return nil, capabilities{}, err }
+
+    reply := make([]byte, 1)
+    _, err = io.ReadFull(connection, reply)
+    if timeout, ok := err.(net.Error); err == io.EOF || (ok && timeout.Timeout()) {
+        return nil, capabilities{}, errNoHello
+    } else if err != nil { return nil, capabilities{}, err }
+    if reply[0] != versionedHello {
+        // Server predates versioning and replied with a compression algorithm
+        protocolStats.Add("version1Connections", 1)
+        return wrapCompression(connection, reply[0]), legacyCapabilities(reply[0]), nil
+    }
+
+    agreed, err := exchangeHello(connection, features, true)
+    if err != nil { return nil, capabilities{}, err }
+    return wrapCompression(connection, agreedCompression(agreed)), agreed, nil
+}
+
+// Negotiates with a client, falling back to the unversioned compression handshake for old nodes
+func negotiateServer(connection net.Conn, features uint64, timeout time.Duration) (net.Conn, capabilities, error) {
+    connection.SetDeadline(time.Now().Add(timeout))
+    defer connection.SetDeadline(time.Time{})
+
+    requested := make([]byte, 1)
+    _, err := io.ReadFull(connection, requested)
+    if err != nil { return nil, capabilities{}, err }
+
+    if requested[0] != versionedHello && requested[0] != compressionNone && requested[0] != compressionFlate {
+        // Client predates compression, and the byte opens its first RPC
+        protocolStats.Add("unversionedConnections", 1)
+        return &replayConn{connection, requested}, legacyCapabilities(compressionNone), nil
+    }
+    if requested[0] != versionedHello {
+        accepted := compressionNone
+        if requested[0] == compressionFlate && features&FeatureFlate != 0 {
+            accepted = compressionFlate
+        }
+        _, err = connection.Write([]byte{accepted})
+        if err != nil { return nil, capabilities{}, err }
+        protocolStats.Add("version1Connections", 1)
+        return wrapCompression(connection, accepted), legacyCapabilities(accepted), nil
+    }
+
+    _, err = connection.Write([]byte{versionedHello})
+    if err != nil { return nil, capabilities{}, err }
+    agreed, err := exchangeHello(connection, features, false)
+    if err != nil { return nil, capabilities{}, err }
+    return wrapCompression(connection, agreedCompression(agreed)), agreed, nil
+}
+
+// Exchanges versions and features, client first, agreeing on the lesser version and common features
+func exchangeHello(connection net.Conn, features uint64, isClient bool) (capabilities, error) {
+    hello := make([]byte, 10)
+    binary.BigEndian.PutUint16(hello[0:2], ProtocolVersion)
+    binary.BigEndian.PutUint64(hello[2:10], features)
+    remote := make([]byte, 10)
+
+    var err error
+    if isClient {
+        _, err = connection.Write(hello)
+        if err == nil {
+            _, err = io.ReadFull(connection, remote)
+        }
+    } else {
+        _, err = io.ReadFull(connection, remote)
+        if err == nil {
+            _, err = connection.Write(hello)
+        }
+    }
+    if err != nil { return capabilities{}, err }
+
+    agreed := capabilities{version: ProtocolVersion, features: features & binary.BigEndian.Uint64(remote[2:10])}
+    if remoteVersion := binary.BigEndian.Uint16(remote[0:2]); remoteVersion < agreed.version {
+        agreed.version = remoteVersion
+    }
+    protocolStats.Add("version2Connections", 1)
+    return agreed, nil
+}
+
+func agreedCompression(agreed capabilities) byte {
+    if agreed.supports(FeatureFlate) {
+        return compressionFlate
+    }
+    return compressionNone
+}
+
+// Connection returning bytes already read from it before reading further
+type replayConn struct {
+    net.Conn
+    pending []byte
+}
+
+func (this *replayConn) Read(data []byte) (int, error) {
+    if len(this.pending) == 0 {
+        return this.Conn.Read(data)
+    }
+    count := copy(data, this.pending)
+    this.pending = this.pending[count:]
+    return count, nil
+}
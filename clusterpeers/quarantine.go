@@ -0,0 +1,59 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "time"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/journal"
+)
+
+var quarantineStats = metrics.Group("quarantine")
+
+// Waits before another connection attempt to an unreachable peer. A peer unreachable since
+// the given time for longer than the quarantine threshold is quarantined, and retried only at
+// the quarantine interval
+func (this *Cluster) awaitRetry(roleId uint64, since time.Time) {
+    interval := this.timeouts.Get().Heartbeat
+    if this.quarantine.Threshold > 0 && this.clock.Now().Sub(since) >= this.quarantine.Threshold {
+        this.confine(roleId, this.clock.Now().Sub(since))
+        interval = this.quarantine.Interval
+    }
+    this.clock.Sleep(interval)
+}
+
+// Quarantines a peer, alerting operators the first time
+func (this *Cluster) confine(roleId uint64, unreachable time.Duration) {
+    peer := this.members().peers[roleId]
+    if peer == nil { return }
+    peer.exclude.Lock()
+    confined := !peer.quarantined
+    peer.quarantined = true
+    peer.exclude.Unlock()
+    if !confined { return }
+
+    quarantineStats.Add("quarantined", 1)
+    quarantineStats.Add("active", 1)
+    fmt.Println("[ NETWORK", this.roleId, "] ALERT: peer", roleId, "unreachable for", unreachable.Round(time.Second),
+                "; quarantined, retrying every", this.quarantine.Interval)
+    this.journal.Record(this.roleId, journal.PeerQuarantined, "Peer %d unreachable for %v; quarantined", roleId, unreachable.Round(time.Second))
+    this.events.PeerQuarantine(roleId, true)
+}
+
+// Reports the end of the quarantine of a peer which has reconnected; install clears the flag
+func (this *Cluster) lift(roleId uint64) {
+    quarantineStats.Add("active", -1)
+    fmt.Println("[ NETWORK", this.roleId, "] Peer", roleId, "reconnected; quarantine lifted")
+    this.journal.Record(this.roleId, journal.PeerQuarantined, "Peer %d reconnected; quarantine lifted", roleId)
+    this.events.PeerQuarantine(roleId, false)
+}
+
+// Reports whether consensus rounds leave out a peer
+func (this *Cluster) evicted(peer *Peer) bool {
+    return this.quarantine.Evict && peer.isQuarantined()
+}
+
+// Reports whether a peer is quarantined
+func (this *Cluster) IsQuarantined(roleId uint64) bool {
+    peer := this.members().peers[roleId]
+    return peer != nil && peer.isQuarantined()
+}
@@ -0,0 +1,247 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "time"
+    "github/paxoscluster/proposal"
+)
+
+// Standing of one phase of a round as its replies are tallied
+type QuorumState int
+
+const (
+    // Neither a quorum nor its failure is settled yet
+    QuorumPending QuorumState = iota
+    // Enough acceptors voted for the proposal
+    QuorumReached
+    // Too few replies remain outstanding for the votes to make a quorum
+    QuorumImpossible
+    // An acceptor holds a higher proposal, so this one cannot be chosen
+    QuorumSuperseded
+)
+
+func (this QuorumState) String() string {
+    switch this {
+    case QuorumReached:
+        return "reached"
+    case QuorumImpossible:
+        return "impossible"
+    case QuorumSuperseded:
+        return "superseded"
+    }
+    return "pending"
+}
+
+// Tally of the replies to one phase of a round. Each reply is a vote, a rejection, or a
+// failure, the last being an error or timeout; a role is counted once however often it
+// replies, and members outside quorums are not counted at all. The first settled state sticks.
+// Not safe for concurrent use
+type QuorumTracker struct {
+    proposalId proposal.Id
+    needed uint64
+    outstanding uint64
+    counted func(uint64) bool
+    replied map[uint64]bool
+    votes uint64
+    rejections uint64
+    failures uint64
+    timeouts uint64
+    higher proposal.Id
+    superseded bool
+    state QuorumState
+    // Broadcast being tallied, abandoned once the state settles unless retained
+    source *round
+    retain bool
+}
+
+// Constructs a tracker for a phase of proposalId which needs the given number of votes from
+// expected replies; counted reports whether a role's vote counts, or is nil if every role's does
+func ConstructQuorumTracker(proposalId proposal.Id, needed uint64, expected uint64, counted func(uint64) bool) *QuorumTracker {
+    newQuorumTracker := QuorumTracker {
+        proposalId: proposalId,
+        needed: needed,
+        outstanding: expected,
+        counted: counted,
+        replied: make(map[uint64]bool),
+        higher: proposal.Default(),
+    }
+    newQuorumTracker.settle()
+    return &newQuorumTracker
+}
+
+// Constructs a tracker for a phase of proposalId sent to expected members, needing a quorum
+// of voters
+func (this *Cluster) TrackQuorum(proposalId proposal.Id, expected uint64) *QuorumTracker {
+    return ConstructQuorumTracker(proposalId, this.GetQuorumSize(), expected, this.IsVoter)
+}
+
+// Counts votes held before the phase, as promises which let a proposer skip a prepare
+func (this *QuorumTracker) AddVotes(votes uint64) QuorumState {
+    this.votes += votes
+    return this.settle()
+}
+
+// Counts a role's vote for the proposal
+func (this *QuorumTracker) Vote(roleId uint64) QuorumState {
+    if this.reply(roleId) && (this.counted == nil || this.counted(roleId)) {
+        this.votes++
+    }
+    return this.settle()
+}
+
+// Counts a role's refusal of the proposal
+func (this *QuorumTracker) Reject(roleId uint64) QuorumState {
+    if this.reply(roleId) {
+        this.rejections++
+    }
+    return this.settle()
+}
+
+// Counts a role which failed to reply, whether it timed out or returned an error
+func (this *QuorumTracker) Fail(roleId uint64, err error) QuorumState {
+    if this.reply(roleId) {
+        if IsTimeout(err) {
+            this.timeouts++
+        } else {
+            this.failures++
+        }
+    }
+    return this.settle()
+}
+
+// Counts a role's refusal because it holds the higher proposal given; a role whose vote would
+// not count cannot supersede the proposal either
+func (this *QuorumTracker) Supersede(roleId uint64, higher proposal.Id) QuorumState {
+    if this.reply(roleId) && (this.counted == nil || this.counted(roleId)) {
+        this.rejections++
+        this.superseded = true
+        this.note(higher)
+    }
+    return this.settle()
+}
+
+// Counts a reply to a prepare request; a refusal notes the proposal promised instead, but
+// does not supersede the prepare, which other acceptors may still promise
+func (this *QuorumTracker) Promise(result PromiseResult) QuorumState {
+    if result.Error != nil {
+        return this.Fail(result.RoleId, result.Error)
+    } else if !result.Promise.PromiseAccepted {
+        this.note(result.Promise.PromisedId)
+        return this.Reject(result.RoleId)
+    }
+    return this.Vote(result.RoleId)
+}
+
+// Counts a reply to an accept request; an acceptor which took a higher proposal supersedes it
+func (this *QuorumTracker) Accept(result AcceptResult) QuorumState {
+    if result.Error != nil {
+        return this.Fail(result.RoleId, result.Error)
+    } else if result.Accept.AcceptedId.IsGreaterThan(this.proposalId) {
+        return this.Supersede(result.RoleId, result.Accept.AcceptedId)
+    }
+    return this.Vote(result.RoleId)
+}
+
+// Counts every reply still outstanding as a timeout, as when the phase's deadline passes
+func (this *QuorumTracker) Expire() QuorumState {
+    this.timeouts += this.outstanding
+    this.outstanding = 0
+    return this.settle()
+}
+
+// Leaves the broadcast collecting replies after the state settles, for callers which go on
+// reading the stragglers
+func (this *QuorumTracker) KeepCollecting() {
+    this.retain = true
+}
+
+// Returns the phase's standing
+func (this *QuorumTracker) State() QuorumState {
+    return this.state
+}
+
+// Returns the votes counted, including those held before the phase
+func (this *QuorumTracker) GetVotes() uint64 {
+    return this.votes
+}
+
+// Returns the votes the phase needs
+func (this *QuorumTracker) GetNeeded() uint64 {
+    return this.needed
+}
+
+// Returns the number of roles which replied or failed to
+func (this *QuorumTracker) GetReplies() uint64 {
+    return uint64(len(this.replied))
+}
+
+// Returns the highest proposal reported by an acceptor refusing this one, or the default if none
+func (this *QuorumTracker) GetHigher() proposal.Id {
+    return this.higher
+}
+
+func (this *QuorumTracker) String() string {
+    return fmt.Sprintf("%v with %d of %d votes, %d rejections, %d failures, %d timeouts", this.state, this.votes, this.needed,
+                       this.rejections, this.failures, this.timeouts)
+}
+
+// Records a proposal an acceptor holds in place of this one
+func (this *QuorumTracker) note(higher proposal.Id) {
+    if higher.IsGreaterThan(this.proposalId) && higher.IsGreaterThan(this.higher) {
+        this.higher = higher
+    }
+}
+
+// Records a reply from roleId, reporting false if it already replied
+func (this *QuorumTracker) reply(roleId uint64) bool {
+    if this.replied[roleId] {
+        return false
+    }
+    this.replied[roleId] = true
+    if this.outstanding > 0 {
+        this.outstanding--
+    }
+    return true
+}
+
+func (this *QuorumTracker) settle() QuorumState {
+    if this.state != QuorumPending {
+        return this.state
+    }
+    if this.votes >= this.needed {
+        this.state = QuorumReached
+    } else if this.superseded {
+        this.state = QuorumSuperseded
+    } else if this.votes+this.outstanding < this.needed {
+        this.state = QuorumImpossible
+    }
+    return this.state
+}
+
+// Passes results to tally until the tracker settles, results close, or expired fires; any
+// replies then outstanding count as timeouts. Returns the tracker's final standing at once,
+// abandoning the broadcast so stragglers are neither awaited nor forwarded, unless the tracker
+// keeps collecting
+func Await[T result](tracker *QuorumTracker, results <-chan T, expired <-chan time.Time, tally func(T) QuorumState) QuorumState {
+    defer tracker.release()
+    for tracker.State() == QuorumPending {
+        select {
+        case reply, open := <- results:
+            if !open {
+                return tracker.Expire()
+            }
+            tracker.source = reply.origin()
+            tally(reply)
+        case <- expired:
+            return tracker.Expire()
+        }
+    }
+    return tracker.State()
+}
+
+// Abandons the broadcast once the state settles, unless the tracker keeps collecting
+func (this *QuorumTracker) release() {
+    if this.state != QuorumPending && !this.retain {
+        this.source.abandon()
+    }
+}
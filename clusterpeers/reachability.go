@@ -0,0 +1,227 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/journal"
+)
+
+var reachabilityStats = metrics.Group("reachability")
+
+// Heartbeats exchanged with each peer: when the peer's last arrived, and since when and until
+// when this node's own heartbeats to it have gone unanswered. Each node reports these in its
+// heartbeat replies, so members can cross-check each other's view of every link
+type reachability struct {
+    heard map[uint64]time.Time
+    failingSince map[uint64]time.Time
+    lastFailed map[uint64]time.Time
+    // When each link now seen asymmetric was first seen so, and those seen so for an election
+    // timeout as of the last check
+    suspected map[[2]uint64]time.Time
+    links []AsymmetricLink
+    checked time.Time
+    exclude sync.Mutex
+}
+
+func constructReachability() *reachability {
+    newReachability := reachability {
+        heard: make(map[uint64]time.Time),
+        failingSince: make(map[uint64]time.Time),
+        lastFailed: make(map[uint64]time.Time),
+        suspected: make(map[[2]uint64]time.Time),
+    }
+    return &newReachability
+}
+
+// Link between two members which carries traffic one way only: From's messages arrive at To,
+// but To's do not arrive back, whether To's own requests or its replies to From. Witness is
+// the member whose report revealed it, as explained by Evidence
+type AsymmetricLink struct {
+    From uint64
+    To uint64
+    Witness uint64
+    Evidence string
+}
+
+// Members one node reports hearing heartbeats from, and those its own heartbeats fail to reach
+type reachabilityReport struct {
+    heard map[uint64]bool
+    unanswered map[uint64]bool
+}
+
+// Records a heartbeat received from a member
+func (this *Cluster) ObserveHeartbeat(roleId uint64) {
+    if roleId == this.roleId { return }
+    this.reachability.exclude.Lock()
+    defer this.reachability.exclude.Unlock()
+    this.reachability.heard[roleId] = this.clock.Now()
+}
+
+// Records which of the peers sent a heartbeat answered it, then checks every link for asymmetry
+func (this *Cluster) observeHeartbeats(sent map[uint64]bool, received map[uint64]bool) {
+    now := this.clock.Now()
+    this.reachability.exclude.Lock()
+    for roleId := range sent {
+        if roleId == this.roleId {
+            continue
+        } else if received[roleId] {
+            delete(this.reachability.failingSince, roleId)
+        } else {
+            if _, failing := this.reachability.failingSince[roleId]; !failing {
+                this.reachability.failingSince[roleId] = now
+            }
+            this.reachability.lastFailed[roleId] = now
+        }
+    }
+    this.reachability.exclude.Unlock()
+    this.checkAsymmetry()
+}
+
+// Returns the members whose heartbeats this node heard within their election timeout, and those
+// its own heartbeats have failed to reach since. A member heard only before its heartbeats
+// began failing is left out, as it may simply have stopped
+func (this *Cluster) ReachabilityReport() ([]uint64, []uint64) {
+    report := this.reachabilityReport()
+    heard := make([]uint64, 0, len(report.heard))
+    for roleId := range report.heard {
+        heard = append(heard, roleId)
+    }
+    unanswered := make([]uint64, 0, len(report.unanswered))
+    for roleId := range report.unanswered {
+        unanswered = append(unanswered, roleId)
+    }
+    sort.Slice(heard, func(i, j int) bool { return heard[i] < heard[j] })
+    sort.Slice(unanswered, func(i, j int) bool { return unanswered[i] < unanswered[j] })
+    return heard, unanswered
+}
+
+func (this *Cluster) reachabilityReport() reachabilityReport {
+    now := this.clock.Now()
+    report := reachabilityReport{make(map[uint64]bool), make(map[uint64]bool)}
+    this.reachability.exclude.Lock()
+    defer this.reachability.exclude.Unlock()
+    for roleId, heard := range this.reachability.heard {
+        since, failing := this.reachability.failingSince[roleId]
+        if now.Sub(heard) <= this.peerTimeouts(roleId).Election && (!failing || heard.After(since)) {
+            report.heard[roleId] = true
+        }
+    }
+    for roleId := range this.reachability.failingSince {
+        if now.Sub(this.reachability.lastFailed[roleId]) <= this.peerTimeouts(roleId).Election {
+            report.unanswered[roleId] = true
+        }
+    }
+    return report
+}
+
+// Returns the links found to work in one direction only for at least an election timeout, as
+// of the last heartbeat this node sent; none if it has not sent one within an election timeout
+func (this *Cluster) AsymmetricLinks() []AsymmetricLink {
+    this.reachability.exclude.Lock()
+    defer this.reachability.exclude.Unlock()
+    if this.clock.Now().Sub(this.reachability.checked) > this.timeouts.Get().Profile(true).Election { return nil }
+    return append([]AsymmetricLink(nil), this.reachability.links...)
+}
+
+// Returns the links which now appear to work in one direction only, by cross-checking the
+// reachability this node observes with that reported in recent heartbeat replies. A link is
+// asymmetric if a member hears another whose heartbeats it fails to reach, or a member's
+// heartbeats go unanswered by one which reports hearing them. Reports from members this node
+// cannot reach are ignored, as they may be stale
+func (this *Cluster) detectAsymmetry() []AsymmetricLink {
+    now := this.clock.Now()
+    own := this.reachabilityReport()
+    reports := map[uint64]reachabilityReport{this.roleId: own}
+    for _, state := range this.FollowerStates() {
+        if own.unanswered[state.RoleId] || now.Sub(state.Received) > this.peerTimeouts(state.RoleId).Election { continue }
+        report := reachabilityReport{make(map[uint64]bool), make(map[uint64]bool)}
+        for _, roleId := range state.Heard {
+            report.heard[roleId] = true
+        }
+        for _, roleId := range state.Unanswered {
+            report.unanswered[roleId] = true
+        }
+        reports[state.RoleId] = report
+    }
+
+    found := make(map[[2]uint64]bool)
+    var links []AsymmetricLink = nil
+    add := func(link AsymmetricLink) {
+        if found[[2]uint64{link.From, link.To}] { return }
+        found[[2]uint64{link.From, link.To}] = true
+        links = append(links, link)
+    }
+    for witness, report := range reports {
+        for roleId := range report.unanswered {
+            if report.heard[roleId] {
+                add(AsymmetricLink{roleId, witness, witness, fmt.Sprintf("role %d hears role %d, but its heartbeats to %d go unanswered",
+                                                                  witness, roleId, roleId)})
+            } else if other, exists := reports[roleId]; exists && other.heard[witness] {
+                add(AsymmetricLink{witness, roleId, roleId, fmt.Sprintf("role %d hears role %d, whose heartbeats to it go unanswered",
+                                                                roleId, witness)})
+            }
+        }
+    }
+    sort.Slice(links, func(i, j int) bool {
+        if links[i].From != links[j].From { return links[i].From < links[j].From }
+        return links[i].To < links[j].To
+    })
+    return links
+}
+
+// Confirms links seen asymmetric for an election timeout of either member, which rides out a
+// connection being re-established after a failure, alerting operators as each is confirmed and
+// noting it healed once it is no longer seen
+func (this *Cluster) checkAsymmetry() {
+    seen := this.detectAsymmetry()
+    now := this.clock.Now()
+    this.reachability.exclude.Lock()
+    previous := make(map[[2]uint64]bool)
+    for _, link := range this.reachability.links {
+        previous[[2]uint64{link.From, link.To}] = true
+    }
+    suspected := make(map[[2]uint64]time.Time)
+    confirmed := make(map[[2]uint64]bool)
+    var links, detected []AsymmetricLink = nil, nil
+    for _, link := range seen {
+        key := [2]uint64{link.From, link.To}
+        first, exists := this.reachability.suspected[key]
+        if !exists {
+            first = now
+        }
+        suspected[key] = first
+        election := this.peerTimeouts(link.From).Election
+        if other := this.peerTimeouts(link.To).Election; other > election {
+            election = other
+        }
+        if now.Sub(first) < election { continue }
+        confirmed[key] = true
+        links = append(links, link)
+        if !previous[key] {
+            detected = append(detected, link)
+        }
+    }
+    var healed [][2]uint64 = nil
+    for key := range previous {
+        if !confirmed[key] {
+            healed = append(healed, key)
+        }
+    }
+    this.reachability.suspected = suspected
+    this.reachability.links = links
+    this.reachability.checked = now
+    this.reachability.exclude.Unlock()
+
+    for _, link := range detected {
+        reachabilityStats.Add("asymmetric", 1)
+        fmt.Println("[ NETWORK", this.roleId, "] ALERT: asymmetric partition; role", link.From, "reaches role", link.To, "but not the reverse:", link.Evidence)
+        this.journal.Record(this.roleId, journal.AsymmetricPartition, "Role %d reaches role %d but not the reverse: %s", link.From, link.To, link.Evidence)
+    }
+    for _, key := range healed {
+        fmt.Println("[ NETWORK", this.roleId, "] Link between roles", key[0], "and", key[1], "no longer asymmetric")
+        this.journal.Record(this.roleId, journal.AsymmetricPartition, "Link between roles %d and %d no longer asymmetric", key[0], key[1])
+    }
+}
@@ -0,0 +1,100 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "net/rpc"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Replaces corrupt log entries with clean copies fetched from peers, retrying until
+// every entry has been repaired; entries not yet chosen are repaired once a value is chosen
+func (this *Cluster) RepairLog(log *replicatedlog.Log) {
+    for {
+        indices := log.GetCorruptIndices()
+        if len(indices) == 0 {
+            return
+        }
+
+        for _, index := range indices {
+            value, found := this.FetchChosenEntry(index)
+            if found {
+                log.SetEntryAt(index, value, proposal.Chosen())
+            }
+        }
+
+        this.clock.Sleep(this.timeouts.Get().Election)
+    }
+}
+
+// Requests up to max chosen entries starting at from from a peer supporting
+// FeatureFetchEntries; the reply may hold fewer entries than requested
+func (this *Cluster) FetchEntries(roleId uint64, from int, max int) (*acceptor.FetchEntriesResp, error) {
+    request := acceptor.FetchEntriesReq{From: from, Max: max}
+    var response acceptor.FetchEntriesResp
+    endpoint := make(chan *rpc.Call, 1)
+    current := this.beginRound()
+    sent := this.PeerSupports(roleId, FeatureFetchEntries) &&
+            this.send(this.members().peers[roleId], "AcceptorRole.FetchEntries", &request, &response, endpoint)
+    if sent {
+        current.expect(&response, roleId)
+    }
+    if !sent {
+        return nil, fmt.Errorf("Role %d cannot serve log entries", roleId)
+    }
+
+    responses := make(chan Response, 1)
+    go this.wrapReply(current, 1, endpoint, responses)
+    select {
+    case reply, open := <- responses:
+        if !open {
+            return nil, fmt.Errorf("Role %d sent no entries", roleId)
+        }
+        if reply.Error != nil {
+            return nil, reply.Error
+        }
+        return reply.Data.(*acceptor.FetchEntriesResp), nil
+    case <- this.clock.After(2*this.peerTimeouts(roleId).Rpc):
+        return nil, fmt.Errorf("Timed out fetching entries from role %d", roleId)
+    }
+}
+
+// Requests the chosen value of a log entry from all peers, returning the first verified copy
+func (this *Cluster) FetchChosenEntry(index int) ([]byte, bool) {
+    members := this.members()
+    request := acceptor.FetchReq{Index: index}
+    peerCount := uint64(0)
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    current := this.beginRound()
+    for roleId, peer := range members.peers {
+        if roleId != this.roleId && this.PeerSupports(roleId, FeatureFetch) {
+            var response acceptor.FetchResp
+            if this.send(peer, "AcceptorRole.Fetch", &request, &response, endpoint) {
+                current.expect(&response, roleId)
+                peerCount++
+            }
+        }
+    }
+
+    responses := make(chan Response, peerCount)
+    go this.wrapReply(current, peerCount, endpoint, responses)
+
+    for replyCount := uint64(0); replyCount < peerCount; replyCount++ {
+        select {
+        case reply, open := <- responses:
+            if !open {
+                return nil, false
+            }
+            if reply.Error != nil { continue }
+            response := reply.Data.(*acceptor.FetchResp)
+            if response.Chosen && replicatedlog.Checksum(response.Value) == response.Checksum {
+                fmt.Println("[ NETWORK", this.roleId, "] Fetched clean copy of entry", index)
+                return response.Value, true
+            }
+        case <- this.clock.After(2*this.roundTimeouts().Rpc):
+            return nil, false
+        }
+    }
+    return nil, false
+}
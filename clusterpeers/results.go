@@ -0,0 +1,187 @@
+package clusterpeers
+
+import (
+    "github/paxoscluster/acceptor"
+)
+
+// Reply of one acceptor to a prepare request; Promise is set unless Error is
+type PromiseResult struct {
+    RoleId uint64
+    Promise *acceptor.PrepareResp
+    Error error
+    source *round
+}
+
+// Reply of one acceptor to an accept request; Accept is set unless Error is
+type AcceptResult struct {
+    RoleId uint64
+    Accept *acceptor.ProposalResp
+    Error error
+    source *round
+}
+
+// Reply of one acceptor to an owner's proposal for its Mencius slots; Owned is set unless Error is
+type OwnedResult struct {
+    RoleId uint64
+    Owned *acceptor.OwnedResp
+    Error error
+    source *round
+}
+
+// Reply of one acceptor to a fast round; Fast is set unless Error is
+type FastResult struct {
+    RoleId uint64
+    Fast *acceptor.FastResp
+    Error error
+    source *round
+}
+
+// Reply of one member to a notice of chosen values, carrying its first unchosen index unless
+// Error is set
+type SuccessResult struct {
+    RoleId uint64
+    FirstUnchosenIndex int
+    Error error
+    source *round
+}
+
+// Typed reply to a broadcast, which knows the broadcast it answers
+type result interface {
+    origin() *round
+}
+
+func (this PromiseResult) origin() *round { return this.source }
+func (this AcceptResult) origin() *round { return this.source }
+func (this OwnedResult) origin() *round { return this.source }
+func (this FastResult) origin() *round { return this.source }
+func (this SuccessResult) origin() *round { return this.source }
+
+// Forwards the replies of a broadcast as typed results, closing results after the last
+func convert[T any](replies <-chan Response, capacity uint64, result func(Response) T) <-chan T {
+    results := make(chan T, capacity)
+    go func() {
+        defer close(results)
+        for reply := range replies {
+            results <- result(reply)
+        }
+    }()
+    return results
+}
+
+func promiseResult(reply Response) PromiseResult {
+    result := PromiseResult{RoleId: reply.RoleId, Error: reply.Error, source: reply.source}
+    if reply.Error == nil {
+        result.Promise = reply.Data.(*acceptor.PrepareResp)
+    }
+    return result
+}
+
+func acceptResult(reply Response) AcceptResult {
+    result := AcceptResult{RoleId: reply.RoleId, Error: reply.Error, source: reply.source}
+    if reply.Error == nil {
+        result.Accept = reply.Data.(*acceptor.ProposalResp)
+    }
+    return result
+}
+
+func ownedResult(reply Response) OwnedResult {
+    result := OwnedResult{RoleId: reply.RoleId, Error: reply.Error, source: reply.source}
+    if reply.Error == nil {
+        result.Owned = reply.Data.(*acceptor.OwnedResp)
+    }
+    return result
+}
+
+func fastResult(reply Response) FastResult {
+    result := FastResult{RoleId: reply.RoleId, Error: reply.Error, source: reply.source}
+    if reply.Error == nil {
+        result.Fast = reply.Data.(*acceptor.FastResp)
+    }
+    return result
+}
+
+func successResult(reply Response) SuccessResult {
+    result := SuccessResult{RoleId: reply.RoleId, Error: reply.Error, source: reply.source}
+    if reply.Error == nil {
+        result.FirstUnchosenIndex = *reply.Data.(*int)
+    }
+    return result
+}
+
+// Broadcasts a prepare phase request to the voters whose promises to the proposal are not held;
+// returns the number of peers sent the request, the number whose promises are held, and the promises
+func (this *Cluster) BroadcastPrepare(request acceptor.PrepareReq) (uint64, uint64, <-chan PromiseResult) {
+    peerCount, promised, replies := this.broadcastPrepare(request)
+    return peerCount, promised, convert(replies, peerCount, promiseResult)
+}
+
+// Broadcasts an accept request to the members not in filter; returns the number sent it and their replies
+func (this *Cluster) BroadcastAccept(request acceptor.ProposalReq, filter map[uint64]bool) (uint64, <-chan AcceptResult) {
+    peerCount, replies := this.broadcastAccept(request, filter)
+    return peerCount, convert(replies, peerCount, acceptResult)
+}
+
+// Broadcasts an owner's proposal for its own Mencius slots, the local acceptor accepting first
+func (this *Cluster) BroadcastOwned(request acceptor.OwnedReq) (uint64, <-chan OwnedResult) {
+    peerCount, replies := this.broadcastOwned(request)
+    return peerCount, convert(replies, peerCount, ownedResult)
+}
+
+// Broadcasts a value proposed in a fast round
+func (this *Cluster) BroadcastFast(request acceptor.FastReq) (uint64, <-chan FastResult) {
+    peerCount, replies := this.broadcastFast(request)
+    return peerCount, convert(replies, peerCount, fastResult)
+}
+
+// Notifies every member other than this node of a chosen value, or with the lagging fan-out only
+// those not known to have passed it; returns the number notified and their replies
+func (this *Cluster) AnnounceSuccess(info acceptor.SuccessNotify) (uint64, <-chan SuccessResult) {
+    peerCount, replies := this.broadcastSuccess(info)
+    return peerCount, convert(replies, peerCount, successResult)
+}
+
+// Directly notifies a specific node of a chosen value
+func (this *Cluster) SendSuccess(roleId uint64, info acceptor.SuccessNotify) <-chan SuccessResult {
+    return convert(this.notifyOfSuccess(roleId, info), 1, successResult)
+}
+
+// Directly notifies a specific node of chosen values for a range of entries; the node must
+// support FeatureSuccessBatch
+func (this *Cluster) SendSuccessBatch(roleId uint64, info acceptor.SuccessBatchNotify) <-chan SuccessResult {
+    return convert(this.notifyOfSuccessBatch(roleId, info), 1, successResult)
+}
+
+// Deprecated: use BroadcastPrepare, whose replies are typed. Kept for one release
+func (this *Cluster) BroadcastPrepareRequest(request acceptor.PrepareReq) (uint64, uint64, <-chan Response) {
+    return this.broadcastPrepare(request)
+}
+
+// Deprecated: use BroadcastAccept, whose replies are typed. Kept for one release
+func (this *Cluster) BroadcastProposalRequest(request acceptor.ProposalReq, filter map[uint64]bool) (uint64, <-chan Response) {
+    return this.broadcastAccept(request, filter)
+}
+
+// Deprecated: use BroadcastOwned, whose replies are typed. Kept for one release
+func (this *Cluster) BroadcastOwnedRequest(request acceptor.OwnedReq) (uint64, <-chan Response) {
+    return this.broadcastOwned(request)
+}
+
+// Deprecated: use BroadcastFast, whose replies are typed. Kept for one release
+func (this *Cluster) BroadcastFastRequest(request acceptor.FastReq) (uint64, <-chan Response) {
+    return this.broadcastFast(request)
+}
+
+// Deprecated: use AnnounceSuccess, whose replies are typed. Kept for one release
+func (this *Cluster) BroadcastSuccess(info acceptor.SuccessNotify) (uint64, <-chan Response) {
+    return this.broadcastSuccess(info)
+}
+
+// Deprecated: use SendSuccess, whose reply is typed. Kept for one release
+func (this *Cluster) NotifyOfSuccess(roleId uint64, info acceptor.SuccessNotify) <-chan Response {
+    return this.notifyOfSuccess(roleId, info)
+}
+
+// Deprecated: use SendSuccessBatch, whose reply is typed. Kept for one release
+func (this *Cluster) NotifyOfSuccessBatch(roleId uint64, info acceptor.SuccessBatchNotify) <-chan Response {
+    return this.notifyOfSuccessBatch(roleId, info)
+}
@@ -0,0 +1,178 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "sort"
+    "bytes"
+    "encoding/json"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/journal"
+)
+
+// Part a member takes in consensus. Voters are counted in quorums and may lead; learners are
+// members demoted for investigation, which still accept values and follow the leader, but are
+// sent no prepares, are counted in no quorum, and never lead
+type MemberRole int
+
+const (
+    Voter MemberRole = iota
+    Learner
+)
+
+func (this MemberRole) String() string {
+    if this == Learner {
+        return "learner"
+    }
+    return "voter"
+}
+
+// Marks the log entry changing the role of a member
+var roleMarker = []byte("\x00role ")
+
+// Change of a member's role recorded in the log; every replica applies it at the same entry
+type RoleChange struct {
+    RoleId uint64
+    Role MemberRole
+}
+
+// Builds the value changing the role of a member
+func RoleChangeValue(roleId uint64, role MemberRole) []byte {
+    encoded, _ := json.Marshal(RoleChange{roleId, role})
+    return append(append([]byte{}, roleMarker...), encoded...)
+}
+
+// Reports whether a value begins with the marker of a role change, whether or not it parses;
+// clients may not propose such values
+func IsRoleChange(value []byte) bool {
+    return bytes.HasPrefix(value, roleMarker)
+}
+
+// Parses a role change; reports false for other values
+func ParseRoleChange(value []byte) (RoleChange, bool) {
+    var change RoleChange
+    if !bytes.HasPrefix(value, roleMarker) {
+        return change, false
+    }
+    err := json.Unmarshal(value[len(roleMarker):], &change)
+    return change, err == nil
+}
+
+// Reports whether a role is a member counted in quorums
+func (this *Cluster) IsVoter(roleId uint64) bool {
+    members := this.members()
+    _, exists := members.peers[roleId]
+    return exists && !members.learners[roleId]
+}
+
+// Returns the members demoted to learners, in order
+func (this *Cluster) GetLearners() []uint64 {
+    return this.members().learnerIds()
+}
+
+// Returns the number of members counted in quorums
+func (this *membership) voterCount() uint64 {
+    return uint64(len(this.peers)-len(this.learners))
+}
+
+func (this *membership) learnerIds() []uint64 {
+    learners := make([]uint64, 0, len(this.learners))
+    for roleId := range this.learners {
+        learners = append(learners, roleId)
+    }
+    sort.Slice(learners, func(i, j int) bool { return learners[i] < learners[j] })
+    return learners
+}
+
+// Checks that a member may take a role: quorums of the voters before and after the change
+// must intersect, and the voters after it must be able to form a quorum. Changes move at most
+// one voter, so majorities always intersect; a fixed quorum size must exceed half of either
+func (this *Cluster) CheckRoleChange(roleId uint64, role MemberRole) error {
+    members := this.members()
+    if _, exists := members.peers[roleId]; !exists {
+        return fmt.Errorf("Role %d is not a member of the cluster", roleId)
+    }
+    voters := members.voterCount()
+    if role == Learner && !members.learners[roleId] {
+        voters--
+    } else if role == Voter && members.learners[roleId] {
+        voters++
+    }
+    if members.quorum.QuorumSize(voters) > voters {
+        return fmt.Errorf("Demoting role %d would leave %d voters, too few for a quorum of %d", roleId, voters, members.quorum.QuorumSize(voters))
+    }
+    if members.quorum.Size != 0 && 2*members.quorum.Size <= voters {
+        return fmt.Errorf("Quorums of %d among %d voters need not intersect", members.quorum.Size, voters)
+    }
+    return nil
+}
+
+// Restores the roles in force when the node stopped, as of the entry at index; must be called
+// before the log is constructed. Role changes are persisted with store as they are applied,
+// and replayed entries at or below index are skipped
+func (this *Cluster) RecoverRoles(index int, learners []uint64, store func(int, []uint64) error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    current := this.members()
+    changed := membership{peers: current.peers, quorum: current.quorum, learners: make(map[uint64]bool), epoch: index}
+    for _, roleId := range learners {
+        if _, exists := current.peers[roleId]; !exists { continue }
+        changed.learners[roleId] = true
+    }
+    this.membership.Store(&changed)
+    this.rolesIndex = index
+    this.storeRoles = store
+    if len(changed.learners) != 0 {
+        fmt.Println("[ NETWORK", this.roleId, "] Recovered learners", changed.learnerIds())
+    }
+}
+
+// Applies a role change chosen in the log; register with Hooks.OnApplyEntry before the log is
+// constructed, so changes replayed on recovery are applied. Changes invalid when applied are
+// ignored by every replica alike
+func (this *Cluster) observeRoleChange(entry hooks.Entry) {
+    change, isChange := ParseRoleChange(entry.Value)
+    if !isChange { return }
+
+    this.exclude.Lock()
+    if entry.Index <= this.rolesIndex {
+        this.exclude.Unlock()
+        return
+    }
+    this.rolesIndex = entry.Index
+    current := this.members()
+    if current.learners[change.RoleId] == (change.Role == Learner) {
+        this.exclude.Unlock()
+        return
+    }
+    err := this.CheckRoleChange(change.RoleId, change.Role)
+    if err != nil {
+        this.exclude.Unlock()
+        fmt.Println("[ NETWORK", this.roleId, "] Ignoring role change at entry", entry.Index, ":", err)
+        return
+    }
+
+    changed := membership{peers: current.peers, quorum: current.quorum, learners: make(map[uint64]bool), epoch: entry.Index}
+    for roleId := range current.learners {
+        changed.learners[roleId] = true
+    }
+    if change.Role == Learner {
+        changed.learners[change.RoleId] = true
+        // A learner's promise no longer counts toward a quorum
+        delete(this.lease.promised, change.RoleId)
+    } else {
+        delete(changed.learners, change.RoleId)
+    }
+    this.membership.Store(&changed)
+    store := this.storeRoles
+    this.exclude.Unlock()
+
+    if store != nil {
+        err = store(entry.Index, changed.learnerIds())
+        if err != nil {
+            fmt.Println("[ NETWORK", this.roleId, "] Failed to write roles to disk:", err)
+        }
+    }
+    this.journal.Record(this.roleId, journal.RoleChanged, "Role %d became a %v at entry %d", change.RoleId, change.Role, entry.Index)
+    fmt.Println("[ NETWORK", this.roleId, "] Role", change.RoleId, "became a", change.Role, "at entry", entry.Index)
+}
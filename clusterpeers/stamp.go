@@ -0,0 +1,182 @@
+package clusterpeers
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+    "strconv"
+    "strings"
+    "net/rpc"
+    "encoding/gob"
+)
+
+// Requests refused when sent under an earlier membership epoch than the receiver's: those
+// whose replies count toward quorums, which a stale sender may count wrongly. Heartbeats,
+// catch-up, and notices of chosen values hold under any membership
+var epochGuarded = map[string]bool {
+    "AcceptorRole.Prepare": true,
+    "AcceptorRole.Accept": true,
+    "AcceptorRole.AcceptOwned": true,
+    "AcceptorRole.AcceptFast": true,
+}
+
+// Marks the receiver's epoch within a stale epoch error message
+const currentEpochMarker = "; current epoch "
+
+// Cluster and membership epoch a request was sent under, encoded between its header and body
+// on connections where both ends advertise FeatureStamp
+type stamp struct {
+    ClusterId string
+    Epoch int
+}
+
+// Returns the index of the log entry of the last role change applied, or -1 if none. Members
+// refuse prepares and accepts sent under an earlier epoch, by a node yet to learn of the change
+func (this *Cluster) GetEpoch() int {
+    return this.members().epoch
+}
+
+// Returns the stamp of requests sent now
+func (this *transport) stamp() stamp {
+    epoch := -1
+    if this.epoch != nil {
+        epoch = this.epoch()
+    }
+    return stamp{this.clusterId, epoch}
+}
+
+// Refuses a request stamped with another cluster, or a guarded request sent by a member under an
+// earlier epoch than this node's. Clients, with roleId 0, and nodes tracking no epoch are not compared
+func (this *transport) checkStamp(roleId uint64, serviceMethod string, received stamp) error {
+    if len(this.clusterId) != 0 && len(received.ClusterId) != 0 && received.ClusterId != this.clusterId {
+        protocolStats.Add("wrongCluster", 1)
+        return fmt.Errorf("Failure: request from cluster %q refused by cluster %q", received.ClusterId, this.clusterId)
+    }
+    if this.epoch == nil || roleId == 0 || !epochGuarded[serviceMethod] { return nil }
+    if current := this.epoch(); received.Epoch < current {
+        protocolStats.Add("staleEpoch", 1)
+        return fmt.Errorf("Failure: %s from role %d sent under stale membership epoch %d%s%d", serviceMethod, roleId, received.Epoch, currentEpochMarker, current)
+    }
+    return nil
+}
+
+// Extracts the membership epoch of the peer which refused a request as sent under an earlier one
+func ParseStaleEpoch(err error) (int, bool) {
+    if err == nil { return 0, false }
+    message := err.Error()
+    separator := strings.Index(message, currentEpochMarker)
+    if separator < 0 { return 0, false }
+    epoch, parseErr := strconv.Atoi(message[separator+len(currentEpochMarker):])
+    if parseErr != nil { return 0, false }
+    return epoch, true
+}
+
+// Returns an RPC client over a connection, stamping each request if the server understands stamps
+func (this *transport) client(connection io.ReadWriteCloser, agreed capabilities) *rpc.Client {
+    if !agreed.supports(FeatureStamp) {
+        return rpc.NewClient(connection)
+    }
+    buffer := bufio.NewWriter(connection)
+    codec := stampedClientCodec{connection, gob.NewDecoder(connection), gob.NewEncoder(buffer), buffer, this.stamp}
+    return rpc.NewClientWithCodec(&codec)
+}
+
+// Serves RPCs arriving over a connection, checking the stamp of each if the client sends them
+func (this *transport) serveConn(handler *rpc.Server, connection io.ReadWriteCloser, agreed capabilities) {
+    if !agreed.supports(FeatureStamp) {
+        handler.ServeConn(connection)
+        return
+    }
+    buffer := bufio.NewWriter(connection)
+    codec := stampedServerCodec{connection: connection, decoder: gob.NewDecoder(connection), encoder: gob.NewEncoder(buffer), buffer: buffer}
+    codec.check = func(serviceMethod string, received stamp) error {
+        return this.checkStamp(agreed.roleId, serviceMethod, received)
+    }
+    handler.ServeCodec(&codec)
+}
+
+// Gob codec of the RPC client which sends a stamp with every request
+type stampedClientCodec struct {
+    connection io.ReadWriteCloser
+    decoder *gob.Decoder
+    encoder *gob.Encoder
+    buffer *bufio.Writer
+    stamp func() stamp
+}
+
+func (this *stampedClientCodec) WriteRequest(request *rpc.Request, body interface{}) error {
+    err := this.encoder.Encode(request)
+    if err == nil {
+        err = this.encoder.Encode(this.stamp())
+    }
+    if err == nil {
+        err = this.encoder.Encode(body)
+    }
+    if err == nil {
+        err = this.buffer.Flush()
+    }
+    if err != nil {
+        this.connection.Close()
+    }
+    return err
+}
+
+func (this *stampedClientCodec) ReadResponseHeader(response *rpc.Response) error {
+    return this.decoder.Decode(response)
+}
+
+func (this *stampedClientCodec) ReadResponseBody(body interface{}) error {
+    return this.decoder.Decode(body)
+}
+
+func (this *stampedClientCodec) Close() error {
+    return this.connection.Close()
+}
+
+// Gob codec of the RPC server which checks the stamp of every request before it is handled;
+// a refused request is answered with the error
+type stampedServerCodec struct {
+    connection io.ReadWriteCloser
+    decoder *gob.Decoder
+    encoder *gob.Encoder
+    buffer *bufio.Writer
+    check func(string, stamp) error
+    serviceMethod string
+    closed bool
+}
+
+func (this *stampedServerCodec) ReadRequestHeader(request *rpc.Request) error {
+    err := this.decoder.Decode(request)
+    this.serviceMethod = request.ServiceMethod
+    return err
+}
+
+func (this *stampedServerCodec) ReadRequestBody(body interface{}) error {
+    var received stamp
+    err := this.decoder.Decode(&received)
+    if err != nil { return err }
+    err = this.decoder.Decode(body)
+    if err != nil || body == nil { return err }
+    return this.check(this.serviceMethod, received)
+}
+
+func (this *stampedServerCodec) WriteResponse(response *rpc.Response, body interface{}) error {
+    err := this.encoder.Encode(response)
+    if err == nil {
+        err = this.encoder.Encode(body)
+    }
+    if err == nil {
+        err = this.buffer.Flush()
+    }
+    if err != nil && !this.closed {
+        this.closed = true
+        this.connection.Close()
+    }
+    return err
+}
+
+func (this *stampedServerCodec) Close() error {
+    if this.closed { return nil }
+    this.closed = true
+    return this.connection.Close()
+}
@@ -0,0 +1,199 @@
+package clusterpeers
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Weight of each new sample in a peer's moving average latency, as a fraction 1/n
+const latencyWeight = 8
+
+// Number of recent response times kept per peer for percentiles
+const latencySamples = 256
+
+// RPC statistics of one peer since this node started
+type PeerStats struct {
+    RoleId uint64
+    // Requests sent to the peer, and those which returned an error
+    Calls uint64
+    Failures uint64
+    // Requests not answered within the RPC timeout, whether or not they were answered later
+    Timeouts uint64
+    // Moving average and percentiles of recent successful response times
+    MeanLatency time.Duration
+    P50Latency time.Duration
+    P90Latency time.Duration
+    P99Latency time.Duration
+    // Time of the last successful response; zero if the peer has never answered
+    LastContact time.Time
+    // Connections re-established after the first
+    Reconnects uint64
+    Slow bool
+}
+
+// Statistics being collected for one peer
+type peerRecord struct {
+    stats PeerStats
+    samples []time.Duration
+    next int
+    connected bool
+}
+
+// Collects each peer's RPC statistics, and tracks the peers currently considered slow
+type latencyTracker struct {
+    records map[uint64]*peerRecord
+    exclude sync.Mutex
+}
+
+func constructLatencyTracker() *latencyTracker {
+    newLatencyTracker := latencyTracker {
+        records: make(map[uint64]*peerRecord),
+    }
+    return &newLatencyTracker
+}
+
+// Returns the record of a peer, creating it if necessary; must be called under the lock
+func (this *latencyTracker) record(roleId uint64) *peerRecord {
+    record, exists := this.records[roleId]
+    if !exists {
+        record = &peerRecord{stats: PeerStats{RoleId: roleId}}
+        this.records[roleId] = record
+    }
+    return record
+}
+
+// Counts a request sent to a peer
+func (this *latencyTracker) issue(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.record(roleId).stats.Calls++
+}
+
+// Counts a request which returned an error
+func (this *latencyTracker) fail(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.record(roleId).stats.Failures++
+}
+
+// Counts a request not answered within the RPC timeout
+func (this *latencyTracker) timeout(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.record(roleId).stats.Timeouts++
+}
+
+// Counts a connection established to a peer; every connection after the first is a reconnect
+func (this *latencyTracker) connect(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := this.record(roleId)
+    if record.connected {
+        record.stats.Reconnects++
+    }
+    record.connected = true
+}
+
+// Reports whether a peer has ever answered a request
+func (this *latencyTracker) hasAnswered(roleId uint64) bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return !this.record(roleId).stats.LastContact.IsZero()
+}
+
+// Folds a successful response time into a peer's statistics
+func (this *latencyTracker) observe(roleId uint64, now time.Time, latency time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := this.record(roleId)
+    record.stats.LastContact = now
+    if len(record.samples) < latencySamples {
+        record.samples = append(record.samples, latency)
+    } else {
+        record.samples[record.next] = latency
+        record.next = (record.next+1) % latencySamples
+    }
+    if len(record.samples) == 1 {
+        record.stats.MeanLatency = latency
+        return
+    }
+    mean := record.stats.MeanLatency
+    record.stats.MeanLatency = mean + (latency-mean)/latencyWeight
+}
+
+// Returns a peer's moving average latency; zero if it has not yet answered
+func (this *latencyTracker) get(roleId uint64) time.Duration {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.record(roleId).stats.MeanLatency
+}
+
+// Records whether a peer is slow, reporting changes
+func (this *latencyTracker) mark(selfId uint64, roleId uint64, slow bool) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := this.record(roleId)
+    if record.stats.Slow == slow { return }
+    record.stats.Slow = slow
+    if slow {
+        fmt.Println("[ NETWORK", selfId, "] Peer", roleId, "is slow; sending to it last")
+        latencyStats.Add("slowPeers", 1)
+    } else {
+        fmt.Println("[ NETWORK", selfId, "] Peer", roleId, "is no longer slow")
+        latencyStats.Add("slowPeers", -1)
+    }
+}
+
+// Reports whether a peer was last found slow
+func (this *latencyTracker) isSlow(roleId uint64) bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.record(roleId).stats.Slow
+}
+
+// Returns a copy of a peer's statistics with percentiles computed from recent samples
+func (this *latencyTracker) snapshot(roleId uint64) PeerStats {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := this.record(roleId)
+    stats := record.stats
+    if len(record.samples) != 0 {
+        sorted := append([]time.Duration(nil), record.samples...)
+        sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+        percentile := func(p int) time.Duration { return sorted[(len(sorted)-1)*p/100] }
+        stats.P50Latency = percentile(50)
+        stats.P90Latency = percentile(90)
+        stats.P99Latency = percentile(99)
+    }
+    return stats
+}
+
+// Returns the RPC statistics of every peer other than this node, ordered by roleId
+func (this *Cluster) PeerStats() []PeerStats {
+    members := this.members()
+    roleIds := make([]uint64, 0, len(members.peers))
+    for roleId := range members.peers {
+        if roleId != this.roleId {
+            roleIds = append(roleIds, roleId)
+        }
+    }
+
+    sort.Slice(roleIds, func(i, j int) bool { return roleIds[i] < roleIds[j] })
+    stats := make([]PeerStats, 0, len(roleIds))
+    for _, roleId := range roleIds {
+        stats = append(stats, this.latency.snapshot(roleId))
+    }
+    return stats
+}
@@ -0,0 +1,65 @@
+package clusterpeers
+
+import (
+    "net/rpc"
+    "github/paxoscluster/acceptor"
+)
+
+// Notifies every member other than this node of a chosen value, or with the lagging fan-out
+// only those not known to have passed it. Returns the number of members notified and their
+// replies, each carrying the member's first unchosen index, which is recorded as it arrives
+func (this *Cluster) broadcastSuccess(info acceptor.SuccessNotify) (uint64, <-chan Response) {
+    members := this.members()
+    peerCount := uint64(0)
+    endpoint := make(chan *rpc.Call, len(members.peers))
+    current := this.beginRound()
+    for roleId, peer := range members.peers {
+        if roleId == this.roleId || (this.laggingFanout && this.hasLearned(roleId, info.Index)) { continue }
+        var firstUnchosenIndex int
+        if this.send(peer, "AcceptorRole.Success", &info, &firstUnchosenIndex, endpoint) {
+            current.expect(&firstUnchosenIndex, roleId)
+            peerCount++
+        }
+    }
+
+    replies := make(chan Response, peerCount)
+    go this.wrapReply(current, peerCount, endpoint, replies)
+    responses := make(chan Response, peerCount)
+    go func() {
+        for reply := range replies {
+            if reply.Error == nil {
+                this.exclude.Lock()
+                this.advance(reply.RoleId, *reply.Data.(*int))
+                this.exclude.Unlock()
+            }
+            responses <- reply
+        }
+        close(responses)
+    }()
+    return peerCount, responses
+}
+
+// Returns the first unchosen index last reported by a member, in reply to a success
+// notification or a heartbeat; false if it has reported none
+func (this *Cluster) GetFirstUnchosenIndex(roleId uint64) (int, bool) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    index, exists := this.progress[roleId]
+    return index, exists
+}
+
+// Reports whether a member is known to have learned the value chosen at an index
+func (this *Cluster) hasLearned(roleId uint64, index int) bool {
+    firstUnchosenIndex, exists := this.GetFirstUnchosenIndex(roleId)
+    return exists && firstUnchosenIndex > index
+}
+
+// Records a member's first unchosen index. Replies may arrive out of order, so the index only
+// advances; a member which lost its log is caught up by the leader's next accept request.
+// exclude MUST be locked
+func (this *Cluster) advance(roleId uint64, firstUnchosenIndex int) {
+    if firstUnchosenIndex > this.progress[roleId] {
+        this.progress[roleId] = firstUnchosenIndex
+    }
+}
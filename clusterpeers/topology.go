@@ -0,0 +1,58 @@
+package clusterpeers
+
+import (
+    "time"
+    "github/paxoscluster/config"
+)
+
+// Reports whether a peer is across a WAN: it and this node are both labelled, with different
+// labels. Unlabelled peers are taken to be on the LAN
+func (this *Cluster) IsRemote(roleId uint64) bool {
+    local, remote := this.labels[this.roleId], this.labels[roleId]
+    return len(local) != 0 && len(remote) != 0 && local != remote
+}
+
+// Reports whether any voter is across a WAN, so a quorum may need its reply
+func (this *Cluster) HasRemoteVoters() bool {
+    members := this.members()
+    for roleId := range members.peers {
+        if !members.learners[roleId] && this.IsRemote(roleId) { return true }
+    }
+    return false
+}
+
+// Returns the timeouts governing exchanges with a peer
+func (this *Cluster) peerTimeouts(roleId uint64) config.Timeouts {
+    return this.timeouts.Get().Profile(this.IsRemote(roleId))
+}
+
+// Returns the timeouts bounding a request broadcast to the voters
+func (this *Cluster) roundTimeouts() config.Timeouts {
+    return this.timeouts.Get().Profile(this.HasRemoteVoters())
+}
+
+// Reports whether a heartbeat is due to a peer, recording it sent if so. Heartbeats go out
+// every LAN interval, and to a peer across a WAN only once its own interval has nearly passed
+func (this *Cluster) heartbeatDue(roleId uint64) bool {
+    if !this.IsRemote(roleId) { return true }
+    timeouts := this.timeouts.Get()
+    interval := timeouts.Profile(true).Heartbeat - timeouts.Heartbeat/2
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    now := this.clock.Now()
+    if now.Sub(this.pulses[roleId]) < interval { return false }
+    this.pulses[roleId] = now
+    return true
+}
+
+// Returns how long a heartbeat waits for replies from the peers sent it
+func (this *Cluster) heartbeatWait(sent map[uint64]bool) time.Duration {
+    wait := this.timeouts.Get().Heartbeat/2
+    for roleId := range sent {
+        if remote := this.peerTimeouts(roleId).Heartbeat/2; remote > wait {
+            wait = remote
+        }
+    }
+    return wait
+}
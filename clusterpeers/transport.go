@@ -0,0 +1,261 @@
+package clusterpeers
+
+import (
+    "io"
+    "context"
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "net/rpc"
+    "crypto/tls"
+    "encoding/binary"
+    "github/paxoscluster/config"
+)
+
+// Settings applied to every connection this node opens or accepts. Connections are layered
+// as TLS, then authentication, then protocol negotiation and compression, then multiplexing,
+// beneath an RPC codec stamping each request with the cluster and membership epoch. Peers
+// identify themselves after negotiation. Only a transport with a handler multiplexes: it
+// serves the requests arriving over connections it dialed, and passes those it accepted to
+// inbound when the dialer authenticated with the key of the member it names
+type transport struct {
+    roleId uint64
+    instance uint64
+    clusterId string
+    tlsConfig *tls.Config
+    auth *authenticator
+    compression byte
+    timeouts *config.LiveTimeouts
+    socket config.SocketConfig
+    handler *rpc.Server
+    inbound func(roleId uint64, connection *rpc.Client, agreed capabilities)
+    // Membership epoch stamped on requests; nil for nodes which are not members
+    epoch func() int
+}
+
+func constructTransport(settings *config.Config, roleId uint64) (*transport, error) {
+    tlsConfig, err := settings.TLS.Build()
+    if err != nil { return nil, err }
+    auth, err := constructAuthenticator(settings.Authentication)
+    if err != nil { return nil, err }
+    instance, err := drawInstance()
+    if err != nil { return nil, err }
+
+    newTransport := transport {
+        roleId: roleId,
+        instance: instance,
+        clusterId: settings.ClusterId,
+        tlsConfig: tlsConfig,
+        auth: auth,
+        compression: compressionAlgorithm(settings.Compression.Algorithm),
+        timeouts: config.ConstructLiveTimeouts(settings.Timeouts),
+        socket: settings.Socket,
+    }
+    return &newTransport, nil
+}
+
+// Builds the transport of the client listener, or of a client dialing it. Clients are
+// authorized by bearer tokens on each request, so it is secured only by the client TLS
+// settings: it holds no peer key, and never multiplexes, so no connection is adopted
+func constructClientTransport(settings *config.Config, roleId uint64, isServer bool) (*transport, error) {
+    build := settings.Client.BuildClient
+    if isServer {
+        build = settings.Client.BuildServer
+    }
+    tlsConfig, err := build()
+    if err != nil { return nil, err }
+
+    newTransport := transport {
+        roleId: roleId,
+        clusterId: settings.ClusterId,
+        tlsConfig: tlsConfig,
+        compression: compressionAlgorithm(settings.Compression.Algorithm),
+        timeouts: config.ConstructLiveTimeouts(settings.Timeouts),
+        socket: settings.Socket,
+    }
+    return &newTransport, nil
+}
+
+// Opens an RPC connection to a node's client listener as a client, secured by the client
+// TLS settings of the configuration. Without a client listener the peer listener is dialed
+// the same way, which succeeds only if peers use neither TLS nor authentication
+func Dial(address string, settings *config.Config) (*rpc.Client, error) {
+    client, err := constructClientTransport(settings, 0, false)
+    if err != nil { return nil, err }
+    connection, _, err := client.dial(address)
+    return connection, err
+}
+
+// Opens an RPC connection to the given address, returning the capabilities agreed with its server
+func (this *transport) dial(address string) (*rpc.Client, capabilities, error) {
+    connection, err := this.connect(address)
+    if err != nil { return nil, capabilities{}, err }
+
+    authenticated, err := authenticateClient(connection, this.auth, this.roleId, this.timeouts.Get().Rpc)
+    if err != nil {
+        connection.Close()
+        return nil, capabilities{}, err
+    }
+
+    negotiated, agreed, err := negotiateClient(authenticated, this.features(), this.timeouts.Get().Rpc)
+    if err == errNoHello && this.auth == nil {
+        // Server predates compression and speaks plain RPC from the first byte
+        connection.Close()
+        connection, err = this.connect(address)
+        if err != nil { return nil, capabilities{}, err }
+        protocolStats.Add("unversionedConnections", 1)
+        agreed = legacyCapabilities(compressionNone)
+        return this.client(connection, agreed), agreed, nil
+    } else if err != nil {
+        connection.Close()
+        return nil, capabilities{}, err
+    }
+    if agreed.supports(FeatureIdentity) {
+        // The server proved only that it knows this node's key, so the role it names is
+        // checked against the address dialed instead
+        remote, err := this.exchangeIdentity(negotiated, true, principal{unauthenticated: true})
+        if err != nil {
+            connection.Close()
+            return nil, capabilities{}, err
+        }
+        agreed.roleId, agreed.instance = remote.roleId, remote.instance
+    }
+    if !agreed.supports(FeatureMultiplex) {
+        return this.client(negotiated, agreed), agreed, nil
+    }
+
+    if !agreed.supports(FeatureIdentity) {
+        // Names this node, so the server can send its own requests back over the connection
+        identity := make([]byte, 8)
+        binary.BigEndian.PutUint64(identity, this.roleId)
+        _, err = negotiated.Write(identity)
+        if err != nil {
+            connection.Close()
+            return nil, capabilities{}, err
+        }
+    }
+    session := constructMuxSession(negotiated)
+    go this.serveConn(this.handler, session.channel(reverseChannel), agreed)
+    return this.client(session.channel(forwardChannel), agreed), agreed, nil
+}
+
+// Opens a connection to the given address, secured with TLS if configured
+func (this *transport) connect(address string) (net.Conn, error) {
+    network, target := splitAddress(address)
+    custom, err := lookupNetwork(network)
+    if err != nil { return nil, err }
+    var connection net.Conn
+    if custom != nil && this.tlsConfig == nil {
+        return nil, fmt.Errorf("Network %s requires TLS", network)
+    } else if custom != nil {
+        connection, err = custom.Dial(target, this.tlsConfig)
+    } else if this.tlsConfig == nil {
+        connection, err = this.dialer().Dial(network, target)
+    } else {
+        connection, err = tls.DialWithDialer(this.dialer(), network, target, this.tlsConfig)
+    }
+    if err != nil { return nil, err }
+    err = this.tune(connection)
+    if err != nil {
+        connection.Close()
+        return nil, err
+    }
+    return connection, nil
+}
+
+// Listens on the given address, removing any stale Unix domain socket left by a previous run
+func (this *transport) listen(address string) (net.Listener, error) {
+    network, target := splitAddress(address)
+    custom, err := lookupNetwork(network)
+    if err != nil { return nil, err }
+    if custom != nil {
+        if this.tlsConfig == nil {
+            return nil, fmt.Errorf("Network %s requires TLS", network)
+        }
+        return custom.Listen(target, this.tlsConfig)
+    }
+    if network == "unix" {
+        err := os.Remove(target)
+        if err != nil && !os.IsNotExist(err) { return nil, err }
+    }
+
+    listenConfig := net.ListenConfig{KeepAlive: keepAlivePeriod(this.socket.KeepAlive)}
+    ln, err := listenConfig.Listen(context.Background(), network, target)
+    if err != nil { return nil, err }
+    if this.tlsConfig != nil {
+        ln = tls.NewListener(ln, this.tlsConfig)
+    }
+    return ln, nil
+}
+
+// Prepares an accepted connection to be served, returning the capabilities agreed with its client
+func (this *transport) accept(connection net.Conn) (net.Conn, capabilities, error) {
+    err := this.tune(connection)
+    if err != nil { return nil, capabilities{}, err }
+    authenticated, remote, err := authenticateServer(connection, this.auth, this.timeouts.Get().Rpc)
+    if err != nil { return nil, capabilities{}, err }
+    negotiated, agreed, err := negotiateServer(authenticated, this.features(), this.timeouts.Get().Rpc)
+    if err != nil { return nil, capabilities{}, err }
+    if agreed.supports(FeatureIdentity) {
+        named, err := this.exchangeIdentity(negotiated, false, remote)
+        if err != nil { return nil, capabilities{}, err }
+        agreed.roleId, agreed.instance = named.roleId, named.instance
+    }
+    if !agreed.supports(FeatureMultiplex) {
+        return negotiated, agreed, nil
+    }
+
+    if !agreed.supports(FeatureIdentity) {
+        identity := make([]byte, 8)
+        connection.SetDeadline(time.Now().Add(this.timeouts.Get().Rpc))
+        _, err = io.ReadFull(negotiated, identity)
+        connection.SetDeadline(time.Time{})
+        if err != nil { return nil, capabilities{}, err }
+        agreed.roleId = binary.BigEndian.Uint64(identity)
+    }
+    if !remote.admits(agreed.roleId) {
+        authenticationStats.Add("rejectedConnections", 1)
+        return nil, capabilities{}, fmt.Errorf("Role %d named itself role %d", remote.roleId, agreed.roleId)
+    }
+    session := constructMuxSession(negotiated)
+    // Requests for a peer are sent back only over connections which proved to come from it
+    if this.inbound != nil && remote.vouchesFor(agreed.roleId) {
+        this.inbound(agreed.roleId, this.client(session.channel(reverseChannel), agreed), agreed)
+    }
+    return session.channel(forwardChannel), agreed, nil
+}
+
+// Returns the dialer of TCP and Unix connections; a connection attempt, including the TLS
+// handshake, fails after the dial timeout so an unreachable peer cannot stall its caller
+func (this *transport) dialer() *net.Dialer {
+    return &net.Dialer{Timeout: this.socket.DialTimeout, KeepAlive: keepAlivePeriod(this.socket.KeepAlive)}
+}
+
+// Applies the configured socket options to a TCP connection; other connections are left as is
+func (this *transport) tune(connection net.Conn) error {
+    if secured, isTLS := connection.(*tls.Conn); isTLS {
+        connection = secured.NetConn()
+    }
+    socket, isTCP := connection.(*net.TCPConn)
+    if !isTCP { return nil }
+    err := socket.SetNoDelay(this.socket.NoDelay)
+    if err != nil { return err }
+    if this.socket.ReadBuffer != 0 {
+        err = socket.SetReadBuffer(int(this.socket.ReadBuffer))
+        if err != nil { return err }
+    }
+    if this.socket.WriteBuffer != 0 {
+        err = socket.SetWriteBuffer(int(this.socket.WriteBuffer))
+        if err != nil { return err }
+    }
+    return nil
+}
+
+// Converts a configured keepalive interval to the net package's, where negative disables probes
+func keepAlivePeriod(interval time.Duration) time.Duration {
+    if interval == 0 {
+        return -1
+    }
+    return interval
+}
@@ -0,0 +1,71 @@
+package clusterpeers
+
+import (
+    "fmt"
+)
+
+// Stage of a rolling upgrade, derived from the protocol versions negotiated with every voter
+type UpgradeState int
+
+const (
+    UpgradeAllOld UpgradeState = iota
+    UpgradeMixed
+    UpgradeAllNew
+)
+
+func (this UpgradeState) String() string {
+    switch this {
+    case UpgradeAllOld:
+        return "all-old"
+    case UpgradeMixed:
+        return "mixed"
+    default:
+        return "all-new"
+    }
+}
+
+// Returns the upgrade stage of the cluster. Log entry formats introduced by the current
+// protocol version must not be proposed until the cluster is all-new, as older nodes would
+// apply them incorrectly
+func (this *Cluster) GetUpgradeState() UpgradeState {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.upgrade
+}
+
+// Advances the upgrade stage once peers acknowledge the current version. Peers not yet
+// connected count as old. The all-new stage is final: new entry formats may already be in
+// the log, so later connections from old nodes are reported rather than returning the
+// cluster to mixed
+func (this *Cluster) updateUpgradeState() {
+    members := this.members()
+    upgraded := uint64(1)
+    for roleId, peer := range members.peers {
+        if _, agreed := peer.connection(); roleId != this.roleId && agreed.version >= ProtocolVersion {
+            upgraded++
+        }
+    }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.upgrade == UpgradeAllNew {
+        if upgraded < uint64(len(members.peers)) {
+            fmt.Println("[ NETWORK", this.roleId, "] Warning: peer running protocol older than", ProtocolVersion,
+                        "joined a fully upgraded cluster")
+        }
+        return
+    }
+
+    state := UpgradeMixed
+    if upgraded == uint64(len(members.peers)) {
+        state = UpgradeAllNew
+    } else if upgraded == 1 {
+        state = UpgradeAllOld
+    }
+    if state != this.upgrade {
+        fmt.Println("[ NETWORK", this.roleId, "] Upgrade state changed from", this.upgrade, "to", state)
+        this.upgrade = state
+    }
+}
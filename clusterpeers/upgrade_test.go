@@ -0,0 +1,238 @@
+package clusterpeers
+
+import (
+    "io"
+    "net"
+    "time"
+    "testing"
+    "net/rpc"
+    "encoding/binary"
+    "github/paxoscluster/config"
+)
+
+// Returns a loopback address on a port free when it was checked
+func reserveAddress(t *testing.T) string {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    defer listener.Close()
+    return listener.Addr().String()
+}
+
+// Returns settings for a member of a cluster with the given peers, on short timeouts
+func upgradeSettings(roleId uint64, peers map[uint64]string) *config.Config {
+    settings := config.Default()
+    settings.RoleId = roleId
+    settings.Timeouts = config.Timeouts{Heartbeat: 20*time.Millisecond, Election: 100*time.Millisecond, Rpc: 200*time.Millisecond}
+    for peerId, address := range peers {
+        settings.Peers[peerId] = address
+    }
+    return settings
+}
+
+// Starts a member running this version, which negotiates every feature including multiplexing
+func launchMember(t *testing.T, roleId uint64, peers map[uint64]string) *Cluster {
+    cluster, _, _, err := ConstructCluster(upgradeSettings(roleId, peers), nil)
+    if err == nil {
+        err = cluster.Listen(rpc.NewServer())
+    }
+    if err != nil { t.Fatal(err) }
+    cluster.Connect()
+    return cluster
+}
+
+// Serves the handshake of a peer at the given address: one predating versioning if legacy,
+// otherwise one running this version but advertising only the given features
+func servePeer(t *testing.T, address string, legacy bool, features uint64) net.Listener {
+    listener, err := net.Listen("tcp", address)
+    if err != nil { t.Fatal(err) }
+    go func() {
+        for {
+            connection, err := listener.Accept()
+            if err != nil { return }
+            go func() {
+                if legacy {
+                    hello := make([]byte, 1)
+                    _, err := io.ReadFull(connection, hello)
+                    if err == nil {
+                        _, err = connection.Write([]byte{compressionNone})
+                    }
+                    if err == nil {
+                        rpc.NewServer().ServeConn(connection)
+                    }
+                    connection.Close()
+                    return
+                }
+                negotiated, _, err := negotiateServer(connection, features, time.Second)
+                if err == nil {
+                    rpc.NewServer().ServeConn(negotiated)
+                }
+                connection.Close()
+            }()
+        }
+    }()
+    return listener
+}
+
+// Waits until the cluster holds connections to every given peer
+func awaitConnected(t *testing.T, cluster *Cluster, roleIds ...uint64) {
+    deadline := time.Now().Add(5*time.Second)
+    for _, roleId := range roleIds {
+        for !cluster.IsConnected(roleId) {
+            if time.Now().After(deadline) {
+                t.Fatalf("Role %d did not connect to role %d", cluster.roleId, roleId)
+            }
+            time.Sleep(10*time.Millisecond)
+        }
+    }
+}
+
+// Waits until the cluster reaches an upgrade state
+func awaitUpgradeState(t *testing.T, cluster *Cluster, expected UpgradeState) {
+    deadline := time.Now().Add(5*time.Second)
+    for cluster.GetUpgradeState() != expected {
+        if time.Now().After(deadline) {
+            t.Fatalf("Upgrade state is %v, expected %v", cluster.GetUpgradeState(), expected)
+        }
+        time.Sleep(10*time.Millisecond)
+    }
+}
+
+func TestNegotiateMixedVersions(t *testing.T) {
+    all := FeatureFetch | FeatureSuccessBatch | FeatureFetchEntries | FeatureFollowerState | FeatureStamp | FeatureMultiplex
+    cases := []struct {
+        name string
+        server uint64
+        expected uint64
+    }{
+        {"every feature", all, all},
+        {"without stamps", all &^ FeatureStamp, all &^ FeatureStamp},
+        {"without multiplexing", all &^ FeatureMultiplex, all &^ FeatureMultiplex},
+        {"without either", all &^ (FeatureStamp | FeatureMultiplex), all &^ (FeatureStamp | FeatureMultiplex)},
+    }
+    for _, test := range cases {
+        client, server := net.Pipe()
+        served := make(chan capabilities, 1)
+        go func() {
+            _, agreed, err := negotiateServer(server, test.server, time.Second)
+            if err != nil { t.Error(err) }
+            served <- agreed
+        }()
+        _, agreed, err := negotiateClient(client, all, time.Second)
+        if err != nil { t.Fatalf("%s: %v", test.name, err) }
+        remote := <- served
+        if agreed.version != ProtocolVersion || agreed.features != test.expected || remote.features != test.expected {
+            t.Errorf("%s: client agreed %+v and server %+v, expected features %b", test.name, agreed, remote, test.expected)
+        }
+        client.Close()
+        server.Close()
+    }
+}
+
+func TestNegotiateOlderPeers(t *testing.T) {
+    // A server predating versioning answers the hello with the compression it accepts
+    client, server := net.Pipe()
+    go func() {
+        io.ReadFull(server, make([]byte, 1))
+        server.Write([]byte{compressionNone})
+    }()
+    _, agreed, err := negotiateClient(client, FeatureStamp | FeatureMultiplex, time.Second)
+    if err != nil { t.Fatal(err) }
+    if agreed.version != 1 || agreed.supports(FeatureStamp) || agreed.supports(FeatureMultiplex) {
+        t.Errorf("Agreed %+v with a server predating versioning", agreed)
+    }
+    client.Close()
+
+    // A server of a later version is spoken to in this node's version
+    client, server = net.Pipe()
+    go func() {
+        io.ReadFull(server, make([]byte, 1))
+        server.Write([]byte{versionedHello})
+        io.ReadFull(server, make([]byte, 10))
+        hello := make([]byte, 10)
+        binary.BigEndian.PutUint16(hello[0:2], ProtocolVersion+1)
+        binary.BigEndian.PutUint64(hello[2:10], FeatureStamp | 1<<40)
+        server.Write(hello)
+    }()
+    _, agreed, err = negotiateClient(client, FeatureStamp, time.Second)
+    if err != nil { t.Fatal(err) }
+    if agreed.version != ProtocolVersion || agreed.features != FeatureStamp {
+        t.Errorf("Agreed %+v with a server of a later version", agreed)
+    }
+    client.Close()
+
+    // A client predating compression opens with its first RPC, which must reach the server intact
+    client, server = net.Pipe()
+    go client.Write([]byte{0x1f, 0x2e})
+    negotiated, agreed, err := negotiateServer(server, FeatureStamp, time.Second)
+    if err != nil { t.Fatal(err) }
+    if agreed.version != 1 {
+        t.Errorf("Agreed %+v with a client predating compression", agreed)
+    }
+    opening := make([]byte, 2)
+    _, err = io.ReadFull(negotiated, opening)
+    if err != nil || opening[0] != 0x1f || opening[1] != 0x2e {
+        t.Errorf("Read %x opening the first RPC: %v", opening, err)
+    }
+    client.Close()
+}
+
+// Chunked and stamped entries may be proposed only once the cluster is all-new, which it must
+// not reach while any voter runs, or may run, an older version
+func TestUpgradeWaitsForEveryVoter(t *testing.T) {
+    peers := map[uint64]string{1: reserveAddress(t), 2: reserveAddress(t), 3: reserveAddress(t), 4: reserveAddress(t)}
+    launchMember(t, 2, peers)
+    unstamped := servePeer(t, peers[3], false, FeatureFetch | FeatureFollowerState)
+    defer unstamped.Close()
+    legacy := servePeer(t, peers[4], true, 0)
+
+    cluster := launchMember(t, 1, peers)
+    awaitConnected(t, cluster, 2, 3, 4)
+    awaitUpgradeState(t, cluster, UpgradeMixed)
+    if !cluster.PeerSupports(2, FeatureMultiplex) || !cluster.PeerSupports(2, FeatureStamp) {
+        t.Errorf("Member running this version did not negotiate multiplexing and stamps")
+    }
+    if cluster.PeerSupports(3, FeatureStamp) || cluster.PeerSupports(3, FeatureMultiplex) {
+        t.Errorf("Negotiated features role 3 does not advertise")
+    }
+    if cluster.PeerSupports(4, FeatureFetch) {
+        t.Errorf("Negotiated features with a peer predating versioning")
+    }
+
+    // The old node upgrades: it stops, and returns running this version
+    legacy.Close()
+    peer := cluster.members().peers[4]
+    comm, _ := peer.connection()
+    comm.Close()
+    cluster.registerBadConnection <- 4
+    time.Sleep(50*time.Millisecond)
+    if state := cluster.GetUpgradeState(); state != UpgradeMixed {
+        t.Errorf("Upgrade state is %v while role 4 is down, expected mixed", state)
+    }
+    upgraded := servePeer(t, peers[4], false, FeatureFetch)
+    defer upgraded.Close()
+    awaitUpgradeState(t, cluster, UpgradeAllNew)
+
+    // Entries of the new formats may already be in the log, so an old node joining later
+    // leaves the cluster all-new
+    local, remote := net.Pipe()
+    defer remote.Close()
+    cluster.install(peer, rpc.NewClient(local), legacyCapabilities(compressionNone), false)
+    cluster.updateUpgradeState()
+    if state := cluster.GetUpgradeState(); state != UpgradeAllNew {
+        t.Errorf("Upgrade state returned to %v", state)
+    }
+}
+
+func TestUpgradeAllOld(t *testing.T) {
+    peers := map[uint64]string{1: reserveAddress(t), 2: reserveAddress(t), 3: reserveAddress(t)}
+    for _, roleId := range []uint64{2, 3} {
+        legacy := servePeer(t, peers[roleId], true, 0)
+        defer legacy.Close()
+    }
+
+    cluster := launchMember(t, 1, peers)
+    awaitConnected(t, cluster, 2, 3)
+    if state := cluster.GetUpgradeState(); state != UpgradeAllOld {
+        t.Errorf("Upgrade state is %v with every peer predating versioning, expected all-old", state)
+    }
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "github/paxoscluster/role"
+    "github/paxoscluster/config"
+    "github/paxoscluster/recovery"
+)
+
+// Runs the arbiter of a two-replica cluster: a member which votes in prepare and accept rounds
+// but never leads, serves clients, or applies values. Its configuration is that of the other
+// members, with roleid set to the arbiter
+func main() {
+    configFile := flag.String("config", "", "cluster configuration naming this role as [arbiter] roleid")
+    flag.Parse()
+    if len(*configFile) == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    settings, err := config.Load(*configFile)
+    if err != nil { fail(err) }
+    disk, err := recovery.ConstructManager(settings.Storage)
+    if err != nil { fail(err) }
+    arbiter, err := role.LaunchArbiter(settings, disk)
+    if err != nil { fail(err) }
+    fmt.Println("[ ARBITER", arbiter.RoleId, "] Voting at", arbiter.Address)
+
+    // Runs until interrupted; storage is finalized on the interrupt
+    select {}
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+}
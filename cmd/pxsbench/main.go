@@ -0,0 +1,164 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "sync"
+    "time"
+    "strings"
+    "strconv"
+    "math/rand"
+    "encoding/binary"
+    "github/paxoscluster/role"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/config"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/pxsclient"
+)
+
+// Drives a cluster with concurrent clients proposing values, reporting commit latency
+// percentiles and throughput. Without -config an in-process cluster is launched
+func main() {
+    configFile := flag.String("config", "", "configuration of a remote cluster; dials its peers unless -addresses is given")
+    addressList := flag.String("addresses", "", "client addresses of a remote cluster as roleId=host:port,...")
+    nodeCount := flag.Int("nodes", 3, "nodes in the in-process cluster")
+    clientCount := flag.Int("clients", 8, "concurrent clients")
+    requestCount := flag.Int("requests", 200, "proposals issued by each client")
+    valueSize := flag.Int("size", 64, "bytes per value")
+    batchSize := flag.Int("batch", 1, "values packed into each proposal")
+    verbose := flag.Bool("verbose", false, "print node logs of the in-process cluster")
+    flag.Parse()
+
+    // Node logs go to stdout; results are reported on stderr
+    if !*verbose && len(*configFile) == 0 {
+        devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+        if err != nil { fail(err) }
+        os.Stdout = devNull
+    }
+
+    settings, addresses, err := connectTarget(*configFile, *addressList, *nodeCount)
+    if err != nil { fail(err) }
+
+    latencies := make([][]time.Duration, *clientCount)
+    failures := make([]int, *clientCount)
+    var clients sync.WaitGroup
+    started := time.Now()
+    for clientId := 0; clientId < *clientCount; clientId++ {
+        clients.Add(1)
+        go func(clientId int) {
+            defer clients.Done()
+            client := pxsclient.Construct(addresses, settings, os.Getenv("PXS_TOKEN"))
+            defer client.Close()
+            random := rand.New(rand.NewSource(int64(clientId)))
+            for count := 0; count < *requestCount; count++ {
+                value := batch(random, *batchSize, *valueSize)
+                proposed := time.Now()
+                err := client.Propose(value)
+                if err != nil {
+                    failures[clientId]++
+                    continue
+                }
+                latencies[clientId] = append(latencies[clientId], time.Since(proposed))
+            }
+        }(clientId)
+    }
+    clients.Wait()
+    report(latencies, failures, time.Since(started), *batchSize, *valueSize)
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+}
+
+// Returns the settings and client addresses of the cluster under test, launching an
+// in-process cluster if no configuration is given
+func connectTarget(configFile string, addressList string, nodeCount int) (*config.Config, map[uint64]string, error) {
+    if len(configFile) == 0 {
+        return launch(nodeCount)
+    }
+
+    settings, err := config.Load(configFile)
+    if err != nil { return nil, nil, err }
+    if len(addressList) == 0 {
+        return settings, settings.Peers, nil
+    }
+
+    addresses := make(map[uint64]string)
+    for _, pair := range strings.Split(addressList, ",") {
+        separator := strings.Index(pair, "=")
+        if separator < 0 { return nil, nil, fmt.Errorf("Expected roleId=address, found %s", pair) }
+        roleId, err := strconv.ParseUint(pair[:separator], 10, 64)
+        if err != nil { return nil, nil, err }
+        addresses[roleId] = pair[separator+1:]
+    }
+    return settings, addresses, nil
+}
+
+// Starts a cluster on Unix domain sockets in a temporary directory and waits for a leader
+func launch(nodeCount int) (*config.Config, map[uint64]string, error) {
+    directory, err := os.MkdirTemp("", "pxsbench")
+    if err != nil { return nil, nil, err }
+
+    var settings *config.Config = nil
+    var nodes []*role.Node = nil
+    for roleId := uint64(1); roleId <= uint64(nodeCount); roleId++ {
+        settings = config.Default()
+        settings.RoleId = roleId
+        settings.Storage.Directory = directory
+        for peerId := uint64(1); peerId <= uint64(nodeCount); peerId++ {
+            settings.Peers[peerId] = fmt.Sprintf("%s%s/node%d.sock", config.UnixScheme, directory, peerId)
+        }
+        disk, err := recovery.ConstructManager(settings.Storage)
+        if err != nil { return nil, nil, err }
+        node, err := role.Launch(settings, disk, hooks.Construct())
+        if err != nil { return nil, nil, err }
+        nodes = append(nodes, node)
+    }
+
+    for deadline := time.Now().Add(30*time.Second); time.Now().Before(deadline); time.Sleep(100*time.Millisecond) {
+        leaderId, _ := nodes[0].Proposer.GetLeader()
+        if leaderId != 0 {
+            return settings, settings.Peers, nil
+        }
+    }
+    return nil, nil, fmt.Errorf("No leader elected")
+}
+
+// Packs values of random bytes into one proposal, each prefixed by its uvarint length
+func batch(random *rand.Rand, batchSize int, valueSize int) []byte {
+    var packed []byte = nil
+    value := make([]byte, valueSize)
+    for count := 0; count < batchSize; count++ {
+        random.Read(value)
+        packed = binary.AppendUvarint(packed, uint64(valueSize))
+        packed = append(packed, value...)
+    }
+    return packed
+}
+
+// Prints throughput and latency percentiles of committed proposals
+func report(perClient [][]time.Duration, failures []int, elapsed time.Duration, batchSize int, valueSize int) {
+    var latencies []time.Duration = nil
+    failed := 0
+    for clientId := range perClient {
+        latencies = append(latencies, perClient[clientId]...)
+        failed += failures[clientId]
+    }
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+    committed := len(latencies)
+    seconds := elapsed.Seconds()
+    fmt.Fprintf(os.Stderr, "Committed %d proposals (%d values), %d failed, in %v\n", committed, committed*batchSize, failed, elapsed)
+    fmt.Fprintf(os.Stderr, "Throughput: %.1f proposals/s, %.1f values/s, %.3f MB/s\n", float64(committed)/seconds,
+                float64(committed*batchSize)/seconds, float64(committed*batchSize*valueSize)/seconds/1e6)
+    if committed == 0 { return }
+
+    percentile := func(fraction float64) time.Duration {
+        return latencies[int(fraction*float64(committed-1))]
+    }
+    fmt.Fprintf(os.Stderr, "Latency: p50 %v, p90 %v, p99 %v, p99.9 %v, max %v\n", percentile(0.5), percentile(0.9),
+                percentile(0.99), percentile(0.999), latencies[committed-1])
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sync"
+    "time"
+    "math/rand"
+    "encoding/json"
+    "github/paxoscluster/role"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/config"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/linearizability"
+)
+
+// Runs concurrent key-value clients against an in-process cluster under injected network
+// faults, then checks the recorded history is linearizable, exiting nonzero if it is not
+func main() {
+    nodeCount := flag.Int("nodes", 3, "nodes in the cluster")
+    clientCount := flag.Int("clients", 4, "concurrent clients")
+    operationCount := flag.Int("ops", 25, "operations issued by each client")
+    keyCount := flag.Int("keys", 2, "distinct keys")
+    dropRate := flag.Float64("drop", 0.02, "probability a message is dropped")
+    reorder := flag.Duration("reorder", 5*time.Millisecond, "window within which messages are reordered")
+    verbose := flag.Bool("verbose", false, "print node logs")
+    flag.Parse()
+
+    // Node logs go to stdout; results are reported on stderr
+    if !*verbose {
+        devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+        if err != nil { fail(err) }
+        os.Stdout = devNull
+    }
+
+    nodes, stores, err := launch(*nodeCount)
+    if err != nil { fail(err) }
+    time.Sleep(4*time.Second)
+
+    clusterpeers.Faults.Drop(*dropRate)
+    clusterpeers.Faults.Reorder(*reorder)
+    history := linearizability.ConstructHistory()
+    var clients sync.WaitGroup
+    for clientId := 0; clientId < *clientCount; clientId++ {
+        clients.Add(1)
+        go func(clientId int) {
+            defer clients.Done()
+            runClient(clientId, *operationCount, *keyCount, nodes, stores, history)
+        }(clientId)
+    }
+    clients.Wait()
+    clusterpeers.Faults.Reset()
+
+    operations := history.Operations()
+    pending := 0
+    for _, operation := range operations {
+        if operation.Pending { pending++ }
+    }
+    err = linearizability.Check(linearizability.KVModel{}, operations)
+    if err != nil { fail(err) }
+    fmt.Fprintln(os.Stderr, "History of", len(operations), "operations,", pending, "pending, is linearizable")
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+}
+
+// Starts a cluster on Unix domain sockets in a temporary directory, each node applying
+// commands to its own key-value store
+func launch(nodeCount int) ([]*role.Node, []*store, error) {
+    directory, err := os.MkdirTemp("", "pxslincheck")
+    if err != nil { return nil, nil, err }
+
+    var nodes []*role.Node = nil
+    var stores []*store = nil
+    for roleId := uint64(1); roleId <= uint64(nodeCount); roleId++ {
+        settings := config.Default()
+        settings.RoleId = roleId
+        settings.Storage.Directory = directory
+        for peerId := uint64(1); peerId <= uint64(nodeCount); peerId++ {
+            settings.Peers[peerId] = fmt.Sprintf("%s%s/node%d.sock", config.UnixScheme, directory, peerId)
+        }
+        disk, err := recovery.ConstructManager(settings.Storage)
+        if err != nil { return nil, nil, err }
+
+        kv := constructStore()
+        events := hooks.Construct()
+        events.OnApply(kv.apply)
+        node, err := role.Launch(settings, disk, events)
+        if err != nil { return nil, nil, err }
+        nodes = append(nodes, node)
+        stores = append(stores, kv)
+    }
+    return nodes, stores, nil
+}
+
+// Issues random gets and puts, following leader hints; an operation whose outcome is unknown
+// is left pending in the history
+func runClient(clientId int, operationCount int, keyCount int, nodes []*role.Node, stores []*store, history *linearizability.History) {
+    random := rand.New(rand.NewSource(int64(clientId)))
+    target := len(nodes)-1
+    for count := 0; count < operationCount; count++ {
+        input := linearizability.KVInput{Op: "get", Key: fmt.Sprintf("key%d", random.Intn(keyCount))}
+        if random.Intn(2) == 0 {
+            input.Op = "put"
+            input.Value = fmt.Sprintf("%d.%d", clientId, count)
+        }
+        request := command{fmt.Sprintf("%d.%d", clientId, count), input.Op, input.Key, input.Value}
+        data, _ := json.Marshal(&request)
+
+        id := history.Invoke(clientId, input)
+        for attempt := 0; attempt < 20; attempt++ {
+            err := replicate(nodes[target].Proposer, data)
+            if proposer.IsNotLeader(err) {
+                // Requests refused by a follower were never proposed, so are safely retried
+                leaderId, _, found := proposer.ParseLeaderHint(err)
+                if found && leaderId >= 1 && int(leaderId) <= len(nodes) {
+                    target = int(leaderId)-1
+                } else {
+                    target = random.Intn(len(nodes))
+                }
+                time.Sleep(100*time.Millisecond)
+                continue
+            }
+            if err != nil { break }
+
+            value, applied := stores[target].await(request.Id, 5*time.Second)
+            if applied {
+                history.Complete(id, linearizability.KVOutput{Value: value})
+            }
+            break
+        }
+    }
+}
+
+// Replicates a value, giving up on rounds which do not finish in time
+func replicate(proposerRole *proposer.ProposerRole, data []byte) error {
+    result := make(chan error, 1)
+    go func() {
+        var reply []byte
+        result <- proposerRole.Replicate(&data, &reply)
+    }()
+    select {
+    case err := <- result:
+        return err
+    case <- time.After(10*time.Second):
+        return fmt.Errorf("Replication timed out")
+    }
+}
+
+// Operation proposed to the log; Id identifies it across retries
+type command struct {
+    Id string
+    Op string
+    Key string
+    Value string
+}
+
+// Key-value state machine recording the result of each command it applies
+type store struct {
+    values map[string]string
+    results map[string]string
+    applied *sync.Cond
+    exclude sync.Mutex
+}
+
+func constructStore() *store {
+    newStore := store {
+        values: make(map[string]string),
+        results: make(map[string]string),
+    }
+    newStore.applied = sync.NewCond(&newStore.exclude)
+    return &newStore
+}
+
+// Applies a committed command once, even if it was chosen more than once
+func (this *store) apply(index int, value []byte) {
+    var request command
+    if json.Unmarshal(value, &request) != nil { return }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if _, seen := this.results[request.Id]; seen { return }
+    if request.Op == "put" {
+        this.values[request.Key] = request.Value
+    }
+    this.results[request.Id] = this.values[request.Key]
+    this.applied.Broadcast()
+}
+
+// Waits for a command to be applied, returning its result
+func (this *store) await(id string, timeout time.Duration) (string, bool) {
+    deadline := time.Now().Add(timeout)
+    go func() {
+        time.Sleep(timeout)
+        this.exclude.Lock()
+        this.applied.Broadcast()
+        this.exclude.Unlock()
+    }()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    for {
+        result, applied := this.results[id]
+        if applied || time.Now().After(deadline) {
+            return result, applied
+        }
+        this.applied.Wait()
+    }
+}
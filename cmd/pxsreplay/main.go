@@ -0,0 +1,178 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "bytes"
+    "encoding/json"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/config"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Replays a trace recorded by a node through fresh roles, checking each reply matches the
+// recorded reply and stopping at the first divergence
+func main() {
+    until := flag.Int("until", 0, "stop after this many records and dump the log; zero replays all")
+    verbose := flag.Bool("verbose", false, "print each record and node logs as it is replayed")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: pxsreplay [flags] trace-<roleId>.jsonl")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() != 1 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    // Node logs go to stdout; results are reported on stderr
+    if !*verbose {
+        devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+        if err != nil { fail(err) }
+        os.Stdout = devNull
+    }
+
+    traceFile, err := os.Open(flag.Arg(0))
+    if err != nil { fail(err) }
+    records, err := trace.ReadRecords(traceFile)
+    traceFile.Close()
+    if err != nil { fail(err) }
+
+    directory, err := os.MkdirTemp("", "pxsreplay")
+    if err != nil { fail(err) }
+    defer os.RemoveAll(directory)
+    disk, err := recovery.ConstructManager(config.StorageConfig{Directory: directory})
+    if err != nil { fail(err) }
+
+    replicas := make(map[uint64]*replica)
+    for step, record := range records {
+        if *until != 0 && step >= *until { break }
+        if *verbose {
+            fmt.Fprintf(os.Stderr, "%d %s role %d %s %s\n", step, record.Time.Format("15:04:05.000000"), record.RoleId, record.Method, record.Request)
+        }
+
+        if record.Method == trace.RecoverMethod {
+            replicas[record.RoleId], err = restore(record, disk)
+            if err != nil { fail(fmt.Errorf("Record %d: %v", step, err)) }
+            continue
+        }
+        current, exists := replicas[record.RoleId]
+        if !exists { fail(fmt.Errorf("Record %d: role %d has no recovered state; the trace must begin at launch", step, record.RoleId)) }
+
+        reply, err := current.apply(record)
+        if err != nil { fail(fmt.Errorf("Record %d: %v", step, err)) }
+        if !bytes.Equal(reply, record.Reply) {
+            fail(fmt.Errorf("Record %d (%s at %s) diverged:\n  recorded reply %s\n  replayed reply %s",
+                            step, record.Method, record.Time, record.Reply, reply))
+        }
+    }
+
+    for roleId, current := range replicas {
+        fmt.Fprintln(os.Stderr, "Role", roleId, "first unchosen index", current.log.GetFirstUnchosenIndex(),
+                     "minimum proposal", current.log.GetMinProposalId())
+        if *until != 0 {
+            for index := 0; index < current.log.GetFirstUnchosenIndex()+1; index++ {
+                entry, err := current.log.GetEntryAt(index)
+                if err != nil { fail(err) }
+                fmt.Fprintf(os.Stderr, "  entry %d: proposal %v value %q\n", index, entry.AcceptedProposalId, entry.Value)
+            }
+        }
+    }
+    fmt.Fprintln(os.Stderr, "Replayed", len(records), "records without divergence")
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+}
+
+// Role rebuilt from the state recorded at the start of its trace
+type replica struct {
+    log *replicatedlog.Log
+    acceptor *acceptor.AcceptorRole
+}
+
+func restore(record trace.Record, disk *recovery.Manager) (*replica, error) {
+    var state recovery.NodeState
+    err := json.Unmarshal(record.Request, &state)
+    if err != nil { return nil, err }
+
+    log := replicatedlog.ConstructLog(record.RoleId, &state, disk, nil)
+    log.EnableInvariants()
+    newReplica := replica{log, acceptor.Construct(record.RoleId, log)}
+    return &newReplica, nil
+}
+
+// Feeds a recorded message through the role, returning the encoded reply
+func (this *replica) apply(record trace.Record) ([]byte, error) {
+    var reply interface{}
+    var err error
+    switch record.Method {
+    case "AcceptorRole.Prepare":
+        var request acceptor.PrepareReq
+        var response acceptor.PrepareResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.Prepare(&request, &response) }
+        reply = &response
+    case "AcceptorRole.Accept":
+        var request acceptor.ProposalReq
+        var response acceptor.ProposalResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.Accept(&request, &response) }
+        reply = &response
+    case "AcceptorRole.AcceptOwned":
+        var request acceptor.OwnedReq
+        var response acceptor.OwnedResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.AcceptOwned(&request, &response) }
+        reply = &response
+    case "AcceptorRole.AcceptFast":
+        var request acceptor.FastReq
+        var response acceptor.FastResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.AcceptFast(&request, &response) }
+        reply = &response
+    case "AcceptorRole.Success":
+        var request acceptor.SuccessNotify
+        var response int
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.Success(&request, &response) }
+        reply = &response
+    case "AcceptorRole.SuccessBatch":
+        var request acceptor.SuccessBatchNotify
+        var response int
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.SuccessBatch(&request, &response) }
+        reply = &response
+    case "AcceptorRole.FetchEntries":
+        var request acceptor.FetchEntriesReq
+        var response acceptor.FetchEntriesResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.FetchEntries(&request, &response) }
+        reply = &response
+    case "AcceptorRole.Fetch":
+        var request acceptor.FetchReq
+        var response acceptor.FetchResp
+        err = json.Unmarshal(record.Request, &request)
+        if err == nil { err = this.acceptor.Fetch(&request, &response) }
+        reply = &response
+    case trace.LearnMethod:
+        var request acceptor.SuccessNotify
+        err = json.Unmarshal(record.Request, &request)
+        if err != nil { return nil, err }
+        this.log.SetEntryAt(request.Index, request.Value, proposal.Chosen())
+        return nil, nil
+    default:
+        return nil, fmt.Errorf("Unknown method %s", record.Method)
+    }
+
+    // Errors the role returned are part of the recorded outcome
+    if (err != nil) != (len(record.Error) != 0) {
+        return nil, fmt.Errorf("%s returned error %v, recorded %q", record.Method, err, record.Error)
+    }
+    return json.Marshal(reply)
+}
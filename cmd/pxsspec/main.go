@@ -0,0 +1,50 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "github/paxoscluster/spec"
+    "github/paxoscluster/trace"
+)
+
+// Checks the state transitions exported by nodes against the invariants of a multi-Paxos
+// specification, exiting nonzero if any is violated
+func main() {
+    complete := flag.Bool("complete", false, "the exports cover every role since the cluster first launched, so chosen values are checked against accepted ones")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: pxsspec [flags] transitions-<roleId>.jsonl...")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    var exports [][]trace.Transition = nil
+    count := 0
+    for _, fileName := range flag.Args() {
+        exportFile, err := os.Open(fileName)
+        if err != nil { fail(err) }
+        transitions, err := trace.ReadTransitions(exportFile)
+        exportFile.Close()
+        if err != nil { fail(fmt.Errorf("%s: %v", fileName, err)) }
+        exports = append(exports, transitions)
+        count += len(transitions)
+    }
+
+    violations := spec.Check(exports, *complete)
+    for _, violation := range violations {
+        fmt.Fprintln(os.Stderr, violation)
+    }
+    if len(violations) != 0 {
+        fail(fmt.Errorf("%d of %d transitions violate the specification", len(violations), count))
+    }
+    fmt.Fprintln(os.Stderr, count, "transitions from", len(exports), "exports satisfy the specification")
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+}
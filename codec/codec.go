@@ -0,0 +1,107 @@
+package codec
+
+import (
+    "fmt"
+    "sync"
+    "bytes"
+    "encoding/gob"
+    "encoding/json"
+)
+
+// Serializes application commands into the opaque values carried by log entries
+type Codec interface {
+    Marshal(command interface{}) ([]byte, error)
+    Unmarshal(data []byte, command interface{}) error
+}
+
+var registry = map[string]Codec {
+    "raw": Raw{},
+    "json": JSON{},
+    "gob": Gob{},
+}
+var exclude sync.Mutex
+
+// Makes a codec available by name to the codec setting of the configuration
+func Register(name string, codec Codec) {
+    exclude.Lock()
+    defer exclude.Unlock()
+
+    registry[name] = codec
+}
+
+// Returns the codec registered under the given name
+func Lookup(name string) (Codec, error) {
+    exclude.Lock()
+    defer exclude.Unlock()
+
+    codec, exists := registry[name]
+    if !exists {
+        return nil, fmt.Errorf("No codec registered as %s", name)
+    }
+    return codec, nil
+}
+
+// Passes string and []byte commands through unchanged
+type Raw struct{}
+
+func (this Raw) Marshal(command interface{}) ([]byte, error) {
+    switch typed := command.(type) {
+    case string:
+        return []byte(typed), nil
+    case []byte:
+        return typed, nil
+    }
+    return nil, fmt.Errorf("Raw codec cannot marshal %T", command)
+}
+
+func (this Raw) Unmarshal(data []byte, command interface{}) error {
+    switch typed := command.(type) {
+    case *string:
+        *typed = string(data)
+        return nil
+    case *[]byte:
+        *typed = append((*typed)[:0], data...)
+        return nil
+    }
+    return fmt.Errorf("Raw codec cannot unmarshal into %T", command)
+}
+
+// Encodes commands as JSON, readable by clients in other languages
+type JSON struct{}
+
+func (this JSON) Marshal(command interface{}) ([]byte, error) {
+    return json.Marshal(command)
+}
+
+func (this JSON) Unmarshal(data []byte, command interface{}) error {
+    return json.Unmarshal(data, command)
+}
+
+// Encodes commands with encoding/gob; each value carries its own type information
+type Gob struct{}
+
+func (this Gob) Marshal(command interface{}) ([]byte, error) {
+    var buffer bytes.Buffer
+    err := gob.NewEncoder(&buffer).Encode(command)
+    if err != nil { return nil, err }
+    return buffer.Bytes(), nil
+}
+
+func (this Gob) Unmarshal(data []byte, command interface{}) error {
+    return gob.NewDecoder(bytes.NewReader(data)).Decode(command)
+}
+
+// Pairs a command type with a codec so clients marshal and unmarshal without type assertions
+type Typed[T any] struct {
+    Codec Codec
+}
+
+func (this Typed[T]) Marshal(command T) ([]byte, error) {
+    return this.Codec.Marshal(command)
+}
+
+func (this Typed[T]) Unmarshal(data []byte) (T, error) {
+    var command T
+    err := this.Codec.Unmarshal(data, &command)
+    return command, err
+}
@@ -0,0 +1,1032 @@
+package config
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "strings"
+    "io/ioutil"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "github/paxoscluster/codec"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/proposal"
+)
+
+// Prefix selecting a Unix domain socket in place of a TCP host:port address
+const UnixScheme = "unix://"
+
+// Prefix selecting QUIC for a host:port address; requires TLS, and a QUIC network registered
+// with clusterpeers.RegisterNetwork
+const QuicScheme = "quic://"
+
+// Settings required to launch a node
+type Config struct {
+    RoleId uint64
+    // Name shared by the nodes of one cluster; connections from nodes naming another cluster are
+    // refused. Empty accepts any, as do nodes predating the check
+    ClusterId string
+    Peers map[uint64]string
+    Learners map[uint64]string
+    // Leader priority of each peer, zero if unlisted; the reachable peer of highest priority
+    // leads, ties going to the highest roleId
+    Priorities map[uint64]uint64
+    // Topology label of each peer, such as its datacenter, empty if unlisted. A peer is across a
+    // WAN if it and this node are both labelled, with different labels, and is then timed by
+    // Timeouts.Wan; every other peer is on the LAN
+    Labels map[uint64]string
+    Discovery DiscoveryConfig
+    Timeouts Timeouts
+    Quorum QuorumPolicy
+    Storage StorageConfig
+    TLS TLSConfig
+    Socket SocketConfig
+    Authentication AuthenticationConfig
+    Compression CompressionConfig
+    Chunking ChunkingConfig
+    Codec CodecConfig
+    Client ClientConfig
+    Gateway GatewayConfig
+    Archive ArchiveConfig
+    Debug DebugConfig
+    Trace TraceConfig
+    Flow FlowConfig
+    RateLimit RateLimitConfig
+    CatchUp CatchUpConfig
+    Stream StreamConfig
+    Mencius MenciusConfig
+    Fast FastConfig
+    Migration MigrationConfig
+    Gossip GossipConfig
+    Retry RetryConfig
+    Quarantine QuarantineConfig
+    Arbiter ArbiterConfig
+    // Time source of protocol timers; injected by tests and never read from files
+    Clock clock.Clock
+    // Random source of retry jitter, contention backoff, and gossip probe order; injected by
+    // tests and never read from files, and seeded from the time when nil
+    Rand clock.Rand
+    // File the settings were loaded from, and are reloaded from; empty for defaults
+    File string
+}
+
+// DNS SRV name from which peer addresses are resolved in place of a static peers table;
+// members are roleIds 1 through Size, matched to SRV targets by hostname ordinal
+type DiscoveryConfig struct {
+    Name string
+    Interval time.Duration
+    Size uint64
+}
+
+// Intervals governing failure detection and request expiry. Wan replaces them for peers across
+// a WAN, so a cross-region cluster fails over quickly within a datacenter yet tolerates slower
+// links between them
+type Timeouts struct {
+    Heartbeat time.Duration
+    Election time.Duration
+    Rpc time.Duration
+    Wan WanTimeouts
+}
+
+// Timeouts of peers across a WAN; zero keeps the LAN timeout
+type WanTimeouts struct {
+    Heartbeat time.Duration
+    Election time.Duration
+    Rpc time.Duration
+}
+
+// Returns the timeouts governing a peer, those of the WAN profile if it is remote
+func (this Timeouts) Profile(remote bool) Timeouts {
+    if !remote { return this }
+    if this.Wan.Heartbeat > 0 {
+        this.Heartbeat = this.Wan.Heartbeat
+    }
+    if this.Wan.Election > 0 {
+        this.Election = this.Wan.Election
+    }
+    if this.Wan.Rpc > 0 {
+        this.Rpc = this.Wan.Rpc
+    }
+    return this
+}
+
+// Number of nodes required to form a quorum; zero selects a simple majority. Acceptors
+// answering more than SlowFactor times slower than the quorum's slowest member are sent
+// requests last, off the broadcast path; zero disables slow-peer detection
+type QuorumPolicy struct {
+    Size uint64
+    SlowFactor uint64
+}
+
+// Location of backup & recovery files; node state is encrypted at rest if a keyring is given.
+// Unsealed state files are refused unless MigratePlaintext is set for the one start which
+// seals the files written before the keyring was given.
+// Fsync selects when writes reach the disk: "always" syncs each write before it completes,
+// "group" lets writers waiting together share one sync, its first writer waiting up to
+// FsyncDelay for others to join, and "buffered" leaves writes to the operating system. The
+// log is stored in segments of SegmentSize entries; committed values beyond CacheSize bytes
+// are evicted from memory and read back as needed, zero holding them all. Storage whose
+// updates average longer than SlowThreshold is degraded; zero disables the check. Acceptors
+// refuse prepare and accept requests while more than MaxPending updates wait on storage, zero
+// admitting every request
+type StorageConfig struct {
+    Directory string
+    Keyring string
+    MigratePlaintext bool
+    Fsync string
+    FsyncDelay time.Duration
+    SegmentSize uint64
+    CacheSize uint64
+    SlowThreshold time.Duration
+    MaxPending uint64
+}
+
+// Certificates used to secure peer connections; disabled when CertFile is empty
+type TLSConfig struct {
+    CertFile string
+    KeyFile string
+    CAFile string
+}
+
+// Options of TCP connections to and from peers and clients: connection attempts fail after
+// DialTimeout, idle connections are probed every KeepAlive to detect dead peers, zero
+// disabling probes, and buffer sizes of zero keep the system defaults
+type SocketConfig struct {
+    DialTimeout time.Duration
+    KeepAlive time.Duration
+    NoDelay bool
+    ReadBuffer uint64
+    WriteBuffer uint64
+}
+
+// Hex-encoded HMAC keys authenticating every message between nodes. Connections opened by a
+// role use its entry in Keys if present, otherwise the shared Secret. Clients hold neither:
+// they reach the client listener, secured by ClientConfig
+type AuthenticationConfig struct {
+    Secret string
+    Keys map[uint64]string
+}
+
+// Algorithm used to compress peer connections, "none" or "flate"; connections are
+// compressed only when both ends enable the same algorithm
+type CompressionConfig struct {
+    Algorithm string
+}
+
+// Largest value proposed as a single log entry; larger values are split across
+// consecutive entries. Zero disables chunking
+type ChunkingConfig struct {
+    Size uint64
+}
+
+// Name of the registered codec used to serialize commands proposed through the Go API
+type CodecConfig struct {
+    Name string
+}
+
+// Listener serving client and administrative requests apart from peer traffic, each
+// authorized by a bearer token listed in Tokens; when Address is empty clients share the
+// peer listener without authorization, though the gateway still requires tokens. The listener
+// never uses the peer certificates or authentication keys: it serves TLS with CertFile when
+// set, requiring client certificates signed by CAFile when that is set too. Clients verify
+// the node against CAFile, or the system roots, and present CertFile if set
+type ClientConfig struct {
+    Address string
+    Tokens string
+    CertFile string
+    KeyFile string
+    CAFile string
+}
+
+// Address of the optional HTTP gateway translating JSON requests to the client API, which
+// authorizes callers by the client tokens file; disabled when empty. Browsers may open
+// WebSockets only from the Origins listed, such as "https://dashboard.example.com"
+type GatewayConfig struct {
+    Address string
+    Origins []string
+}
+
+// Destination to which sealed segments of the committed log are archived, either a bucket of
+// an S3-compatible store or a local directory; disabled when both are empty. Store credentials
+// are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+type ArchiveConfig struct {
+    Endpoint string
+    Bucket string
+    Region string
+    Directory string
+    Prefix string
+    SegmentSize uint64
+    Interval time.Duration
+}
+
+// Member of the peers table run by pxsarbiter, which only votes in prepare and accept rounds and
+// never leads, so two full replicas and the arbiter tolerate the loss of either replica. The
+// arbiter must have the lowest roleId; zero means no arbiter
+type ArbiterConfig struct {
+    RoleId uint64
+}
+
+// Diagnostics; Invariants checks core Paxos invariants after every change to the log, stopping
+// the node with a dump of its state on violation, and is too costly for production. Address,
+// when set, serves expvar counters and pprof profiles over HTTP to holders of debug tokens, and
+// liveness and readiness probes to anyone. Journal bounds the ring of recent consensus events read through the admin API; zero disables it
+type DebugConfig struct {
+    Invariants bool
+    Address string
+    Journal uint64
+}
+
+// Directory in which each role appends the consensus messages it handles to
+// trace-<roleId>.jsonl, for replay with pxsreplay; disabled when empty. With Transitions, each
+// role also exports the changes to its Paxos state to transitions-<roleId>.jsonl, for pxsspec
+type TraceConfig struct {
+    Directory string
+    Transitions bool
+}
+
+// Limit on proposals a leader holds uncommitted; a proposal beyond the limit waits up to Wait
+// for one to commit, then fails with ErrBackpressure. Zero MaxInFlight removes the limit
+type FlowConfig struct {
+    MaxInFlight uint64
+    Wait time.Duration
+}
+
+// Proposals per second admitted in each priority class, so background maintenance traffic
+// cannot starve interactive client writes; zero leaves a class unlimited
+type RateLimitConfig struct {
+    Interactive uint64
+    Background uint64
+}
+
+// Chosen entries sent per notification to a node which is behind, and notifications sent
+// per second to each such node; a rate of zero is unlimited. Fanout selects the members told
+// of each value chosen outside a leader's rounds: "all", or "lagging" to skip members known
+// to have learned it
+type CatchUpConfig struct {
+    BatchSize uint64
+    Rate uint64
+    Fanout string
+}
+
+// Committed entries the leader sends learners per batch, and batches sent to a learner ahead
+// of its acknowledgments; together they bound how far a connected learner lags
+type StreamConfig struct {
+    BatchSize uint64
+    Window uint64
+}
+
+// Mencius mode pre-partitions log slots among the members round-robin, so every node proposes
+// in its own slots without a leader. A slot left unchosen for Revoke while later slots fill is
+// revoked by the other nodes and filled with a no-op
+type MenciusConfig struct {
+    Enabled bool
+    Revoke time.Duration
+}
+
+// Fast rounds let any node have a value chosen in one round trip by a fast quorum of about
+// three quarters of the members, with no leader. Colliding proposals are resolved by a full
+// round; a slot left unchosen for Revoke while later slots fill is chosen by a full round
+type FastConfig struct {
+    Enabled bool
+    Revoke time.Duration
+}
+
+// Committed entries per second streamed to each new member while migrating a group, zero
+// being unlimited, and the entries new members may still lack when the old group is sealed;
+// a smaller lag shortens the pause in writes before the new group takes over
+type MigrationConfig struct {
+    Rate uint64
+    Lag uint64
+}
+
+// SWIM gossip replacing the all-to-all heartbeat: every Interval each node probes one member,
+// asking Indirect others to probe it if it does not answer, and declares a member dead once
+// suspected for Suspicion. Must match on every node
+type GossipConfig struct {
+    Enabled bool
+    Interval time.Duration
+    Indirect uint64
+    Suspicion time.Duration
+}
+
+// Backoff between failed consensus rounds of a proposal. The delay after the first failure is
+// Base, multiplied by Multiplier after each further one up to Max, less a random part of up to
+// Jitter percent. A proposal fails after MaxAttempts rounds, and retries beyond Budget per
+// second across all proposals fail at once; zero leaves either unlimited
+type RetryConfig struct {
+    MaxAttempts uint64
+    Base time.Duration
+    Multiplier uint64
+    Max time.Duration
+    Jitter uint64
+    Budget uint64
+}
+
+// A peer unreachable for Threshold is quarantined: connection attempts to it are made only
+// every Interval, operators are alerted, and if Evict is set consensus rounds stop sending to
+// it, though quorums are still counted over every member. Zero Threshold disables quarantine
+type QuarantineConfig struct {
+    Threshold time.Duration
+    Interval time.Duration
+    Evict bool
+}
+
+// Returns the settings used when no configuration file is provided
+func Default() *Config {
+    newConfig := Config {
+        RoleId: 0,
+        Peers: make(map[uint64]string),
+        Learners: make(map[uint64]string),
+        Priorities: make(map[uint64]uint64),
+        Labels: make(map[uint64]string),
+        Authentication: AuthenticationConfig {
+            Keys: make(map[uint64]string),
+        },
+        Discovery: DiscoveryConfig {
+            Interval: 30*time.Second,
+        },
+        Timeouts: Timeouts {
+            Heartbeat: time.Second,
+            Election: 2*time.Second,
+            Rpc: time.Second,
+        },
+        Quorum: QuorumPolicy {
+            Size: 0,
+            SlowFactor: 4,
+        },
+        Storage: StorageConfig {
+            Directory: "coldstorage",
+            Fsync: "group",
+            SegmentSize: 1024,
+            CacheSize: 64*1024*1024,
+            SlowThreshold: 500*time.Millisecond,
+        },
+        Socket: SocketConfig {
+            DialTimeout: 5*time.Second,
+            KeepAlive: 15*time.Second,
+            NoDelay: true,
+        },
+        Compression: CompressionConfig {
+            Algorithm: "none",
+        },
+        Chunking: ChunkingConfig {
+            Size: 64*1024,
+        },
+        CatchUp: CatchUpConfig {
+            BatchSize: 64,
+            Rate: 100,
+            Fanout: "all",
+        },
+        Stream: StreamConfig {
+            BatchSize: 256,
+            Window: 4,
+        },
+        Mencius: MenciusConfig {
+            Revoke: 2*time.Second,
+        },
+        Fast: FastConfig {
+            Revoke: 2*time.Second,
+        },
+        Migration: MigrationConfig {
+            Rate: 1000,
+            Lag: 64,
+        },
+        Gossip: GossipConfig {
+            Interval: 500*time.Millisecond,
+            Indirect: 3,
+            Suspicion: 3*time.Second,
+        },
+        Retry: RetryConfig {
+            Base: 10*time.Millisecond,
+            Multiplier: 2,
+            Max: time.Second,
+            Jitter: 50,
+        },
+        Quarantine: QuarantineConfig {
+            Threshold: 30*time.Second,
+            Interval: 30*time.Second,
+        },
+        Codec: CodecConfig {
+            Name: "raw",
+        },
+        Clock: clock.Real(),
+        Archive: ArchiveConfig {
+            Region: "us-east-1",
+            Prefix: "pxs",
+            SegmentSize: 1024,
+            Interval: time.Minute,
+        },
+        Debug: DebugConfig {
+            Journal: 1024,
+        },
+    }
+    return &newConfig
+}
+
+// Reads configuration from the specified file, filling unspecified settings with defaults
+func Load(fileName string) (*Config, error) {
+    configFile, err := os.Open(fileName)
+    if err != nil { return nil, err }
+    defer configFile.Close()
+
+    tables, err := parse(configFile)
+    if err != nil { return nil, fmt.Errorf("%s: %v", fileName, err) }
+
+    newConfig := Default()
+    err = newConfig.apply(tables)
+    if err != nil { return nil, fmt.Errorf("%s: %v", fileName, err) }
+
+    err = newConfig.Validate()
+    if err != nil { return nil, fmt.Errorf("%s: %v", fileName, err) }
+
+    newConfig.File = fileName
+    return newConfig, nil
+}
+
+// Copies parsed values into the configuration
+func (this *Config) apply(tables map[string]map[string]value) error {
+    for table, entries := range tables {
+        for key, entry := range entries {
+            var err error
+            switch table + "." + key {
+            case ".roleId":
+                this.RoleId, err = entry.toUint()
+            case ".clusterId":
+                this.ClusterId, err = entry.toString()
+            case "discovery.srv":
+                this.Discovery.Name, err = entry.toString()
+            case "discovery.interval":
+                this.Discovery.Interval, err = entry.toDuration()
+            case "discovery.size":
+                this.Discovery.Size, err = entry.toUint()
+            case "timeouts.heartbeat":
+                this.Timeouts.Heartbeat, err = entry.toDuration()
+            case "timeouts.election":
+                this.Timeouts.Election, err = entry.toDuration()
+            case "timeouts.rpc":
+                this.Timeouts.Rpc, err = entry.toDuration()
+            case "timeouts.wan.heartbeat":
+                this.Timeouts.Wan.Heartbeat, err = entry.toDuration()
+            case "timeouts.wan.election":
+                this.Timeouts.Wan.Election, err = entry.toDuration()
+            case "timeouts.wan.rpc":
+                this.Timeouts.Wan.Rpc, err = entry.toDuration()
+            case "arbiter.roleid":
+                this.Arbiter.RoleId, err = entry.toUint()
+            case "quorum.size":
+                this.Quorum.Size, err = entry.toUint()
+            case "quorum.slowfactor":
+                this.Quorum.SlowFactor, err = entry.toUint()
+            case "storage.directory":
+                this.Storage.Directory, err = entry.toString()
+            case "storage.keyring":
+                this.Storage.Keyring, err = entry.toString()
+            case "storage.migrateplaintext":
+                this.Storage.MigratePlaintext, err = entry.toBool()
+            case "storage.fsync":
+                this.Storage.Fsync, err = entry.toString()
+            case "storage.fsyncdelay":
+                this.Storage.FsyncDelay, err = entry.toDuration()
+            case "storage.segmentsize":
+                this.Storage.SegmentSize, err = entry.toUint()
+            case "storage.cachesize":
+                this.Storage.CacheSize, err = entry.toUint()
+            case "storage.slowthreshold":
+                this.Storage.SlowThreshold, err = entry.toDuration()
+            case "storage.maxpending":
+                this.Storage.MaxPending, err = entry.toUint()
+            case "tls.cert":
+                this.TLS.CertFile, err = entry.toString()
+            case "tls.key":
+                this.TLS.KeyFile, err = entry.toString()
+            case "tls.ca":
+                this.TLS.CAFile, err = entry.toString()
+            case "socket.dialtimeout":
+                this.Socket.DialTimeout, err = entry.toDuration()
+            case "socket.keepalive":
+                this.Socket.KeepAlive, err = entry.toDuration()
+            case "socket.nodelay":
+                this.Socket.NoDelay, err = entry.toBool()
+            case "socket.readbuffer":
+                this.Socket.ReadBuffer, err = entry.toUint()
+            case "socket.writebuffer":
+                this.Socket.WriteBuffer, err = entry.toUint()
+            case "authentication.secret":
+                this.Authentication.Secret, err = entry.toString()
+            case "compression.algorithm":
+                this.Compression.Algorithm, err = entry.toString()
+            case "chunking.size":
+                this.Chunking.Size, err = entry.toUint()
+            case "codec.name":
+                this.Codec.Name, err = entry.toString()
+            case "client.address":
+                this.Client.Address, err = entry.toString()
+            case "client.tokens":
+                this.Client.Tokens, err = entry.toString()
+            case "client.cert":
+                this.Client.CertFile, err = entry.toString()
+            case "client.key":
+                this.Client.KeyFile, err = entry.toString()
+            case "client.ca":
+                this.Client.CAFile, err = entry.toString()
+            case "gateway.address":
+                this.Gateway.Address, err = entry.toString()
+            case "gateway.origins":
+                this.Gateway.Origins, err = entry.toStrings()
+            case "archive.endpoint":
+                this.Archive.Endpoint, err = entry.toString()
+            case "archive.bucket":
+                this.Archive.Bucket, err = entry.toString()
+            case "archive.region":
+                this.Archive.Region, err = entry.toString()
+            case "archive.directory":
+                this.Archive.Directory, err = entry.toString()
+            case "archive.prefix":
+                this.Archive.Prefix, err = entry.toString()
+            case "archive.segmentsize":
+                this.Archive.SegmentSize, err = entry.toUint()
+            case "archive.interval":
+                this.Archive.Interval, err = entry.toDuration()
+            case "debug.invariants":
+                this.Debug.Invariants, err = entry.toBool()
+            case "debug.address":
+                this.Debug.Address, err = entry.toString()
+            case "debug.journal":
+                this.Debug.Journal, err = entry.toUint()
+            case "trace.directory":
+                this.Trace.Directory, err = entry.toString()
+            case "trace.transitions":
+                this.Trace.Transitions, err = entry.toBool()
+            case "flow.maxinflight":
+                this.Flow.MaxInFlight, err = entry.toUint()
+            case "flow.wait":
+                this.Flow.Wait, err = entry.toDuration()
+            case "ratelimit.interactive":
+                this.RateLimit.Interactive, err = entry.toUint()
+            case "ratelimit.background":
+                this.RateLimit.Background, err = entry.toUint()
+            case "catchup.batchsize":
+                this.CatchUp.BatchSize, err = entry.toUint()
+            case "catchup.rate":
+                this.CatchUp.Rate, err = entry.toUint()
+            case "catchup.fanout":
+                this.CatchUp.Fanout, err = entry.toString()
+            case "stream.batchsize":
+                this.Stream.BatchSize, err = entry.toUint()
+            case "stream.window":
+                this.Stream.Window, err = entry.toUint()
+            case "mencius.enabled":
+                this.Mencius.Enabled, err = entry.toBool()
+            case "mencius.revoke":
+                this.Mencius.Revoke, err = entry.toDuration()
+            case "fast.enabled":
+                this.Fast.Enabled, err = entry.toBool()
+            case "fast.revoke":
+                this.Fast.Revoke, err = entry.toDuration()
+            case "migration.rate":
+                this.Migration.Rate, err = entry.toUint()
+            case "migration.lag":
+                this.Migration.Lag, err = entry.toUint()
+            case "gossip.enabled":
+                this.Gossip.Enabled, err = entry.toBool()
+            case "gossip.interval":
+                this.Gossip.Interval, err = entry.toDuration()
+            case "gossip.indirect":
+                this.Gossip.Indirect, err = entry.toUint()
+            case "gossip.suspicion":
+                this.Gossip.Suspicion, err = entry.toDuration()
+            case "retry.maxattempts":
+                this.Retry.MaxAttempts, err = entry.toUint()
+            case "retry.base":
+                this.Retry.Base, err = entry.toDuration()
+            case "retry.multiplier":
+                this.Retry.Multiplier, err = entry.toUint()
+            case "retry.max":
+                this.Retry.Max, err = entry.toDuration()
+            case "retry.jitter":
+                this.Retry.Jitter, err = entry.toUint()
+            case "retry.budget":
+                this.Retry.Budget, err = entry.toUint()
+            case "quarantine.threshold":
+                this.Quarantine.Threshold, err = entry.toDuration()
+            case "quarantine.interval":
+                this.Quarantine.Interval, err = entry.toDuration()
+            case "quarantine.evict":
+                this.Quarantine.Evict, err = entry.toBool()
+            default:
+                switch table {
+                case "peers":
+                    err = this.applyPeer(key, entry)
+                case "learners":
+                    err = this.applyLearner(key, entry)
+                case "priorities":
+                    err = this.applyPriority(key, entry)
+                case "labels":
+                    err = this.applyLabel(key, entry)
+                case "authentication.keys":
+                    err = this.applyKey(key, entry)
+                default:
+                    return fmt.Errorf("Unknown setting %s.%s", table, key)
+                }
+            }
+            if err != nil { return err }
+        }
+    }
+    return nil
+}
+
+// Adds an entry of the peers table to the configuration
+func (this *Config) applyPeer(key string, entry value) error {
+    roleId, err := parseUint(key)
+    if err != nil { return fmt.Errorf("Invalid peer roleId %s", key) }
+    address, err := entry.toString()
+    if err != nil { return err }
+    this.Peers[roleId] = address
+    return nil
+}
+
+// Adds an entry of the authentication keys table to the configuration
+func (this *Config) applyKey(key string, entry value) error {
+    roleId, err := parseUint(key)
+    if err != nil { return fmt.Errorf("Invalid key roleId %s", key) }
+    this.Authentication.Keys[roleId], err = entry.toString()
+    return err
+}
+
+// Adds an entry of the learners table to the configuration
+func (this *Config) applyLearner(key string, entry value) error {
+    roleId, err := parseUint(key)
+    if err != nil { return fmt.Errorf("Invalid learner roleId %s", key) }
+    address, err := entry.toString()
+    if err != nil { return err }
+    this.Learners[roleId] = address
+    return nil
+}
+
+// Adds an entry of the priorities table to the configuration
+func (this *Config) applyPriority(key string, entry value) error {
+    roleId, err := parseUint(key)
+    if err != nil { return fmt.Errorf("Invalid priority roleId %s", key) }
+    this.Priorities[roleId], err = entry.toUint()
+    return err
+}
+
+// Adds an entry of the labels table to the configuration
+func (this *Config) applyLabel(key string, entry value) error {
+    roleId, err := parseUint(key)
+    if err != nil { return fmt.Errorf("Invalid label roleId %s", key) }
+    this.Labels[roleId], err = entry.toString()
+    return err
+}
+
+// Checks the configuration for errors which would prevent the node from operating correctly
+func (this *Config) Validate() error {
+    if len(this.Discovery.Name) != 0 {
+        return this.validateDiscovery()
+    }
+    return this.validatePeers()
+}
+
+// Checks settings common to static and discovered membership
+func (this *Config) validateCommon(peerCount uint64) error {
+    // Checks timeouts are positive and ordered so heartbeats arrive before elections trigger
+    if this.Timeouts.Heartbeat <= 0 || this.Timeouts.Election <= 0 || this.Timeouts.Rpc <= 0 {
+        return fmt.Errorf("Timeouts must be positive")
+    }
+    if this.Timeouts.Election <= this.Timeouts.Heartbeat {
+        return fmt.Errorf("Election timeout %v must exceed heartbeat interval %v",
+                          this.Timeouts.Election, this.Timeouts.Heartbeat)
+    }
+    // Heartbeats are paced by the LAN interval, so WAN peers are sent them no more often
+    wan := this.Timeouts.Profile(true)
+    if this.Timeouts.Wan.Heartbeat < 0 || this.Timeouts.Wan.Election < 0 || this.Timeouts.Wan.Rpc < 0 {
+        return fmt.Errorf("WAN timeouts must not be negative")
+    }
+    if wan.Heartbeat < this.Timeouts.Heartbeat {
+        return fmt.Errorf("WAN heartbeat interval %v must not be below LAN interval %v", wan.Heartbeat, this.Timeouts.Heartbeat)
+    }
+    if wan.Election <= wan.Heartbeat {
+        return fmt.Errorf("WAN election timeout %v must exceed WAN heartbeat interval %v", wan.Election, wan.Heartbeat)
+    }
+
+    if this.Quorum.Size != 0 && (this.Quorum.Size <= peerCount/2 || this.Quorum.Size > peerCount) {
+        return fmt.Errorf("Quorum size %d must be a majority of %d peers", this.Quorum.Size, peerCount)
+    }
+
+    if len(this.ClusterId) > 255 {
+        return fmt.Errorf("Cluster ID must not exceed 255 bytes")
+    }
+
+    if len(this.Storage.Directory) == 0 {
+        return fmt.Errorf("No storage directory specified")
+    }
+    if this.Storage.MigratePlaintext && len(this.Storage.Keyring) == 0 {
+        return fmt.Errorf("Migrating plaintext state requires a keyring")
+    }
+    if this.Storage.Fsync != "always" && this.Storage.Fsync != "group" && this.Storage.Fsync != "buffered" {
+        return fmt.Errorf("Unknown fsync policy %s", this.Storage.Fsync)
+    }
+    if this.Storage.FsyncDelay < 0 {
+        return fmt.Errorf("Fsync delay must not be negative")
+    }
+    if this.Storage.SlowThreshold < 0 {
+        return fmt.Errorf("Slow storage threshold must not be negative")
+    }
+    if this.Storage.SegmentSize == 0 {
+        return fmt.Errorf("Log segment size must be positive")
+    }
+
+    if len(this.TLS.CertFile) != 0 || len(this.TLS.KeyFile) != 0 {
+        if len(this.TLS.CertFile) == 0 || len(this.TLS.KeyFile) == 0 || len(this.TLS.CAFile) == 0 {
+            return fmt.Errorf("TLS requires cert, key, and ca files")
+        }
+    }
+
+    if this.Socket.DialTimeout <= 0 || this.Socket.KeepAlive < 0 {
+        return fmt.Errorf("Socket dial timeout must be positive and keepalive not negative")
+    }
+
+    if this.Compression.Algorithm != "none" && this.Compression.Algorithm != "flate" {
+        return fmt.Errorf("Unknown compression algorithm %s", this.Compression.Algorithm)
+    }
+
+    if this.CatchUp.Fanout != "all" && this.CatchUp.Fanout != "lagging" {
+        return fmt.Errorf("Unknown success fan-out %s", this.CatchUp.Fanout)
+    }
+
+    _, err := codec.Lookup(this.Codec.Name)
+    if err != nil { return err }
+
+    if len(this.Authentication.Secret) != 0 {
+        err = validateKey(this.Authentication.Secret)
+        if err != nil { return fmt.Errorf("Invalid authentication secret: %v", err) }
+    }
+    for roleId, key := range this.Authentication.Keys {
+        err = validateKey(key)
+        if err != nil { return fmt.Errorf("Invalid authentication key for role %d: %v", roleId, err) }
+    }
+
+    if this.CatchUp.BatchSize == 0 {
+        return fmt.Errorf("Catch-up batch size must be positive")
+    }
+
+    for roleId := range this.Learners {
+        if roleId == 0 {
+            return fmt.Errorf("Learner roleId 0 is reserved for address auto-detection")
+        }
+        if _, exists := this.Peers[roleId]; exists {
+            return fmt.Errorf("Role %d cannot be both a peer and a learner", roleId)
+        }
+    }
+    if len(this.Learners) != 0 && (this.Stream.BatchSize == 0 || this.Stream.Window == 0) {
+        return fmt.Errorf("Stream batch size and window must be positive")
+    }
+
+    if this.Mencius.Enabled {
+        if len(this.Discovery.Name) != 0 {
+            return fmt.Errorf("Mencius mode requires a static peers table")
+        }
+        if this.Mencius.Revoke <= 0 {
+            return fmt.Errorf("Mencius revoke timeout must be positive")
+        }
+    }
+    if this.Fast.Enabled {
+        if this.Mencius.Enabled {
+            return fmt.Errorf("Fast rounds and Mencius mode are mutually exclusive")
+        }
+        if this.Fast.Revoke <= 0 {
+            return fmt.Errorf("Fast revoke timeout must be positive")
+        }
+    }
+    if this.Gossip.Enabled && (this.Gossip.Interval <= 0 || this.Gossip.Suspicion <= 0) {
+        return fmt.Errorf("Gossip interval and suspicion timeout must be positive")
+    }
+    if this.Retry.Base <= 0 || this.Retry.Max < this.Retry.Base || this.Retry.Multiplier == 0 {
+        return fmt.Errorf("Retry base and multiplier must be positive, and the maximum at least the base")
+    }
+    if this.Retry.Jitter > 100 {
+        return fmt.Errorf("Retry jitter %d exceeds 100 percent", this.Retry.Jitter)
+    }
+    if this.Quarantine.Threshold < 0 || (this.Quarantine.Threshold > 0 && this.Quarantine.Interval <= 0) {
+        return fmt.Errorf("Quarantine threshold must not be negative, nor its interval when enabled")
+    }
+
+    if len(this.Client.Address) != 0 && len(this.Client.Tokens) == 0 {
+        return fmt.Errorf("Client listener requires a tokens file")
+    }
+    if (len(this.Client.CertFile) == 0) != (len(this.Client.KeyFile) == 0) {
+        return fmt.Errorf("Client certificate and key must be set together")
+    }
+    if len(this.Gateway.Address) != 0 && len(this.Client.Tokens) == 0 {
+        return fmt.Errorf("Gateway requires a tokens file")
+    }
+    if len(this.Debug.Address) != 0 && len(this.Client.Tokens) == 0 {
+        return fmt.Errorf("Debug listener requires a tokens file")
+    }
+
+    if len(this.Archive.Bucket) != 0 || len(this.Archive.Directory) != 0 {
+        if len(this.Archive.Bucket) != 0 && len(this.Archive.Directory) != 0 {
+            return fmt.Errorf("Archive bucket and directory are mutually exclusive")
+        }
+        if len(this.Archive.Bucket) != 0 && len(this.Archive.Endpoint) == 0 {
+            return fmt.Errorf("Archive bucket requires an endpoint")
+        }
+        if this.Archive.SegmentSize == 0 || this.Archive.Interval <= 0 {
+            return fmt.Errorf("Archive segment size and interval must be positive")
+        }
+    }
+
+    if this.Trace.Transitions && len(this.Trace.Directory) == 0 {
+        return fmt.Errorf("Exporting transitions requires a trace directory")
+    }
+
+    return nil
+}
+
+// Checks settings for membership resolved from DNS
+func (this *Config) validateDiscovery() error {
+    if len(this.Peers) != 0 {
+        return fmt.Errorf("Peers table and discovery are mutually exclusive")
+    }
+    if this.Arbiter.RoleId != 0 {
+        return fmt.Errorf("An arbiter requires a static peers table")
+    }
+    if this.Discovery.Size == 0 {
+        return fmt.Errorf("Discovery requires a cluster size")
+    }
+    if this.Discovery.Size > proposal.MaxRoleId {
+        return fmt.Errorf("Cluster size %d exceeds maximum %d", this.Discovery.Size, proposal.MaxRoleId)
+    }
+    if this.Discovery.Interval <= 0 {
+        return fmt.Errorf("Discovery interval must be positive")
+    }
+    if this.RoleId > this.Discovery.Size {
+        return fmt.Errorf("RoleId %d exceeds cluster size %d", this.RoleId, this.Discovery.Size)
+    }
+    for roleId := range this.Priorities {
+        if roleId == 0 || roleId > this.Discovery.Size {
+            return fmt.Errorf("Priority given for role %d, outside the cluster", roleId)
+        }
+    }
+    return this.validateCommon(this.Discovery.Size)
+}
+
+// Checks settings for a static peers table
+func (this *Config) validatePeers() error {
+    if len(this.Peers) == 0 {
+        return fmt.Errorf("No peers specified")
+    }
+
+    // Checks peer roleIds and addresses; uniqueness of roleIds is enforced by the parser
+    seen := make(map[string]uint64)
+    for roleId, address := range this.Peers {
+        if roleId == 0 {
+            return fmt.Errorf("Peer roleId 0 is reserved for address auto-detection")
+        }
+        if roleId > proposal.MaxRoleId {
+            return fmt.Errorf("Peer roleId %d exceeds maximum %d", roleId, proposal.MaxRoleId)
+        }
+        if strings.HasPrefix(address, UnixScheme) {
+            if len(address) == len(UnixScheme) {
+                return fmt.Errorf("Invalid address for peer %d: missing socket path", roleId)
+            }
+        } else {
+            if strings.HasPrefix(address, QuicScheme) && len(this.TLS.CertFile) == 0 {
+                return fmt.Errorf("Invalid address for peer %d: QUIC requires TLS", roleId)
+            }
+            host, _, err := net.SplitHostPort(strings.TrimPrefix(address, QuicScheme))
+            if err != nil { return fmt.Errorf("Invalid address for peer %d: %v", roleId, err) }
+            if net.ParseIP(host) == nil {
+                _, err = net.LookupHost(host)
+                if err != nil { return fmt.Errorf("Unresolvable address for peer %d: %v", roleId, err) }
+            }
+        }
+        if other, exists := seen[address]; exists {
+            return fmt.Errorf("Peers %d and %d share address %s", other, roleId, address)
+        }
+        seen[address] = roleId
+    }
+
+    if this.RoleId != 0 {
+        _, peer := this.Peers[this.RoleId]
+        _, learner := this.Learners[this.RoleId]
+        if !peer && !learner {
+            return fmt.Errorf("RoleId %d not found in peers or learners table", this.RoleId)
+        }
+    }
+
+    for roleId := range this.Priorities {
+        if _, peer := this.Peers[roleId]; !peer {
+            return fmt.Errorf("Priority given for role %d, which is not in the peers table", roleId)
+        }
+    }
+
+    if arbiterId := this.Arbiter.RoleId; arbiterId != 0 {
+        if _, peer := this.Peers[arbiterId]; !peer {
+            return fmt.Errorf("Arbiter %d not found in peers table", arbiterId)
+        }
+        // Roles follow the highest ranked role heard, so the arbiter, which never leads, is lowest
+        for roleId := range this.Peers {
+            if roleId < arbiterId {
+                return fmt.Errorf("Arbiter %d must have the lowest roleId; peer %d is lower", arbiterId, roleId)
+            }
+        }
+        if this.Priorities[arbiterId] != 0 {
+            return fmt.Errorf("Arbiter %d never leads, and cannot be given a priority", arbiterId)
+        }
+        if this.Mencius.Enabled || this.Fast.Enabled {
+            return fmt.Errorf("Arbiter %d cannot propose, as Mencius mode and fast rounds require", arbiterId)
+        }
+    }
+
+    return this.validateCommon(uint64(len(this.Peers)))
+}
+
+// Checks a hex-encoded HMAC key is long enough to resist brute force
+func validateKey(key string) error {
+    decoded, err := hex.DecodeString(key)
+    if err != nil { return err }
+    if len(decoded) < 16 {
+        return fmt.Errorf("Key must be at least 16 bytes")
+    }
+    return nil
+}
+
+// Returns the number of nodes required to form a quorum of the given cluster size
+func (this *QuorumPolicy) QuorumSize(peerCount uint64) uint64 {
+    if this.Size != 0 {
+        return this.Size
+    }
+    return peerCount/2+1
+}
+
+// Builds the TLS settings of the client listener; returns nil if it serves plain connections
+func (this *ClientConfig) BuildServer() (*tls.Config, error) {
+    if len(this.CertFile) == 0 {
+        return nil, nil
+    }
+    certificate, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile)
+    if err != nil { return nil, err }
+    tlsConfig := tls.Config{Certificates: []tls.Certificate{certificate}}
+    if len(this.CAFile) != 0 {
+        tlsConfig.ClientCAs, err = loadPool(this.CAFile)
+        if err != nil { return nil, err }
+        tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return &tlsConfig, nil
+}
+
+// Builds the TLS settings of clients dialing the client listener; returns nil if neither a
+// certificate nor an authority is set, and clients dial plain connections
+func (this *ClientConfig) BuildClient() (*tls.Config, error) {
+    if len(this.CertFile) == 0 && len(this.CAFile) == 0 {
+        return nil, nil
+    }
+    var tlsConfig tls.Config
+    var err error
+    if len(this.CAFile) != 0 {
+        tlsConfig.RootCAs, err = loadPool(this.CAFile)
+        if err != nil { return nil, err }
+    }
+    if len(this.CertFile) != 0 {
+        certificate, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile)
+        if err != nil { return nil, err }
+        tlsConfig.Certificates = []tls.Certificate{certificate}
+    }
+    return &tlsConfig, nil
+}
+
+// Reads the certificates of an authority
+func loadPool(file string) (*x509.CertPool, error) {
+    authority, err := ioutil.ReadFile(file)
+    if err != nil { return nil, err }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(authority) {
+        return nil, fmt.Errorf("No certificates found in %s", file)
+    }
+    return pool, nil
+}
+
+// Builds the TLS settings for peer connections; returns nil if TLS is disabled
+func (this *TLSConfig) Build() (*tls.Config, error) {
+    if len(this.CertFile) == 0 {
+        return nil, nil
+    }
+
+    certificate, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile)
+    if err != nil { return nil, err }
+
+    pool, err := loadPool(this.CAFile)
+    if err != nil { return nil, err }
+
+    // Peers authenticate each other using certificates signed by the cluster authority
+    tlsConfig := tls.Config {
+        Certificates: []tls.Certificate{certificate},
+        RootCAs: pool,
+        ClientCAs: pool,
+        ClientAuth: tls.RequireAndVerifyClientCert,
+    }
+    return &tlsConfig, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+    "io"
+    "fmt"
+    "time"
+    "bufio"
+    "strconv"
+    "strings"
+)
+
+// Raw setting read from a configuration file
+type value struct {
+    text string
+    quoted bool
+}
+
+// Parses the TOML subset used by configuration files: [tables] of key = value
+// pairs, where values are quoted strings, integers, or booleans
+func parse(reader io.Reader) (map[string]map[string]value, error) {
+    tables := make(map[string]map[string]value)
+    table := ""
+    tables[table] = make(map[string]value)
+
+    scanner := bufio.NewScanner(reader)
+    lineNumber := 0
+    for scanner.Scan() {
+        lineNumber++
+        line := strings.TrimSpace(stripComment(scanner.Text()))
+        if len(line) == 0 { continue }
+
+        // Table header
+        if strings.HasPrefix(line, "[") {
+            if !strings.HasSuffix(line, "]") {
+                return nil, fmt.Errorf("line %d: malformed table header", lineNumber)
+            }
+            table = strings.TrimSpace(line[1:len(line)-1])
+            if _, exists := tables[table]; exists {
+                return nil, fmt.Errorf("line %d: duplicate table %s", lineNumber, table)
+            }
+            tables[table] = make(map[string]value)
+            continue
+        }
+
+        // Key-value pair
+        separator := strings.Index(line, "=")
+        if separator < 0 {
+            return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+        }
+        key := strings.TrimSpace(line[:separator])
+        text := strings.TrimSpace(line[separator+1:])
+        if len(key) == 0 || len(text) == 0 {
+            return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+        }
+        if _, exists := tables[table][key]; exists {
+            return nil, fmt.Errorf("line %d: duplicate key %s", lineNumber, key)
+        }
+
+        entry := value{text, false}
+        if strings.HasPrefix(text, "\"") {
+            unquoted, err := strconv.Unquote(text)
+            if err != nil { return nil, fmt.Errorf("line %d: malformed string", lineNumber) }
+            entry = value{unquoted, true}
+        }
+        tables[table][key] = entry
+    }
+
+    err := scanner.Err()
+    if err != nil { return nil, err }
+    return tables, nil
+}
+
+// Removes a trailing comment, ignoring '#' characters inside quoted strings
+func stripComment(line string) string {
+    quoted := false
+    for idx := 0; idx < len(line); idx++ {
+        switch line[idx] {
+        case '\\':
+            idx++
+        case '"':
+            quoted = !quoted
+        case '#':
+            if !quoted {
+                return line[:idx]
+            }
+        }
+    }
+    return line
+}
+
+func parseUint(text string) (uint64, error) {
+    return strconv.ParseUint(text, 10, 64)
+}
+
+func (this value) toString() (string, error) {
+    if !this.quoted {
+        return "", fmt.Errorf("Expected string, found %s", this.text)
+    }
+    return this.text, nil
+}
+
+func (this value) toUint() (uint64, error) {
+    if this.quoted {
+        return 0, fmt.Errorf("Expected integer, found \"%s\"", this.text)
+    }
+    return parseUint(this.text)
+}
+
+func (this value) toBool() (bool, error) {
+    if this.quoted || (this.text != "true" && this.text != "false") {
+        return false, fmt.Errorf("Expected boolean, found %s", this.text)
+    }
+    return this.text == "true", nil
+}
+
+// Lists are written as strings of comma-separated items
+func (this value) toStrings() ([]string, error) {
+    text, err := this.toString()
+    if err != nil { return nil, err }
+    var items []string = nil
+    for _, item := range strings.Split(text, ",") {
+        item = strings.TrimSpace(item)
+        if len(item) != 0 {
+            items = append(items, item)
+        }
+    }
+    return items, nil
+}
+
+// Durations are written as strings such as "500ms" or "2s"
+func (this value) toDuration() (time.Duration, error) {
+    text, err := this.toString()
+    if err != nil { return 0, err }
+    return time.ParseDuration(text)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+    "fmt"
+    "reflect"
+    "strings"
+    "sync/atomic"
+)
+
+// Timeouts of a running component, replaced as a whole when the node's settings are reloaded;
+// safe for concurrent use
+type LiveTimeouts struct {
+    current atomic.Value
+}
+
+func ConstructLiveTimeouts(timeouts Timeouts) *LiveTimeouts {
+    newLiveTimeouts := LiveTimeouts{}
+    newLiveTimeouts.current.Store(timeouts)
+    return &newLiveTimeouts
+}
+
+func (this *LiveTimeouts) Get() Timeouts {
+    return this.current.Load().(Timeouts)
+}
+
+func (this *LiveTimeouts) Set(timeouts Timeouts) {
+    this.current.Store(timeouts)
+}
+
+// Checks that a running node may take the settings of next without restarting: only the
+// tunables may differ, namely the timeouts, rate limits, admission wait, catch-up batch size
+// and rate, and retry policy. Returns the settings to run with, which are those of this
+// configuration with the tunables of next
+func (this *Config) CheckReload(next *Config) (*Config, error) {
+    reloaded := *this
+    reloaded.Timeouts = next.Timeouts
+    reloaded.RateLimit = next.RateLimit
+    reloaded.Flow.Wait = next.Flow.Wait
+    reloaded.CatchUp.BatchSize = next.CatchUp.BatchSize
+    reloaded.CatchUp.Rate = next.CatchUp.Rate
+    reloaded.Retry = next.Retry
+
+    // Every other setting must be unchanged; the clock and random source are never read from files
+    compared := *next
+    compared.Clock = this.Clock
+    compared.Rand = this.Rand
+    compared.File = this.File
+    var changed []string = nil
+    current, proposed := reflect.ValueOf(reloaded), reflect.ValueOf(compared)
+    for field := 0; field < current.NumField(); field++ {
+        if !reflect.DeepEqual(current.Field(field).Interface(), proposed.Field(field).Interface()) {
+            changed = append(changed, current.Type().Field(field).Name)
+        }
+    }
+    if len(changed) != 0 {
+        return nil, fmt.Errorf("Settings of %s cannot change without a restart", strings.Join(changed, ", "))
+    }
+
+    err := reloaded.Validate()
+    if err != nil { return nil, err }
+    return &reloaded, nil
+}
@@ -0,0 +1,79 @@
+package diagnostics
+
+import (
+    "fmt"
+    "net"
+    "time"
+    "expvar"
+    "runtime"
+    "strings"
+    "net/http"
+    "net/http/pprof"
+    "crypto/tls"
+)
+
+func init() {
+    expvar.Publish("goroutines", expvar.Func(func() interface{} {
+        return runtime.NumGoroutine()
+    }))
+}
+
+// Publishes a node's state under the name node-<roleId> among the expvar variables, read
+// afresh on each request
+func PublishNode(roleId uint64, state func() interface{}) {
+    expvar.Publish(fmt.Sprintf("node-%d", roleId), expvar.Func(state))
+}
+
+// Serves the expvar variables on /debug/vars and the runtime profiles of net/http/pprof under
+// /debug/pprof/, using TLS if configured. Each request must carry a bearer token which
+// authorize accepts, except the probes of orchestrators such as Kubernetes: /healthz answers
+// 200 while the process runs, and /readyz answers 200 while ready returns nil, and 503 with
+// its error otherwise
+func Serve(address string, tlsConfig *tls.Config, authorize func(token string) error, ready func() error) error {
+    probes := http.NewServeMux()
+    probes.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+        fmt.Fprintln(writer, "ok")
+    })
+    probes.HandleFunc("/readyz", func(writer http.ResponseWriter, request *http.Request) {
+        err := ready()
+        if err != nil {
+            http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+            return
+        }
+        fmt.Fprintln(writer, "ok")
+    })
+    mux := http.NewServeMux()
+    mux.Handle("/debug/vars", expvar.Handler())
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+        if request.URL.Path == "/healthz" || request.URL.Path == "/readyz" {
+            probes.ServeHTTP(writer, request)
+            return
+        }
+        err := authorize(strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer "))
+        if err != nil {
+            http.Error(writer, err.Error(), http.StatusForbidden)
+            return
+        }
+        mux.ServeHTTP(writer, request)
+    })
+
+    ln, err := net.Listen("tcp", address)
+    if err != nil { return err }
+    if tlsConfig != nil {
+        // Tokens authenticate callers, so client certificates are not required
+        debugTls := tlsConfig.Clone()
+        debugTls.ClientAuth = tls.NoClientCert
+        ln = tls.NewListener(ln, debugTls)
+    }
+
+    fmt.Println("[ DEBUG ] Listening on", address)
+    // Profiles and traces stream for as long as requested, so writes are not limited
+    server := http.Server{Handler: handler, ReadHeaderTimeout: 10*time.Second}
+    go server.Serve(ln)
+    return nil
+}
@@ -0,0 +1,357 @@
+package gateway
+
+import (
+    "fmt"
+    "context"
+    "net"
+    "time"
+    "mime"
+    "strings"
+    "strconv"
+    "net/http"
+    "crypto/tls"
+    "encoding/json"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Longest a read may wait for entries to be committed
+const maxReadWait = 30*time.Second
+
+// Longest a watch waits for commits before checking for status changes
+const watchInterval = time.Second
+
+// Serves JSON over HTTP, translating requests into calls on a node's client role:
+//   POST /propose  {"value": "..."}  (Content-Type: application/json)
+//   GET  /read?from=0&max=100&wait=1s
+//   GET  /status
+//   GET  /watch?from=0     (WebSocket)
+// Requests carry their client token as "Authorization: Bearer <token>"; browsers, which
+// cannot set headers on WebSocket requests, may pass it as ?token=<token> to /watch. Browsers
+// send the cookies and credentials of the gateway's origin with WebSocket requests from any
+// page, so /watch admits only pages from the allowed origins
+type Gateway struct {
+    client *admin.ClientRole
+    origins map[string]bool
+}
+
+// Entry returned by /read
+type entryJson struct {
+    Index int `json:"index"`
+    Value string `json:"value"`
+}
+
+// Body returned by /status
+type statusJson struct {
+    RoleId uint64 `json:"roleId"`
+    LeaderId uint64 `json:"leaderId"`
+    LeaderAddress string `json:"leaderAddress"`
+    CommitIndex int `json:"commitIndex"`
+    AppliedIndex int `json:"appliedIndex"`
+    Members map[uint64]string `json:"members"`
+    Learners []uint64 `json:"learners"`
+    StorageLatency time.Duration `json:"storageLatency"`
+    StorageDegraded bool `json:"storageDegraded"`
+    Draining bool `json:"draining"`
+    ReadOnly bool `json:"readOnly"`
+    AsymmetricLinks []asymmetricLinkJson `json:"asymmetricLinks"`
+    State string `json:"state"`
+}
+
+// Messages sent to /watch subscribers: "commit" carries a committed value, "status" the
+// node's status when first connected and whenever the leader or membership changes, and
+// "error" the failure ending the stream
+type commitEventJson struct {
+    Type string `json:"type"`
+    entryJson
+}
+
+type statusEventJson struct {
+    Type string `json:"type"`
+    statusJson
+}
+
+// Error body; leader fields are set when the node is not leader
+type errorJson struct {
+    Error string `json:"error"`
+    LeaderId uint64 `json:"leaderId,omitempty"`
+    LeaderAddress string `json:"leaderAddress,omitempty"`
+}
+
+// Listens on the specified address, using TLS if configured
+func Serve(address string, origins []string, client *admin.ClientRole, tlsConfig *tls.Config) error {
+    newGateway := Gateway{client, make(map[string]bool)}
+    for _, origin := range origins {
+        newGateway.origins[strings.ToLower(origin)] = true
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/propose", newGateway.propose)
+    mux.HandleFunc("/read", newGateway.read)
+    mux.HandleFunc("/status", newGateway.status)
+    mux.HandleFunc("/watch", newGateway.watch)
+
+    ln, err := net.Listen("tcp", address)
+    if err != nil { return err }
+    if tlsConfig != nil {
+        // Tokens authenticate gateway callers, so client certificates are not required
+        gatewayTls := tlsConfig.Clone()
+        gatewayTls.ClientAuth = tls.NoClientCert
+        ln = tls.NewListener(ln, gatewayTls)
+    }
+
+    fmt.Println("[ GATEWAY ] Listening on", address)
+    server := http.Server{Handler: mux, ReadHeaderTimeout: 10*time.Second}
+    go server.Serve(ln)
+    return nil
+}
+
+func (this *Gateway) propose(writer http.ResponseWriter, request *http.Request) {
+    if request.Method != "POST" {
+        respondError(writer, http.StatusMethodNotAllowed, fmt.Errorf("Use POST"))
+        return
+    }
+    // Browsers send forms cross-site without asking, but not JSON
+    if !isJson(request) {
+        respondError(writer, http.StatusUnsupportedMediaType, fmt.Errorf("Expected Content-Type: application/json"))
+        return
+    }
+
+    var body struct {
+        Value string `json:"value"`
+        Session int `json:"session"`
+        ClientId string `json:"client"`
+        Sequence uint64 `json:"sequence"`
+        // Milliseconds the caller will wait for the value to commit; zero waits indefinitely
+        Timeout int64 `json:"timeout"`
+    }
+    err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, 1<<26)).Decode(&body)
+    if err != nil {
+        respondError(writer, http.StatusBadRequest, err)
+        return
+    }
+
+    req := admin.ReplicateReq {
+        Token: bearerToken(request),
+        Value: []byte(body.Value),
+        Session: admin.Session{Next: body.Session},
+        ClientId: body.ClientId,
+        TraceId: request.Header.Get("X-Trace-Id"),
+        Sequence: body.Sequence,
+        Timeout: time.Duration(body.Timeout)*time.Millisecond,
+    }
+    var session admin.Session
+    // Proposals are abandoned if the caller disconnects before they commit
+    err = this.client.ReplicateInSessionWithContext(request.Context(), &req, &session)
+    if err != nil {
+        respondError(writer, errorStatus(err), err)
+        return
+    }
+    respond(writer, map[string]interface{}{"value": body.Value, "session": session.Next})
+}
+
+func (this *Gateway) read(writer http.ResponseWriter, request *http.Request) {
+    query := request.URL.Query()
+    req := admin.ReadReq{Token: bearerToken(request)}
+    var err error
+    if len(query.Get("from")) != 0 {
+        req.From, err = strconv.Atoi(query.Get("from"))
+    }
+    if err == nil && len(query.Get("max")) != 0 {
+        req.Max, err = strconv.Atoi(query.Get("max"))
+    }
+    if err == nil && len(query.Get("wait")) != 0 {
+        req.Wait, err = time.ParseDuration(query.Get("wait"))
+    }
+    if err == nil && len(query.Get("staleness")) != 0 {
+        req.MaxStaleness, err = time.ParseDuration(query.Get("staleness"))
+    }
+    if err == nil && len(query.Get("session")) != 0 {
+        req.Session.Next, err = strconv.Atoi(query.Get("session"))
+    }
+    if err != nil || req.From < 0 || req.Max < 0 {
+        respondError(writer, http.StatusBadRequest, fmt.Errorf("Invalid read parameters"))
+        return
+    }
+    if req.Wait > maxReadWait {
+        req.Wait = maxReadWait
+    }
+
+    var reply []replicatedlog.CommittedEntry
+    err = this.client.Read(&req, &reply)
+    if err != nil {
+        respondError(writer, errorStatus(err), err)
+        return
+    }
+    entries := make([]entryJson, 0, len(reply))
+    for _, entry := range reply {
+        entries = append(entries, entryJson{entry.Index, string(entry.Value)})
+    }
+    respond(writer, map[string][]entryJson{"entries": entries})
+}
+
+func (this *Gateway) status(writer http.ResponseWriter, request *http.Request) {
+    req := admin.TokenReq{Token: bearerToken(request)}
+    var reply admin.StatusResp
+    err := this.client.Status(&req, &reply)
+    if err != nil {
+        respondError(writer, errorStatus(err), err)
+        return
+    }
+    respond(writer, toStatusJson(reply))
+}
+
+// Streams committed values from index from, or from the next commit if absent, along with
+// changes of leader and membership, until the browser disconnects
+func (this *Gateway) watch(writer http.ResponseWriter, request *http.Request) {
+    // Requests from outside a browser carry no origin
+    origin := request.Header.Get("Origin")
+    if len(origin) != 0 && !this.origins[strings.ToLower(origin)] {
+        respondError(writer, http.StatusForbidden, fmt.Errorf("Origin %s is not allowed", origin))
+        return
+    }
+    token := bearerToken(request)
+    if len(token) == 0 {
+        token = request.URL.Query().Get("token")
+    }
+    // Authorizes before upgrading, so refusals are reported as HTTP errors
+    var status admin.StatusResp
+    err := this.client.Status(&admin.TokenReq{Token: token}, &status)
+    if err != nil {
+        respondError(writer, errorStatus(err), err)
+        return
+    }
+    next := status.CommitIndex+1
+    if from := request.URL.Query().Get("from"); len(from) != 0 {
+        next, err = strconv.Atoi(from)
+        if err != nil || next < 0 {
+            respondError(writer, http.StatusBadRequest, fmt.Errorf("Invalid watch parameters"))
+            return
+        }
+    }
+
+    socket, err := upgradeWebsocket(writer, request)
+    if err != nil {
+        respondError(writer, http.StatusBadRequest, err)
+        return
+    }
+    defer socket.Close()
+    closed := make(chan error, 1)
+    go func() { closed <- socket.Receive() }()
+
+    var sent *admin.StatusResp = nil
+    for {
+        if sent == nil || sent.LeaderId != status.LeaderId || !sameMembers(sent.Members, status.Members) ||
+            !sameRoleIds(sent.Learners, status.Learners) {
+            err = sendJson(socket, statusEventJson{"status", toStatusJson(status)})
+            if err != nil { return }
+            current := status
+            sent = &current
+        }
+
+        var entries []replicatedlog.CommittedEntry
+        err = this.client.Read(&admin.ReadReq{Token: token, From: next, Wait: watchInterval}, &entries)
+        for _, entry := range entries {
+            if err == nil {
+                err = sendJson(socket, commitEventJson{"commit", entryJson{entry.Index, string(entry.Value)}})
+            }
+            next = entry.Index+1
+        }
+        if err == nil {
+            err = this.client.Status(&admin.TokenReq{Token: token}, &status)
+        }
+        if err != nil {
+            sendJson(socket, map[string]string{"type": "error", "error": err.Error()})
+            return
+        }
+
+        select {
+        case <- closed:
+            return
+        default:
+        }
+    }
+}
+
+// Link carrying traffic only from one member to another, in /status
+type asymmetricLinkJson struct {
+    From uint64 `json:"from"`
+    To uint64 `json:"to"`
+    Witness uint64 `json:"witness"`
+    Evidence string `json:"evidence"`
+}
+
+func toStatusJson(status admin.StatusResp) statusJson {
+    links := make([]asymmetricLinkJson, 0, len(status.AsymmetricLinks))
+    for _, link := range status.AsymmetricLinks {
+        links = append(links, asymmetricLinkJson{link.From, link.To, link.Witness, link.Evidence})
+    }
+    return statusJson{status.RoleId, status.LeaderId, status.LeaderAddress, status.CommitIndex, status.AppliedIndex, status.Members,
+                      status.Learners, status.StorageLatency, status.StorageDegraded, status.Draining, status.ReadOnly,
+                      links, status.State}
+}
+
+// Reports whether two memberships hold the same members at the same addresses
+func sameMembers(first map[uint64]string, second map[uint64]string) bool {
+    if len(first) != len(second) { return false }
+    for roleId, address := range first {
+        if other, exists := second[roleId]; !exists || other != address { return false }
+    }
+    return true
+}
+
+// Reports whether two ordered lists hold the same roles
+func sameRoleIds(first []uint64, second []uint64) bool {
+    if len(first) != len(second) { return false }
+    for index := range first {
+        if first[index] != second[index] { return false }
+    }
+    return true
+}
+
+func sendJson(socket *websocket, body interface{}) error {
+    encoded, err := json.Marshal(body)
+    if err != nil { return err }
+    return socket.WriteText(encoded)
+}
+
+func isJson(request *http.Request) bool {
+    mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+    return err == nil && mediaType == "application/json"
+}
+
+func bearerToken(request *http.Request) string {
+    return strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+}
+
+// Maps errors from the client role to HTTP status codes
+func errorStatus(err error) int {
+    switch {
+    case proposer.IsNotLeader(err), proposer.IsStaleRead(err), proposer.IsStorageDegraded(err), proposer.IsDraining(err),
+         proposer.IsReadOnly(err), proposer.IsNotReady(err):
+        return http.StatusServiceUnavailable
+    case proposer.IsDeadlineTooShort(err), err == context.DeadlineExceeded:
+        return http.StatusGatewayTimeout
+    case proposer.IsBackpressure(err):
+        return http.StatusTooManyRequests
+    case proposer.IsReservedValue(err):
+        return http.StatusBadRequest
+    case strings.HasPrefix(err.Error(), "Permission denied"):
+        return http.StatusForbidden
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+func respond(writer http.ResponseWriter, body interface{}) {
+    writer.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(writer).Encode(body)
+}
+
+func respondError(writer http.ResponseWriter, status int, err error) {
+    body := errorJson{Error: err.Error()}
+    body.LeaderId, body.LeaderAddress, _ = proposer.ParseLeaderHint(err)
+    writer.Header().Set("Content-Type", "application/json")
+    writer.WriteHeader(status)
+    json.NewEncoder(writer).Encode(body)
+}
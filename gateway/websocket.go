@@ -0,0 +1,147 @@
+package gateway
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "sync"
+    "bufio"
+    "strings"
+    "net/http"
+    "crypto/sha1"
+    "encoding/binary"
+    "encoding/base64"
+)
+
+// Appended to the client's key to derive the accept key of the opening handshake
+const websocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Largest message accepted from a browser; clients only send control frames
+const maxWebsocketMessage = 4096
+
+// Frame opcodes used by the gateway
+const (
+    opText byte = 0x1
+    opClose byte = 0x8
+    opPing byte = 0x9
+    opPong byte = 0xA
+)
+
+// Server end of a WebSocket connection, as specified by RFC 6455. The gateway only sends
+// messages, answering pings and closes from the browser
+type websocket struct {
+    connection net.Conn
+    buffered *bufio.ReadWriter
+    exclude sync.Mutex
+}
+
+// Completes the opening handshake of a WebSocket request, taking over its connection
+func upgradeWebsocket(writer http.ResponseWriter, request *http.Request) (*websocket, error) {
+    if !strings.EqualFold(request.Header.Get("Upgrade"), "websocket") ||
+       !strings.Contains(strings.ToLower(request.Header.Get("Connection")), "upgrade") {
+        return nil, fmt.Errorf("Expected a WebSocket upgrade")
+    }
+    if request.Header.Get("Sec-WebSocket-Version") != "13" {
+        writer.Header().Set("Sec-WebSocket-Version", "13")
+        return nil, fmt.Errorf("Unsupported WebSocket version")
+    }
+    key := request.Header.Get("Sec-WebSocket-Key")
+    if len(key) == 0 {
+        return nil, fmt.Errorf("Missing WebSocket key")
+    }
+    hijacker, ok := writer.(http.Hijacker)
+    if !ok {
+        return nil, fmt.Errorf("Connection cannot be upgraded")
+    }
+    connection, buffered, err := hijacker.Hijack()
+    if err != nil { return nil, err }
+
+    digest := sha1.Sum([]byte(key+websocketGuid))
+    buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+    buffered.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+    buffered.WriteString("Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(digest[:])+"\r\n\r\n")
+    err = buffered.Flush()
+    if err != nil {
+        connection.Close()
+        return nil, err
+    }
+    newWebsocket := websocket{connection: connection, buffered: buffered}
+    return &newWebsocket, nil
+}
+
+// Sends a text message
+func (this *websocket) WriteText(message []byte) error {
+    return this.writeFrame(opText, message)
+}
+
+// Sends one unfragmented frame; the server never masks its frames
+func (this *websocket) writeFrame(opcode byte, payload []byte) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    header := []byte{0x80 | opcode, 0}
+    switch {
+    case len(payload) < 126:
+        header[1] = byte(len(payload))
+    case len(payload) <= 0xFFFF:
+        header[1] = 126
+        header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+    default:
+        header[1] = 127
+        header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+    }
+    this.buffered.Write(header)
+    this.buffered.Write(payload)
+    return this.buffered.Flush()
+}
+
+// Reads frames from the browser until it closes the connection, answering pings; returns
+// once the connection is closed or fails
+func (this *websocket) Receive() error {
+    header := make([]byte, 2)
+    for {
+        _, err := io.ReadFull(this.buffered, header)
+        if err != nil { return err }
+        opcode := header[0] & 0x0F
+        length := uint64(header[1] & 0x7F)
+        switch length {
+        case 126:
+            extended := make([]byte, 2)
+            _, err = io.ReadFull(this.buffered, extended)
+            length = uint64(binary.BigEndian.Uint16(extended))
+        case 127:
+            extended := make([]byte, 8)
+            _, err = io.ReadFull(this.buffered, extended)
+            length = binary.BigEndian.Uint64(extended)
+        }
+        if err != nil { return err }
+        if header[1]&0x80 == 0 || length > maxWebsocketMessage {
+            // Browsers mask every frame, and send nothing large
+            this.writeFrame(opClose, []byte{0x03, 0xEA})
+            return fmt.Errorf("Invalid WebSocket frame")
+        }
+
+        mask := make([]byte, 4)
+        _, err = io.ReadFull(this.buffered, mask)
+        if err != nil { return err }
+        payload := make([]byte, length)
+        _, err = io.ReadFull(this.buffered, payload)
+        if err != nil { return err }
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+
+        switch opcode {
+        case opClose:
+            this.writeFrame(opClose, payload)
+            return io.EOF
+        case opPing:
+            err = this.writeFrame(opPong, payload)
+            if err != nil { return err }
+        }
+    }
+}
+
+func (this *websocket) Close() error {
+    return this.connection.Close()
+}
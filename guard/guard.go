@@ -0,0 +1,38 @@
+package guard
+
+import (
+    "fmt"
+    "runtime/debug"
+    "github/paxoscluster/metrics"
+)
+
+// Counts recovered panics by method
+var panicStats = metrics.Group("panics")
+
+// Panic value signalling a broken invariant; unlike other panics it is never recovered, as
+// the node's state can no longer be trusted
+type Fatal struct {
+    Message string
+}
+
+func (this Fatal) Error() string {
+    return this.Message
+}
+
+// Recovers from a panic in an RPC handler or background routine, logging it with its stack
+// and turning it into an error so one bad request or round cannot crash the node. Must be
+// deferred directly; err may be nil where there is no caller to inform
+func Recover(tag string, roleId uint64, method string, err *error) {
+    cause := recover()
+    if cause == nil { return }
+    if _, isFatal := cause.(Fatal); isFatal {
+        panic(cause)
+    }
+
+    panicStats.Add(method, 1)
+    fmt.Println("[", tag, roleId, "] Recovered from panic in", method, ":", cause)
+    fmt.Println(string(debug.Stack()))
+    if err != nil {
+        *err = fmt.Errorf("[ %s %d ] Internal error in %s", tag, roleId, method)
+    }
+}
@@ -0,0 +1,220 @@
+package hooks
+
+import (
+    "sync"
+    "time"
+    "sync/atomic"
+    "github/paxoscluster/clock"
+)
+
+// Committed value delivered to the application, with the metadata it was proposed with
+type Entry struct {
+    Index int
+    Value []byte
+    Metadata Metadata
+}
+
+// Details of a value recorded alongside it in the log: when its proposer stamped it, which
+// client it came from, and the trace of the request, where known. Timestamp and Logical form
+// a hybrid logical clock reading, increasing along the log
+type Metadata struct {
+    Timestamp time.Time
+    Logical uint32
+    ClientId string
+    TraceId string
+    // Position of the command among its client's, numbered from 1; zero if not numbered
+    Sequence uint64
+}
+
+// Returns the hybrid logical clock reading of the value, zero if it was not stamped
+func (this Metadata) Hlc() clock.Timestamp {
+    if this.Timestamp.IsZero() {
+        return clock.Timestamp{}
+    }
+    return clock.Timestamp{Wall: this.Timestamp.UnixNano(), Logical: this.Logical}
+}
+
+// Callbacks fired at points in the consensus lifecycle. Callbacks run synchronously on the
+// goroutine reaching the event, in registration order, so they must return promptly and
+// must not call back into the node. Apply callbacks run on the log's apply loop, one value
+// at a time in index order. Methods on a nil Hooks are no-ops
+type Hooks struct {
+    onCommit []func(index int, value []byte)
+    onApply []func(index int, value []byte)
+    onApplyEntry []func(entry Entry)
+    onApplyAsync []func(entry Entry, done func())
+    schedulers []*Scheduler
+    onLeaderChange []func(leaderId uint64)
+    onMembershipChange []func(roleId uint64, address string)
+    onPeerQuarantine []func(roleId uint64, quarantined bool)
+    exclude sync.RWMutex
+}
+
+func Construct() *Hooks {
+    newHooks := Hooks{}
+    return &newHooks
+}
+
+// Registers a callback fired as each log entry is chosen, in index order
+func (this *Hooks) OnCommit(callback func(index int, value []byte)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onCommit = append(this.onCommit, callback)
+}
+
+// Registers a callback fired as each complete value is delivered to the application;
+// chunked values are delivered once, at the index of their final chunk
+func (this *Hooks) OnApply(callback func(index int, value []byte)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onApply = append(this.onApply, callback)
+}
+
+// Registers a callback fired as OnApply, with the metadata recorded alongside each value
+func (this *Hooks) OnApplyEntry(callback func(entry Entry)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onApplyEntry = append(this.onApplyEntry, callback)
+}
+
+// Registers a callback receiving each complete value as OnApplyEntry, which may apply it in
+// the background and must call done exactly once when it has. Later values are delivered
+// meanwhile, still in index order, but the applied index passes a value only once done has
+// been called for it and every value before it
+func (this *Hooks) OnApplyAsync(callback func(entry Entry, done func())) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onApplyAsync = append(this.onApplyAsync, callback)
+}
+
+// Registers a callback fired when this node learns of a new leader, including itself
+func (this *Hooks) OnLeaderChange(callback func(leaderId uint64)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onLeaderChange = append(this.onLeaderChange, callback)
+}
+
+// Registers a callback fired when the address of a cluster member changes
+func (this *Hooks) OnMembershipChange(callback func(roleId uint64, address string)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onMembershipChange = append(this.onMembershipChange, callback)
+}
+
+// Registers a callback fired when a peer is quarantined after being unreachable past the
+// threshold, and when a quarantined peer reconnects; suited to alerting operators
+func (this *Hooks) OnPeerQuarantine(callback func(roleId uint64, quarantined bool)) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.onPeerQuarantine = append(this.onPeerQuarantine, callback)
+}
+
+func (this *Hooks) Commit(index int, value []byte) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onCommit {
+        callback(index, value)
+    }
+}
+
+func (this *Hooks) Apply(index int, value []byte) {
+    this.ApplyEntry(Entry{Index: index, Value: value})
+}
+
+func (this *Hooks) ApplyEntry(entry Entry) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onApply {
+        callback(entry.Index, entry.Value)
+    }
+    for _, callback := range this.onApplyEntry {
+        callback(entry)
+    }
+    for _, scheduler := range this.schedulers {
+        scheduler.Submit(entry.Index, entry.Value)
+    }
+}
+
+// Delivers a complete value to every apply callback, calling done once the synchronous
+// callbacks have returned and every asynchronous one has reported the value applied
+func (this *Hooks) Deliver(entry Entry, done func()) {
+    this.ApplyEntry(entry)
+    if this == nil {
+        done()
+        return
+    }
+
+    this.exclude.RLock()
+    callbacks := this.onApplyAsync
+    this.exclude.RUnlock()
+    if len(callbacks) == 0 {
+        done()
+        return
+    }
+    remaining := int32(len(callbacks))
+    for _, callback := range callbacks {
+        callback(entry, func() {
+            if atomic.AddInt32(&remaining, -1) == 0 {
+                done()
+            }
+        })
+    }
+}
+
+// Applies the values recovered from disk as a node starts, in index order; parallel apply
+// callbacks replay them concurrently, and this returns once all are applied
+func (this *Hooks) ApplyRecovered(entries []Entry) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onApply {
+        for _, entry := range entries {
+            callback(entry.Index, entry.Value)
+        }
+    }
+    for _, callback := range this.onApplyEntry {
+        for _, entry := range entries {
+            callback(entry)
+        }
+    }
+    for _, scheduler := range this.schedulers {
+        scheduler.Replay(entries)
+    }
+    for _, callback := range this.onApplyAsync {
+        var applied sync.WaitGroup
+        applied.Add(len(entries))
+        for _, entry := range entries {
+            callback(entry, applied.Done)
+        }
+        applied.Wait()
+    }
+}
+
+func (this *Hooks) LeaderChange(leaderId uint64) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onLeaderChange {
+        callback(leaderId)
+    }
+}
+
+func (this *Hooks) MembershipChange(roleId uint64, address string) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onMembershipChange {
+        callback(roleId, address)
+    }
+}
+
+func (this *Hooks) PeerQuarantine(roleId uint64, quarantined bool) {
+    if this == nil { return }
+    this.exclude.RLock()
+    defer this.exclude.RUnlock()
+    for _, callback := range this.onPeerQuarantine {
+        callback(roleId, quarantined)
+    }
+}
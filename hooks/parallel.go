@@ -0,0 +1,208 @@
+package hooks
+
+import (
+    "sync"
+    "context"
+)
+
+// Conflict relation of a state machine: two commands conflict if they share a key, and a
+// command with no keys conflicts with every other command
+type Conflicts func(value []byte) []string
+
+// Applies committed values on a pool of workers, out of index order where the conflict
+// relation allows: a value is applied only after every earlier value it conflicts with
+type Scheduler struct {
+    conflicts Conflicts
+    workers int
+    apply func(index int, value []byte)
+    last map[string]*task
+    barrier *task
+    outstanding map[*task]bool
+    queue []*task
+    order []int
+    finished map[int]bool
+    appliedIndex int
+    started bool
+    ready *sync.Cond
+    applied *sync.Cond
+    exclude sync.Mutex
+}
+
+// Value awaiting application, with the later values which must wait for it
+type task struct {
+    index int
+    value []byte
+    keys []string
+    waiting int
+    dependents []*task
+}
+
+// Registers a callback applying complete values on the given number of goroutines, ordered
+// only by the conflict relation; the callback must be safe for concurrent use. The returned scheduler
+// reports which values have been applied
+func (this *Hooks) OnApplyParallel(conflicts Conflicts, workers int, callback func(index int, value []byte)) *Scheduler {
+    scheduler := ConstructScheduler(conflicts, workers, callback)
+    scheduler.Start()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.schedulers = append(this.schedulers, scheduler)
+    return scheduler
+}
+
+// Constructor for Scheduler applying values on the given number of goroutines; values are
+// not applied until it is started
+func ConstructScheduler(conflicts Conflicts, workers int, apply func(index int, value []byte)) *Scheduler {
+    if workers < 1 {
+        workers = 1
+    }
+    newScheduler := Scheduler {
+        conflicts: conflicts,
+        workers: workers,
+        apply: apply,
+        last: make(map[string]*task),
+        outstanding: make(map[*task]bool),
+        finished: make(map[int]bool),
+        appliedIndex: -1,
+    }
+    newScheduler.ready = sync.NewCond(&newScheduler.exclude)
+    newScheduler.applied = sync.NewCond(&newScheduler.exclude)
+    return &newScheduler
+}
+
+// Starts the workers applying values
+func (this *Scheduler) Start() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.started { return }
+    this.started = true
+    for worker := 0; worker < this.workers; worker++ {
+        go this.work()
+    }
+}
+
+// Queues a value for application behind the earlier values it conflicts with; values must be
+// submitted in index order. Never blocks on application
+func (this *Scheduler) Submit(index int, value []byte) {
+    keys := this.conflicts(value)
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    newTask := task{index: index, value: value, keys: keys}
+    current := &newTask
+    if len(keys) == 0 {
+        // Conflicts with everything, so later values need only wait for it
+        for previous := range this.outstanding {
+            this.depend(current, previous)
+        }
+        this.barrier = current
+        this.last = make(map[string]*task)
+    } else {
+        this.depend(current, this.barrier)
+        for _, key := range keys {
+            this.depend(current, this.last[key])
+            this.last[key] = current
+        }
+    }
+    this.outstanding[current] = true
+    this.order = append(this.order, index)
+    if current.waiting == 0 {
+        this.queue = append(this.queue, current)
+        this.ready.Signal()
+    }
+}
+
+// Makes current wait for previous if it is outstanding; exclude MUST be locked
+func (this *Scheduler) depend(current *task, previous *task) {
+    if previous == nil || !this.outstanding[previous] { return }
+    for _, dependent := range previous.dependents {
+        if dependent == current { return }
+    }
+    previous.dependents = append(previous.dependents, current)
+    current.waiting++
+}
+
+// Applies queued values until the process exits
+func (this *Scheduler) work() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for {
+        for len(this.queue) == 0 {
+            this.ready.Wait()
+        }
+        current := this.queue[0]
+        this.queue = this.queue[1:]
+
+        this.exclude.Unlock()
+        this.apply(current.index, current.value)
+        this.exclude.Lock()
+
+        this.finish(current)
+    }
+}
+
+// Releases the values waiting for a task, and advances the applied index; exclude MUST be locked
+func (this *Scheduler) finish(current *task) {
+    delete(this.outstanding, current)
+    if this.barrier == current {
+        this.barrier = nil
+    }
+    for _, key := range current.keys {
+        if this.last[key] == current {
+            delete(this.last, key)
+        }
+    }
+    for _, dependent := range current.dependents {
+        dependent.waiting--
+        if dependent.waiting == 0 {
+            this.queue = append(this.queue, dependent)
+            this.ready.Signal()
+        }
+    }
+
+    this.finished[current.index] = true
+    for len(this.order) != 0 && this.finished[this.order[0]] {
+        delete(this.finished, this.order[0])
+        this.appliedIndex = this.order[0]
+        this.order = this.order[1:]
+    }
+    this.applied.Broadcast()
+}
+
+// Returns the index up to which every value submitted has been applied
+func (this *Scheduler) GetAppliedIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.appliedIndex
+}
+
+// Waits until every value submitted up to and including index has been applied, or the
+// context is done
+func (this *Scheduler) WaitForIndex(ctx context.Context, index int) error {
+    // Wakes the wait below when the context ends
+    finished := make(chan bool)
+    defer close(finished)
+    go func() {
+        select {
+        case <- ctx.Done():
+            this.exclude.Lock()
+            this.applied.Broadcast()
+            this.exclude.Unlock()
+        case <- finished:
+        }
+    }()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    for this.appliedIndex < index {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        this.applied.Wait()
+    }
+    return nil
+}
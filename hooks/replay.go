@@ -0,0 +1,87 @@
+package hooks
+
+import (
+    "sync"
+)
+
+// Applies values recovered from disk, all known in advance, without tracking dependencies one
+// by one: values with no keys divide the entries into stretches replayed one after another,
+// and each stretch is partitioned into groups sharing keys, which are replayed concurrently,
+// each in index order. Must be called before any value is submitted
+func (this *Scheduler) Replay(entries []Entry) {
+    if len(entries) == 0 { return }
+
+    keys := make([][]string, len(entries))
+    for offset, entry := range entries {
+        keys[offset] = this.conflicts(entry.Value)
+    }
+    for start := 0; start < len(entries); {
+        end := start
+        for end < len(entries) && len(keys[end]) != 0 {
+            end++
+        }
+        this.replayStretch(entries[start:end], keys[start:end])
+        if end < len(entries) {
+            this.apply(entries[end].Index, entries[end].Value)
+        }
+        start = end+1
+    }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.appliedIndex = entries[len(entries)-1].Index
+    this.applied.Broadcast()
+}
+
+// Replays entries which all have keys, grouping those connected by shared keys
+func (this *Scheduler) replayStretch(entries []Entry, keys [][]string) {
+    if len(entries) == 0 { return }
+
+    // Each entry joins the group of the first entry to use each of its keys
+    parent := make([]int, len(entries))
+    first := make(map[string]int)
+    var root func(offset int) int
+    root = func(offset int) int {
+        for parent[offset] != offset {
+            parent[offset] = parent[parent[offset]]
+            offset = parent[offset]
+        }
+        return offset
+    }
+    for offset := range entries {
+        parent[offset] = offset
+        for _, key := range keys[offset] {
+            owner, seen := first[key]
+            if !seen {
+                first[key] = offset
+                continue
+            }
+            parent[root(offset)] = root(owner)
+        }
+    }
+
+    groups := make(map[int][]Entry)
+    for offset, entry := range entries {
+        group := root(offset)
+        groups[group] = append(groups[group], entry)
+    }
+
+    pending := make(chan []Entry, len(groups))
+    for _, group := range groups {
+        pending <- group
+    }
+    close(pending)
+    var done sync.WaitGroup
+    for worker := 0; worker < this.workers && worker < len(groups); worker++ {
+        done.Add(1)
+        go func() {
+            defer done.Done()
+            for group := range pending {
+                for _, entry := range group {
+                    this.apply(entry.Index, entry.Value)
+                }
+            }
+        }()
+    }
+    done.Wait()
+}
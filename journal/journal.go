@@ -0,0 +1,103 @@
+package journal
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "github/paxoscluster/clock"
+)
+
+// Kinds of events recorded
+const (
+    // A role heard no leader within an election timeout and began leading
+    ElectionStarted = "electionStarted"
+    // The role believed to be leader changed; to this node when it won an election
+    LeaderChanged = "leaderChanged"
+    // A round failed to gather a quorum of promises or accepts, usually against a competing proposal
+    RoundRejected = "roundRejected"
+    // A connection to a peer was re-established, or the peer was quarantined or released
+    PeerReconnected = "peerReconnected"
+    PeerQuarantined = "peerQuarantined"
+    // A link between two members was found to work in one direction only, or healed
+    AsymmetricPartition = "asymmetricPartition"
+    // Storage became degraded or recovered
+    StorageHealth = "storageHealth"
+    // The node started from recovered state, or discarded a prefix covered by a snapshot
+    Recovered = "recovered"
+    Compacted = "compacted"
+    // A member was demoted to a learner or promoted back to a voter
+    RoleChanged = "roleChanged"
+    // The node began draining for shutdown
+    Draining = "draining"
+    // The node entered or left read-only mode
+    ReadOnly = "readOnly"
+    // The node moved toward serving clients after it started
+    StateChanged = "stateChanged"
+)
+
+// Significant event in the life of a node; Sequence numbers events from 1 as recorded, so
+// gaps show where older events were overwritten
+type Event struct {
+    Sequence uint64
+    Time time.Time
+    RoleId uint64
+    Kind string
+    Detail string
+}
+
+// Bounded ring of the most recent significant consensus events on a node, for reconstructing
+// the timeline of an incident. Methods on a nil Journal are no-ops
+type Journal struct {
+    events []Event
+    recorded uint64
+    clock clock.Clock
+    exclude sync.Mutex
+}
+
+// Creates a journal holding up to capacity events, or nil if capacity is zero
+func ConstructJournal(capacity int, source clock.Clock) *Journal {
+    if capacity <= 0 { return nil }
+
+    newJournal := Journal {
+        events: make([]Event, capacity),
+        clock: clock.OrReal(source),
+    }
+    return &newJournal
+}
+
+// Records an event, overwriting the oldest once the journal is full
+func (this *Journal) Record(roleId uint64, kind string, format string, args ...interface{}) {
+    if this == nil { return }
+    event := Event {
+        Time: this.clock.Now(),
+        RoleId: roleId,
+        Kind: kind,
+        Detail: fmt.Sprintf(format, args...),
+    }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    event.Sequence = this.recorded+1
+    this.events[this.recorded % uint64(len(this.events))] = event
+    this.recorded++
+}
+
+// Returns the events held with a sequence number greater than after, oldest first
+func (this *Journal) Read(after uint64) []Event {
+    if this == nil { return nil }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    capacity := uint64(len(this.events))
+    first := after+1
+    if this.recorded > capacity && first <= this.recorded-capacity {
+        first = this.recorded-capacity+1
+    }
+    events := make([]Event, 0)
+    for sequence := first; sequence <= this.recorded; sequence++ {
+        events = append(events, this.events[(sequence-1) % capacity])
+    }
+    return events
+}
@@ -0,0 +1,48 @@
+package learner
+
+import (
+    "fmt"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/replicatedlog"
+)
+
+/*
+ * Learner Role
+ */
+type LearnerRole struct {
+    roleId uint64
+    log *replicatedlog.Log
+}
+
+// Constructor for LearnerRole, which records committed entries streamed by the leader
+func Construct(roleId uint64, log *replicatedlog.Log) *LearnerRole {
+    newLearnerRole := LearnerRole{roleId, log}
+    return &newLearnerRole
+}
+
+// Committed entries starting at Start, streamed by the leader; an empty batch asks only for
+// the learner's position
+type DeliverReq struct {
+    LeaderId uint64
+    Start int
+    Values [][]byte
+    Checksums []uint32
+}
+
+// Records a batch of committed entries, acknowledging with the first index the learner is
+// missing. Pipelined batches may be handled out of order, leaving gaps until earlier ones arrive
+func (this *LearnerRole) Deliver(req *DeliverReq, reply *int) (err error) {
+    defer guard.Recover("LEARNER", this.roleId, "LearnerRole.Deliver", &err)
+    if len(req.Values) != len(req.Checksums) {
+        return fmt.Errorf("[ LEARNER %d ] Batch of %d values carries %d checksums", this.roleId, len(req.Values), len(req.Checksums))
+    }
+    for offset, value := range req.Values {
+        if replicatedlog.Checksum(value) != req.Checksums[offset] {
+            return fmt.Errorf("[ LEARNER %d ] Checksum mismatch for entry %d", this.roleId, req.Start+offset)
+        }
+        this.log.SetEntryAt(req.Start+offset, value, proposal.Chosen())
+    }
+    *reply = this.log.GetFirstUnchosenIndex()
+    return nil
+}
@@ -0,0 +1,54 @@
+package learner
+
+import (
+    "fmt"
+    "context"
+)
+
+// Moves this group to new members while it keeps serving writes. The new members, started as
+// learners, are streamed the log at the configured rate; once every one is within the
+// configured lag of the commit index, the log is sealed, and the stream continues through the
+// seal. Each new member then relaunches as a voter of the new group, whose log resumes after
+// the seal. Members of the current group staying in the new one already hold the log. Must be
+// invoked on the leader
+func (this *Streamer) Migrate(ctx context.Context, members map[uint64]string) error {
+    err := this.proposer.RequireLeader()
+    if err != nil { return err }
+
+    var targets []uint64 = nil
+    for roleId, address := range members {
+        if this.cluster.IsMember(roleId) { continue }
+        this.AddLearner(roleId, address, this.migration.Rate)
+        targets = append(targets, roleId)
+    }
+    fmt.Println("[ STREAM", this.roleId, "] Migrating group to", members, "; transferring log to", targets)
+
+    // The log is transferred while writes continue, so the seal waits only on the remainder
+    err = this.awaitTransfer(ctx, targets, int(this.migration.Lag))
+    if err != nil { return err }
+    err = this.proposer.Seal(ctx, members)
+    if err != nil { return err }
+    return this.awaitTransfer(ctx, targets, 0)
+}
+
+// Waits until every target has acknowledged all but lag of the committed entries
+func (this *Streamer) awaitTransfer(ctx context.Context, targets []uint64, lag int) error {
+    for {
+        committed := this.log.GetCommitIndex()+1
+        remaining := 0
+        for _, roleId := range targets {
+            if missing := committed-this.GetAcknowledged(roleId); missing > lag {
+                remaining += missing
+            }
+        }
+        if remaining == 0 {
+            return nil
+        }
+
+        select {
+        case <- ctx.Done():
+            return fmt.Errorf("[ STREAM %d ] Migration stopped with %d entries left to transfer: %v", this.roleId, remaining, ctx.Err())
+        case <- this.clock.After(this.timeouts.Get().Heartbeat):
+        }
+    }
+}
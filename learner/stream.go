@@ -0,0 +1,221 @@
+package learner
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "expvar"
+    "net/rpc"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+var streamStats = metrics.Group("stream")
+
+// Pushes committed entries from the leader to each configured learner as they commit. At
+// most window batches are sent ahead of a learner's acknowledgments, bounding its lag
+type Streamer struct {
+    roleId uint64
+    log *replicatedlog.Log
+    cluster *clusterpeers.Cluster
+    proposer *proposer.ProposerRole
+    configured map[uint64]string
+    learners map[uint64]string
+    rates map[uint64]uint64
+    acknowledged map[uint64]int
+    batchSize int
+    window int
+    migration config.MigrationConfig
+    timeouts *config.LiveTimeouts
+    clock clock.Clock
+    exclude sync.Mutex
+}
+
+func ConstructStreamer(roleId uint64, log *replicatedlog.Log, cluster *clusterpeers.Cluster,
+                       proposerRole *proposer.ProposerRole, settings *config.Config) *Streamer {
+    newStreamer := Streamer {
+        roleId: roleId,
+        log: log,
+        cluster: cluster,
+        proposer: proposerRole,
+        configured: settings.Learners,
+        learners: make(map[uint64]string),
+        rates: make(map[uint64]uint64),
+        acknowledged: make(map[uint64]int),
+        batchSize: int(settings.Stream.BatchSize),
+        window: int(settings.Stream.Window),
+        migration: settings.Migration,
+        timeouts: config.ConstructLiveTimeouts(settings.Timeouts),
+        clock: clock.OrReal(settings.Clock),
+    }
+    return &newStreamer
+}
+
+// Streams to every configured learner while this node is leader
+func (this *Streamer) Run() {
+    roleIds := make([]uint64, 0, len(this.configured))
+    for roleId := range this.configured {
+        roleIds = append(roleIds, roleId)
+    }
+    sort.Slice(roleIds, func(i, j int) bool { return roleIds[i] < roleIds[j] })
+    for _, roleId := range roleIds {
+        this.AddLearner(roleId, this.configured[roleId], 0)
+    }
+}
+
+// Takes reloaded timeouts, which pace streaming from now on
+func (this *Streamer) SetTimeouts(timeouts config.Timeouts) {
+    this.timeouts.Set(timeouts)
+}
+
+// Begins streaming to a learner while this node is leader, sending at most rate entries per
+// second if rate is nonzero; a learner already streamed to is left unchanged
+func (this *Streamer) AddLearner(roleId uint64, address string, rate uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if _, exists := this.learners[roleId]; exists { return }
+    this.learners[roleId] = address
+    this.rates[roleId] = rate
+    go this.serve(roleId, address)
+}
+
+// Returns the first index a learner was last known to be missing
+func (this *Streamer) GetAcknowledged(roleId uint64) int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.acknowledged[roleId]
+}
+
+// Records the first index a learner reports missing
+func (this *Streamer) acknowledge(roleId uint64, index int) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if index > this.acknowledged[roleId] {
+        this.acknowledged[roleId] = index
+    }
+}
+
+// Returns the entries per second sent to a learner, zero if unlimited
+func (this *Streamer) getRate(roleId uint64) uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.rates[roleId]
+}
+
+// Streams to one learner whenever this node is leader, reconnecting after failures
+func (this *Streamer) serve(roleId uint64, address string) {
+    for {
+        if this.proposer.IsLeader() {
+            err := this.stream(roleId, address)
+            if err != nil {
+                fmt.Println("[ STREAM", this.roleId, "] Stream to learner", roleId, "stopped:", err)
+                streamStats.Add("failures", 1)
+            }
+        }
+        this.clock.Sleep(this.timeouts.Get().Heartbeat)
+    }
+}
+
+// Sent batch awaiting the learner's acknowledgment
+type pending struct {
+    call *rpc.Call
+    end int
+}
+
+// Streams committed entries to a learner from the first index it is missing, until this
+// node stops leading or the learner fails to acknowledge
+func (this *Streamer) stream(roleId uint64, address string) error {
+    connection, err := this.cluster.DialNode(address)
+    if err != nil { return err }
+    defer connection.Close()
+
+    var from int
+    err = connection.Call("LearnerRole.Deliver", &DeliverReq{LeaderId: this.roleId}, &from)
+    if err != nil { return err }
+    this.acknowledge(roleId, from)
+    if this.log.IsCompacted(from) {
+        return fmt.Errorf("Learner %d is missing entry %d, which is compacted", roleId, from)
+    }
+    fmt.Println("[ STREAM", this.roleId, "] Streaming to learner", roleId, "from", from)
+
+    entries, cancel := this.log.Subscribe(from)
+    defer cancel()
+
+    lag := new(expvar.Int)
+    streamStats.Set(fmt.Sprintf("lag-%d", roleId), lag)
+
+    // Acknowledgments are processed in order as they arrive; a full window stops sending
+    inFlight := make(chan pending, this.window)
+    failed := make(chan error, 1)
+    go func() {
+        for batch := range inFlight {
+            select {
+            case <- batch.call.Done:
+            case <- this.clock.After(2*this.timeouts.Get().Rpc):
+                failed <- fmt.Errorf("Learner %d did not acknowledge entries up to %d", roleId, batch.end)
+                return
+            }
+            if batch.call.Error != nil {
+                failed <- batch.call.Error
+                return
+            }
+            acknowledged := *batch.call.Reply.(*int)
+            this.acknowledge(roleId, acknowledged)
+            lag.Set(int64(this.log.GetFirstUnchosenIndex()-acknowledged))
+        }
+    }()
+    defer close(inFlight)
+
+    for this.proposer.IsLeader() {
+        req := DeliverReq{LeaderId: this.roleId, Start: -1}
+        select {
+        case entry, open := <- entries:
+            if !open {
+                return fmt.Errorf("Log stopped streaming to learner %d", roleId)
+            }
+            req.Start = entry.Index
+            req.Values = append(req.Values, entry.Value)
+        case err := <- failed:
+            return err
+        case <- this.clock.After(this.timeouts.Get().Heartbeat):
+            continue
+        }
+        batching := true
+        for batching && len(req.Values) < this.batchSize {
+            select {
+            case entry, open := <- entries:
+                batching = open
+                if open {
+                    req.Values = append(req.Values, entry.Value)
+                }
+            default:
+                batching = false
+            }
+        }
+        for _, value := range req.Values {
+            req.Checksums = append(req.Checksums, replicatedlog.Checksum(value))
+        }
+
+        var acknowledged int
+        call := connection.Go("LearnerRole.Deliver", &req, &acknowledged, make(chan *rpc.Call, 1))
+        select {
+        case inFlight <- pending{call, req.Start+len(req.Values)}:
+            streamStats.Add("entries", int64(len(req.Values)))
+        case err := <- failed:
+            return err
+        }
+        if rate := this.getRate(roleId); rate != 0 {
+            this.clock.Sleep(time.Duration(len(req.Values))*time.Second/time.Duration(rate))
+        }
+    }
+    return nil
+}
@@ -0,0 +1,175 @@
+package linearizability
+
+import (
+    "fmt"
+    "sort"
+)
+
+// Sequential specification against which histories are checked
+type Model interface {
+    Init() interface{}
+    // Applies an operation to a state, reporting whether the output is allowed and the next
+    // state; output is nil for pending operations, which allow any output
+    Step(state interface{}, input interface{}, output interface{}) (bool, interface{})
+    Equal(a interface{}, b interface{}) bool
+}
+
+// Model whose operations split into independent histories, such as those on different keys,
+// which are checked separately
+type Partitioner interface {
+    Partition(history []Operation) [][]Operation
+}
+
+// Checks that a history is linearizable with respect to the model, returning an error
+// describing the first independent history which is not
+func Check(model Model, history []Operation) error {
+    partitions := [][]Operation{history}
+    if partitioner, isPartitioner := model.(Partitioner); isPartitioner {
+        partitions = partitioner.Partition(history)
+    }
+
+    for _, partition := range partitions {
+        if !checkPartition(model, partition) {
+            return fmt.Errorf("History of %d operations is not linearizable: %s", len(partition), describe(partition))
+        }
+    }
+    return nil
+}
+
+// Lists operations in call order
+func describe(history []Operation) string {
+    sorted := append([]Operation{}, history...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].Call < sorted[j].Call })
+    description := ""
+    for _, operation := range sorted {
+        if operation.Pending {
+            description += fmt.Sprintf("[client %d %v -> pending from %d] ", operation.ClientId, operation.Input, operation.Call)
+        } else {
+            description += fmt.Sprintf("[client %d %v -> %v from %d to %d] ", operation.ClientId, operation.Input,
+                                       operation.Output, operation.Call, operation.Return)
+        }
+    }
+    return description
+}
+
+// Call or return of an operation, linked in time order
+type entry struct {
+    isCall bool
+    id int
+    time int64
+    operation *Operation
+    match *entry
+    previous *entry
+    next *entry
+}
+
+// Builds the list of call and return entries in time order, behind a sentinel head; calls
+// precede returns at equal times, treating such operations as concurrent
+func buildEntries(history []Operation) *entry {
+    var entries []*entry = nil
+    for id := range history {
+        call := &entry{isCall: true, id: id, time: history[id].Call, operation: &history[id]}
+        ret := &entry{isCall: false, id: id, time: history[id].Return}
+        call.match = ret
+        entries = append(entries, call, ret)
+    }
+    sort.SliceStable(entries, func(i, j int) bool {
+        if entries[i].time != entries[j].time { return entries[i].time < entries[j].time }
+        return entries[i].isCall && !entries[j].isCall
+    })
+
+    head := &entry{id: -1}
+    last := head
+    for _, current := range entries {
+        last.next = current
+        current.previous = last
+        last = current
+    }
+    return head
+}
+
+// Removes a call and its return from the list
+func lift(call *entry) {
+    call.previous.next = call.next
+    call.next.previous = call.previous
+    ret := call.match
+    ret.previous.next = ret.next
+    if ret.next != nil {
+        ret.next.previous = ret.previous
+    }
+}
+
+// Restores a call and its return removed by lift
+func unlift(call *entry) {
+    ret := call.match
+    ret.previous.next = ret
+    if ret.next != nil {
+        ret.next.previous = ret
+    }
+    call.previous.next = call
+    call.next.previous = call
+}
+
+// Set of linearized operations
+type bitset []uint64
+
+func (this bitset) set(position int) { this[position/64] |= 1 << uint(position%64) }
+func (this bitset) clear(position int) { this[position/64] &^= 1 << uint(position%64) }
+func (this bitset) key() string { return fmt.Sprint([]uint64(this)) }
+
+// Operation linearized at some point of the search, with the state preceding it
+type frame struct {
+    call *entry
+    state interface{}
+}
+
+// Searches for a linearization of one history, following Wing & Gong with the memoization of
+// Lowe: the earliest pending call is linearized next whenever the model allows it, and the
+// search backtracks when a return is reached before its call was linearized
+func checkPartition(model Model, history []Operation) bool {
+    head := buildEntries(history)
+    state := model.Init()
+    linearized := make(bitset, len(history)/64+1)
+    cache := make(map[string][]interface{})
+    var calls []frame = nil
+
+    current := head.next
+    for head.next != nil {
+        if current.isCall {
+            allowed, next := model.Step(state, current.operation.Input, current.operation.Output)
+            if allowed {
+                linearized.set(current.id)
+                if !cacheContains(model, cache, linearized.key(), next) {
+                    cache[linearized.key()] = append(cache[linearized.key()], next)
+                    calls = append(calls, frame{current, state})
+                    state = next
+                    lift(current)
+                    current = head.next
+                    continue
+                }
+                linearized.clear(current.id)
+            }
+            current = current.next
+        } else {
+            if len(calls) == 0 {
+                return false
+            }
+            top := calls[len(calls)-1]
+            calls = calls[:len(calls)-1]
+            state = top.state
+            linearized.clear(top.call.id)
+            unlift(top.call)
+            current = top.call.next
+        }
+    }
+    return true
+}
+
+func cacheContains(model Model, cache map[string][]interface{}, key string, state interface{}) bool {
+    for _, seen := range cache[key] {
+        if model.Equal(seen, state) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,60 @@
+package linearizability
+
+import (
+    "math"
+    "testing"
+)
+
+func put(clientId int, key string, value string, call int64, ret int64) Operation {
+    return Operation{ClientId: clientId, Input: KVInput{Op: "put", Key: key, Value: value}, Output: KVOutput{Value: value}, Call: call, Return: ret}
+}
+
+func get(clientId int, key string, value string, call int64, ret int64) Operation {
+    return Operation{ClientId: clientId, Input: KVInput{Op: "get", Key: key}, Output: KVOutput{Value: value}, Call: call, Return: ret}
+}
+
+func TestCheckAcceptsLinearizable(t *testing.T) {
+    cases := []struct {
+        name string
+        history []Operation
+    }{
+        {"sequential", []Operation{put(0, "x", "1", 0, 10), get(1, "x", "1", 20, 30)}},
+        // A read concurrent with a write may see either value
+        {"concurrent old", []Operation{put(0, "x", "1", 0, 30), get(1, "x", "", 10, 20)}},
+        {"concurrent new", []Operation{put(0, "x", "1", 0, 30), get(1, "x", "1", 10, 20)}},
+        // A write whose outcome is unknown may take effect at any point after its call
+        {"pending", []Operation{
+            {ClientId: 0, Input: KVInput{Op: "put", Key: "x", Value: "1"}, Call: 0, Return: math.MaxInt64, Pending: true},
+            get(1, "x", "", 10, 20), get(1, "x", "1", 30, 40),
+        }},
+        {"separate keys", []Operation{put(0, "x", "1", 0, 10), put(1, "y", "2", 0, 10), get(2, "y", "2", 20, 30)}},
+    }
+    for _, test := range cases {
+        if err := Check(KVModel{}, test.history); err != nil {
+            t.Errorf("%s: %v", test.name, err)
+        }
+    }
+}
+
+func TestCheckRejectsViolations(t *testing.T) {
+    cases := []struct {
+        name string
+        history []Operation
+    }{
+        // The read began after the write returned, so must see it
+        {"stale read", []Operation{put(0, "x", "1", 0, 10), get(1, "x", "", 20, 30)}},
+        {"value never written", []Operation{put(0, "x", "1", 0, 10), get(1, "x", "2", 20, 30)}},
+        // Once one read has seen the new value, a later read may not see the old one
+        {"reads regress", []Operation{
+            put(0, "x", "1", 0, 100), get(1, "x", "1", 10, 20), get(2, "x", "", 30, 40),
+        }},
+        {"writes reordered", []Operation{
+            put(0, "x", "1", 0, 10), put(0, "x", "2", 20, 30), get(1, "x", "1", 40, 50),
+        }},
+    }
+    for _, test := range cases {
+        if err := Check(KVModel{}, test.history); err == nil {
+            t.Errorf("%s: history accepted as linearizable", test.name)
+        }
+    }
+}
@@ -0,0 +1,65 @@
+package linearizability
+
+import (
+    "sync"
+    "time"
+    "math"
+)
+
+// Operation invoked by a client, spanning the interval from Call to Return in nanoseconds.
+// Operations which never returned are pending: they may take effect at any point after their
+// call, and their output is unknown
+type Operation struct {
+    ClientId int
+    Input interface{}
+    Output interface{}
+    Call int64
+    Return int64
+    Pending bool
+}
+
+// Records operations as concurrent clients invoke and complete them
+type History struct {
+    started time.Time
+    operations []Operation
+    exclude sync.Mutex
+}
+
+func ConstructHistory() *History {
+    newHistory := History{started: time.Now()}
+    return &newHistory
+}
+
+// Records the invocation of an operation, returning its identifier for Complete
+func (this *History) Invoke(clientId int, input interface{}) int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    operation := Operation {
+        ClientId: clientId,
+        Input: input,
+        Call: int64(time.Since(this.started)),
+        Return: math.MaxInt64,
+        Pending: true,
+    }
+    this.operations = append(this.operations, operation)
+    return len(this.operations)-1
+}
+
+// Records the output of a previously invoked operation; operations never completed remain pending
+func (this *History) Complete(id int, output interface{}) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.operations[id].Output = output
+    this.operations[id].Return = int64(time.Since(this.started))
+    this.operations[id].Pending = false
+}
+
+// Returns a copy of the recorded operations
+func (this *History) Operations() []Operation {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return append([]Operation{}, this.operations...)
+}
@@ -0,0 +1,56 @@
+package linearizability
+
+// Operation on a key-value store; Op is "get" or "put"
+type KVInput struct {
+    Op string
+    Key string
+    Value string
+}
+
+// Value read by a get; puts return the value written
+type KVOutput struct {
+    Value string
+}
+
+// Sequential key-value store, partitioned by key; each partition's state is the key's value
+type KVModel struct{}
+
+func (this KVModel) Init() interface{} {
+    return ""
+}
+
+func (this KVModel) Step(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+    request := input.(KVInput)
+    switch request.Op {
+    case "get":
+        if output == nil {
+            return true, state
+        }
+        return output.(KVOutput).Value == state.(string), state
+    case "put":
+        return true, request.Value
+    }
+    return false, state
+}
+
+func (this KVModel) Equal(a interface{}, b interface{}) bool {
+    return a.(string) == b.(string)
+}
+
+func (this KVModel) Partition(history []Operation) [][]Operation {
+    byKey := make(map[string][]Operation)
+    var keys []string = nil
+    for _, operation := range history {
+        key := operation.Input.(KVInput).Key
+        if _, exists := byKey[key]; !exists {
+            keys = append(keys, key)
+        }
+        byKey[key] = append(byKey[key], operation)
+    }
+
+    partitions := make([][]Operation, 0, len(keys))
+    for _, key := range keys {
+        partitions = append(partitions, byKey[key])
+    }
+    return partitions
+}
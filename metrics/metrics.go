@@ -0,0 +1,30 @@
+package metrics
+
+import (
+    "sync"
+    "expvar"
+)
+
+var exclude sync.Mutex
+
+// Returns the named group of counters published through expvar, creating it if necessary
+func Group(name string) *expvar.Map {
+    exclude.Lock()
+    defer exclude.Unlock()
+
+    existing := expvar.Get(name)
+    if existing != nil {
+        return existing.(*expvar.Map)
+    }
+    return expvar.NewMap(name)
+}
+
+// Returns the ratio of two counters in a group, or zero if the denominator is empty
+func Ratio(group *expvar.Map, numerator string, denominator string) float64 {
+    top, _ := group.Get(numerator).(*expvar.Int)
+    bottom, _ := group.Get(denominator).(*expvar.Int)
+    if top == nil || bottom == nil || bottom.Value() == 0 {
+        return 0
+    }
+    return float64(top.Value())/float64(bottom.Value())
+}
@@ -1,21 +1,45 @@
 package proposal
 
-import "sync"
+import (
+    "fmt"
+    "sync"
+)
+
+// Number of low bits of a proposal sequence holding the proposer's roleId, so sequences
+// drawn by different proposers never collide
+const RoleIdBits = 16
+
+// Largest roleId which fits in a proposal sequence
+const MaxRoleId = 1<<RoleIdBits - 1
+
+// Durable record of the highest proposal counter used by a role, so a restarted proposer
+// never reuses a proposal number
+type CounterStore interface {
+    UpdateProposalCounter(roleId uint64, counter int64) error
+}
 
 type Manager struct {
     roleId uint64
     proposalCount int64
     currentId Id
+    store CounterStore
     exclude sync.Mutex
 }
 
-func ConstructManager(roleId uint64) *Manager {
+// Resumes numbering after proposalCount, the highest counter recovered from the store
+func ConstructManager(roleId uint64, proposalCount int64, store CounterStore) (*Manager, error) {
+    if roleId > MaxRoleId {
+        return nil, fmt.Errorf("RoleId %d exceeds maximum %d", roleId, MaxRoleId)
+    }
+
     newManager := Manager {
         roleId: roleId,
-        proposalCount: 0,
+        proposalCount: proposalCount,
+        store: store,
     }
-    newManager.GenerateNextProposalId()
-    return &newManager
+    _, err := newManager.GenerateNextProposalId()
+    if err != nil { return nil, err }
+    return &newManager, nil
 }
 
 func (this *Manager) GetCurrentProposalId() Id {
@@ -25,14 +49,30 @@ func (this *Manager) GetCurrentProposalId() Id {
     return this.currentId
 }
 
-func (this *Manager) GenerateNextProposalId() Id {
+// Advances to a new proposal number, recording it durably before it can be used
+func (this *Manager) GenerateNextProposalId() (Id, error) {
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
+    err := this.store.UpdateProposalCounter(this.roleId, this.proposalCount+1)
+    if err != nil { return this.currentId, err }
+
     this.proposalCount++
     this.currentId = Id {
         RoleId: this.roleId,
-        Sequence: this.proposalCount,
+        Sequence: this.proposalCount<<RoleIdBits | int64(this.roleId),
+    }
+    return this.currentId, nil
+}
+
+// Records a proposal of another role which outnumbered this role's, so the next proposal
+// number generated exceeds it rather than climbing past it one round at a time
+func (this *Manager) ObserveHigher(higher Id) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    count := higher.Sequence>>RoleIdBits
+    if count > this.proposalCount {
+        this.proposalCount = count
     }
-    return this.currentId
 }
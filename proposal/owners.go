@@ -0,0 +1,65 @@
+package proposal
+
+import (
+    "sort"
+)
+
+// Assignment of log slots to members in Mencius mode: entry i is owned by the member at
+// position i modulo the member count, in order of roleId
+type Owners struct {
+    members []uint64
+}
+
+// Constructor for Owners; the members must be the same on every node
+func ConstructOwners(members []uint64) *Owners {
+    sorted := append([]uint64{}, members...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    newOwners := Owners{sorted}
+    return &newOwners
+}
+
+// Returns the roleId owning the entry at index
+func (this *Owners) Owner(index int) uint64 {
+    return this.members[index%len(this.members)]
+}
+
+// Returns the first index at or after from owned by roleId, or -1 if roleId owns no slots
+func (this *Owners) Next(roleId uint64, from int) int {
+    if from < 0 {
+        from = 0
+    }
+    for offset := 0; offset < len(this.members); offset++ {
+        if this.Owner(from+offset) == roleId {
+            return from+offset
+        }
+    }
+    return -1
+}
+
+// Returns the number of members
+func (this *Owners) Count() int {
+    return len(this.members)
+}
+
+// Returns how many positions roleId follows the owner of index, wrapping around; the owner's
+// successor is one, and the owner itself is zero
+func (this *Owners) Distance(index int, roleId uint64) int {
+    next := this.Next(roleId, index)
+    if next < 0 {
+        return len(this.members)
+    }
+    return next-index
+}
+
+// Returns the proposal under which an owner proposes in its own slots without a prepare
+// phase. It exceeds Default but is below every proposal drawn from a Manager, so any prepare
+// for the slot supersedes it and learns the value it carried
+func OwnedId(roleId uint64) Id {
+    return Id{RoleId: roleId, Sequence: int64(roleId)}
+}
+
+// Returns the proposal under which any proposer may propose in an unused slot during a fast
+// round. Like an owned proposal, it is superseded by any prepare for the slot
+func FastId() Id {
+    return Id{RoleId: 0, Sequence: 1}
+}
@@ -0,0 +1,260 @@
+package proposer
+
+import (
+    "fmt"
+    "sync"
+    "context"
+    "sync/atomic"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/config"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Catch-up of nodes missing chosen values; each node is caught up by at most one routine,
+// at a limited rate, so a node returning after a long absence is not flooded. The batch size
+// is read atomically, as it changes when settings are reloaded
+type catchUp struct {
+    batchSize int64
+    rate uint64
+    clock clock.Clock
+    followers map[uint64]*follower
+    exclude sync.Mutex
+}
+
+// Catch-up state of one node
+type follower struct {
+    active bool
+    target int
+    rate *tokenBucket
+}
+
+func constructCatchUp(settings config.CatchUpConfig, source clock.Clock) *catchUp {
+    newCatchUp := catchUp {
+        batchSize: int64(settings.BatchSize),
+        rate: settings.Rate,
+        clock: source,
+        followers: make(map[uint64]*follower),
+    }
+    return &newCatchUp
+}
+
+// Returns the chosen entries sent or fetched per batch
+func (this *catchUp) getBatchSize() int {
+    return int(atomic.LoadInt64(&this.batchSize))
+}
+
+// Takes a reloaded batch size and rate, the rate applying at once to nodes being caught up
+func (this *catchUp) reload(settings config.CatchUpConfig) {
+    atomic.StoreInt64(&this.batchSize, int64(settings.BatchSize))
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.rate = settings.Rate
+    for _, state := range this.followers {
+        state.rate = constructTokenBucket(this.rate, this.clock)
+    }
+}
+
+// Returns the limit on the rate a node is caught up at
+func (this *catchUp) limiter(state *follower) *tokenBucket {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return state.rate
+}
+
+// Raises the index a node must be caught up to, returning the node's state and whether the
+// caller should run its catch-up
+func (this *catchUp) begin(roleId uint64, target int) (*follower, bool) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    state, exists := this.followers[roleId]
+    if !exists {
+        state = &follower{rate: constructTokenBucket(this.rate, this.clock)}
+        this.followers[roleId] = state
+    }
+    if target > state.target {
+        state.target = target
+    }
+    if state.active {
+        return state, false
+    }
+    state.active = true
+    return state, true
+}
+
+// Returns the index a node must be caught up to, ending its catch-up once reached
+func (this *catchUp) next(state *follower, index int) (int, bool) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if index >= state.target {
+        state.active = false
+        return state.target, false
+    }
+    return state.target, true
+}
+
+// Ends a node's catch-up early; it resumes when the node is next found behind
+func (this *catchUp) end(state *follower) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    state.active = false
+}
+
+// Explicitly transfer chosen values to a role which is missing that information, batching
+// contiguous entries if the role supports it
+func (this *ProposerRole) notifyOfSuccess(roleId uint64, firstUnchosenIndex int, index int) {
+    // Nodes far behind fetch the missing entries themselves
+    if firstUnchosenIndex-index > this.catchUp.getBatchSize() && this.peers.PeerSupports(roleId, clusterpeers.FeatureFetchEntries) {
+        return
+    }
+    state, run := this.catchUp.begin(roleId, firstUnchosenIndex)
+    if !run { return }
+
+    for {
+        target, more := this.catchUp.next(state, index)
+        if !more { return }
+        this.catchUp.limiter(state).take(context.Background(), -1)
+
+        var endpoint <-chan clusterpeers.SuccessResult
+        if this.peers.PeerSupports(roleId, clusterpeers.FeatureSuccessBatch) {
+            info, ok := this.chosenBatch(index, target)
+            if !ok {
+                this.catchUp.end(state)
+                return
+            }
+            endpoint = this.peers.SendSuccessBatch(roleId, info)
+        } else {
+            values, ok := this.chosenBatch(index, index+1)
+            if !ok {
+                this.catchUp.end(state)
+                return
+            }
+            info := acceptor.SuccessNotify {
+                Index: index,
+                Value: values.Values[0],
+                Checksum: values.Checksums[0],
+            }
+            endpoint = this.peers.SendSuccess(roleId, info)
+        }
+
+        select {
+        case response, open := <- endpoint:
+            if open && response.Error == nil {
+                index = response.FirstUnchosenIndex
+            } else {
+                // Waits as long as a timeout before retrying a peer which failed to answer
+                this.clock.Sleep(this.peerTimeouts(roleId).Rpc)
+            }
+        case <- this.clock.After(this.peerTimeouts(roleId).Rpc):
+        }
+    }
+}
+
+// Collects up to a batch of chosen entries from index, stopping short of target; returns false
+// if the entry at index cannot be served yet
+func (this *ProposerRole) chosenBatch(index int, target int) (acceptor.SuccessBatchNotify, bool) {
+    info := acceptor.SuccessBatchNotify{Start: index}
+    for current := index; current < target && current < index+this.catchUp.getBatchSize(); current++ {
+        // Corrupt entries are served once repaired from a peer, and compacted ones never
+        if this.log.IsCorrupt(current) || this.log.IsCompacted(current) {
+            break
+        }
+
+        logEntry, err := this.log.GetEntryAt(current)
+        if err != nil {
+            fmt.Println("[ PROPOSER", this.roleId, "] Stopped catch up batch:", err)
+            break
+        }
+        if logEntry.AcceptedProposalId != proposal.Chosen() {
+            fmt.Println("FATAL ERROR: cluster state corrupted")
+            this.terminator <- true
+            break
+        }
+        info.Values = append(info.Values, logEntry.Value)
+        info.Checksums = append(info.Checksums, replicatedlog.Checksum(logEntry.Value))
+    }
+    return info, len(info.Values) != 0
+}
+
+// Fetches chosen values this node is missing from the given proposer, up to its first unchosen
+// index, if the gap is too large to be pushed; at most one fetch runs at a time
+func (this *ProposerRole) catchUpFrom(roleId uint64, target int) {
+    index := this.log.GetFirstUnchosenIndex()
+    if roleId == this.roleId || target-index <= this.catchUp.getBatchSize() {
+        return
+    }
+    state, run := this.catchUp.begin(this.roleId, target)
+    if !run { return }
+    go this.fetchEntries(state, roleId, index)
+}
+
+// Fetches the values a peer has chosen through the role change of its membership epoch, however
+// few, as the peer refuses this node's requests until it has applied them
+func (this *ProposerRole) catchUpEpoch(roleId uint64, epoch int) {
+    index := this.log.GetFirstUnchosenIndex()
+    if roleId == this.roleId || index > epoch || !this.peers.PeerSupports(roleId, clusterpeers.FeatureFetchEntries) {
+        return
+    }
+    state, run := this.catchUp.begin(this.roleId, epoch+1)
+    if !run { return }
+    go this.fetchEntries(state, roleId, index)
+}
+
+// Fetches chosen values in batches until the target is reached or the proposer stops serving
+func (this *ProposerRole) fetchEntries(state *follower, roleId uint64, index int) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.fetchEntries", nil)
+    fmt.Println("[ PROPOSER", this.roleId, "] Fetching chosen values from", roleId, "starting at", index)
+    for {
+        _, more := this.catchUp.next(state, index)
+        if !more { return }
+
+        if !this.fetchBatch(roleId, index, this.catchUp.getBatchSize()) {
+            this.catchUp.end(state)
+            return
+        }
+
+        next := this.log.GetFirstUnchosenIndex()
+        if next <= index {
+            this.catchUp.end(state)
+            return
+        }
+        index = next
+    }
+}
+
+// Fetches up to max chosen values starting at index from the given proposer and learns them,
+// returning false if none could be learned
+func (this *ProposerRole) fetchBatch(roleId uint64, index int, max int) bool {
+    response, err := this.peers.FetchEntries(roleId, index, max)
+    if err != nil { return false }
+    return this.learnFetched(response)
+}
+
+// Learns the chosen values fetched from a proposer, returning false if none could be learned
+func (this *ProposerRole) learnFetched(response *acceptor.FetchEntriesResp) bool {
+    if len(response.Values) == 0 {
+        return false
+    }
+    for offset, value := range response.Values {
+        if replicatedlog.Checksum(value) != response.Checksums[offset] {
+            fmt.Println("[ PROPOSER", this.roleId, "] Checksum mismatch fetching entry", response.Start+offset)
+            return false
+        }
+        learned := acceptor.SuccessNotify{Index: response.Start+offset, Value: value, Checksum: response.Checksums[offset]}
+        this.tracer.Trace(this.roleId, trace.LearnMethod, &learned, nil, func() error {
+            this.log.SetEntryAt(learned.Index, value, proposal.Chosen())
+            return nil
+        })
+    }
+    return true
+}
@@ -0,0 +1,90 @@
+package proposer
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/proposal"
+)
+
+var contentionStats = metrics.Group("contention")
+
+// Consecutive rounds lost to the same rival before two proposers are taken to be duelling
+const contentionThreshold = 2
+
+// Rounds this proposer lost to the proposals of another role. Two proposers which keep
+// preempting each other with higher proposals may never choose a value: once one rival has
+// preempted contentionThreshold rounds in a row, the proposer waits a random part of a window
+// which doubles with each further loss, up to an election timeout. The lower ranked of the two,
+// by priority and then roleId, also waits out a whole window, so the higher ranked one normally
+// completes its round alone
+type contention struct {
+    rival uint64
+    streak int
+    random clock.Rand
+    exclude sync.Mutex
+}
+
+func constructContention(random clock.Rand) *contention {
+    newContention := contention {
+        random: random,
+    }
+    return &newContention
+}
+
+// Records the proposal which preempted a failed round; proposals of this role, or failures
+// without one, end the streak
+func (this *ProposerRole) observePreemption(higher proposal.Id) {
+    this.contention.exclude.Lock()
+    defer this.contention.exclude.Unlock()
+
+    if higher == proposal.Default() || higher.RoleId == this.roleId {
+        this.contention.rival, this.contention.streak = 0, 0
+        return
+    }
+    // Rounds from this role would keep losing while its numbering lags the rival's
+    this.proposals.ObserveHigher(higher)
+    if higher.RoleId == this.contention.rival {
+        this.contention.streak++
+    } else {
+        this.contention.rival, this.contention.streak = higher.RoleId, 1
+    }
+}
+
+// Ends the streak once a round chooses its value
+func (this *ProposerRole) resolveContention() {
+    this.contention.exclude.Lock()
+    defer this.contention.exclude.Unlock()
+
+    this.contention.rival, this.contention.streak = 0, 0
+}
+
+// Waits out contention with a rival before the next round, if it is duelling with one
+func (this *ProposerRole) awaitContention() {
+    this.contention.exclude.Lock()
+    rival, streak := this.contention.rival, this.contention.streak
+    if streak < contentionThreshold {
+        this.contention.exclude.Unlock()
+        return
+    }
+    timeouts := this.roundTimeouts()
+    window := timeouts.Rpc
+    for i := contentionThreshold; i < streak && window < timeouts.Election; i++ {
+        window *= 2
+    }
+    if window > timeouts.Election {
+        window = timeouts.Election
+    }
+    delay := time.Duration(this.contention.random.Int63n(int64(window)+1))
+    this.contention.exclude.Unlock()
+
+    contentionStats.Add("detected", 1)
+    if this.peers.Outranks(rival, this.roleId) {
+        contentionStats.Add("deferred", 1)
+        delay += window
+    }
+    fmt.Println("[ PROPOSER", this.roleId, "] Contending with role", rival, "for", streak, "rounds; backing off", delay)
+    this.clock.Sleep(delay)
+}
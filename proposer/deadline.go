@@ -0,0 +1,92 @@
+package proposer
+
+import (
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "context"
+    "strings"
+)
+
+// Rejection of a proposal whose caller would stop waiting before it could commit: the time
+// remaining before its deadline was below the recent 99th percentile commit latency. The
+// proposal was not executed
+var ErrDeadlineTooShort = errors.New("Failure: deadline shorter than commit latency")
+
+// Number of recent commit latencies kept for the percentile
+const commitSamples = 256
+
+// Percentile is recomputed after this many new samples, sparing a sort per proposal
+const commitRecompute = 16
+
+// Recent times from admission to commit of this node's proposals
+type commitLatency struct {
+    samples []time.Duration
+    next int
+    fresh int
+    p99 time.Duration
+    exclude sync.Mutex
+}
+
+// Records the commit latency of a proposal
+func (this *commitLatency) observe(latency time.Duration) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if len(this.samples) < commitSamples {
+        this.samples = append(this.samples, latency)
+    } else {
+        this.samples[this.next] = latency
+        this.next = (this.next+1) % commitSamples
+    }
+    this.fresh++
+    if this.fresh < commitRecompute && len(this.samples) > commitRecompute { return }
+
+    this.fresh = 0
+    sorted := append([]time.Duration(nil), this.samples...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    this.p99 = sorted[(len(sorted)-1)*99/100]
+}
+
+// Returns the 99th percentile of recent commit latencies, zero before any commit
+func (this *commitLatency) percentile() time.Duration {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.p99
+}
+
+// Returns the 99th percentile of this node's recent commit latencies
+func (this *ProposerRole) GetCommitLatency() time.Duration {
+    return this.latency.percentile()
+}
+
+// Rejects a proposal whose context expires sooner than proposals have recently taken to commit
+func (this *ProposerRole) checkDeadline(ctx context.Context) error {
+    deadline, bounded := ctx.Deadline()
+    if !bounded { return nil }
+    if deadline.Sub(this.clock.Now()) >= this.latency.percentile() { return nil }
+    flowStats.Add("deadlineTooShort", 1)
+    return ErrDeadlineTooShort
+}
+
+// Returns how long a phase of a round may wait for replies: an RPC timeout, shortened to the
+// time left before the context's deadline. Fails once the deadline has passed
+func (this *ProposerRole) phaseTimeout(ctx context.Context) (time.Duration, error) {
+    timeout := this.roundTimeouts().Rpc
+    if deadline, bounded := ctx.Deadline(); bounded {
+        remaining := deadline.Sub(this.clock.Now())
+        if remaining <= 0 {
+            return 0, context.DeadlineExceeded
+        } else if remaining < timeout {
+            timeout = remaining
+        }
+    }
+    return timeout, nil
+}
+
+// Reports whether an error, possibly received over RPC, rejected a proposal for its deadline
+func IsDeadlineTooShort(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrDeadlineTooShort.Error())
+}
@@ -0,0 +1,34 @@
+package proposer
+
+import (
+    "sync/atomic"
+)
+
+// Work in progress on a proposer, published on the debug listener
+type DebugState struct {
+    LeaderId uint64
+    // Proposals admitted and not yet committed or failed
+    InFlight int
+    // Entries claimed by rounds, and those rounds awaiting promises or accepts
+    Rounds int
+    Preparing int64
+    Accepting int64
+    // Client values admitted and not yet chosen or refused
+    Proposing int64
+}
+
+// Returns the proposer's work in progress
+func (this *ProposerRole) GetDebugState() DebugState {
+    this.exclude.Lock()
+    rounds := len(this.claimed)
+    this.exclude.Unlock()
+
+    return DebugState {
+        LeaderId: atomic.LoadUint64(&this.leaderId),
+        InFlight: len(this.inFlight),
+        Rounds: rounds,
+        Preparing: atomic.LoadInt64(&this.preparing),
+        Accepting: atomic.LoadInt64(&this.accepting),
+        Proposing: atomic.LoadInt64(&this.proposing),
+    }
+}
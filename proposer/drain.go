@@ -0,0 +1,71 @@
+package proposer
+
+import (
+    "fmt"
+    "errors"
+    "context"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/journal"
+)
+
+// Rejection of a proposal by a node being drained for shutdown; the proposal was not executed,
+// so clients may retry it on another node
+var ErrDraining = errors.New("Failure: node is draining")
+
+// Prepares this node to be stopped: it refuses client proposals from now on, yields leadership
+// to a successor if it leads, then waits for the proposals it admitted to finish and its
+// applied index to reach its commit index. Returns nil once the process may be stopped safely,
+// or an error naming what remains if the context ends first. Draining lasts until restart
+func (this *ProposerRole) Drain(ctx context.Context) error {
+    if atomic.CompareAndSwapInt32(&this.draining, 0, 1) {
+        this.journal.Record(this.roleId, journal.Draining, "Draining; refusing client proposals")
+        fmt.Println("[ PROPOSER", this.roleId, "] Draining; refusing client proposals")
+    }
+    for {
+        remaining := this.drainRemaining()
+        if len(remaining) == 0 {
+            fmt.Println("[ PROPOSER", this.roleId, "] Drained; safe to stop")
+            return nil
+        }
+        if ctx.Err() != nil {
+            return fmt.Errorf("[ PROPOSER %d ] Failure: drain incomplete; %s", this.roleId, remaining)
+        }
+        this.clock.Sleep(this.timeouts.Get().Heartbeat/10)
+    }
+}
+
+// Describes what keeps a draining node from stopping safely, or returns empty if nothing does
+func (this *ProposerRole) drainRemaining() string {
+    if this.IsLeader() {
+        if this.successor() == 0 {
+            return "no other voter can take leadership"
+        }
+        return "leadership has not yet moved"
+    }
+    if proposing := atomic.LoadInt64(&this.proposing); proposing != 0 {
+        return fmt.Sprintf("%d admitted proposals in progress", proposing)
+    }
+    applied, committed := this.log.GetAppliedIndex(), this.log.GetCommitIndex()
+    if applied < committed {
+        return fmt.Sprintf("applied through %d of %d committed", applied, committed)
+    }
+    return ""
+}
+
+// Reports whether this node is being drained for shutdown
+func (this *ProposerRole) IsDraining() bool {
+    return atomic.LoadInt32(&this.draining) == 1
+}
+
+// Refuses client proposals while draining
+func (this *ProposerRole) checkDraining() error {
+    if !this.IsDraining() { return nil }
+    flowStats.Add("drainRejected", 1)
+    return ErrDraining
+}
+
+// Reports whether an error, possibly received over RPC, rejected a proposal on a draining node
+func IsDraining(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrDraining.Error())
+}
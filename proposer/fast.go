@@ -0,0 +1,149 @@
+package proposer
+
+import (
+    "fmt"
+    "time"
+    "sync"
+    "bytes"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/proposal"
+)
+
+var fastStats = metrics.Group("fast")
+
+// Slots claimed by this node for fast rounds. Any node proposes in the first slot it has not
+// seen used; a collision with another node's proposal is resolved by a full round
+type fastRounds struct {
+    revoke time.Duration
+    rank int
+    next int
+    stalled stall
+    exclude sync.Mutex
+}
+
+// Constructor for fastRounds; rank orders this node among the members, staggering the full
+// rounds each runs for a stalled slot
+func constructFastRounds(settings config.FastConfig, rank int) *fastRounds {
+    newFastRounds := fastRounds {
+        revoke: settings.Revoke,
+        rank: rank,
+        stalled: stall{index: -1},
+    }
+    return &newFastRounds
+}
+
+// Claims the first slot at or after from not yet claimed by this node
+func (this *fastRounds) claim(from int) int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if from < this.next {
+        from = this.next
+    }
+    this.next = from+1
+    return from
+}
+
+// Returns how long the first unchosen slot has held up later slots
+func (this *fastRounds) stalledFor(first int, length int, now time.Time) time.Duration {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.stalled.measure(first, length > first+1, now)
+}
+
+// Reports whether fast rounds are enabled
+func (this *ProposerRole) UsesFastRounds() bool {
+    return this.fast != nil
+}
+
+// Proposes a value in a fast round, resolving a collision with a full round and moving to a
+// later slot if another value is chosen
+func (this *ProposerRole) proposeFast(value []byte) error {
+    for {
+        first := this.log.GetFirstUnchosenIndex()
+        if length := this.log.GetLength(); length > first {
+            first = length
+        }
+        index := this.fast.claim(first)
+        if this.chooseFast(index, value) {
+            fastStats.Add("chosen", 1)
+            return nil
+        }
+
+        fastStats.Add("collisions", 1)
+        chosenValue, err := this.settleSlot(index, value)
+        if err != nil { return err }
+        if bytes.Equal(chosenValue, value) {
+            fastStats.Add("recovered", 1)
+            return nil
+        }
+    }
+}
+
+// Sends a value straight to the acceptors; reports whether a fast quorum accepted it
+func (this *ProposerRole) chooseFast(index int, value []byte) bool {
+    peerCount, endpoint := this.peers.BroadcastFast(acceptor.FastReq{Index: index, Value: value})
+    tracker := clusterpeers.ConstructQuorumTracker(proposal.Default(), this.peers.GetFastQuorumSize(), peerCount, nil)
+
+    // Gives up once too many acceptors refuse for a fast quorum to remain possible
+    state := clusterpeers.Await(tracker, endpoint, this.clock.After(this.roundTimeouts().Rpc), func(reply clusterpeers.FastResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            return tracker.Fail(reply.RoleId, reply.Error)
+        } else if !reply.Fast.Accepted {
+            return tracker.Reject(reply.RoleId)
+        }
+        return tracker.Vote(reply.RoleId)
+    })
+    if state != clusterpeers.QuorumReached {
+        return false
+    }
+
+    this.learn(index, value)
+    this.announce(index, value)
+    return true
+}
+
+// Runs full rounds for a slot until a value is chosen there, backing off by rank between
+// attempts so colliding proposers settle; returns the chosen value
+func (this *ProposerRole) settleSlot(index int, preferred []byte) ([]byte, error) {
+    for {
+        entry, err := this.log.GetEntryAt(index)
+        if err != nil { return nil, err }
+        if index < this.log.GetFirstUnchosenIndex() || entry.AcceptedProposalId == proposal.Chosen() {
+            return entry.Value, nil
+        }
+
+        value, chosen, err := this.chooseSlot(index, preferred)
+        if err != nil { return nil, err }
+        if chosen {
+            return value, nil
+        }
+        this.clock.Sleep(time.Duration(1+this.fast.rank)*this.timeouts.Get().Rpc/4)
+    }
+}
+
+// Runs full rounds for a slot which holds up later slots, as when its proposer failed before
+// it was chosen. Each member waits longer the higher its rank, so one normally acts alone
+func (this *ProposerRole) runFastRounds() {
+    for {
+        this.clock.Sleep(this.timeouts.Get().Heartbeat)
+
+        first := this.log.GetFirstUnchosenIndex()
+        stalled := this.fast.stalledFor(first, this.log.GetLength(), this.clock.Now())
+        if stalled < time.Duration(1+this.fast.rank)*this.fast.revoke {
+            continue
+        }
+        fmt.Println("[ PROPOSER", this.roleId, "] Settling stalled entry", first)
+        _, chosen, err := this.chooseSlot(first, nil)
+        if err != nil {
+            fmt.Println("[ PROPOSER", this.roleId, "] Failed to settle entry", first, ":", err)
+        }
+        if chosen {
+            fastStats.Add("settled", 1)
+        }
+    }
+}
@@ -0,0 +1,49 @@
+package proposer
+
+import (
+    "errors"
+    "context"
+    "strings"
+    "github/paxoscluster/metrics"
+)
+
+var flowStats = metrics.Group("flow")
+
+// Rejection of a proposal because the leader already holds as many uncommitted proposals as
+// it allows; the proposal was not executed, so clients may retry after backing off
+var ErrBackpressure = errors.New("Failure: too many proposals in flight")
+
+// Takes a slot for a proposal, waiting up to the admission wait for one to free, unless the
+// context is cancelled first
+func (this *ProposerRole) admit(ctx context.Context) error {
+    if this.inFlight == nil { return nil }
+
+    select {
+    case this.inFlight <- true:
+        return nil
+    default:
+    }
+    if wait := this.getTunables().admissionWait; wait > 0 {
+        select {
+        case this.inFlight <- true:
+            flowStats.Add("delayed", 1)
+            return nil
+        case <- this.clock.After(wait):
+        case <- ctx.Done():
+            return ctx.Err()
+        }
+    }
+    flowStats.Add("rejected", 1)
+    return ErrBackpressure
+}
+
+// Frees the slot taken by a proposal once it commits or fails
+func (this *ProposerRole) release() {
+    if this.inFlight == nil { return }
+    <- this.inFlight
+}
+
+// Reports whether an error, possibly received over RPC, rejected a proposal for backpressure
+func IsBackpressure(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrBackpressure.Error())
+}
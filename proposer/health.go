@@ -0,0 +1,109 @@
+package proposer
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/journal"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Rejection of a proposal because this node's storage is too slow or failing to keep up; the
+// proposal was not executed, so clients may retry, after leadership has moved if possible
+var ErrStorageDegraded = errors.New("Failure: storage is degraded")
+
+// Reports the smoothed latency of this node's storage updates and whether it is degraded;
+// must be set before Run
+func (this *ProposerRole) SetStorageHealth(health func() (time.Duration, bool)) {
+    this.storageHealth = health
+}
+
+// Returns the smoothed latency of this node's storage updates and whether it is degraded
+func (this *ProposerRole) GetStorageHealth() (time.Duration, bool) {
+    if this.storageHealth == nil { return 0, false }
+    return this.storageHealth()
+}
+
+// Rejects proposals while storage is degraded, rather than adding to its queue
+func (this *ProposerRole) checkStorage() error {
+    _, degraded := this.GetStorageHealth()
+    if !degraded { return nil }
+    flowStats.Add("storageRejected", 1)
+    return ErrStorageDegraded
+}
+
+// Reports whether this node is yielding leadership: it is draining or read-only, or its storage is degraded
+// and a successor was heard from. A yielding node withholds its heartbeats and follows lower
+// roles, until its storage recovers
+func (this *ProposerRole) IsYielding() bool {
+    if this.IsDraining() || this.IsReadOnly() {
+        return true
+    }
+    _, degraded := this.GetStorageHealth()
+    if !degraded {
+        if atomic.CompareAndSwapInt32(&this.yielding, 1, 0) {
+            this.journal.Record(this.roleId, journal.StorageHealth, "Storage recovered; resuming heartbeats")
+            fmt.Println("[ PROPOSER", this.roleId, "] Storage recovered; resuming heartbeats")
+        }
+        return false
+    }
+    if atomic.LoadInt32(&this.yielding) == 1 {
+        return true
+    }
+
+    successor := this.successor()
+    if successor == 0 {
+        return false
+    }
+    if atomic.CompareAndSwapInt32(&this.yielding, 0, 1) {
+        flowStats.Add("storageYields", 1)
+        this.journal.Record(this.roleId, journal.StorageHealth, "Storage degraded; yielding leadership to role %d and others healthy", successor)
+        fmt.Println("[ PROPOSER", this.roleId, "] Storage degraded; yielding leadership to healthy members")
+    }
+    return true
+}
+
+// Returns a voter other than an arbiter, with healthy storage and heard from within an election
+// timeout, which may take leadership from this node; zero if there is none
+func (this *ProposerRole) successor() uint64 {
+    now := this.clock.Now()
+    for _, state := range this.peers.FollowerStates() {
+        if state.RoleId == this.arbiterId || !this.peers.IsVoter(state.RoleId) || state.StorageDegraded || now.Sub(state.Received) > this.peerTimeouts(state.RoleId).Election { continue }
+        return state.RoleId
+    }
+    return 0
+}
+
+// Acts on a peer's refusal of a request: catches up through the membership epoch of a peer
+// which refused a stale one, and notes the wait asked for by an acceptor shedding load, so the
+// next round is not sent before it
+func (this *ProposerRole) observeRefusal(roleId uint64, err error) {
+    if epoch, stale := clusterpeers.ParseStaleEpoch(err); stale {
+        this.catchUpEpoch(roleId, epoch)
+        return
+    }
+    after, shed := acceptor.ParseRetryAfter(err)
+    if !shed { return }
+    flowStats.Add("acceptorShed", 1)
+    until := this.clock.Now().Add(after).UnixNano()
+    for {
+        current := atomic.LoadInt64(&this.shedUntil)
+        if current >= until || atomic.CompareAndSwapInt64(&this.shedUntil, current, until) { return }
+    }
+}
+
+// Waits out the longest wait acceptors shedding load have asked for
+func (this *ProposerRole) awaitShedding() {
+    wait := time.Duration(atomic.LoadInt64(&this.shedUntil) - this.clock.Now().UnixNano())
+    if wait > 0 {
+        this.clock.Sleep(wait)
+    }
+}
+
+// Reports whether an error, possibly received over RPC, rejected a proposal for degraded storage
+func IsStorageDegraded(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrStorageDegraded.Error())
+}
@@ -0,0 +1,90 @@
+package proposer
+
+import (
+    "fmt"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Marks the leader hint within a not-leader error message
+const leaderHintMarker = "; leader is "
+
+// Rejection of a client request by a role which is not leader, naming the role it believes
+// is leader. The hint survives transmission as an RPC error string; see ParseLeaderHint
+type NotLeaderError struct {
+    RoleId uint64
+    LeaderId uint64
+    Address string
+}
+
+func (this *NotLeaderError) Error() string {
+    message := fmt.Sprintf("[ PROPOSER %d ] Failure: not cluster leader", this.RoleId)
+    if this.LeaderId == 0 || len(this.Address) == 0 {
+        return message
+    }
+    return fmt.Sprintf("%s%s%d at %s", message, leaderHintMarker, this.LeaderId, this.Address)
+}
+
+// Extracts the believed leader from an error returned by a role which is not leader
+func ParseLeaderHint(err error) (uint64, string, bool) {
+    if err == nil { return 0, "", false }
+    message := err.Error()
+    separator := strings.Index(message, leaderHintMarker)
+    if separator < 0 { return 0, "", false }
+
+    var leaderId uint64
+    var address string
+    _, scanErr := fmt.Sscanf(message[separator+len(leaderHintMarker):], "%d at %s", &leaderId, &address)
+    if scanErr != nil { return 0, "", false }
+    return leaderId, address, true
+}
+
+// Returns the role believed to be leader from recent heartbeats, and its peer address
+func (this *ProposerRole) GetLeader() (uint64, string) {
+    leaderId := atomic.LoadUint64(&this.leaderId)
+    if leaderId == 0 { return 0, "" }
+    return leaderId, this.peers.GetPeerAddress(leaderId)
+}
+
+// Returns the addresses of the members of the cluster
+func (this *ProposerRole) GetMembership() map[uint64]string {
+    return this.peers.GetMembership()
+}
+
+// Returns the members demoted to learners
+func (this *ProposerRole) GetLearners() []uint64 {
+    return this.peers.GetLearners()
+}
+
+// Returns the links between members found to carry traffic in one direction only
+func (this *ProposerRole) GetAsymmetricLinks() []clusterpeers.AsymmetricLink {
+    return this.peers.AsymmetricLinks()
+}
+
+// Reports whether this proposer believes itself leader
+func (this *ProposerRole) IsLeader() bool {
+    return atomic.LoadUint64(&this.leaderId) == this.roleId
+}
+
+// Returns the rejection a role which is not leader gives clients, or nil if it is leader
+func (this *ProposerRole) RequireLeader() error {
+    if this.IsLeader() { return nil }
+    return this.notLeader()
+}
+
+// Returns the roleId of this proposer
+func (this *ProposerRole) GetRoleId() uint64 {
+    return this.roleId
+}
+
+// Builds the rejection returned to clients while not leader
+func (this *ProposerRole) notLeader() error {
+    leaderId, address := this.GetLeader()
+    return &NotLeaderError{this.roleId, leaderId, address}
+}
+
+// Reports whether an error was returned by a role refusing a request because it is not leader
+func IsNotLeader(err error) bool {
+    return err != nil && strings.Contains(err.Error(), "Failure: not cluster leader")
+}
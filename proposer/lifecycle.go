@@ -0,0 +1,162 @@
+package proposer
+
+import (
+    "fmt"
+    "errors"
+    "context"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/journal"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Stage of a node between launch and serving clients; a node only moves forward
+type NodeState int32
+
+const (
+    // Constructed, but not yet running
+    Starting NodeState = iota
+    // Applying the entries recovered from disk
+    Recovering
+    // Learning the values chosen while it was away from the leader
+    CatchingUp
+    // Caught up with the cluster and answering clients
+    Serving
+)
+
+func (this NodeState) String() string {
+    switch this {
+    case Starting:
+        return "starting"
+    case Recovering:
+        return "recovering"
+    case CatchingUp:
+        return "catching up"
+    }
+    return "serving"
+}
+
+// Rejection of a client request by a node which has not caught up with the cluster since it
+// started; answering it could expose state older than the client has already seen
+var ErrNotReady = errors.New("Failure: node is not ready")
+
+// Returns the node's stage since launch
+func (this *ProposerRole) GetState() NodeState {
+    return NodeState(atomic.LoadInt32(&this.state))
+}
+
+// Refuses client requests until the node is serving
+func (this *ProposerRole) CheckReady() error {
+    state := this.GetState()
+    if state == Serving { return nil }
+    flowStats.Add("notReady", 1)
+    return fmt.Errorf("[ PROPOSER %d ] %v; %v", this.roleId, ErrNotReady, state)
+}
+
+// Reports whether an error, possibly received over RPC, refused a request on a node not ready
+func IsNotReady(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrNotReady.Error())
+}
+
+// Moves the node to the given stage
+func (this *ProposerRole) advanceState(state NodeState) {
+    atomic.StoreInt32(&this.state, int32(state))
+    this.journal.Record(this.roleId, journal.StateChanged, "Node is %v", state)
+    fmt.Println("[ PROPOSER", this.roleId, "] Node is", state)
+}
+
+// Takes the node from Recovering to Serving: it first applies every entry recovered from disk,
+// then waits for a leader and closes any gap with the cluster, then applies what it learned
+func (this *ProposerRole) runLifecycle(recovered int) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.runLifecycle", nil)
+    this.advanceState(Recovering)
+    this.log.WaitForIndex(context.Background(), recovered)
+
+    this.advanceState(CatchingUp)
+    for !this.caughtUp() {
+        this.clock.Sleep(this.timeouts.Get().Heartbeat)
+    }
+    this.log.WaitForIndex(context.Background(), this.log.GetCommitIndex())
+    this.advanceState(Serving)
+}
+
+// Reports whether the gap with the cluster is closed: a follower must have fetched every value
+// the leader has chosen, and a leader every value a quorum of voters, itself included, has
+// chosen. Sources which cannot serve fetches are trusted to push missing values
+func (this *ProposerRole) caughtUp() bool {
+    leaderId, _ := this.GetLeader()
+    if leaderId == 0 {
+        return false
+    }
+    sources := []uint64{leaderId}
+    var closed, needed uint64 = 0, 1
+    if leaderId == this.roleId {
+        sources = nil
+        for roleId := range this.peers.GetMembership() {
+            if roleId != this.roleId && this.peers.IsVoter(roleId) {
+                sources = append(sources, roleId)
+            }
+        }
+        closed, needed = 1, this.peers.GetQuorumSize()
+    }
+    for _, roleId := range sources {
+        if closed >= needed { break }
+        if !this.peers.IsConnected(roleId) { continue }
+        if !this.peers.PeerSupports(roleId, clusterpeers.FeatureFetchEntries) || this.fetchAll(roleId) {
+            closed++
+        }
+    }
+    return closed >= needed
+}
+
+// Fetches chosen values from the given role in batches, returning true once it has none this
+// node lacks
+func (this *ProposerRole) fetchAll(roleId uint64) bool {
+    for {
+        response, err := this.peers.FetchEntries(roleId, this.log.GetFirstUnchosenIndex(), this.catchUp.getBatchSize())
+        if err != nil { return false }
+        if len(response.Values) == 0 {
+            return true
+        }
+        if !this.learnFetched(response) { return false }
+    }
+}
+
+// Reports why the node should be withheld from client traffic, or nil if it is ready: it must
+// be serving and not draining, and in contact with a quorum of voters. A leader must have heard
+// from a quorum of voters, itself included, within an election timeout; a follower must have
+// heard from the leader within an election timeout and applied what the leader last reported chosen
+func (this *ProposerRole) CheckReadiness() error {
+    if state := this.GetState(); state != Serving {
+        return fmt.Errorf("Node is %v", state)
+    } else if this.IsDraining() {
+        return fmt.Errorf("Node is draining")
+    }
+
+    now := this.clock.Now()
+    leaderId, _ := this.GetLeader()
+    if leaderId == 0 {
+        return fmt.Errorf("No leader is known")
+    } else if leaderId == this.roleId {
+        heard := uint64(1)
+        for _, state := range this.peers.FollowerStates() {
+            if this.peers.IsVoter(state.RoleId) && now.Sub(state.Received) <= this.peerTimeouts(state.RoleId).Election {
+                heard++
+            }
+        }
+        if quorum := this.peers.GetQuorumSize(); heard < quorum {
+            return fmt.Errorf("Heard from %d voters of a quorum of %d", heard, quorum)
+        }
+        return nil
+    }
+
+    if election := this.peerTimeouts(leaderId).Election; now.Sub(this.lastLeaderContact()) > election {
+        return fmt.Errorf("Leader %d not heard from within %v", leaderId, election)
+    }
+    applied, progress := this.log.GetAppliedIndex(), atomic.LoadInt64(&this.leaderProgress)
+    if int64(applied+1) < progress {
+        return fmt.Errorf("Applied through %d of %d chosen by leader %d", applied, progress-1, leaderId)
+    }
+    return nil
+}
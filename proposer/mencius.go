@@ -0,0 +1,233 @@
+package proposer
+
+import (
+    "fmt"
+    "time"
+    "sync"
+    "bytes"
+    "context"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+    "github/paxoscluster/proposal"
+)
+
+var menciusStats = metrics.Group("mencius")
+
+// Slots of this node in Mencius mode. Every member proposes in its own slots under its owned
+// proposal, skipping those which others have passed with no-ops; other members revoke a slot
+// which stays unchosen, choosing whatever its owner may have had accepted there, or a no-op
+type mencius struct {
+    roleId uint64
+    owners *proposal.Owners
+    revoke time.Duration
+    next int
+    highest int
+    stalled stall
+    wake chan bool
+    exclude sync.Mutex
+}
+
+// Constructor for mencius; slots before next, the length of the recovered log, may already
+// have been claimed, as the local acceptor accepts every claim first
+func constructMencius(roleId uint64, owners *proposal.Owners, settings config.MenciusConfig, next int) *mencius {
+    newMencius := mencius {
+        roleId: roleId,
+        owners: owners,
+        revoke: settings.Revoke,
+        next: next,
+        highest: -1,
+        stalled: stall{index: -1},
+        wake: make(chan bool, 1),
+    }
+    return &newMencius
+}
+
+// Claims this node's first unused slot at or after from
+func (this *mencius) claim(from int) int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if from < this.next {
+        from = this.next
+    }
+    index := this.owners.Next(this.roleId, from)
+    this.next = index+1
+    return index
+}
+
+// Claims every unused slot of this node below the highest slot proposed by any member
+func (this *mencius) skips() []int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    var indices []int = nil
+    for index := this.owners.Next(this.roleId, this.next); index < this.highest; index = this.owners.Next(this.roleId, index+1) {
+        indices = append(indices, index)
+        this.next = index+1
+    }
+    return indices
+}
+
+// Records a slot proposed by its owner, waking the loop if this node has slots to skip
+func (this *mencius) observe(index int) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if index <= this.highest { return }
+    this.highest = index
+    if this.owners.Next(this.roleId, this.next) < index {
+        select {
+        case this.wake <- true:
+        default:
+        }
+    }
+}
+
+// Moves past slots which were revoked from this node
+func (this *mencius) passRevoked(revokedThrough int) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.next <= revokedThrough {
+        this.next = revokedThrough+1
+    }
+}
+
+// Returns how long the first unchosen slot has held up later slots
+func (this *mencius) stalledFor(first int, now time.Time) time.Duration {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.stalled.measure(first, this.highest > first, now)
+}
+
+// Returns the slot ownership in Mencius mode, or nil if disabled
+func (this *ProposerRole) GetOwners() *proposal.Owners {
+    if this.mencius == nil { return nil }
+    return this.mencius.owners
+}
+
+// Records a slot accepted from its owner, so this node can skip its own slots behind it
+func (this *ProposerRole) ObserveClaim(roleId uint64, index int) {
+    if this.mencius == nil { return }
+    this.mencius.observe(index)
+}
+
+// Proposes a value in this node's next free slot, moving to a later slot if one is revoked
+// before the value is chosen there
+func (this *ProposerRole) proposeOwned(value []byte) error {
+    for {
+        index := this.mencius.claim(this.log.GetFirstUnchosenIndex())
+        entries := []acceptor.OwnedEntry{{Index: index, Value: value}}
+        chosen, refused := this.chooseOwned(entries)
+        for failures := 1; !chosen && !refused; failures++ {
+            err := this.getTunables().retry.backoff(failures)
+            if err != nil { return err }
+            chosen, refused = this.chooseOwned(entries)
+        }
+        if chosen {
+            menciusStats.Add("owned", 1)
+            return nil
+        }
+
+        // If any acceptor holds the value, the revoking proposer may have chosen it
+        held, err := this.log.GetEntryAt(index)
+        if err != nil { return err }
+        if held.AcceptedProposalId != proposal.OwnedId(this.roleId) {
+            continue
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), time.Duration(this.mencius.owners.Count()+1)*this.mencius.revoke)
+        err = this.log.WaitForIndex(ctx, index)
+        cancel()
+        if err != nil {
+            return fmt.Errorf("[ PROPOSER %d ] Failure: entry %d was revoked", this.roleId, index)
+        }
+        chosenEntry, err := this.log.GetEntryAt(index)
+        if err != nil { return err }
+        if bytes.Equal(chosenEntry.Value, value) {
+            menciusStats.Add("owned", 1)
+            return nil
+        }
+    }
+}
+
+// Proposes values in this node's own slots without a prepare phase. Reports whether a quorum
+// accepted them all, so they are chosen, or whether any acceptor refused them
+func (this *ProposerRole) chooseOwned(entries []acceptor.OwnedEntry) (bool, bool) {
+    request := acceptor.OwnedReq {
+        RoleId: this.roleId,
+        Entries: entries,
+    }
+    peerCount, endpoint := this.peers.BroadcastOwned(request)
+    tracker := this.peers.TrackQuorum(proposal.Default(), peerCount)
+    refused := false
+
+    state := clusterpeers.Await(tracker, endpoint, this.clock.After(this.roundTimeouts().Rpc), func(reply clusterpeers.OwnedResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            this.observeRefusal(reply.RoleId, reply.Error)
+            return tracker.Fail(reply.RoleId, reply.Error)
+        } else if !reply.Owned.AcceptedAll(len(entries)) {
+            refused = true
+            this.mencius.passRevoked(reply.Owned.RevokedThrough)
+            return tracker.Reject(reply.RoleId)
+        }
+        return tracker.Vote(reply.RoleId)
+    })
+    if state != clusterpeers.QuorumReached {
+        return false, refused
+    }
+
+    for _, entry := range entries {
+        this.learn(entry.Index, entry.Value)
+        this.announce(entry.Index, entry.Value)
+    }
+    return true, false
+}
+
+// Skips this node's slots which others have passed, and revokes a slot holding up the log.
+// Each member waits longer the further it follows the slot's owner, so the owner's successor
+// normally revokes alone
+func (this *ProposerRole) runMencius() {
+    for {
+        select {
+        case <- this.mencius.wake:
+        case <- this.clock.After(this.timeouts.Get().Heartbeat):
+        }
+
+        indices := this.mencius.skips()
+        if len(indices) != 0 {
+            fmt.Println("[ PROPOSER", this.roleId, "] Skipping entries", indices)
+            entries := make([]acceptor.OwnedEntry, 0, len(indices))
+            for _, index := range indices {
+                entries = append(entries, acceptor.OwnedEntry{Index: index})
+            }
+            chosen, _ := this.chooseOwned(entries)
+            if chosen {
+                menciusStats.Add("skipped", int64(len(entries)))
+            }
+        }
+
+        // A slot may be chosen already, with its notification lost, so it is first fetched
+        // from its owner
+        first := this.log.GetFirstUnchosenIndex()
+        owner := this.mencius.owners.Owner(first)
+        stalled := this.mencius.stalledFor(first, this.clock.Now())
+        if stalled >= this.mencius.revoke && owner != this.roleId && this.fetchBatch(owner, first, this.catchUp.getBatchSize()) {
+            continue
+        }
+        // A revoking round chooses the value the owner may have had accepted, or a no-op
+        distance := this.mencius.owners.Distance(first, this.roleId)
+        if stalled >= time.Duration(1+distance)*this.mencius.revoke {
+            fmt.Println("[ PROPOSER", this.roleId, "] Revoking entry", first, "from role", owner)
+            _, chosen, err := this.chooseSlot(first, nil)
+            if err != nil {
+                fmt.Println("[ PROPOSER", this.roleId, "] Failed to revoke entry", first, ":", err)
+            }
+            if chosen {
+                menciusStats.Add("revoked", 1)
+            }
+        }
+    }
+}
@@ -0,0 +1,131 @@
+package proposer
+
+import (
+    "fmt"
+    "bytes"
+    "context"
+    "strings"
+    "sync/atomic"
+    "encoding/json"
+    "github/paxoscluster/hooks"
+)
+
+// Marks the log entry sealing a group which migrated to other members; no client value is
+// chosen after it
+var sealMarker = []byte("\x00sealed ")
+
+// Builds the value sealing the log of a group migrating to the given members
+func SealValue(members map[uint64]string) []byte {
+    encoded, _ := json.Marshal(members)
+    return append(append([]byte{}, sealMarker...), encoded...)
+}
+
+// Returns the members named by a sealing value
+func ParseSeal(value []byte) (map[uint64]string, bool) {
+    if !bytes.HasPrefix(value, sealMarker) {
+        return nil, false
+    }
+    var members map[uint64]string
+    err := json.Unmarshal(value[len(sealMarker):], &members)
+    if err != nil || len(members) == 0 {
+        return nil, false
+    }
+    return members, true
+}
+
+// Rejection of a client request by a group which has migrated, or is migrating, to other
+// members; clients should direct requests to those members
+type MigratedError struct {
+    RoleId uint64
+    Members map[uint64]string
+}
+
+func (this *MigratedError) Error() string {
+    return fmt.Sprintf("[ PROPOSER %d ] Failure: group migrated to %v", this.RoleId, this.Members)
+}
+
+// Reports whether an error was returned by a group which has migrated to other members
+func IsMigrated(err error) bool {
+    return err != nil && strings.Contains(err.Error(), "Failure: group migrated")
+}
+
+// Returns the members this group migrated to, or nil if it has not. A seal naming the
+// current membership is the one the group was launched after, and is ignored
+func (this *ProposerRole) GetMigration() map[uint64]string {
+    members, _ := this.migratedTo.Load().(map[uint64]string)
+    return members
+}
+
+// Records a seal applied from the log
+func (this *ProposerRole) observeSeal(entry hooks.Entry) {
+    members, sealed := ParseSeal(entry.Value)
+    if !sealed || this.isMembership(members) { return }
+    if this.GetMigration() == nil {
+        fmt.Println("[ PROPOSER", this.roleId, "] Group sealed at entry", entry.Index, "; migrated to", members)
+    }
+    this.migratedTo.Store(members)
+}
+
+// Finds a seal among the entries recovered before the proposer was constructed; only empty
+// values may follow it
+func (this *ProposerRole) recoverSeal() {
+    for index := this.log.GetCommitIndex(); index >= 0; index-- {
+        entries, err := this.log.ReadEntries(index, index+1, true)
+        if err != nil { return }
+        entry := this.log.SplitEntry(entries[0])
+        if len(entry.Value) != 0 {
+            this.observeSeal(hooks.Entry{Index: entry.Index, Value: entry.Value})
+            return
+        }
+    }
+}
+
+// Reports whether members are exactly the current members of the cluster
+func (this *ProposerRole) isMembership(members map[uint64]string) bool {
+    current := this.peers.GetMembership()
+    if len(current) != len(members) { return false }
+    for roleId := range members {
+        if _, exists := current[roleId]; !exists { return false }
+    }
+    return true
+}
+
+// Refuses client values once the group is sealing or sealed; otherwise counts the value as
+// proposing until done is called
+func (this *ProposerRole) admitUnsealed() (func(), error) {
+    atomic.AddInt64(&this.proposing, 1)
+    done := func() { atomic.AddInt64(&this.proposing, -1) }
+    if members := this.GetMigration(); members != nil {
+        done()
+        return nil, &MigratedError{this.roleId, members}
+    }
+    if members, sealing := this.sealing.Load().(map[uint64]string); sealing {
+        done()
+        return nil, &MigratedError{this.roleId, members}
+    }
+    return done, nil
+}
+
+// Chooses the seal ending this group's log, after which it refuses client values. Values
+// admitted before sealing began are chosen ahead of the seal. Only a leader may seal, and
+// only outside Mencius mode and fast rounds, where other members propose concurrently
+func (this *ProposerRole) Seal(ctx context.Context, members map[uint64]string) error {
+    if this.mencius != nil || this.fast != nil {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: migration requires a leader; disable Mencius mode and fast rounds", this.roleId)
+    }
+    err := this.RequireLeader()
+    if err != nil { return err }
+    if len(members) == 0 {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: migration requires members", this.roleId)
+    }
+
+    this.sealing.Store(members)
+    for atomic.LoadInt64(&this.proposing) != 0 {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        this.clock.Sleep(this.timeouts.Get().Heartbeat/10)
+    }
+    fmt.Println("[ PROPOSER", this.roleId, "] Sealing group for migration to", members)
+    return this.replicate(context.Background(), SealValue(members))
+}
@@ -0,0 +1,103 @@
+package proposer
+
+import (
+    "fmt"
+    "sync"
+    "context"
+    "time"
+    "github/paxoscluster/clock"
+)
+
+// Class of a proposal, each limited to its own rate
+type Priority int
+
+const (
+    // Client writes; rejected with ErrBackpressure if over their rate for longer than the
+    // admission wait
+    Interactive Priority = iota
+    // Maintenance traffic such as compaction markers; delayed as long as necessary
+    Background
+    priorityCount
+)
+
+func (this Priority) String() string {
+    switch this {
+    case Interactive:
+        return "interactive"
+    case Background:
+        return "background"
+    }
+    return fmt.Sprintf("Priority(%d)", int(this))
+}
+
+// Waits for the proposal's class to admit it under its rate limit, or for the context to be cancelled
+func (this *ProposerRole) limit(ctx context.Context, priority Priority) error {
+    if priority < 0 || priority >= priorityCount {
+        return fmt.Errorf("Unknown priority %d", int(priority))
+    }
+    settings := this.getTunables()
+    wait := settings.admissionWait
+    if priority == Background {
+        wait = -1
+    }
+    err := settings.rates[priority].take(ctx, wait)
+    if err != nil {
+        flowStats.Add(priority.String() + "RateLimited", 1)
+    }
+    return err
+}
+
+// Token bucket refilled at a fixed rate, holding at most one second of tokens; a nil
+// bucket admits everything
+type tokenBucket struct {
+    rate float64
+    tokens float64
+    refilled time.Time
+    clock clock.Clock
+    exclude sync.Mutex
+}
+
+func constructTokenBucket(perSecond uint64, source clock.Clock) *tokenBucket {
+    if perSecond == 0 { return nil }
+
+    newTokenBucket := tokenBucket {
+        rate: float64(perSecond),
+        tokens: float64(perSecond),
+        refilled: source.Now(),
+        clock: source,
+    }
+    return &newTokenBucket
+}
+
+// Takes a token, waiting for one to accrue; a negative wait waits indefinitely, otherwise
+// fails with ErrBackpressure if no token accrues in time. Cancelling the context ends the wait
+func (this *tokenBucket) take(ctx context.Context, wait time.Duration) error {
+    if this == nil { return nil }
+    deadline := this.clock.Now().Add(wait)
+
+    for {
+        this.exclude.Lock()
+        now := this.clock.Now()
+        this.tokens += now.Sub(this.refilled).Seconds() * this.rate
+        if this.tokens > this.rate {
+            this.tokens = this.rate
+        }
+        this.refilled = now
+        if this.tokens >= 1 {
+            this.tokens--
+            this.exclude.Unlock()
+            return nil
+        }
+        shortfall := time.Duration((1 - this.tokens) / this.rate * float64(time.Second))
+        this.exclude.Unlock()
+
+        if wait >= 0 && now.Add(shortfall).After(deadline) {
+            return ErrBackpressure
+        }
+        select {
+        case <- this.clock.After(shortfall):
+        case <- ctx.Done():
+            return ctx.Err()
+        }
+    }
+}
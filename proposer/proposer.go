@@ -2,11 +2,23 @@ package proposer
 
 import (
     "fmt"
+    "sync"
+    "context"
     "time"
+    "sync/atomic"
+    "crypto/rand"
+    "encoding/binary"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/codec"
+    "github/paxoscluster/config"
+    "github/paxoscluster/hooks"
     "github/paxoscluster/acceptor"
     "github/paxoscluster/proposal"
     "github/paxoscluster/clusterpeers"
     "github/paxoscluster/replicatedlog"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/journal"
 )
 
 type ProposerRole struct {
@@ -14,31 +26,140 @@ type ProposerRole struct {
     log *replicatedlog.Log
     peers *clusterpeers.Cluster
     proposals *proposal.Manager
+    timeouts *config.LiveTimeouts
+    chunkSize int
+    // Chunked values are identified by this launch of the proposer and a count within it, as
+    // the count starts again on restart
+    launch uint64
+    chunkCount uint64
+    codec codec.Codec
+    leaderId uint64
+    leaderSeen int64
+    leaderProgress int64
+    // Heartbeats received from voters
+    heartbeats uint64
+    clock clock.Clock
+    random clock.Rand
+    hlc *clock.Hybrid
+    events *hooks.Hooks
+    tracer *trace.Recorder
+    journal *journal.Journal
+    inFlight chan bool
+    // Admission wait, rate limits, and retry policy, replaced when settings are reloaded
+    tunables atomic.Value
+    catchUp *catchUp
+    contention *contention
+    mencius *mencius
+    fast *fastRounds
+    claimed map[int]bool
+    proposing int64
+    // Rounds of this proposer awaiting promises and accepts
+    preparing int64
+    accepting int64
+    sealing atomic.Value
+    migratedTo atomic.Value
+    storageHealth func() (time.Duration, bool)
+    // Member which only votes, and never takes leadership; zero if none
+    arbiterId uint64
+    latency commitLatency
+    yielding int32
+    // Time, in nanoseconds, before which acceptors shedding load asked not to be sent another round
+    shedUntil int64
+    // Set while a role change is being chosen; changes are made one at a time
+    changingRole int32
+    // Set once the node is drained for shutdown
+    draining int32
+    // Set while the node is in read-only mode for maintenance
+    readOnly int32
+    // Stage since launch, a NodeState
+    state int32
     client chan ClientRequest
     heartbeat chan uint64
     terminator chan bool
+    exclude sync.Mutex
 }
 
 // Constructor for ProposerRole
-func Construct(roleId uint64, log *replicatedlog.Log, peers *clusterpeers.Cluster) *ProposerRole {
+func Construct(roleId uint64, log *replicatedlog.Log, peers *clusterpeers.Cluster, settings *config.Config,
+               events *hooks.Hooks, counters proposal.CounterStore, proposalCount int64) (*ProposerRole, error) {
+    commandCodec, err := codec.Lookup(settings.Codec.Name)
+    if err != nil { return nil, err }
+    proposals, err := proposal.ConstructManager(roleId, proposalCount, counters)
+    if err != nil { return nil, err }
+
     newProposerRole := ProposerRole {
         roleId: roleId,
         log: log,
         peers: peers,
-        proposals: proposal.ConstructManager(roleId),    
+        proposals: proposals,
+        timeouts: config.ConstructLiveTimeouts(settings.Timeouts),
+        chunkSize: int(settings.Chunking.Size),
+        launch: newLaunch(),
+        codec: commandCodec,
+        clock: clock.OrReal(settings.Clock),
+        random: clock.OrRandom(settings.Rand),
+        hlc: clock.NewHybrid(settings.Clock),
+        arbiterId: settings.Arbiter.RoleId,
+        events: events,
+        claimed: make(map[int]bool),
         client: make(chan ClientRequest),
         heartbeat: make(chan uint64),
         terminator: make(chan bool),
     }
-    return &newProposerRole
+    newProposerRole.tunables.Store(newProposerRole.constructTunables(settings))
+    newProposerRole.catchUp = constructCatchUp(settings.CatchUp, newProposerRole.clock)
+    newProposerRole.contention = constructContention(newProposerRole.random)
+    members := make([]uint64, 0)
+    for member := range peers.GetMembership() {
+        members = append(members, member)
+    }
+    owners := proposal.ConstructOwners(members)
+    if settings.Mencius.Enabled {
+        newProposerRole.mencius = constructMencius(roleId, owners, settings.Mencius, log.GetLength())
+    }
+    if settings.Fast.Enabled {
+        newProposerRole.fast = constructFastRounds(settings.Fast, owners.Distance(0, roleId))
+    }
+    if settings.Flow.MaxInFlight != 0 {
+        newProposerRole.inFlight = make(chan bool, settings.Flow.MaxInFlight)
+    }
+    newProposerRole.recoverSeal()
+    if events != nil {
+        events.OnApplyEntry(newProposerRole.observeSeal)
+    }
+    return &newProposerRole, nil
+}
+
+// Returns a random identifier for this launch of the proposer, distinct from those before it
+func newLaunch() uint64 {
+    var launch [8]byte
+    rand.Read(launch[:])
+    return binary.BigEndian.Uint64(launch[:])
+}
+
+// Records values this proposer learns chosen by its own rounds; must be set before Run
+func (this *ProposerRole) SetTracer(tracer *trace.Recorder) {
+    this.tracer = tracer
+}
+
+// Records significant events, such as elections and rejected rounds; must be set before Run
+func (this *ProposerRole) SetJournal(events *journal.Journal) {
+    this.journal = events
 }
 
 // Starts proposer role state machine
 func Run(this *ProposerRole) {
+    go this.runLifecycle(this.log.GetCommitIndex())
     isLeaderStateChannel := make(chan bool)
     isNotLeaderStateChannel := make(chan bool)
     go this.isNotLeaderState(isLeaderStateChannel, isNotLeaderStateChannel)
     go this.isLeaderState(isNotLeaderStateChannel, isLeaderStateChannel)
+    if this.mencius != nil {
+        go this.runMencius()
+    }
+    if this.fast != nil {
+        go this.runFastRounds()
+    }
 }
 
 // Role is not leader; will reject client requests
@@ -54,20 +175,32 @@ func (this *ProposerRole) isNotLeaderState(trans chan<- bool, self <-chan bool)
             <- self
             startElection <- true
         case request := <- this.client:
-            request.reply <- fmt.Errorf("[ PROPOSER %d ] Failure: not cluster leader", this.roleId)
+            request.reply <- this.notLeader()
         case <- this.terminator:
             return
         }
     }
 }
 
-// Elects self leader if not receiving heartbeat signal from role with higher ID
+// Elects self leader if not receiving heartbeat signal from a higher ranked role within the
+// election timeout of the believed leader, longer if it is across a WAN; learners and draining
+// or read-only roles never stand, and a role waits a heartbeat longer for each voter of higher
+// priority, so the preferred reachable role claims leadership first
 func (this *ProposerRole) electLeader(startElection <-chan bool, electionNotify chan<- bool) {
     for {
+        deferral := time.Duration(this.peers.CountPreferred(this.roleId))*this.timeouts.Get().Heartbeat
+        leaderId, _ := this.GetLeader()
+        election := this.peerTimeouts(leaderId).Election
         select {
-        case <- this.heartbeat:
+        case leaderId := <- this.heartbeat:
+            this.observeLeader(leaderId)
             continue
-        case <- time.After(2*time.Second):
+        case <- this.clock.After(election+deferral):
+            if !this.peers.IsVoter(this.roleId) || this.IsDraining() || this.IsReadOnly() {
+                continue
+            }
+            this.journal.Record(this.roleId, journal.ElectionStarted, "No heartbeat from a higher role within %v", election)
+            this.observeLeader(this.roleId)
             electionNotify <- true
             <- startElection
         }
@@ -80,138 +213,249 @@ func (this *ProposerRole) isLeaderState(trans chan<- bool, self <-chan bool) {
 
     for {
         select {
-        case <- this.heartbeat:
+        case leaderId := <- this.heartbeat:
+            this.observeLeader(leaderId)
             trans <- true
             <- self
         case request := <- this.client:
-            fmt.Println("[ PROPOSER", this.roleId, "] Initiating paxos for client request", request.value)
-            go func () { request.reply <- this.paxos(request.value) }()
+            if request.ctx.Err() != nil {
+                // Cancelled while queued; never proposed
+                request.reply <- request.ctx.Err()
+                continue
+            }
+            fmt.Println("[ PROPOSER", this.roleId, "] Initiating paxos for client request", string(request.value))
+            go func () {
+                // A failed round is reported to its client rather than crashing the node
+                var err error
+                defer func() { request.reply <- err }()
+                defer guard.Recover("PROPOSER", this.roleId, "replicate", &err)
+                err = this.replicate(request.ctx, request.value)
+            }()
         case <- this.terminator:
             return
         }
     }
 }
 
-// Executes single round of Paxos protocol
-func (this *ProposerRole) paxos(value string) error {
-    chosen := false
-    roleId := this.roleId
+// Returns the size values are split at, or zero, which splits none, until every voter has been
+// upgraded: nodes predating chunking would apply each chunk as a separate value
+func (this *ProposerRole) entryChunkSize() int {
+    if this.peers.GetUpgradeState() != clusterpeers.UpgradeAllNew {
+        return 0
+    }
+    return this.chunkSize
+}
 
-    for !chosen {
-        index := this.log.GetFirstUnchosenIndex()
-        proposalId := this.proposals.GetCurrentProposalId()
-        usingValue := value
+// Replicates a value, splitting values larger than the chunk size across consecutive log entries
+// once every node has been upgraded to understand chunks. Cancelling the context abandons the
+// value until its first chunk is chosen; the rest must then follow, or it would stay incomplete
+func (this *ProposerRole) replicate(ctx context.Context, value []byte) error {
+    valueId := fmt.Sprintf("%d.%x.%d", this.roleId, this.launch, atomic.AddUint64(&this.chunkCount, 1))
+    chunks := replicatedlog.SplitValue(value, valueId, this.entryChunkSize())
+    if len(chunks) > 1 {
+        fmt.Println("[ PROPOSER", this.roleId, "] Splitting value into", len(chunks), "chunks")
+    }
 
-        // Prepare phase
-        fmt.Println("[ PROPOSER", roleId, "] Executing prepare phase of protocol for value", usingValue)
-        request := acceptor.PrepareReq {
-            ProposalId: proposalId, 
-            Index: index,
+    for number, chunk := range chunks {
+        if number == 1 {
+            ctx = context.Background()
         }
-        peerCount, endpoint := this.peers.BroadcastPrepareRequest(request)
-        success, changed, changedValue, err := this.recvPromises(peerCount, endpoint)
+        err := this.paxos(ctx, chunk)
         if err != nil { return err }
+    }
+    return nil
+}
 
-        if success {
-            if changed {
-                usingValue = changedValue
-                fmt.Println("[ PROPOSER", roleId, "] Value", value, "superseded by value", changedValue)
-            }
-
-            // Proposal phase
-            fmt.Println("[ PROPOSER", roleId, "] Executing proposal phase of protocol for value", usingValue)
-            request := acceptor.ProposalReq {
-                ProposalId: proposalId, 
-                Index: index, 
-                Value: usingValue, 
-                FirstUnchosenIndex: this.log.GetFirstUnchosenIndex(),
-            }
-            peerCount, endpoint := this.peers.BroadcastProposalRequest(request, nil)
-            success, err = this.recvAccepts(request, peerCount, endpoint)
+// Executes Paxos until the value is chosen, backing off between rounds which fail to reach a
+// quorum. Each round claims its own entry, so concurrent proposals never share one. Once the
+// context is cancelled no further round is started; a value accepted by an earlier round may
+// still be chosen by a later proposer
+func (this *ProposerRole) paxos(ctx context.Context, value []byte) error {
+    failures := 0
+    for {
+        if ctx.Err() != nil {
+            flowStats.Add("abandoned", 1)
+            return ctx.Err()
+        }
+        index := this.claimIndex()
+        chosen, failed, err := this.round(ctx, index, value)
+        this.releaseIndex(index)
+        if err != nil { return err }
+        if chosen {
+            this.resolveContention()
+            break
+        }
+        if failed {
+            failures++
+            fmt.Println("[ PROPOSER", this.roleId, "] Retrying after", failures, "failed rounds for", string(value))
+            this.awaitShedding()
+            this.awaitContention()
+            err = this.getTunables().retry.backoff(failures)
             if err != nil { return err }
-
-            if success {
-                fmt.Println("[ PROPOSER", roleId, "] Success; chose", usingValue, "for log entry", index)
-                this.log.SetEntryAt(index, usingValue, proposal.Chosen())
-                chosen = !changed
-            } else {
-                this.proposals.GenerateNextProposalId()
-            }
-        } else {
-            fmt.Println("[ PROPOSER", roleId, "] Retrying prepare phase for", usingValue)
-            this.proposals.GenerateNextProposalId()
         }
     }
 
-    fmt.Println("[ PROPOSER", roleId, "] Paxos protocol execution complete for client request", value)
+    fmt.Println("[ PROPOSER", this.roleId, "] Paxos protocol execution complete for client request", string(value))
     return nil
 }
 
-// Receves replies to prepare requests
-func (this *ProposerRole) recvPromises(peerCount uint64, endpoint <-chan clusterpeers.Response) (bool, bool, string, error) {
-    success := false
-    changed := false
-    value := ""
-    majority := this.peers.GetPeerCount()/2+1
-    replyCount := uint64(0)
-    promiseCount := this.peers.GetSkipPromiseCount()
-    highestAccepted := proposal.Default()
+// Executes a single round of Paxos for an entry. Reports whether the value was chosen there,
+// or whether the round failed to reach a quorum; a round choosing a value already accepted in
+// the entry does neither, and the value must be proposed in another entry. Each phase waits at
+// most an RPC timeout, and no later than the context's deadline, which acceptors are told
+func (this *ProposerRole) round(ctx context.Context, index int, value []byte) (bool, bool, error) {
+    roleId := this.roleId
+    proposalId := this.proposals.GetCurrentProposalId()
+    usingValue := value
+
+    // Prepare phase
+    fmt.Println("[ PROPOSER", roleId, "] Executing prepare phase of protocol for value", string(usingValue))
+    timeout, err := this.phaseTimeout(ctx)
+    if err != nil { return false, false, err }
+    request := acceptor.PrepareReq {
+        ProposalId: proposalId, 
+        Index: index,
+        Timeout: timeout,
+    }
+    atomic.AddInt64(&this.preparing, 1)
+    peerCount, promised, promises := this.peers.BroadcastPrepare(request)
+    success, changed, changedValue, err := this.recvPromises(proposalId, peerCount, promised, promises, timeout)
+    atomic.AddInt64(&this.preparing, -1)
+    if err != nil { return false, false, err }
+    if !success {
+        return false, true, this.abandonProposal()
+    }
 
-    for promiseCount < majority && replyCount < peerCount {
-        var promise acceptor.PrepareResp
-        select {
-        case reply := <- endpoint:
-            promise = *reply.Data.(*acceptor.PrepareResp)
-            replyCount++
-        case <- time.After(time.Second):
-            return success, changed, value, nil
+    if changed {
+        usingValue = changedValue
+        fmt.Println("[ PROPOSER", roleId, "] Value", string(value), "superseded by value", string(changedValue))
+    }
+
+    // Proposal phase
+    fmt.Println("[ PROPOSER", roleId, "] Executing proposal phase of protocol for value", string(usingValue))
+    timeout, err = this.phaseTimeout(ctx)
+    if err != nil { return false, false, err }
+    proposalRequest := acceptor.ProposalReq {
+        ProposalId: proposalId, 
+        Index: index, 
+        Value: usingValue, 
+        FirstUnchosenIndex: this.log.GetFirstUnchosenIndex(),
+        Timeout: timeout,
+    }
+    atomic.AddInt64(&this.accepting, 1)
+    peerCount, accepts := this.peers.BroadcastAccept(proposalRequest, nil)
+    success, err = this.recvAccepts(proposalRequest, peerCount, accepts, timeout)
+    atomic.AddInt64(&this.accepting, -1)
+    if err != nil { return false, false, err }
+    if !success {
+        return false, true, this.abandonProposal()
+    }
+
+    fmt.Println("[ PROPOSER", roleId, "] Success; chose", string(usingValue), "for log entry", index)
+    learned := acceptor.SuccessNotify{Index: index, Value: usingValue, Checksum: replicatedlog.Checksum(usingValue)}
+    this.tracer.Trace(roleId, trace.LearnMethod, &learned, nil, func() error {
+        this.log.SetEntryAt(index, usingValue, proposal.Chosen())
+        return nil
+    })
+    return !changed, false, nil
+}
+
+// Claims the first entry neither chosen nor claimed by another round of this proposer
+func (this *ProposerRole) claimIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    index := this.log.GetFirstUnchosenIndex()
+    for {
+        // Only chosen values are read back from disk, so an entry which cannot be read is chosen
+        entry, err := this.log.GetEntryAt(index)
+        if !this.claimed[index] && err == nil && entry.AcceptedProposalId != proposal.Chosen() {
+            break
         }
+        index++
+    }
+    this.claimed[index] = true
+    return index
+}
 
-        if promise.PromiseAccepted {
-            promiseCount++
+// Frees an entry claimed by a round once it completes
+func (this *ProposerRole) releaseIndex(index int) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    delete(this.claimed, index)
+}
+
+// Moves to a greater proposal after a round fails; promises held for the abandoned proposal
+// do not cover the new one
+func (this *ProposerRole) abandonProposal() error {
+    _, err := this.proposals.GenerateNextProposalId()
+    this.peers.ResetPromises()
+    return err
+}
 
-            if promise.AcceptedProposalId.IsGreaterThan(highestAccepted) {
-                highestAccepted = promise.AcceptedProposalId
+// Receves replies to prepare requests for a proposal within the phase's timeout, counting the
+// promises already held
+func (this *ProposerRole) recvPromises(proposalId proposal.Id, peerCount uint64, promised uint64, endpoint <-chan clusterpeers.PromiseResult,
+                                       timeout time.Duration) (bool, bool, []byte, error) {
+    changed := false
+    var value []byte = nil
+    highestAccepted := proposal.Default()
+    tracker := this.peers.TrackQuorum(proposalId, peerCount)
+    tracker.AddVotes(promised)
+
+    state := clusterpeers.Await(tracker, endpoint, this.clock.After(timeout), func(reply clusterpeers.PromiseResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            this.observeRefusal(reply.RoleId, reply.Error)
+        } else if reply.Promise.PromiseAccepted {
+            if reply.Promise.AcceptedProposalId.IsGreaterThan(highestAccepted) {
+                highestAccepted = reply.Promise.AcceptedProposalId
                 changed = true
-                value = promise.AcceptedValue
+                value = reply.Promise.AcceptedValue
             } else {
-                this.peers.SetPromiseRequirement(promise.RoleId, !promise.NoMoreAccepted)
+                this.peers.RecordPromise(reply.Promise.RoleId, proposalId, reply.Promise.NoMoreAccepted)
             }
         }
+        return tracker.Promise(reply)
+    })
+
+    fmt.Println("[ PROPOSER", this.roleId, "] Processed", tracker.GetReplies(), "replies,", tracker.GetVotes(), "promises.")
+    success := state == clusterpeers.QuorumReached
+    if !success {
+        this.observePreemption(tracker.GetHigher())
+        this.journal.Record(this.roleId, journal.RoundRejected, "Prepare of proposal %v gathered %d of %d promises from %d replies",
+                            proposalId, tracker.GetVotes(), tracker.GetNeeded(), tracker.GetReplies())
     }
-
-    fmt.Println("[ PROPOSER", this.roleId, "] Processed", replyCount, "replies,", promiseCount, "promises.")
-    success = promiseCount >= majority
     return success, changed, value, nil
 }
 
-// Receves replies to proposal
-func (this *ProposerRole) recvAccepts(request acceptor.ProposalReq, peerCount uint64, endpoint <-chan clusterpeers.Response) (bool, error) {
-    majority := peerCount/2+1
-    acceptCount := uint64(0)
+// Receves replies to proposal within the phase's timeout
+func (this *ProposerRole) recvAccepts(request acceptor.ProposalReq, peerCount uint64, endpoint <-chan clusterpeers.AcceptResult,
+                                      timeout time.Duration) (bool, error) {
     received := make(map[uint64]bool)
-
-    for acceptCount < majority {
-        var response acceptor.ProposalResp
-        select {
-            case reply := <- endpoint:
-                response = *reply.Data.(*acceptor.ProposalResp)
-                received[response.RoleId] = true
-            case <- time.After(time.Second):
-                return false, nil
-        }
-
-        if request.ProposalId.IsGreaterThan(response.AcceptedId) ||
-            request.ProposalId == response.AcceptedId {
-            acceptCount++
-        } else {
-            this.peers.SetPromiseRequirement(response.RoleId, true)
-            return false, nil
+    tracker := this.peers.TrackQuorum(request.ProposalId, peerCount)
+    // Stragglers are read once a quorum accepts, to revoke promises and bring laggards up to date
+    tracker.KeepCollecting()
+
+    state := clusterpeers.Await(tracker, endpoint, this.clock.After(timeout), func(reply clusterpeers.AcceptResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            this.observeRefusal(reply.RoleId, reply.Error)
+            return tracker.Accept(reply)
         }
-
-        if request.FirstUnchosenIndex > response.FirstUnchosenIndex {
-            go this.notifyOfSuccess(response.RoleId, request.FirstUnchosenIndex, response.FirstUnchosenIndex)
+        received[reply.RoleId] = true
+        state := tracker.Accept(reply)
+        if state == clusterpeers.QuorumSuperseded {
+            this.journal.Record(this.roleId, journal.RoundRejected, "Accept of proposal %v for entry %d rejected by role %d, which holds proposal %v",
+                                request.ProposalId, request.Index, reply.RoleId, reply.Accept.AcceptedId)
+            this.peers.RevokePromise(reply.RoleId)
+        } else if request.FirstUnchosenIndex > reply.Accept.FirstUnchosenIndex {
+            go this.notifyOfSuccess(reply.RoleId, request.FirstUnchosenIndex, reply.Accept.FirstUnchosenIndex)
         }
+        return state
+    })
+    if state != clusterpeers.QuorumReached {
+        this.observePreemption(tracker.GetHigher())
+        return false, nil
     }
 
     go this.processAllAccepts(request, peerCount, received, endpoint)
@@ -219,21 +463,27 @@ func (this *ProposerRole) recvAccepts(request acceptor.ProposalReq, peerCount ui
     return true, nil
 }
 
-func (this *ProposerRole) processAllAccepts(request acceptor.ProposalReq, peerCount uint64, received map[uint64]bool, endpoint <-chan clusterpeers.Response) {
+func (this *ProposerRole) processAllAccepts(request acceptor.ProposalReq, peerCount uint64, received map[uint64]bool, endpoint <-chan clusterpeers.AcceptResult) {
     for uint64(len(received)) < peerCount {
         var response acceptor.ProposalResp
         select {
-        case reply := <- endpoint:
-            response = *reply.Data.(*acceptor.ProposalResp)
+        case reply, open := <- endpoint:
+            if !open {
+                // Every peer has answered or failed; the rest are retried once the timeout passes
+                endpoint = nil
+                continue
+            }
+            if reply.Error != nil { continue }
+            response = *reply.Accept
             received[response.RoleId] = true
-        case <- time.After(2*time.Second):
-            _, endpoint = this.peers.BroadcastProposalRequest(request, received)
+        case <- this.clock.After(2*this.roundTimeouts().Rpc):
+            _, endpoint = this.peers.BroadcastAccept(request, received)
             continue
         }
 
         // If failed, set promises as required
         if response.AcceptedId.IsGreaterThan(request.ProposalId) {
-            this.peers.SetPromiseRequirement(response.RoleId, true)
+            this.peers.RevokePromise(response.RoleId)
         }
 
         if request.FirstUnchosenIndex > response.FirstUnchosenIndex {
@@ -242,66 +492,199 @@ func (this *ProposerRole) processAllAccepts(request acceptor.ProposalReq, peerCo
     }
 }
 
-// Explicitly transfer chosen values to a role which is missing that information
-func (this *ProposerRole) notifyOfSuccess(roleId uint64, firstUnchosenIndex int, index int) {
-    for firstUnchosenIndex > index {
-        logEntry := this.log.GetEntryAt(index)
-
-        if logEntry.AcceptedProposalId != proposal.Chosen() {
-            fmt.Println("FATAL ERROR: cluster state corrupted")
-            this.terminator <- true
-        }
-
-        info := acceptor.SuccessNotify {
-            Index: index,
-            Value: logEntry.Value,
-        }
-
-        endpoint := this.peers.NotifyOfSuccess(roleId, info)
-
-        select {
-        case response := <- endpoint:
-            index = *response.Data.(*int)
-            continue
-        case <- time.After(time.Second):
-            continue
-        }
+// Records the role believed to be leader, firing the leader change hook if it differs. Every
+// role broadcasts heartbeats, so the leader is the highest ranked role heard within an election
+// timeout, by priority and then roleId, unless it is yielding leadership and follows a lower role
+func (this *ProposerRole) observeLeader(leaderId uint64) {
+    current := atomic.LoadUint64(&this.leaderId)
+    yielded := current == this.roleId && this.IsYielding()
+    if this.peers.Outranks(current, leaderId) && !yielded && this.clock.Now().Sub(this.lastLeaderContact()) < this.peerTimeouts(current).Election {
+        return
+    }
+    atomic.StoreInt64(&this.leaderSeen, this.clock.Now().UnixNano())
+    if previous := atomic.SwapUint64(&this.leaderId, leaderId); previous != leaderId {
+        this.journal.Record(this.roleId, journal.LeaderChanged, "Leader changed from %d to %d", previous, leaderId)
+        // Promises gathered in an earlier term may since have been made to another leader
+        this.peers.ResetPromises()
+        this.events.LeaderChange(leaderId)
     }
 }
 
 // Catches heartbeat signal as a remote procedure call
-func (this *ProposerRole) Heartbeat(req *uint64, reply *uint64) error {
-    if this.roleId < *req {
+func (this *ProposerRole) Heartbeat(req *uint64, reply *uint64) (err error) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.Heartbeat", &err)
+    this.peers.ObserveHeartbeat(*req)
+    if this.peers.IsVoter(*req) {
+        atomic.AddUint64(&this.heartbeats, 1)
+    }
+    // Removed members may still be running, and must not be followed, nor may learners; a
+    // learner follows whichever voter it hears, as it never leads
+    if (this.peers.Outranks(*req, this.roleId) || this.IsYielding() || !this.peers.IsVoter(this.roleId)) && this.peers.IsVoter(*req) {
         this.heartbeat <- *req
     }
     *reply = this.roleId
     return nil
 }
 
-// Client request to replicate data
+// Receives heartbeat as Heartbeat does, replying with this node's state for the sender
+func (this *ProposerRole) HeartbeatState(req *uint64, reply *clusterpeers.FollowerState) (err error) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.HeartbeatState", &err)
+    var roleId uint64
+    this.Heartbeat(req, &roleId)
+    *reply = clusterpeers.FollowerState {
+        RoleId: roleId,
+        CommitIndex: this.log.GetCommitIndex(),
+        AppliedIndex: this.log.GetAppliedIndex(),
+        CorruptEntries: len(this.log.GetCorruptIndices()),
+        InFlight: len(this.inFlight),
+        MinProposalId: this.log.GetMinProposalId(),
+    }
+    _, reply.StorageDegraded = this.GetStorageHealth()
+    reply.Heard, reply.Unanswered = this.peers.ReachabilityReport()
+    return nil
+}
+
+// Client request to replicate data; reply is buffered, as a cancelled client no longer waits
 type ClientRequest struct {
-    value string
+    value []byte
+    ctx context.Context
     reply chan error
 }
 
 // Receives requests from client
-func (this *ProposerRole) Replicate(value *string, retValue *string) error {
-    if len(*value) == 0 {
-        *retValue = *value
+func (this *ProposerRole) Replicate(value *[]byte, retValue *[]byte) (err error) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.Replicate", &err)
+    *retValue = *value
+    return this.ReplicateWithPriority(*value, Interactive)
+}
+
+// Replicates a value in the given priority class, once admitted by the class's rate limit
+// and the limit on proposals in flight
+func (this *ProposerRole) ReplicateWithPriority(value []byte, priority Priority) error {
+    return this.ReplicateWithMetadata(value, priority, hooks.Metadata{})
+}
+
+// Replicates a value as ReplicateWithPriority, recording metadata alongside it once every
+// node has been upgraded to understand it; an unset timestamp is stamped with this node's
+// hybrid logical clock
+func (this *ProposerRole) ReplicateWithMetadata(value []byte, priority Priority, metadata hooks.Metadata) error {
+    return this.ReplicateWithContext(context.Background(), value, priority, metadata)
+}
+
+// Replicates a value as ReplicateWithMetadata until the context is cancelled, when it returns
+// the context's error at once. Cancellation is best effort: a value still awaiting admission or
+// queued for the leader is dropped, and the leader starts no further round for it, but a value
+// already accepted may yet be chosen, and its result is then discarded. Values in Mencius mode
+// and fast rounds are proposed on the caller's goroutine, and cancelled only before admission.
+// A value whose deadline leaves less time than recent proposals took to commit is rejected
+// with ErrDeadlineTooShort, before and again after waiting for admission. Values beginning
+// with a prefix reserved for control entries are rejected with ErrReservedValue
+func (this *ProposerRole) ReplicateWithContext(ctx context.Context, value []byte, priority Priority, metadata hooks.Metadata) error {
+    if len(value) == 0 {
         return nil
     }
+    err := checkReserved(value)
+    if err != nil { return err }
+    done, err := this.admitUnsealed()
+    if err != nil { return err }
+    defer done()
+    // Checked once counted as proposing, so a drain waits for every proposal it lets through
+    err = this.checkDraining()
+    if err != nil { return err }
+    err = this.checkReadOnly()
+    if err != nil { return err }
+
+    if metadata.Sequence != 0 && metadata.Sequence <= this.log.GetSessionSequence(metadata.ClientId) {
+        // Retry of a command already applied, perhaps chosen under an earlier leader
+        fmt.Println("[ PROPOSER", this.roleId, "] Acknowledging repeated command", metadata.Sequence, "of client", metadata.ClientId)
+        return nil
+    }
+    fmt.Println("[ PROPOSER", this.roleId, "] Received client request", string(value))
+    err = this.checkStorage()
+    if err != nil { return err }
+    err = this.checkDeadline(ctx)
+    if err != nil { return err }
+    err = this.limit(ctx, priority)
+    if err != nil { return err }
+    err = this.admit(ctx)
+    if err != nil { return err }
+    defer this.release()
+
+    if this.peers.GetUpgradeState() == clusterpeers.UpgradeAllNew {
+        // Nodes predating metadata would apply the header as part of the value
+        if metadata.Timestamp.IsZero() {
+            metadata = this.stamp(metadata)
+        }
+        value = replicatedlog.WrapMetadata(value, metadata)
+    }
 
-    fmt.Println("[ PROPOSER", this.roleId, "] Received client request", *value)
-    replyChannel := make(chan error)
-    request := ClientRequest{*value, replyChannel}
-    this.client <- request
-    err := <- replyChannel
-    *retValue = *value
+    err = this.checkDeadline(ctx)
+    if err != nil { return err }
+    start := this.clock.Now()
+    err = this.propose(ctx, value)
+    if err == nil {
+        this.latency.observe(this.clock.Now().Sub(start))
+    }
     return err
 }
 
+// Proposes an admitted value, through the leader unless every node proposes on its own
+func (this *ProposerRole) propose(ctx context.Context, value []byte) error {
+    // Every node proposes on its own in Mencius mode and fast rounds, so values are not chunked
+    if this.mencius != nil {
+        return this.proposeOwned(value)
+    }
+    if this.fast != nil {
+        return this.proposeFast(value)
+    }
+
+    replyChannel := make(chan error, 1)
+    request := ClientRequest{value, ctx, replyChannel}
+    select {
+    case this.client <- request:
+    case <- ctx.Done():
+        return this.cancelled(ctx)
+    }
+    select {
+    case err := <- replyChannel:
+        return err
+    case <- ctx.Done():
+        return this.cancelled(ctx)
+    }
+}
+
+// Reports a proposal abandoned by its client
+func (this *ProposerRole) cancelled(ctx context.Context) error {
+    flowStats.Add("cancelled", 1)
+    fmt.Println("[ PROPOSER", this.roleId, "] Client abandoned proposal:", ctx.Err())
+    return ctx.Err()
+}
+
+// Stamps metadata with a hybrid logical clock reading after every value this node has applied.
+// Only the leader replicates values outside Mencius mode and fast rounds, so its clock orders
+// the log, and readings follow the previous leader's once it has caught up
+func (this *ProposerRole) stamp(metadata hooks.Metadata) hooks.Metadata {
+    this.hlc.Observe(this.log.GetLastStamp())
+    stamp := this.hlc.Now()
+    metadata.Timestamp, metadata.Logical = stamp.Time(), stamp.Logical
+    return metadata
+}
+
+// Replicates an application command, serialized with the configured codec
+func (this *ProposerRole) Propose(command interface{}) error {
+    return this.ProposeWithPriority(command, Interactive)
+}
+
+// Replicates an application command in the given priority class
+func (this *ProposerRole) ProposeWithPriority(command interface{}, priority Priority) error {
+    data, err := this.codec.Marshal(command)
+    if err != nil { return err }
+    return this.ReplicateWithPriority(data, priority)
+}
+
 // Receives termination command
-func (this *ProposerRole) Terminate(req *bool, reply *bool) error {
+func (this *ProposerRole) Terminate(req *bool, reply *bool) (err error) {
+    defer guard.Recover("PROPOSER", this.roleId, "ProposerRole.Terminate", &err)
     this.terminator <- *req
     *reply = *req
     return nil
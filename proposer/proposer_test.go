@@ -0,0 +1,154 @@
+package proposer
+
+import (
+    "net"
+    "time"
+    "context"
+    "testing"
+    "net/rpc"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/config"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Starts a member of a cluster with the given peers on loopback, on short timeouts
+func launchPeers(t *testing.T, roleId uint64, peers map[uint64]string) *clusterpeers.Cluster {
+    settings := config.Default()
+    settings.RoleId = roleId
+    settings.Timeouts = config.Timeouts{Heartbeat: 20*time.Millisecond, Election: 100*time.Millisecond, Rpc: 200*time.Millisecond}
+    for peerId, address := range peers {
+        settings.Peers[peerId] = address
+    }
+    cluster, _, _, err := clusterpeers.ConstructCluster(settings, nil)
+    if err == nil {
+        err = cluster.Listen(rpc.NewServer())
+    }
+    if err != nil { t.Fatal(err) }
+    cluster.Connect()
+    return cluster
+}
+
+// Values are split into chunks only once every voter has acknowledged the version which
+// understands them; a voter which has not connected may still run an older one
+func TestChunkingWaitsForEveryVoter(t *testing.T) {
+    peers := make(map[uint64]string)
+    for roleId := uint64(1); roleId <= 3; roleId++ {
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil { t.Fatal(err) }
+        peers[roleId] = listener.Addr().String()
+        listener.Close()
+    }
+    launchPeers(t, 2, peers)
+    proposer := ProposerRole{roleId: 1, peers: launchPeers(t, 1, peers), chunkSize: 64}
+
+    deadline := time.Now().Add(5*time.Second)
+    for !proposer.peers.IsConnected(2) && time.Now().Before(deadline) {
+        time.Sleep(10*time.Millisecond)
+    }
+    if state := proposer.peers.GetUpgradeState(); state != clusterpeers.UpgradeMixed {
+        t.Fatalf("Upgrade state is %v with role 3 down, expected mixed", state)
+    }
+    if size := proposer.entryChunkSize(); size != 0 {
+        t.Errorf("Chunked values at %d bytes before every voter was upgraded", size)
+    }
+
+    launchPeers(t, 3, peers)
+    for proposer.peers.GetUpgradeState() != clusterpeers.UpgradeAllNew && time.Now().Before(deadline) {
+        time.Sleep(10*time.Millisecond)
+    }
+    if size := proposer.entryChunkSize(); size != 64 {
+        t.Errorf("Chunk size is %d once every voter was upgraded, expected 64", size)
+    }
+}
+
+// Control entries are applied by every replica, so a client able only to propose must not be
+// able to choose one
+func TestReplicateRefusesReservedValues(t *testing.T) {
+    proposer := ProposerRole{roleId: 1}
+    reserved := [][]byte{
+        clusterpeers.RoleChangeValue(3, clusterpeers.Learner),
+        []byte("\x00role not even json"),
+        SealValue(map[uint64]string{9: "127.0.0.1:1"}),
+        []byte("\x00chunk 2.1f.7 2 5\nforged"),
+        replicatedlog.WrapMetadata([]byte("x"), hooks.Metadata{ClientId: "victim", Sequence: 1000}),
+    }
+    for _, value := range reserved {
+        err := proposer.ReplicateWithContext(context.Background(), value, Interactive, hooks.Metadata{})
+        if !IsReservedValue(err) {
+            t.Errorf("Proposing %q returned %v, expected it refused as reserved", value, err)
+        }
+    }
+}
+
+// Returns promises from acceptors which accepted the given values in the fast round; an empty
+// value stands for an acceptor which accepted nothing
+func fastPromises(values ...string) []*acceptor.PrepareResp {
+    var promises []*acceptor.PrepareResp = nil
+    for _, value := range values {
+        promise := acceptor.PrepareResp{PromiseAccepted: true, AcceptedProposalId: proposal.Default()}
+        if value != "" {
+            promise.AcceptedProposalId = proposal.FastId()
+            promise.AcceptedValue = []byte(value)
+        }
+        promises = append(promises, &promise)
+    }
+    return promises
+}
+
+func TestFastQuorumSize(t *testing.T) {
+    cases := []struct {
+        peerCount uint64
+        expected uint64
+    }{
+        {1, 1}, {2, 2}, {3, 3}, {4, 3}, {5, 4}, {7, 6},
+    }
+    for _, test := range cases {
+        size := clusterpeers.FastQuorumSize(test.peerCount, test.peerCount/2+1)
+        if size != test.expected {
+            t.Errorf("Fast quorum of %d peers is %d, expected %d", test.peerCount, size, test.expected)
+        }
+    }
+}
+
+// A full round after a fast round must propose any value a fast quorum may have chosen: one
+// accepted by at least the fast quorum size, less the peers outside the promising quorum
+func TestRecoverValue(t *testing.T) {
+    classic := fastPromises("a", "")
+    classic[1].AcceptedProposalId = proposal.Id{RoleId: 2, Sequence: 2}
+    classic[1].AcceptedValue = []byte("c")
+
+    cases := []struct {
+        name string
+        peerCount int
+        promises []*acceptor.PrepareResp
+        preferred string
+        expected string
+    }{
+        {"N=3 nothing accepted", 3, fastPromises("", ""), "p", "p"},
+        {"N=3 both accepted", 3, fastPromises("a", "a"), "p", "a"},
+        {"N=3 one accepted", 3, fastPromises("a", ""), "p", "p"},
+        {"N=3 collision", 3, fastPromises("a", "b"), "p", "p"},
+        {"N=3 every promise split", 3, fastPromises("a", "a", "b"), "p", "p"},
+        {"N=3 every promise agrees", 3, fastPromises("a", "a", "a"), "p", "a"},
+        {"N=3 classic proposal", 3, classic, "p", "c"},
+        {"N=5 two of three", 5, fastPromises("a", "a", "b"), "p", "a"},
+        {"N=5 one of three", 5, fastPromises("a", "b", ""), "p", "p"},
+        {"N=5 split four", 5, fastPromises("a", "a", "b", "b"), "p", "p"},
+        {"N=5 three of four", 5, fastPromises("a", "a", "a", "b"), "p", "a"},
+        {"N=5 free choice", 5, fastPromises("a", "a", "b", "", ""), "", "a"},
+    }
+    for _, test := range cases {
+        fastQuorumSize := clusterpeers.FastQuorumSize(uint64(test.peerCount), uint64(test.peerCount/2+1))
+        var preferred []byte = nil
+        if test.preferred != "" {
+            preferred = []byte(test.preferred)
+        }
+        value := RecoverValue(test.promises, preferred, int(fastQuorumSize), test.peerCount)
+        if string(value) != test.expected {
+            t.Errorf("%s: recovered %q, expected %q", test.name, value, test.expected)
+        }
+    }
+}
@@ -0,0 +1,65 @@
+package proposer
+
+import (
+    "fmt"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/journal"
+)
+
+// Rejection of a proposal by a node in read-only mode, naming the leader at which to retry;
+// see ParseLeaderHint. The proposal was not executed
+type ReadOnlyError struct {
+    RoleId uint64
+    LeaderId uint64
+    Address string
+}
+
+func (this *ReadOnlyError) Error() string {
+    message := fmt.Sprintf("[ PROPOSER %d ] Failure: node is read-only", this.RoleId)
+    if this.LeaderId == 0 || len(this.Address) == 0 {
+        return message
+    }
+    return fmt.Sprintf("%s%s%d at %s", message, leaderHintMarker, this.LeaderId, this.Address)
+}
+
+// Puts this node into read-only mode, or takes it out: while read-only it refuses client
+// proposals with a hint naming the leader, yields leadership to a successor if it leads, and
+// never stands for election, but still votes as an acceptor and serves reads within their
+// staleness bounds. Takes write traffic off a node before maintenance without removing it from
+// the cluster; the mode lasts until turned off or the node restarts
+func (this *ProposerRole) SetReadOnly(enabled bool) {
+    if enabled && atomic.CompareAndSwapInt32(&this.readOnly, 0, 1) {
+        this.journal.Record(this.roleId, journal.ReadOnly, "Read-only; refusing client proposals")
+        fmt.Println("[ PROPOSER", this.roleId, "] Read-only; refusing client proposals")
+    } else if !enabled && atomic.CompareAndSwapInt32(&this.readOnly, 1, 0) {
+        this.journal.Record(this.roleId, journal.ReadOnly, "Writable; accepting client proposals")
+        fmt.Println("[ PROPOSER", this.roleId, "] Writable; accepting client proposals")
+    }
+}
+
+// Reports whether this node is in read-only mode
+func (this *ProposerRole) IsReadOnly() bool {
+    return atomic.LoadInt32(&this.readOnly) == 1
+}
+
+// Refuses client proposals while read-only, pointing clients at the leader; a read-only node
+// still leading names the successor expected to take over
+func (this *ProposerRole) checkReadOnly() error {
+    if !this.IsReadOnly() { return nil }
+    flowStats.Add("readOnlyRejected", 1)
+    leaderId, address := this.GetLeader()
+    if leaderId == this.roleId {
+        leaderId = this.successor()
+        address = this.peers.GetPeerAddress(leaderId)
+        if leaderId == 0 {
+            address = ""
+        }
+    }
+    return &ReadOnlyError{this.roleId, leaderId, address}
+}
+
+// Reports whether an error, possibly received over RPC, rejected a proposal on a read-only node
+func IsReadOnly(err error) bool {
+    return err != nil && strings.Contains(err.Error(), "Failure: node is read-only")
+}
@@ -0,0 +1,91 @@
+package proposer
+
+import (
+    "fmt"
+    "context"
+    "time"
+    "strings"
+    "sync/atomic"
+    "github/paxoscluster/metrics"
+)
+
+var readStats = metrics.Group("reads")
+
+// Rejection of a read by a replica which cannot show its state is within the read's staleness
+// bound, naming the leader at which to retry; see ParseLeaderHint
+type StaleReadError struct {
+    RoleId uint64
+    LeaderId uint64
+    Address string
+}
+
+func (this *StaleReadError) Error() string {
+    message := fmt.Sprintf("[ PROPOSER %d ] Failure: replica too stale", this.RoleId)
+    if this.LeaderId == 0 || len(this.Address) == 0 {
+        return message
+    }
+    return fmt.Sprintf("%s%s%d at %s", message, leaderHintMarker, this.LeaderId, this.Address)
+}
+
+// Reports whether an error was returned by a replica refusing a read beyond its staleness bound
+func IsStaleRead(err error) bool {
+    return err != nil && strings.Contains(err.Error(), "Failure: replica too stale")
+}
+
+// Waits up to the context's deadline for this node to apply every entry before next, fetching
+// them from the leader if missing, so a session observes its own writes; if the node does not
+// apply them in time, the read must be retried at the leader
+func (this *ProposerRole) WaitForSession(ctx context.Context, next int) error {
+    if next <= 0 { return nil }
+
+    // Followers learn chosen values lazily, so missing ones are fetched from the leader
+    first := this.log.GetFirstUnchosenIndex()
+    leaderId, _ := this.GetLeader()
+    if first < next && leaderId != 0 && leaderId != this.roleId {
+        this.fetchBatch(leaderId, first, next-first)
+    }
+
+    if this.log.WaitForIndex(ctx, next-1) != nil {
+        readStats.Add("sessionStale", 1)
+        leaderId, address := this.GetLeader()
+        return &StaleReadError{this.roleId, leaderId, address}
+    }
+    return nil
+}
+
+// Records the first unchosen index reported by a proposer's accept request, fetching the
+// missing values if this node has fallen far behind
+func (this *ProposerRole) ObserveProgress(roleId uint64, firstUnchosenIndex int) {
+    atomic.StoreInt64(&this.leaderProgress, int64(firstUnchosenIndex))
+    this.catchUpFrom(roleId, firstUnchosenIndex)
+}
+
+// Returns when the believed leader was last heard from
+func (this *ProposerRole) lastLeaderContact() time.Time {
+    return time.Unix(0, atomic.LoadInt64(&this.leaderSeen))
+}
+
+// Returns the number of heartbeats received from voters, and when the believed leader was last
+// heard from; the leader hears no heartbeat from itself
+func (this *ProposerRole) GetHeartbeats() (uint64, time.Time) {
+    return atomic.LoadUint64(&this.heartbeats), this.lastLeaderContact()
+}
+
+// Checks this replica may serve a read staler than the leader by at most bound: the leader
+// must have been heard from within bound, and every entry it last reported chosen applied.
+// The leader itself always serves reads
+func (this *ProposerRole) CheckStaleness(bound time.Duration) error {
+    leaderId, address := this.GetLeader()
+    if leaderId == this.roleId {
+        return nil
+    }
+
+    stale := leaderId == 0 || this.clock.Now().Sub(this.lastLeaderContact()) > bound ||
+             int64(this.log.GetAppliedIndex()+1) < atomic.LoadInt64(&this.leaderProgress)
+    if stale {
+        readStats.Add("stale", 1)
+        return &StaleReadError{this.roleId, leaderId, address}
+    }
+    readStats.Add("local", 1)
+    return nil
+}
@@ -0,0 +1,53 @@
+package proposer
+
+import (
+    "time"
+    "github/paxoscluster/config"
+)
+
+// Settings of a proposer which may change while it runs, replaced as a whole on reload
+type tunables struct {
+    admissionWait time.Duration
+    rates [priorityCount]*tokenBucket
+    retry *retryPolicy
+}
+
+func (this *ProposerRole) constructTunables(settings *config.Config) *tunables {
+    newTunables := tunables {
+        admissionWait: settings.Flow.Wait,
+        retry: constructRetryPolicy(settings.Retry, this.clock, this.random),
+    }
+    newTunables.rates[Interactive] = constructTokenBucket(settings.RateLimit.Interactive, this.clock)
+    newTunables.rates[Background] = constructTokenBucket(settings.RateLimit.Background, this.clock)
+    return &newTunables
+}
+
+func (this *ProposerRole) getTunables() *tunables {
+    return this.tunables.Load().(*tunables)
+}
+
+// Returns the timeouts in force
+func (this *ProposerRole) GetTimeouts() config.Timeouts {
+    return this.timeouts.Get()
+}
+
+// Returns the timeouts governing exchanges with a peer, those of the WAN profile if it is
+// across a WAN; zero or this role's own roleId takes the LAN timeouts
+func (this *ProposerRole) peerTimeouts(roleId uint64) config.Timeouts {
+    return this.timeouts.Get().Profile(this.peers.IsRemote(roleId))
+}
+
+// Returns the timeouts bounding a consensus round: those of the WAN profile if any voter is
+// across a WAN, as a quorum may need its reply
+func (this *ProposerRole) roundTimeouts() config.Timeouts {
+    return this.timeouts.Get().Profile(this.peers.HasRemoteVoters())
+}
+
+// Takes the timeouts, admission wait, rate limits, catch-up batch size and rate, and retry
+// policy of reloaded settings, checked by Config.CheckReload. Rate limits and the retry
+// budget start afresh, with a second of tokens
+func (this *ProposerRole) Reload(settings *config.Config) {
+    this.timeouts.Set(settings.Timeouts)
+    this.tunables.Store(this.constructTunables(settings))
+    this.catchUp.reload(settings.CatchUp)
+}
@@ -0,0 +1,30 @@
+package proposer
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Rejection of a value beginning with a prefix reserved for entries the nodes interpret
+// themselves, such as role changes, seals, chunks, and metadata. Such entries are proposed only
+// by the operations which check their own permissions; a client proposing one could otherwise
+// change the membership, seal the group for good, overwrite part of another client's value, or
+// suppress another client's commands, with no more than permission to propose
+var ErrReservedValue = errors.New("Failure: value begins with a prefix reserved for control entries")
+
+// Refuses a client value which every replica would interpret as a control entry
+func checkReserved(value []byte) error {
+    if clusterpeers.IsRoleChange(value) || bytes.HasPrefix(value, sealMarker) || replicatedlog.IsChunk(value) ||
+       replicatedlog.HasMetadata(value) {
+        return ErrReservedValue
+    }
+    return nil
+}
+
+// Reports whether an error, possibly received over RPC, rejected a value for a reserved prefix
+func IsReservedValue(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrReservedValue.Error())
+}
@@ -0,0 +1,71 @@
+package proposer
+
+import (
+    "time"
+    "errors"
+    "context"
+    "strings"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/config"
+    "github/paxoscluster/metrics"
+)
+
+var retryStats = metrics.Group("retry")
+
+// Failure of a proposal whose rounds kept failing to reach a quorum until the retry policy
+// allowed no more attempts; the value may still be chosen by a later round of another proposal
+var ErrRetriesExhausted = errors.New("Failure: consensus rounds failed too many times")
+
+// Delays between failed rounds of a proposal: each delay grows from the base by the multiplier
+// up to the maximum, and is shortened by a random part of up to Jitter percent so competing
+// proposers fall out of step. The budget bounds retries per second across all proposals
+type retryPolicy struct {
+    settings config.RetryConfig
+    budget *tokenBucket
+    random clock.Rand
+    clock clock.Clock
+}
+
+func constructRetryPolicy(settings config.RetryConfig, source clock.Clock, random clock.Rand) *retryPolicy {
+    newRetryPolicy := retryPolicy {
+        settings: settings,
+        budget: constructTokenBucket(settings.Budget, source),
+        random: random,
+        clock: source,
+    }
+    return &newRetryPolicy
+}
+
+// Waits before retrying a proposal after its given number of failed rounds; fails with
+// ErrRetriesExhausted if the attempts or the budget allow no further round
+func (this *retryPolicy) backoff(failures int) error {
+    if this.settings.MaxAttempts != 0 && uint64(failures) >= this.settings.MaxAttempts {
+        retryStats.Add("exhausted", 1)
+        return ErrRetriesExhausted
+    }
+    if this.budget.take(context.Background(), 0) != nil {
+        retryStats.Add("overBudget", 1)
+        return ErrRetriesExhausted
+    }
+    retryStats.Add("retries", 1)
+    this.clock.Sleep(this.delay(failures))
+    return nil
+}
+
+// Returns the jittered delay after a number of failed rounds
+func (this *retryPolicy) delay(failures int) time.Duration {
+    delay := this.settings.Base
+    for i := 1; i < failures && delay < this.settings.Max; i++ {
+        delay *= time.Duration(this.settings.Multiplier)
+    }
+    if delay > this.settings.Max {
+        delay = this.settings.Max
+    }
+    jitter := float64(delay) * float64(this.settings.Jitter) / 100 * this.random.Float64()
+    return delay - time.Duration(jitter)
+}
+
+// Reports whether an error, possibly received over RPC, failed a proposal for exhausting its retries
+func IsRetriesExhausted(err error) bool {
+    return err != nil && strings.Contains(err.Error(), ErrRetriesExhausted.Error())
+}
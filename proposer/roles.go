@@ -0,0 +1,47 @@
+package proposer
+
+import (
+    "fmt"
+    "context"
+    "sync/atomic"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Demotes a member to a learner, or promotes a learner back to a voter, by choosing the change
+// in the log; every replica applies it at the same entry. Returns once the change is in force
+// on this node. Only a leader may change roles, one change at a time, and only outside Mencius
+// mode and fast rounds, where every member owns slots. The leader and an arbiter always vote
+func (this *ProposerRole) ChangeRole(ctx context.Context, roleId uint64, role clusterpeers.MemberRole) error {
+    if this.mencius != nil || this.fast != nil {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: role changes require a leader; disable Mencius mode and fast rounds", this.roleId)
+    }
+    err := this.RequireLeader()
+    if err != nil { return err }
+    if role == clusterpeers.Learner && roleId == this.roleId {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: the leader cannot be demoted; transfer leadership first", this.roleId)
+    }
+    if role == clusterpeers.Learner && roleId == this.arbiterId {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: the arbiter cannot be demoted", this.roleId)
+    }
+    if !atomic.CompareAndSwapInt32(&this.changingRole, 0, 1) {
+        return fmt.Errorf("[ PROPOSER %d ] Failure: another role change is in progress", this.roleId)
+    }
+    defer atomic.StoreInt32(&this.changingRole, 0)
+
+    if this.peers.IsVoter(roleId) == (role == clusterpeers.Voter) && this.peers.IsMember(roleId) {
+        return nil
+    }
+    err = this.peers.CheckRoleChange(roleId, role)
+    if err != nil { return err }
+
+    fmt.Println("[ PROPOSER", this.roleId, "] Changing role", roleId, "to", role)
+    err = this.replicate(ctx, clusterpeers.RoleChangeValue(roleId, role))
+    if err != nil { return err }
+    for this.peers.IsVoter(roleId) != (role == clusterpeers.Voter) {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        this.clock.Sleep(this.timeouts.Get().Heartbeat/10)
+    }
+    return nil
+}
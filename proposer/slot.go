@@ -0,0 +1,156 @@
+package proposer
+
+import (
+    "fmt"
+    "time"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+    "github/paxoscluster/trace"
+)
+
+// First unchosen slot while it holds up later slots, and since when
+type stall struct {
+    index int
+    since time.Time
+}
+
+// Returns how long the first unchosen slot has held up later slots; blocking reports whether
+// any later slot is in use
+func (this *stall) measure(first int, blocking bool, now time.Time) time.Duration {
+    if !blocking {
+        this.index = -1
+        return 0
+    }
+    if this.index != first {
+        this.index = first
+        this.since = now
+    }
+    return now.Sub(this.since)
+}
+
+// Runs one full round of Paxos for a single slot, revoking it from proposals which skip the
+// prepare phase. Chooses the value the slot may already hold, otherwise preferred; reports
+// the value chosen, or false if the round did not complete
+func (this *ProposerRole) chooseSlot(index int, preferred []byte) ([]byte, bool, error) {
+    proposalId, err := this.proposals.GenerateNextProposalId()
+    if err != nil { return nil, false, err }
+
+    majority := this.peers.GetQuorumSize()
+    timeout := this.roundTimeouts().Rpc
+    this.peers.ResetPromises()
+    peerCount, _, endpoint := this.peers.BroadcastPrepare(acceptor.PrepareReq{ProposalId: proposalId, Index: index, Timeout: timeout})
+    promises := make([]*acceptor.PrepareResp, 0, majority)
+    tracker := this.peers.TrackQuorum(proposalId, peerCount)
+    state := clusterpeers.Await(tracker, endpoint, this.clock.After(timeout), func(reply clusterpeers.PromiseResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            this.observeRefusal(reply.RoleId, reply.Error)
+        } else if reply.Promise.PromiseAccepted {
+            promises = append(promises, reply.Promise)
+        }
+        return tracker.Promise(reply)
+    })
+    if state != clusterpeers.QuorumReached {
+        return nil, false, nil
+    }
+
+    value := this.recoveredValue(promises, preferred)
+    request := acceptor.ProposalReq {
+        ProposalId: proposalId,
+        Index: index,
+        Value: value,
+        FirstUnchosenIndex: index,
+        Timeout: timeout,
+    }
+    peerCount, accepts := this.peers.BroadcastAccept(request, nil)
+    tracker = this.peers.TrackQuorum(proposalId, peerCount)
+    state = clusterpeers.Await(tracker, accepts, this.clock.After(timeout), func(reply clusterpeers.AcceptResult) clusterpeers.QuorumState {
+        if reply.Error != nil {
+            this.observeRefusal(reply.RoleId, reply.Error)
+        }
+        return tracker.Accept(reply)
+    })
+    if state != clusterpeers.QuorumReached {
+        return nil, false, nil
+    }
+
+    fmt.Println("[ PROPOSER", this.roleId, "] Chose", fmt.Sprintf("%q", value), "for entry", index, "in a full round")
+    this.learn(index, value)
+    this.announce(index, value)
+    return value, true, nil
+}
+
+// Returns the value a full round must propose given a quorum of promises
+func (this *ProposerRole) recoveredValue(promises []*acceptor.PrepareResp, preferred []byte) []byte {
+    return RecoverValue(promises, preferred, int(this.peers.GetFastQuorumSize()), int(this.peers.GetPeerCount()))
+}
+
+// Returns the value a full round must propose given a quorum of promises: that of the highest
+// proposal accepted, or if it is the fast proposal, the value a fast quorum may have chosen.
+// Otherwise the choice is free, and falls to preferred, or to the fast value accepted most
+// often if preferred is nil. Exported so the simulator recovers slots by the same rule
+func RecoverValue(promises []*acceptor.PrepareResp, preferred []byte, fastQuorumSize int, peerCount int) []byte {
+    highestAccepted := proposal.Default()
+    var value []byte = nil
+    for _, promise := range promises {
+        if promise.AcceptedProposalId.IsGreaterThan(highestAccepted) {
+            highestAccepted = promise.AcceptedProposalId
+            value = promise.AcceptedValue
+        }
+    }
+    if highestAccepted == proposal.Default() {
+        return preferred
+    }
+    if highestAccepted != proposal.FastId() {
+        return value
+    }
+
+    // A fast quorum may have chosen a value only if enough of this quorum accepted it; at most
+    // one value can qualify
+    counts := make(map[string]int)
+    for _, promise := range promises {
+        if promise.AcceptedProposalId == proposal.FastId() {
+            counts[string(promise.AcceptedValue)]++
+        }
+    }
+    threshold := fastQuorumSize - peerCount + len(promises)
+    mostAccepted := 0
+    for candidate, count := range counts {
+        if count >= threshold {
+            return []byte(candidate)
+        }
+        if count > mostAccepted {
+            mostAccepted = count
+            value = []byte(candidate)
+        }
+    }
+    if preferred != nil {
+        return preferred
+    }
+    return value
+}
+
+// Records a value chosen by this node
+func (this *ProposerRole) learn(index int, value []byte) {
+    learned := acceptor.SuccessNotify{Index: index, Value: value, Checksum: replicatedlog.Checksum(value)}
+    this.tracer.Trace(this.roleId, trace.LearnMethod, &learned, nil, func() error {
+        this.log.SetEntryAt(index, value, proposal.Chosen())
+        return nil
+    })
+}
+
+// Notifies the other members of a value chosen by this node when there is no leader to tell
+// them, catching up those found missing earlier values
+func (this *ProposerRole) announce(index int, value []byte) {
+    info := acceptor.SuccessNotify{Index: index, Value: value, Checksum: replicatedlog.Checksum(value)}
+    _, responses := this.peers.AnnounceSuccess(info)
+    go func() {
+        for reply := range responses {
+            if reply.Error != nil { continue }
+            if firstUnchosenIndex := reply.FirstUnchosenIndex; firstUnchosenIndex < index {
+                go this.notifyOfSuccess(reply.RoleId, this.log.GetFirstUnchosenIndex(), firstUnchosenIndex)
+            }
+        }
+    }()
+}
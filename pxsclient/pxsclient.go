@@ -0,0 +1,382 @@
+package pxsclient
+
+import (
+    "fmt"
+    "sort"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "sync"
+    "time"
+    "net/rpc"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/config"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Client of a cluster which follows the leader between nodes. Addresses are those serving
+// client requests: the client listeners if configured, otherwise the peer addresses
+type Client struct {
+    addresses map[uint64]string
+    settings *config.Config
+    token string
+    connections map[uint64]*rpc.Client
+    leaderId uint64
+    readNext int
+    Attempts int
+    Backoff time.Duration
+    MaxBackoff time.Duration
+    WatchInterval time.Duration
+    exclude sync.Mutex
+}
+
+// Creates a client of the nodes at the given addresses; settings supply the TLS,
+// authentication, and compression options used to dial them
+func Construct(addresses map[uint64]string, settings *config.Config, token string) *Client {
+    newClient := Client {
+        addresses: addresses,
+        settings: settings,
+        token: token,
+        connections: make(map[uint64]*rpc.Client),
+        leaderId: 0,
+        Attempts: 10,
+        Backoff: 50*time.Millisecond,
+        MaxBackoff: 2*time.Second,
+        WatchInterval: 5*time.Second,
+    }
+    return &newClient
+}
+
+// Replicates a value through the leader. Proposals are not idempotent, so a proposal is
+// retried only when it was certainly not executed: the node was unreachable, not leader, or
+// shedding load
+func (this *Client) Propose(value []byte) error {
+    return this.ProposeWithPriority(value, proposer.Interactive)
+}
+
+// Replicates a value in the given priority class, as Propose
+func (this *Client) ProposeWithPriority(value []byte, priority proposer.Priority) error {
+    req := admin.ReplicateReq{Token: this.token, Value: value, Priority: priority}
+    var reply []byte
+    return this.retry(func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        err := cxn.Call("ClientRole.Replicate", &req, &reply)
+        if proposer.IsNotLeader(err) || proposer.IsDraining(err) || proposer.IsReadOnly(err) {
+            this.followHint(roleId, err)
+            return true, err
+        }
+        return proposer.IsBackpressure(err) || proposer.IsStorageDegraded(err) || proposer.IsNotReady(err), err
+    })
+}
+
+// Replicates a value in the given priority class, as Propose, until the context is cancelled.
+// Cancellation returns the context's error at once and asks the node to abandon the proposal;
+// this is best effort, and a value already accepted may still commit. The time left before the
+// context's deadline is sent with each attempt, so the node may reject it as too short
+func (this *Client) ProposeWithContext(ctx context.Context, value []byte, priority proposer.Priority) error {
+    req := admin.ReplicateReq{Token: this.token, Value: value, Priority: priority, RequestId: newRequestId()}
+    var reply []byte
+    return this.retryWithContext(ctx, func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        if deadline, bounded := ctx.Deadline(); bounded {
+            req.Timeout = time.Until(deadline)
+        }
+        call := cxn.Go("ClientRole.Replicate", &req, &reply, make(chan *rpc.Call, 1))
+        select {
+        case <- call.Done:
+        case <- ctx.Done():
+            cancel := admin.CancelReq{Token: this.token, RequestId: req.RequestId}
+            cxn.Go("ClientRole.Cancel", &cancel, new(bool), make(chan *rpc.Call, 1))
+            return false, ctx.Err()
+        }
+        err := call.Error
+        if proposer.IsNotLeader(err) || proposer.IsDraining(err) || proposer.IsReadOnly(err) {
+            this.followHint(roleId, err)
+            return true, err
+        }
+        return proposer.IsBackpressure(err) || proposer.IsStorageDegraded(err) || proposer.IsNotReady(err), err
+    })
+}
+
+// Returns an identifier for a request, unique among a client's requests
+func newRequestId() string {
+    id := make([]byte, 8)
+    rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// Reads up to max committed entries starting at index from; max of zero reads to the
+// commit index of the node serving the read
+func (this *Client) Read(from int, max int) ([]replicatedlog.CommittedEntry, error) {
+    return this.read(from, max, 0)
+}
+
+// Streams committed entries in order starting at fromIndex until cancel is called
+func (this *Client) Watch(fromIndex int) (<-chan replicatedlog.CommittedEntry, func()) {
+    entries := make(chan replicatedlog.CommittedEntry)
+    done := make(chan bool)
+    var once sync.Once
+    cancel := func() { once.Do(func() { close(done) }) }
+
+    go func() {
+        defer close(entries)
+        next := fromIndex
+        for {
+            batch, err := this.read(next, 0, this.WatchInterval)
+            if err != nil {
+                fmt.Println("[ CLIENT ] Watch failed to read from index", next, ":", err)
+            }
+            for _, entry := range batch {
+                select {
+                case entries <- entry:
+                    next = entry.Index+1
+                case <- done:
+                    return
+                }
+            }
+            select {
+            case <- done:
+                return
+            default:
+            }
+        }
+    }()
+
+    return entries, cancel
+}
+
+// Streams the cluster state seen by one node as an observer, sending an observation whenever
+// heartbeats, membership, roles, or the commit index change there, and at least every
+// WatchInterval, until cancel is called. Failures are retried after Backoff
+func (this *Client) Observe(roleId uint64) (<-chan admin.Observation, func()) {
+    observations := make(chan admin.Observation)
+    done := make(chan bool)
+    var once sync.Once
+    cancel := func() { once.Do(func() { close(done) }) }
+
+    go func() {
+        defer close(observations)
+        req := admin.ObserveReq{Token: this.token, Wait: this.WatchInterval}
+        for {
+            var reply admin.Observation
+            cxn, err := this.connect(roleId)
+            if err == nil {
+                err = cxn.Call("ClientRole.Observe", &req, &reply)
+                if _, isServerError := err.(rpc.ServerError); err != nil && !isServerError {
+                    this.disconnect(roleId)
+                }
+            }
+            if err != nil {
+                fmt.Println("[ CLIENT ] Observing role", roleId, "failed:", err)
+                select {
+                case <- time.After(this.Backoff):
+                    continue
+                case <- done:
+                    return
+                }
+            }
+            req.Last = reply
+            select {
+            case observations <- reply:
+            case <- done:
+                return
+            }
+        }
+    }()
+
+    return observations, cancel
+}
+
+// Invokes an idempotent method served alongside the client requests, such as one registered
+// by an application; it is retried after any failure, following the leader if redirected
+func (this *Client) Invoke(serviceMethod string, req interface{}, reply interface{}) error {
+    return this.retry(func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        err := cxn.Call(serviceMethod, req, reply)
+        if proposer.IsNotLeader(err) || proposer.IsStaleRead(err) {
+            this.followHint(roleId, err)
+        }
+        return err != nil, err
+    })
+}
+
+// Returns the bearer token presented with each request
+func (this *Client) GetToken() string {
+    return this.token
+}
+
+// Closes all connections
+func (this *Client) Close() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for roleId, cxn := range this.connections {
+        cxn.Close()
+        delete(this.connections, roleId)
+    }
+}
+
+// Reads as Read, but from any node whose state is staler than the leader's by at most
+// staleness, spreading reads across the cluster; falls back to the leader otherwise
+func (this *Client) ReadWithin(from int, max int, staleness time.Duration) ([]replicatedlog.CommittedEntry, error) {
+    req := admin.ReadReq{Token: this.token, From: from, Max: max, MaxStaleness: staleness}
+    var reply []replicatedlog.CommittedEntry
+    roleId := this.rotate()
+    cxn, err := this.connect(roleId)
+    if err == nil {
+        err = cxn.Call("ClientRole.Read", &req, &reply)
+        if err == nil { return reply, nil }
+        this.handleReadFailure(roleId, err)
+    }
+    return this.read(from, max, 0)
+}
+
+// Selects the next node in rotation for a read which any node may serve
+func (this *Client) rotate() uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    var roleIds []uint64 = nil
+    for roleId := range this.addresses {
+        roleIds = append(roleIds, roleId)
+    }
+    sort.Slice(roleIds, func(i, j int) bool { return roleIds[i] < roleIds[j] })
+    roleId := roleIds[this.readNext%len(roleIds)]
+    this.readNext++
+    return roleId
+}
+
+// Handles a read refused or failed by a node: stale nodes point to the leader, and failed
+// connections are dropped
+func (this *Client) handleReadFailure(roleId uint64, err error) {
+    if proposer.IsStaleRead(err) {
+        this.followHint(roleId, err)
+    } else if _, isServerError := err.(rpc.ServerError); !isServerError {
+        this.disconnect(roleId)
+    }
+}
+
+// Reads are idempotent and retried after any failure
+func (this *Client) read(from int, max int, wait time.Duration) ([]replicatedlog.CommittedEntry, error) {
+    req := admin.ReadReq{Token: this.token, From: from, Max: max, Wait: wait}
+    var reply []replicatedlog.CommittedEntry
+    err := this.retry(func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        err := cxn.Call("ClientRole.Read", &req, &reply)
+        return err != nil, err
+    })
+    return reply, err
+}
+
+// Invokes an operation on the leader, or on each node in turn while the leader is unknown,
+// backing off exponentially between attempts. The operation reports whether it may be retried
+func (this *Client) retry(operation func(uint64, *rpc.Client) (bool, error)) error {
+    return this.retryWithContext(context.Background(), operation)
+}
+
+// Retries an operation as retry until the context is cancelled
+func (this *Client) retryWithContext(ctx context.Context, operation func(uint64, *rpc.Client) (bool, error)) error {
+    backoff := this.Backoff
+    var err error
+    for attempt := 0; attempt < this.Attempts; attempt++ {
+        if attempt > 0 {
+            select {
+            case <- time.After(backoff):
+            case <- ctx.Done():
+                return ctx.Err()
+            }
+            backoff *= 2
+            if backoff > this.MaxBackoff {
+                backoff = this.MaxBackoff
+            }
+        }
+
+        roleId := this.target(attempt)
+        cxn, dialErr := this.connect(roleId)
+        if dialErr != nil {
+            err = dialErr
+            this.forgetLeader(roleId)
+            continue
+        }
+
+        var retryable bool
+        retryable, err = operation(roleId, cxn)
+        if err == nil { return nil }
+        if err == ctx.Err() { return err }
+        if _, isServerError := err.(rpc.ServerError); !isServerError {
+            // Connection failed; the operation may or may not have been executed
+            this.disconnect(roleId)
+            this.forgetLeader(roleId)
+        }
+        if !retryable { return err }
+    }
+    return err
+}
+
+// Selects the node for an attempt: the believed leader, otherwise nodes in rotation
+func (this *Client) target(attempt int) uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.leaderId != 0 {
+        return this.leaderId
+    }
+    var roleIds []uint64 = nil
+    for roleId := range this.addresses {
+        roleIds = append(roleIds, roleId)
+    }
+    sort.Slice(roleIds, func(i, j int) bool { return roleIds[i] < roleIds[j] })
+    return roleIds[attempt%len(roleIds)]
+}
+
+// Redirects subsequent requests to the leader named in a not-leader error
+func (this *Client) followHint(roleId uint64, err error) {
+    leaderId, _, ok := proposer.ParseLeaderHint(err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if ok && leaderId != roleId {
+        if _, known := this.addresses[leaderId]; known {
+            this.leaderId = leaderId
+            return
+        }
+    }
+    if this.leaderId == roleId {
+        this.leaderId = 0
+    }
+}
+
+func (this *Client) forgetLeader(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if this.leaderId == roleId {
+        this.leaderId = 0
+    }
+}
+
+// Returns the connection to a node, dialing it if necessary
+func (this *Client) connect(roleId uint64) (*rpc.Client, error) {
+    this.exclude.Lock()
+    cxn, exists := this.connections[roleId]
+    address := this.addresses[roleId]
+    this.exclude.Unlock()
+    if exists { return cxn, nil }
+
+    cxn, err := clusterpeers.Dial(address, this.settings)
+    if err != nil { return nil, err }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if existing, exists := this.connections[roleId]; exists {
+        cxn.Close()
+        return existing, nil
+    }
+    this.connections[roleId] = cxn
+    return cxn, nil
+}
+
+func (this *Client) disconnect(roleId uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if cxn, exists := this.connections[roleId]; exists {
+        cxn.Close()
+        delete(this.connections, roleId)
+    }
+}
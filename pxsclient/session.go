@@ -0,0 +1,77 @@
+package pxsclient
+
+import (
+    "sync"
+    "net/rpc"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Sequence of operations which observe their own writes: reads may be served by any node,
+// which first applies every write made in the session
+type Session struct {
+    client *Client
+    token admin.Session
+    exclude sync.Mutex
+}
+
+// Begins a session, resuming from a token saved by an earlier session if not zero
+func (this *Client) Session(token admin.Session) *Session {
+    newSession := Session {
+        client: this,
+        token: token,
+    }
+    return &newSession
+}
+
+// Returns the session's token, which may be saved to resume the session elsewhere
+func (this *Session) Token() admin.Session {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.token
+}
+
+// Replicates a value as Client.Propose, advancing the session past it
+func (this *Session) Propose(value []byte) error {
+    req := admin.ReplicateReq{Token: this.client.token, Value: value, Session: this.Token()}
+    var reply admin.Session
+    err := this.client.retry(func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        err := cxn.Call("ClientRole.ReplicateInSession", &req, &reply)
+        if proposer.IsNotLeader(err) || proposer.IsDraining(err) || proposer.IsReadOnly(err) {
+            this.client.followHint(roleId, err)
+            return true, err
+        }
+        return proposer.IsBackpressure(err) || proposer.IsStorageDegraded(err) || proposer.IsNotReady(err), err
+    })
+    if err != nil { return err }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    this.token = this.token.Merge(reply)
+    return nil
+}
+
+// Reads as Client.Read from any node which has applied the session's writes, falling back
+// to the leader
+func (this *Session) Read(from int, max int) ([]replicatedlog.CommittedEntry, error) {
+    req := admin.ReadReq{Token: this.client.token, From: from, Max: max, Session: this.Token()}
+    var reply []replicatedlog.CommittedEntry
+    roleId := this.client.rotate()
+    cxn, err := this.client.connect(roleId)
+    if err == nil {
+        err = cxn.Call("ClientRole.Read", &req, &reply)
+        if err == nil { return reply, nil }
+        this.client.handleReadFailure(roleId, err)
+    }
+
+    err = this.client.retry(func(roleId uint64, cxn *rpc.Client) (bool, error) {
+        err := cxn.Call("ClientRole.Read", &req, &reply)
+        if proposer.IsStaleRead(err) {
+            this.client.followHint(roleId, err)
+        }
+        return err != nil, err
+    })
+    return reply, err
+}
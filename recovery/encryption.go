@@ -0,0 +1,184 @@
+package recovery
+
+import (
+    "os"
+    "fmt"
+    "bytes"
+    "io/ioutil"
+    "crypto/aes"
+    "crypto/rand"
+    "crypto/cipher"
+    "encoding/csv"
+    "encoding/hex"
+)
+
+// Identifies files sealed by EncryptedStorage
+var encryptionMagic = []byte("PXE1")
+
+// Supplies encryption keys; keys are identified so that files sealed with a retired key
+// remain readable after rotation
+type KeyProvider interface {
+    // Returns the identifier and contents of the key used to seal new writes
+    CurrentKey() (string, []byte, error)
+    // Returns the key with the given identifier
+    Key(id string) ([]byte, error)
+}
+
+// Encrypts files with AES-GCM before passing them to the underlying storage. Files not sealed
+// are refused, as they may have been truncated or replaced, unless migrating: files written
+// before encryption was enabled are then read as plaintext and sealed on their next write
+type EncryptedStorage struct {
+    storage Storage
+    keys KeyProvider
+    migrate bool
+}
+
+func ConstructEncryptedStorage(storage Storage, keys KeyProvider, migrate bool) *EncryptedStorage {
+    newStorage := EncryptedStorage{storage, keys, migrate}
+    return &newStorage
+}
+
+// Decrypts a file sealed with any key known to the key provider
+func (this *EncryptedStorage) Read(name string) ([]byte, error) {
+    data, err := this.storage.Read(name)
+    if err != nil { return nil, err }
+    if !bytes.HasPrefix(data, encryptionMagic) {
+        if !this.migrate {
+            return nil, fmt.Errorf("State file %s is not sealed", name)
+        }
+        fmt.Println("[ DISK ] Read unsealed state file", name)
+        return data, nil
+    }
+
+    // Header holds magic, key identifier length, key identifier, and nonce
+    data = data[len(encryptionMagic):]
+    if len(data) < 1 || len(data) < 1+int(data[0]) {
+        return nil, fmt.Errorf("Truncated encryption header in %s", name)
+    }
+    keyId := string(data[1:1+int(data[0])])
+    data = data[1+int(data[0]):]
+
+    key, err := this.keys.Key(keyId)
+    if err != nil { return nil, err }
+    aead, err := newAEAD(key)
+    if err != nil { return nil, err }
+    if len(data) < aead.NonceSize() {
+        return nil, fmt.Errorf("Truncated encryption header in %s", name)
+    }
+
+    // Name is authenticated so sealed files cannot be swapped for one another
+    plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+    if err != nil { return nil, fmt.Errorf("Failed to decrypt %s: %v", name, err) }
+    return plaintext, nil
+}
+
+// Seals a file with the current key
+func (this *EncryptedStorage) Write(name string, data []byte) error {
+    keyId, key, err := this.keys.CurrentKey()
+    if err != nil { return err }
+    if len(keyId) > 255 {
+        return fmt.Errorf("Key identifier %s is too long", keyId)
+    }
+    aead, err := newAEAD(key)
+    if err != nil { return err }
+
+    nonce := make([]byte, aead.NonceSize())
+    _, err = rand.Read(nonce)
+    if err != nil { return err }
+
+    sealed := append([]byte(nil), encryptionMagic...)
+    sealed = append(sealed, byte(len(keyId)))
+    sealed = append(sealed, keyId...)
+    sealed = append(sealed, nonce...)
+    sealed = aead.Seal(sealed, nonce, data, []byte(name))
+    return this.storage.Write(name, sealed)
+}
+
+func (this *EncryptedStorage) Remove(name string) error {
+    return this.storage.Remove(name)
+}
+
+// Syncs the underlying storage, if its writes may be buffered
+func (this *EncryptedStorage) Sync() error {
+    synced, ok := this.storage.(SyncedStorage)
+    if !ok { return nil }
+    return synced.Sync()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil { return nil, err }
+    return cipher.NewGCM(block)
+}
+
+// Reads keys from a keyring file of "id,hexkey" records; the last record is the current
+// key. The file is re-read on each use, so appending a record rotates the key
+type FileKeyProvider struct {
+    fileName string
+}
+
+func ConstructFileKeyProvider(fileName string) *FileKeyProvider {
+    newProvider := FileKeyProvider{fileName}
+    return &newProvider
+}
+
+func (this *FileKeyProvider) CurrentKey() (string, []byte, error) {
+    records, err := this.read()
+    if err != nil { return "", nil, err }
+    last := records[len(records)-1]
+    key, err := hex.DecodeString(last[1])
+    return last[0], key, err
+}
+
+func (this *FileKeyProvider) Key(id string) ([]byte, error) {
+    records, err := this.read()
+    if err != nil { return nil, err }
+    for _, record := range records {
+        if record[0] == id {
+            return hex.DecodeString(record[1])
+        }
+    }
+    return nil, fmt.Errorf("Key %s not found in keyring %s", id, this.fileName)
+}
+
+func (this *FileKeyProvider) read() ([][]string, error) {
+    data, err := ioutil.ReadFile(this.fileName)
+    if err != nil { return nil, err }
+    keyringReader := csv.NewReader(bytes.NewReader(data))
+    keyringReader.FieldsPerRecord = 2
+    records, err := keyringReader.ReadAll()
+    if err != nil { return nil, err }
+    if len(records) == 0 {
+        return nil, fmt.Errorf("Keyring %s is empty", this.fileName)
+    }
+    return records, nil
+}
+
+// Re-writes this role's state files so they are sealed with the current key, after which
+// retired keys may be removed from the key provider, or unsealed files read when migrating
+// may no longer be accepted
+func (this *Manager) RotateKeys(roleId uint64) (err error) {
+    defer this.complete("rotate", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return err }
+    names := []string{segmentIndexName(roleId), compactionName(roleId, this.generations[roleId])}
+    for _, file := range []string{"minproposalid", "proposalcounter", "appliedindex", "membership", "learners", "revocations"} {
+        names = append(names, fmt.Sprintf("%d/%s.csv", roleId, file))
+    }
+    for _, listed := range segments {
+        names = append(names, segmentName(roleId, this.generations[roleId], listed.first))
+    }
+    for _, name := range names {
+        data, err := this.storage.Read(name)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil { return err }
+
+        err = this.storage.Write(name, data)
+        if err != nil { return err }
+    }
+    return nil
+}
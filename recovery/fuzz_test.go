@@ -0,0 +1,69 @@
+package recovery
+
+import (
+    "testing"
+    "github/paxoscluster/proposal"
+)
+
+// Files of role 1 whose contents FuzzState replaces
+var fuzzFiles = []string {
+    legacyLogName(1),
+    segmentIndexName(1),
+    segmentName(1, 0, 0),
+    compactionName(1, 0),
+    "1/minproposalid.csv",
+    "1/proposalcounter.csv",
+    "1/membership.csv",
+    "1/learners.csv",
+    "1/revocations.csv",
+    "1/appliedindex.csv",
+}
+
+// Returns a manager over memory holding a well-formed log of role 1, in segments of 4 records
+func fuzzManager(t testing.TB) (*MemoryStorage, *Manager) {
+    storage := ConstructMemoryStorage()
+    disk := Manager {
+        directory: ".",
+        storage: storage,
+        segmentSize: 4,
+        segments: make(map[uint64][]segment),
+        generations: make(map[uint64]int),
+        collect: make(chan uint64, 16),
+        health: constructStorageHealth(0),
+    }
+    accepted := proposal.Id{RoleId: 2, Sequence: 1<<proposal.RoleIdBits | 2}
+    for index := 0; index < 6; index++ {
+        id := proposal.Chosen()
+        if index >= 4 {
+            id = accepted
+        }
+        err := disk.UpdateLogRecord(1, index, []byte{byte(index)}, id)
+        if err != nil { t.Fatal(err) }
+    }
+    return storage, &disk
+}
+
+// The first byte picks one of role 1's files and the rest becomes its contents, as if that
+// file were corrupted on disk, then the role's state is recovered. A well-formed log is stored
+// first, so corrupt indices and segments meet real records. Recovery may refuse the state,
+// but must not panic
+func FuzzState(f *testing.F) {
+    storage, _ := fuzzManager(f)
+    for file, name := range fuzzFiles {
+        contents, _ := storage.Read(name)
+        f.Add(append([]byte{byte(file)}, contents...))
+    }
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        if len(data) == 0 { return }
+        storage, disk := fuzzManager(t)
+        name := fuzzFiles[int(data[0])%len(fuzzFiles)]
+        if name == legacyLogName(1) {
+            // Only read while no segments are listed
+            storage.Remove(segmentIndexName(1))
+        }
+        storage.Write(name, data[1:])
+        disk.Invalidate()
+        disk.Recover(1)
+    })
+}
@@ -0,0 +1,61 @@
+package recovery
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "sync/atomic"
+)
+
+// Latency of durable updates, smoothed over recent updates. Storage is degraded once the
+// smoothed latency exceeds the threshold, or an update fails, and healthy again once
+// updates succeed and the latency falls below half the threshold; a threshold of zero only
+// measures
+type storageHealth struct {
+    threshold time.Duration
+    latency time.Duration
+    degraded bool
+    exclude sync.Mutex
+}
+
+func constructStorageHealth(threshold time.Duration) *storageHealth {
+    newStorageHealth := storageHealth{threshold: threshold}
+    return &newStorageHealth
+}
+
+// Records the latency and outcome of an update
+func (this *storageHealth) observe(operation string, latency time.Duration, err error) {
+    storageStats.Add(operation+"Updates", 1)
+    storageStats.Add(operation+"Nanos", latency.Nanoseconds())
+    if err != nil {
+        storageStats.Add(operation+"Failures", 1)
+    }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.latency += (latency-this.latency)/8
+    if this.threshold == 0 { return }
+    switch {
+    case !this.degraded && (err != nil || this.latency > this.threshold):
+        this.degraded = true
+        storageStats.Add("degraded", 1)
+        fmt.Println("[ DISK ] WARNING: storage degraded; updates averaging", this.latency, "last failure:", err)
+    case this.degraded && err == nil && this.latency < this.threshold/2:
+        this.degraded = false
+        fmt.Println("[ DISK ] Storage recovered; updates averaging", this.latency)
+    }
+}
+
+// Returns the smoothed latency of durable updates, and whether storage is degraded
+func (this *Manager) GetStorageHealth() (time.Duration, bool) {
+    this.health.exclude.Lock()
+    defer this.health.exclude.Unlock()
+
+    return this.health.latency, this.health.degraded
+}
+
+// Returns the number of updates waiting for the lock or for their writes to become durable
+func (this *Manager) GetPendingUpdates() int64 {
+    return atomic.LoadInt64(&this.pending)
+}
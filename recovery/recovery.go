@@ -5,28 +5,69 @@ import (
     "io"
     "fmt"
     "net"
+    "bytes"
     "sync"
+    "time"
     "strconv"
+    "sync/atomic"
     "os/signal"
+    "hash/crc32"
     "encoding/csv"
+    "encoding/base64"
+    "github/paxoscluster/config"
     "github/paxoscluster/proposal"
 )
 
 type Manager struct {
+    directory string
+    storage Storage
+    segmentSize int
+    // Segments of each role's log, and its generation, as last read or written
+    segments map[uint64][]segment
+    generations map[uint64]int
+    // Roles whose logs have compacted segments to collect
+    collect chan uint64
+    health *storageHealth
+    // Updates begun and not yet durable
+    pending int64
     sigint chan os.Signal
     exclude sync.Mutex
 }
 
-// Creates disk access manager for backup & recovery files
-func ConstructManager() (*Manager, error) {
-    _, err := os.Stat("coldstorage")
+// Creates disk access manager for backup & recovery files, encrypting node state if a keyring is configured
+func ConstructManager(settings config.StorageConfig) (*Manager, error) {
+    var storage Storage = ConstructFileStorage(settings.Directory, settings.Fsync, settings.FsyncDelay)
+    if len(settings.Keyring) != 0 {
+        storage = ConstructEncryptedStorage(storage, ConstructFileKeyProvider(settings.Keyring), settings.MigratePlaintext)
+    }
+    manager, err := ConstructManagerWithStorage(settings.Directory, storage)
+    if err != nil { return nil, err }
+    if settings.SegmentSize != 0 {
+        manager.segmentSize = int(settings.SegmentSize)
+    }
+    manager.health = constructStorageHealth(settings.SlowThreshold)
+    return manager, nil
+}
+
+// Creates disk access manager which keeps node state in the given storage; the peers
+// file is always read directly from the directory
+func ConstructManagerWithStorage(directory string, storage Storage) (*Manager, error) {
+    _, err := os.Stat(directory)
     if err != nil { return nil, err }
 
     newManager := Manager {
+        directory: directory,
+        storage: storage,
+        segmentSize: defaultSegmentSize,
+        segments: make(map[uint64][]segment),
+        generations: make(map[uint64]int),
+        collect: make(chan uint64, 16),
+        health: constructStorageHealth(0),
         sigint: make(chan os.Signal, 1),
     }
     signal.Notify(newManager.sigint, os.Interrupt)
     go newManager.finalize()
+    go newManager.collectSegments()
 
     return &newManager, nil
 }
@@ -39,6 +80,26 @@ func (this *Manager) finalize() {
     os.Exit(0)
 }
 
+// Counts an update as pending until it completes, returning when it began
+func (this *Manager) begin() time.Time {
+    atomic.AddInt64(&this.pending, 1)
+    return time.Now()
+}
+
+// Waits, after the lock is released, for the writes of an update to become durable, so
+// writers waiting together share one sync, then records how long the update took; deferred
+// with begin before the lock is taken
+func (this *Manager) complete(operation string, start time.Time, err *error) {
+    defer atomic.AddInt64(&this.pending, -1)
+    if *err == nil {
+        synced, ok := this.storage.(SyncedStorage)
+        if ok {
+            *err = synced.Sync()
+        }
+    }
+    this.health.observe(operation, time.Since(start), *err)
+}
+
 // Reads the list of peers from a file on disk
 func (this *Manager) RetrieveAddresses() (map[uint64]string, error) {
     this.exclude.Lock()
@@ -47,11 +108,11 @@ func (this *Manager) RetrieveAddresses() (map[uint64]string, error) {
     addresses := make(map[uint64]string)
 
     // Checks for existence of peers file
-    _, err := os.Stat("coldstorage/peers.csv")
+    _, err := os.Stat(this.directory + "/peers.csv")
     if err != nil { return nil, err }
 
     // Opens & reads peers file
-    peersFile, err := os.Open("coldstorage/peers.csv")
+    peersFile, err := os.Open(this.directory + "/peers.csv")
     if err != nil { return nil, err }
     peersFileReader := csv.NewReader(peersFile)
     records, err := peersFileReader.ReadAll()
@@ -75,138 +136,357 @@ func (this *Manager) RecoverMinProposalId(roleId uint64) (proposal.Id, error) {
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
-    // Checks for existence of directory
-    _, err := os.Stat(fmt.Sprintf("coldstorage/%d", roleId))
-    if os.IsNotExist(err) {
-        return proposal.Default(), nil
-    } else if err != nil { return proposal.Default(), err }
-
-    // Checks for existence of file
-    proposalFileName := fmt.Sprintf("coldstorage/%d/minproposalid.csv", roleId)
-    _, err = os.Stat(proposalFileName) 
+    // Reads file if it exists
+    data, err := this.storage.Read(fmt.Sprintf("%d/minproposalid.csv", roleId))
     if os.IsNotExist(err) {
         return proposal.Default(), nil
     } else if err != nil { return proposal.Default(), err }
 
-    // File exists, therefore read from it
-    proposalFile, err := os.Open(proposalFileName)
-    if err != nil { return proposal.Default(), err }
-    proposalFileReader := csv.NewReader(proposalFile)
+    // An empty file was truncated, and the promises it recorded are lost, so recovery must not
+    // proceed as though none were made
+    proposalFileReader := csv.NewReader(bytes.NewReader(data))
     record, err := proposalFileReader.Read()
-    proposalFile.Close()
-    if err != nil && err != io.EOF { return proposal.Default(), err }
+    if err == io.EOF {
+        return proposal.Default(), fmt.Errorf("Empty proposal file %d", roleId)
+    } else if err != nil { return proposal.Default(), err }
+    if len(record) != 2 { return proposal.Default(), fmt.Errorf("Invalid record length in proposal file %d", roleId) }
     proposalRole, err := strconv.ParseUint(record[0], 10, 64)
     if err != nil { return proposal.Default(), err }
     sequence, err := strconv.ParseInt(record[1], 10, 64)
     if err != nil { return proposal.Default(), err }
 
-    return proposal.Id{proposalRole, sequence}, nil
+    return proposal.Id{RoleId: proposalRole, Sequence: sequence}, nil
 }
 
-func (this *Manager) UpdateMinProposalId(roleId uint64, id proposal.Id) error {
+func (this *Manager) UpdateMinProposalId(roleId uint64, id proposal.Id) (err error) {
+    defer this.complete("minProposalId", this.begin(), &err)
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
-    // Ensures existence of directory, creating it if necessary
-    _, err := os.Stat(fmt.Sprintf("coldstorage/%d", roleId))
-    if os.IsNotExist(err) {
-        err = os.Mkdir(fmt.Sprintf("coldstorage/%d", roleId), 0700)
-        if err != nil { return err }
-    } else if err != nil { return err }
-
-    // Creates file (automatic overwrite)
-    proposalFileName := fmt.Sprintf("coldstorage/%d/minproposalid.csv", roleId)
-    proposalFile, err := os.Create(proposalFileName)
-    if err != nil { return err }
-    defer proposalFile.Close()
-
-    // Writes data to file
+    // Writes data to file (automatic overwrite)
+    var buffer bytes.Buffer
     record := []string{strconv.FormatUint(id.RoleId, 10), strconv.FormatInt(id.Sequence, 10)}
-    proposalFileWriter := csv.NewWriter(proposalFile)
+    proposalFileWriter := csv.NewWriter(&buffer)
     err = proposalFileWriter.Write(record)
     if err != nil { return err }
     proposalFileWriter.Flush()
-    return nil
+    return this.storage.Write(fmt.Sprintf("%d/minproposalid.csv", roleId), buffer.Bytes())
 }
 
-func (this *Manager) RecoverLog(roleId uint64) ([]string, []proposal.Id, error) {
+// Returns the highest proposal counter this role has used, or zero if it has never proposed
+func (this *Manager) RecoverProposalCounter(roleId uint64) (int64, error) {
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
-    // Checks for existence of log file directory
-    _, err := os.Stat(fmt.Sprintf("coldstorage/%d", roleId))
+    data, err := this.storage.Read(fmt.Sprintf("%d/proposalcounter.csv", roleId))
     if os.IsNotExist(err) {
-        return nil, nil, nil
-    } else if err != nil { return nil, nil, err }
+        return 0, nil
+    } else if err != nil { return 0, err }
+
+    // Reusing a counter would reuse a proposal number, so a damaged file is fatal
+    counter, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+    if err != nil { return 0, fmt.Errorf("Invalid proposal counter file %d: %v", roleId, err) }
+    return counter, nil
+}
+
+// Records the highest proposal counter this role has used
+func (this *Manager) UpdateProposalCounter(roleId uint64, counter int64) (err error) {
+    defer this.complete("proposalCounter", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    data := []byte(strconv.FormatInt(counter, 10) + "\n")
+    return this.storage.Write(fmt.Sprintf("%d/proposalcounter.csv", roleId), data)
+}
+
+// Returns the index of the last entry the application acknowledged applying before the node
+// stopped, or -1 if none was
+func (this *Manager) RecoverAppliedIndex(roleId uint64) (int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
 
-    // Checks for existence of log file
-    logFileName := fmt.Sprintf("coldstorage/%d/log.csv", roleId)
-    _, err = os.Stat(logFileName) 
+    data, err := this.storage.Read(fmt.Sprintf("%d/appliedindex.csv", roleId))
     if os.IsNotExist(err) {
-        return nil, nil, nil
-    } else if err != nil { return nil, nil, err }
+        return -1, nil
+    } else if err != nil { return -1, err }
 
-    // Log file exists, therefore read from it
-    logFile, err := os.Open(logFileName)
-    if err != nil { return nil, nil, err }
-    logFileReader := csv.NewReader(logFile)
-    records, err := logFileReader.ReadAll()
-    logFile.Close()
-    if err != nil { return nil, nil, err }
+    return strconv.Atoi(string(bytes.TrimSpace(data)))
+}
+
+// Records the index of the last entry the application acknowledged applying
+func (this *Manager) UpdateAppliedIndex(roleId uint64, index int) (err error) {
+    defer this.complete("appliedIndex", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    data := []byte(strconv.Itoa(index) + "\n")
+    return this.storage.Write(fmt.Sprintf("%d/appliedindex.csv", roleId), data)
+}
+
+// Returns the membership forced by an unsafe reconfiguration, or nil if none was forced
+func (this *Manager) RecoverMembership(roleId uint64) ([]uint64, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    data, err := this.storage.Read(fmt.Sprintf("%d/membership.csv", roleId))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil { return nil, err }
+
+    record, err := csv.NewReader(bytes.NewReader(data)).Read()
+    if err != nil { return nil, err }
+    members := make([]uint64, 0, len(record))
+    for _, field := range record {
+        member, err := strconv.ParseUint(field, 10, 64)
+        if err != nil { return nil, err }
+        members = append(members, member)
+    }
+    return members, nil
+}
+
+// Records the membership forced by an unsafe reconfiguration, overriding configured peers
+func (this *Manager) UpdateMembership(roleId uint64, members []uint64) (err error) {
+    defer this.complete("membership", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := make([]string, 0, len(members))
+    for _, member := range members {
+        record = append(record, strconv.FormatUint(member, 10))
+    }
+    var buffer bytes.Buffer
+    membershipFileWriter := csv.NewWriter(&buffer)
+    err = membershipFileWriter.Write(record)
+    if err != nil { return err }
+    membershipFileWriter.Flush()
+    return this.storage.Write(fmt.Sprintf("%d/membership.csv", roleId), buffer.Bytes())
+}
+
+// Returns the members demoted to learners, as of the last role change applied, and the index
+// of that change; -1 and no learners if none was applied
+func (this *Manager) RecoverLearners(roleId uint64) (int, []uint64, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    data, err := this.storage.Read(fmt.Sprintf("%d/learners.csv", roleId))
+    if os.IsNotExist(err) {
+        return -1, nil, nil
+    } else if err != nil { return -1, nil, err }
+
+    record, err := csv.NewReader(bytes.NewReader(data)).Read()
+    if err != nil { return -1, nil, err }
+    index, err := strconv.Atoi(record[0])
+    if err != nil { return -1, nil, err }
+    learners := make([]uint64, 0, len(record)-1)
+    for _, field := range record[1:] {
+        learner, err := strconv.ParseUint(field, 10, 64)
+        if err != nil { return -1, nil, err }
+        learners = append(learners, learner)
+    }
+    return index, learners, nil
+}
+
+// Records the members demoted to learners after applying the role change at index
+func (this *Manager) UpdateLearners(roleId uint64, index int, learners []uint64) (err error) {
+    defer this.complete("learners", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    record := []string{strconv.Itoa(index)}
+    for _, learner := range learners {
+        record = append(record, strconv.FormatUint(learner, 10))
+    }
+    var buffer bytes.Buffer
+    learnersFileWriter := csv.NewWriter(&buffer)
+    err = learnersFileWriter.Write(record)
+    if err != nil { return err }
+    learnersFileWriter.Flush()
+    return this.storage.Write(fmt.Sprintf("%d/learners.csv", roleId), buffer.Bytes())
+}
+
+// Returns, for each owner of Mencius slots, the highest of its slots this role has promised to
+// a revoking proposer; the owner may no longer propose in that slot or any before it
+func (this *Manager) RecoverRevocations(roleId uint64) (map[uint64]int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    revocations := make(map[uint64]int)
+    data, err := this.storage.Read(fmt.Sprintf("%d/revocations.csv", roleId))
+    if os.IsNotExist(err) {
+        return revocations, nil
+    } else if err != nil { return nil, err }
+
+    records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+    if err != nil { return nil, err }
+    for _, record := range records {
+        if len(record) != 2 { return nil, fmt.Errorf("Invalid record length in revocations file %d", roleId) }
+        owner, err := strconv.ParseUint(record[0], 10, 64)
+        if err != nil { return nil, err }
+        index, err := strconv.Atoi(record[1])
+        if err != nil { return nil, err }
+        revocations[owner] = index
+    }
+    return revocations, nil
+}
+
+// Records the highest revoked slot of each owner
+func (this *Manager) UpdateRevocations(roleId uint64, revocations map[uint64]int) (err error) {
+    defer this.complete("revocations", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    records := make([][]string, 0, len(revocations))
+    for owner, index := range revocations {
+        records = append(records, []string{strconv.FormatUint(owner, 10), strconv.Itoa(index)})
+    }
+    var buffer bytes.Buffer
+    revocationsFileWriter := csv.NewWriter(&buffer)
+    err = revocationsFileWriter.WriteAll(records)
+    if err != nil { return err }
+    return this.storage.Write(fmt.Sprintf("%d/revocations.csv", roleId), buffer.Bytes())
+}
+
+// Reads the log, verifying the CRC32C of each record; indices of records which fail
+// verification are returned as corrupt, with blank values in their place. Entries within the
+// compacted prefix are returned chosen, with nil values
+func (this *Manager) RecoverLog(roleId uint64) ([][]byte, []proposal.Id, []int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    compacted, err := this.readCompaction(roleId)
+    if err != nil { return nil, nil, nil, err }
+    records, start, err := this.readLogRecords(roleId, compacted.index)
+    if err != nil { return nil, nil, nil, err }
 
     // Parse records
-    var values []string = nil
+    var values [][]byte = nil
     var proposals []proposal.Id = nil
-    for _, record := range records {
-        if len(record) != 3 { return nil, nil, fmt.Errorf("Invalid record length in log file %d", roleId) }
-        values = append(values, record[0])
-        proposalRole, err := strconv.ParseUint(record[1], 10, 64)
-        if err != nil { return nil, nil, err }
-        sequence, err := strconv.ParseInt(record[2], 10, 64)
-        if err != nil { return nil, nil, err }
-        proposals = append(proposals, proposal.Id{proposalRole, sequence})
+    var corrupt []int = nil
+    for idx := 0; idx < start; idx++ {
+        values = append(values, nil)
+        if idx <= compacted.index {
+            proposals = append(proposals, proposal.Chosen())
+        } else {
+            proposals = append(proposals, proposal.Default())
+        }
+    }
+    for offset, record := range records {
+        value, id, err := parseLogRecord(record)
+        if err != nil {
+            fmt.Println("[ DISK ] Log", roleId, "record", start+offset, "is corrupt:", err)
+            value, id = nil, proposal.Default()
+            corrupt = append(corrupt, start+offset)
+        }
+        values = append(values, value)
+        proposals = append(proposals, id)
     }
 
-    return values, proposals, nil
+    return values, proposals, corrupt, nil
 }
 
-// Updates a record in the log file
-func (this *Manager) UpdateLogRecord(roleId uint64, index int, value string, id proposal.Id) error {
+// Parses and verifies a log record of value, proposal roleId, proposal sequence, and checksum
+func parseLogRecord(record []string) ([]byte, proposal.Id, error) {
+    if len(record) != 4 { return nil, proposal.Id{}, fmt.Errorf("Invalid record length") }
+    value, err := base64.StdEncoding.DecodeString(record[0])
+    if err != nil { return nil, proposal.Id{}, err }
+    proposalRole, err := strconv.ParseUint(record[1], 10, 64)
+    if err != nil { return nil, proposal.Id{}, err }
+    sequence, err := strconv.ParseInt(record[2], 10, 64)
+    if err != nil { return nil, proposal.Id{}, err }
+    checksum, err := strconv.ParseUint(record[3], 16, 32)
+    if err != nil { return nil, proposal.Id{}, err }
+
+    id := proposal.Id{RoleId: proposalRole, Sequence: sequence}
+    if uint32(checksum) != recordChecksum(value, id) {
+        return nil, proposal.Id{}, fmt.Errorf("Checksum mismatch")
+    }
+    return value, id, nil
+}
+
+// Parses a record of the single log file written before values were encoded and checksummed:
+// a plaintext value, proposal roleId, and proposal sequence
+func parseLegacyLogRecord(record []string) ([]byte, proposal.Id, error) {
+    if len(record) != 3 { return nil, proposal.Id{}, fmt.Errorf("Invalid record length") }
+    proposalRole, err := strconv.ParseUint(record[1], 10, 64)
+    if err != nil { return nil, proposal.Id{}, err }
+    sequence, err := strconv.ParseInt(record[2], 10, 64)
+    if err != nil { return nil, proposal.Id{}, err }
+    return []byte(record[0]), proposal.Id{RoleId: proposalRole, Sequence: sequence}, nil
+}
+
+// Formats a log record, appending the CRC32C of its contents
+func formatLogRecord(value []byte, id proposal.Id) []string {
+    return []string {
+        base64.StdEncoding.EncodeToString(value),
+        strconv.FormatUint(id.RoleId, 10),
+        strconv.FormatInt(id.Sequence, 10),
+        strconv.FormatUint(uint64(recordChecksum(value, id)), 16),
+    }
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func recordChecksum(value []byte, id proposal.Id) uint32 {
+    checksum := crc32.New(castagnoli)
+    checksum.Write(value)
+    fmt.Fprintf(checksum, ",%d,%d", id.RoleId, id.Sequence)
+    return checksum.Sum32()
+}
+
+// Updates a record in the log; values are stored base64-encoded as they may hold arbitrary bytes,
+// and each record carries a CRC32C so corruption is detected on recovery. Only the segment
+// holding the record is rewritten, replacing the old one at once, and a segment is listed in
+// the index only once written
+func (this *Manager) UpdateLogRecord(roleId uint64, index int, value []byte, id proposal.Id) (err error) {
+    defer this.complete("logRecord", this.begin(), &err)
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
-    // Ensures existence of directory, creating it if necessary
-    _, err := os.Stat(fmt.Sprintf("coldstorage/%d", roleId))
-    if os.IsNotExist(err) {
-        err = os.Mkdir(fmt.Sprintf("coldstorage/%d", roleId), 0700)
-        if err != nil { return err }
-    } else if err != nil { return err }
-
-    // If log file exists, read from it
-    logFileName := fmt.Sprintf("coldstorage/%d/log.csv", roleId)
-    var records [][]string = nil
-    _, err = os.Stat(logFileName)
-    if err == nil {
-        logFile, err := os.Open(logFileName)
-        if err != nil { return err }
-        logFileReader := csv.NewReader(logFile)
-        records, err = logFileReader.ReadAll()
-        logFile.Close()
-        if err != nil { return err }
-    } else if err != nil && !os.IsNotExist(err) { return err }
+    segments, position, extended, err := this.segmentFor(roleId, index)
+    if err != nil { return err }
+    first := segments[position].first
+    records, err := this.readSegment(roleId, first)
+    if err != nil { return err }
 
     // Modifies record
-    blank := []string{"", "0", "0"}
-    record := []string{value, strconv.FormatUint(id.RoleId, 10), strconv.FormatInt(id.Sequence, 10)}
-    for recordCount := len(records); recordCount <= index; recordCount++ {
+    blank := formatLogRecord(nil, proposal.Default())
+    for recordCount := len(records); recordCount <= index-first; recordCount++ {
         records = append(records, blank)
     }
-    records[index] = record
+    records[index-first] = formatLogRecord(value, id)
 
-    // Writes out log contents
-    modifiedLogFile, err := os.Create(logFileName)
+    // Segments are written before the index lists them
+    err = this.writeSegment(roleId, this.generations[roleId], first, records)
     if err != nil { return err }
-    defer modifiedLogFile.Close()
-    logFileWriter := csv.NewWriter(modifiedLogFile)
-    return logFileWriter.WriteAll(records)
+    if extended {
+        return this.writeSegmentIndex(roleId, this.generations[roleId], segments)
+    }
+    return nil
+}
+
+// Replaces the whole log with the given values and their accepted proposals, discarding any
+// compacted prefix. The new log is written as the next generation, taking effect when its
+// index is written, so a crash leaves either the old log or the new; the old generation's
+// files are deleted afterward
+func (this *Manager) WriteLog(roleId uint64, values [][]byte, ids []proposal.Id) (err error) {
+    defer this.complete("log", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return err }
+    generation := this.generations[roleId]
+
+    records := make([][]string, 0, len(values))
+    for index, value := range values {
+        records = append(records, formatLogRecord(value, ids[index]))
+    }
+    _, err = this.writeSegments(roleId, generation+1, records)
+    if err != nil { return err }
+
+    for _, listed := range segments {
+        err = this.storage.Remove(segmentName(roleId, generation, listed.first))
+        if err != nil { return err }
+    }
+    return this.storage.Remove(compactionName(roleId, generation))
 }
@@ -0,0 +1,399 @@
+package recovery
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "bytes"
+    "strconv"
+    "encoding/csv"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/proposal"
+)
+
+// The log is stored in segment files of a fixed number of records, <roleId>/log/<first>.csv,
+// listed in order by <roleId>/log/index.csv, so an update rewrites only the segment holding
+// its entry. Once a snapshot of the application covers a prefix of the log, the prefix is
+// recorded in <roleId>/log/compaction.csv, and the collector deletes every segment lying
+// entirely within it. Replacing the whole log starts a new generation, whose segments and
+// compaction record are named apart from those of the last, so the new log takes effect at
+// once when the index naming its generation replaces the old index
+const defaultSegmentSize = 1024
+
+// Segment of the log as listed in the index, holding entries first through first+size-1
+type segment struct {
+    first int
+    size int
+}
+
+func (this segment) end() int {
+    return this.first+this.size
+}
+
+// Prefix of the log covered by a snapshot of the application: the last entry covered, or -1
+// if none, with the timestamp agreed for the last value stamped within it, and the highest
+// command applied for each client
+type compaction struct {
+    index int
+    stamp clock.Timestamp
+    sessions map[string]uint64
+}
+
+func segmentName(roleId uint64, generation int, first int) string {
+    if generation == 0 {
+        return fmt.Sprintf("%d/log/%d.csv", roleId, first)
+    }
+    return fmt.Sprintf("%d/log/%d-%d.csv", roleId, first, generation)
+}
+
+func segmentIndexName(roleId uint64) string {
+    return fmt.Sprintf("%d/log/index.csv", roleId)
+}
+
+func compactionName(roleId uint64, generation int) string {
+    if generation == 0 {
+        return fmt.Sprintf("%d/log/compaction.csv", roleId)
+    }
+    return fmt.Sprintf("%d/log/compaction-%d.csv", roleId, generation)
+}
+
+// Log file written before the log was segmented
+func legacyLogName(roleId uint64) string {
+    return fmt.Sprintf("%d/log.csv", roleId)
+}
+
+// Returns the segments of a role's log, migrating a log written as a single file on first
+// use. The index opens with the log's generation, unless it is the first. exclude MUST be
+// locked
+func (this *Manager) loadSegments(roleId uint64) ([]segment, error) {
+    segments, loaded := this.segments[roleId]
+    if loaded {
+        return segments, nil
+    }
+
+    data, err := this.storage.Read(segmentIndexName(roleId))
+    if os.IsNotExist(err) {
+        segments, err = this.migrateLog(roleId)
+        if err != nil { return nil, err }
+    } else if err != nil {
+        return nil, err
+    } else {
+        indexFileReader := csv.NewReader(bytes.NewReader(data))
+        indexFileReader.FieldsPerRecord = -1
+        records, err := indexFileReader.ReadAll()
+        if err != nil { return nil, err }
+        generation := 0
+        if len(records) != 0 && len(records[0]) == 1 {
+            generation, err = strconv.Atoi(records[0][0])
+            if err != nil { return nil, err }
+            if generation <= 0 { return nil, fmt.Errorf("Invalid generation %d in log index %d", generation, roleId) }
+            records = records[1:]
+        }
+        this.generations[roleId] = generation
+        for _, record := range records {
+            if len(record) != 2 { return nil, fmt.Errorf("Invalid record length in log index %d", roleId) }
+            first, err := strconv.Atoi(record[0])
+            if err != nil { return nil, err }
+            size, err := strconv.Atoi(record[1])
+            if err != nil { return nil, err }
+            // Segments follow one another from the first kept after compaction
+            if first < 0 || size <= 0 || (len(segments) != 0 && first != segments[len(segments)-1].end()) {
+                return nil, fmt.Errorf("Invalid segment of %d entries from %d in log index %d", size, first, roleId)
+            }
+            segments = append(segments, segment{first, size})
+        }
+    }
+    this.segments[roleId] = segments
+    return segments, nil
+}
+
+// Forgets the segments read from storage, as when the storage is wiped beneath the manager
+func (this *Manager) Invalidate() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.segments = make(map[uint64][]segment)
+    this.generations = make(map[uint64]int)
+}
+
+// Splits a log written as a single file into segments, removing the file once they are listed
+func (this *Manager) migrateLog(roleId uint64) ([]segment, error) {
+    data, err := this.storage.Read(legacyLogName(roleId))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil { return nil, err }
+
+    logFileReader := csv.NewReader(bytes.NewReader(data))
+    logFileReader.FieldsPerRecord = -1
+    records, err := logFileReader.ReadAll()
+    if err != nil { return nil, err }
+    // Records written before values were encoded and checksummed are converted as they move
+    for idx, record := range records {
+        if len(record) != 3 {
+            continue
+        }
+        value, id, err := parseLegacyLogRecord(record)
+        if err != nil { return nil, fmt.Errorf("Invalid record %d in log file %d: %v", idx, roleId, err) }
+        records[idx] = formatLogRecord(value, id)
+    }
+    segments, err := this.writeSegments(roleId, 0, records)
+    if err != nil { return nil, err }
+    fmt.Println("[ DISK ] Split log", roleId, "into", len(segments), "segments")
+    return segments, this.storage.Remove(legacyLogName(roleId))
+}
+
+// Writes records from the start of the log as consecutive segments of a generation, then the
+// index listing them. exclude MUST be locked
+func (this *Manager) writeSegments(roleId uint64, generation int, records [][]string) ([]segment, error) {
+    var segments []segment = nil
+    for first := 0; first < len(records); first += this.segmentSize {
+        end := first+this.segmentSize
+        if end > len(records) {
+            end = len(records)
+        }
+        err := this.writeSegment(roleId, generation, first, records[first:end])
+        if err != nil { return nil, err }
+        segments = append(segments, segment{first, this.segmentSize})
+    }
+    return segments, this.writeSegmentIndex(roleId, generation, segments)
+}
+
+func (this *Manager) writeSegment(roleId uint64, generation int, first int, records [][]string) error {
+    var buffer bytes.Buffer
+    segmentFileWriter := csv.NewWriter(&buffer)
+    err := segmentFileWriter.WriteAll(records)
+    if err != nil { return err }
+    return this.storage.Write(segmentName(roleId, generation, first), buffer.Bytes())
+}
+
+// Returns the records of a segment of the current generation; a segment never written holds
+// none. exclude MUST be locked
+func (this *Manager) readSegment(roleId uint64, first int) ([][]string, error) {
+    data, err := this.storage.Read(segmentName(roleId, this.generations[roleId], first))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil { return nil, err }
+
+    segmentFileReader := csv.NewReader(bytes.NewReader(data))
+    segmentFileReader.FieldsPerRecord = -1
+    return segmentFileReader.ReadAll()
+}
+
+// Records the segments of the log and its generation, caching them. exclude MUST be locked
+func (this *Manager) writeSegmentIndex(roleId uint64, generation int, segments []segment) error {
+    records := make([][]string, 0, len(segments)+1)
+    if generation != 0 {
+        records = append(records, []string{strconv.Itoa(generation)})
+    }
+    for _, listed := range segments {
+        records = append(records, []string{strconv.Itoa(listed.first), strconv.Itoa(listed.size)})
+    }
+    var buffer bytes.Buffer
+    indexFileWriter := csv.NewWriter(&buffer)
+    err := indexFileWriter.WriteAll(records)
+    if err != nil { return err }
+    err = this.storage.Write(segmentIndexName(roleId), buffer.Bytes())
+    if err != nil { return err }
+    this.segments[roleId] = segments
+    this.generations[roleId] = generation
+    return nil
+}
+
+// Reads every record of the log past the compacted prefix, in order; segments not yet filled
+// are padded with blank records, except the last. Returns the index of the first record
+func (this *Manager) readLogRecords(roleId uint64, compacted int) ([][]string, int, error) {
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return nil, 0, err }
+
+    // Only segments within the compacted prefix are collected, and never the last
+    if len(segments) != 0 && (segments[0].first > compacted+1 || compacted >= segments[len(segments)-1].end()) {
+        return nil, 0, fmt.Errorf("Log %d compacted through entry %d does not match its segments", roleId, compacted)
+    } else if len(segments) == 0 && compacted >= 0 {
+        return nil, 0, fmt.Errorf("Log %d compacted through entry %d has no segments", roleId, compacted)
+    }
+
+    var records [][]string = nil
+    start := compacted+1
+    for position, listed := range segments {
+        if listed.end() <= start { continue }
+        segmentRecords, err := this.readSegment(roleId, listed.first)
+        if err != nil { return nil, 0, err }
+        for position != len(segments)-1 && len(segmentRecords) < listed.size {
+            segmentRecords = append(segmentRecords, formatLogRecord(nil, proposal.Default()))
+        }
+        if listed.first < start {
+            skipped := start-listed.first
+            if skipped > len(segmentRecords) {
+                skipped = len(segmentRecords)
+            }
+            segmentRecords = segmentRecords[skipped:]
+        } else if len(records) == 0 {
+            start = listed.first
+        }
+        records = append(records, segmentRecords...)
+    }
+    return records, start, nil
+}
+
+// Finds the segment holding an entry, listing new segments until one does; reports whether
+// any were listed. exclude MUST be locked
+func (this *Manager) segmentFor(roleId uint64, index int) ([]segment, int, bool, error) {
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return nil, 0, false, err }
+
+    extended := false
+    for len(segments) == 0 || index >= segments[len(segments)-1].end() {
+        first := 0
+        if len(segments) != 0 {
+            first = segments[len(segments)-1].end()
+        }
+        segments = append(segments[:len(segments):len(segments)], segment{first, this.segmentSize})
+        extended = true
+    }
+    if index < segments[0].first {
+        return nil, 0, false, fmt.Errorf("Entry %d of log %d is compacted", index, roleId)
+    }
+    position := sort.Search(len(segments), func(position int) bool { return segments[position].end() > index })
+    return segments, position, extended, nil
+}
+
+// Returns the compacted prefix of a role's log. exclude MUST be locked
+func (this *Manager) readCompaction(roleId uint64) (compaction, error) {
+    compacted := compaction{index: -1, sessions: make(map[string]uint64)}
+    _, err := this.loadSegments(roleId)
+    if err != nil { return compacted, err }
+    data, err := this.storage.Read(compactionName(roleId, this.generations[roleId]))
+    if os.IsNotExist(err) {
+        return compacted, nil
+    } else if err != nil { return compacted, err }
+
+    compactionFileReader := csv.NewReader(bytes.NewReader(data))
+    compactionFileReader.FieldsPerRecord = -1
+    records, err := compactionFileReader.ReadAll()
+    if err != nil { return compacted, err }
+    if len(records) == 0 || len(records[0]) != 3 {
+        return compacted, fmt.Errorf("Invalid compaction record for log %d", roleId)
+    }
+    index, err := strconv.Atoi(records[0][0])
+    if err != nil { return compacted, err }
+    if index < -1 {
+        return compacted, fmt.Errorf("Invalid compaction index %d for log %d", index, roleId)
+    }
+    wall, err := strconv.ParseInt(records[0][1], 10, 64)
+    if err != nil { return compacted, err }
+    logical, err := strconv.ParseUint(records[0][2], 10, 32)
+    if err != nil { return compacted, err }
+    for _, record := range records[1:] {
+        if len(record) != 2 { return compacted, fmt.Errorf("Invalid session record for log %d", roleId) }
+        sequence, err := strconv.ParseUint(record[1], 10, 64)
+        if err != nil { return compacted, err }
+        compacted.sessions[record[0]] = sequence
+    }
+    compacted.index = index
+    compacted.stamp = clock.Timestamp{Wall: wall, Logical: uint32(logical)}
+    return compacted, nil
+}
+
+// Returns the last entry of a role's log covered by a snapshot of the application, or -1 if
+// none, with the timestamp and client sessions in force there
+func (this *Manager) RecoverCompaction(roleId uint64) (int, clock.Timestamp, map[string]uint64, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    compacted, err := this.readCompaction(roleId)
+    return compacted.index, compacted.stamp, compacted.sessions, err
+}
+
+// Records that a snapshot of the application covers the log through index, along with the
+// timestamp and client sessions in force there, so the entries need no longer be kept; the
+// collector then deletes the segments lying entirely within the prefix
+func (this *Manager) CompactLog(roleId uint64, index int, stamp clock.Timestamp, sessions map[string]uint64) error {
+    err := this.writeCompaction(roleId, index, stamp, sessions)
+    if err != nil { return err }
+    select {
+    case this.collect <- roleId:
+    default:
+        // Already scheduled
+    }
+    return nil
+}
+
+func (this *Manager) writeCompaction(roleId uint64, index int, stamp clock.Timestamp, sessions map[string]uint64) (err error) {
+    defer this.complete("compaction", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    records := [][]string{{strconv.Itoa(index), strconv.FormatInt(stamp.Wall, 10), strconv.FormatUint(uint64(stamp.Logical), 10)}}
+    for clientId, sequence := range sessions {
+        records = append(records, []string{clientId, strconv.FormatUint(sequence, 10)})
+    }
+    var buffer bytes.Buffer
+    compactionFileWriter := csv.NewWriter(&buffer)
+    err = compactionFileWriter.WriteAll(records)
+    if err != nil { return err }
+    _, err = this.loadSegments(roleId)
+    if err != nil { return err }
+    return this.storage.Write(compactionName(roleId, this.generations[roleId]), buffer.Bytes())
+}
+
+// Deletes compacted segments in the background, as compactions are recorded
+func (this *Manager) collectSegments() {
+    for roleId := range this.collect {
+        err := this.collectRole(roleId)
+        if err != nil {
+            fmt.Println("[ DISK ] Failed to collect segments of log", roleId, ":", err)
+        }
+    }
+}
+
+// Deletes the segments of a role's log lying entirely within its compacted prefix, then
+// drops them from the index. The last segment is kept, so the index still marks the end of
+// the log; segments deleted but still listed are skipped on recovery
+func (this *Manager) collectRole(roleId uint64) (err error) {
+    defer this.complete("collection", this.begin(), &err)
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    compacted, err := this.readCompaction(roleId)
+    if err != nil { return err }
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return err }
+
+    collected := 0
+    for collected < len(segments)-1 && segments[collected].end() <= compacted.index+1 {
+        err = this.storage.Remove(segmentName(roleId, this.generations[roleId], segments[collected].first))
+        if err != nil { return err }
+        collected++
+    }
+    if collected == 0 {
+        return nil
+    }
+    storageStats.Add("segmentsCollected", int64(collected))
+    fmt.Println("[ DISK ] Collected", collected, "segments of log", roleId, "through entry", compacted.index)
+    return this.writeSegmentIndex(roleId, this.generations[roleId], segments[collected:])
+}
+
+// Reads back the segment of a role's log holding index, returning the index of its first
+// entry, its values, and whether each record verified; values of records which fail are nil
+func (this *Manager) ReadLogSegment(roleId uint64, index int) (int, [][]byte, []bool, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    segments, err := this.loadSegments(roleId)
+    if err != nil { return 0, nil, nil, err }
+    position := sort.Search(len(segments), func(position int) bool { return segments[position].end() > index })
+    if position == len(segments) || index < segments[position].first {
+        return 0, nil, nil, fmt.Errorf("Entry %d of log %d is not stored", index, roleId)
+    }
+
+    first := segments[position].first
+    records, err := this.readSegment(roleId, first)
+    if err != nil { return 0, nil, nil, err }
+    values := make([][]byte, len(records))
+    verified := make([]bool, len(records))
+    for offset, record := range records {
+        value, _, err := parseLogRecord(record)
+        values[offset], verified[offset] = value, err == nil
+    }
+    return first, values, verified, nil
+}
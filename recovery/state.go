@@ -0,0 +1,83 @@
+package recovery
+
+import (
+    "fmt"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/proposal"
+)
+
+// Durable state of a node, as it stood before the node stopped
+type NodeState struct {
+    Values [][]byte
+    AcceptedProposals []proposal.Id
+    CorruptIndices []int
+    MinProposalId proposal.Id
+    ProposalCounter int64
+    Membership []uint64
+    // Members demoted to learners as of the role change applied at LearnersIndex, -1 if none
+    Learners []uint64
+    LearnersIndex int
+    Revocations map[uint64]int
+    // Last entry the application acknowledged applying, or -1 if none
+    AppliedIndex int
+    // Last entry covered by a snapshot of the application, or -1 if none, with the timestamp
+    // and client sessions in force there; Values holds nil for every compacted entry
+    CompactedIndex int
+    CompactedStamp clock.Timestamp
+    Sessions map[string]uint64
+}
+
+// Reconstructs the state of a node from storage; must complete before the node rejoins the
+// cluster. The proposal counter is raised past any of this role's proposals found in the log
+// or promised by its acceptor, in case the node crashed before recording the counter
+func (this *Manager) Recover(roleId uint64) (*NodeState, error) {
+    values, acceptedProposals, corruptIndices, err := this.RecoverLog(roleId)
+    if err != nil { return nil, err }
+    minProposalId, err := this.RecoverMinProposalId(roleId)
+    if err != nil { return nil, err }
+    proposalCounter, err := this.RecoverProposalCounter(roleId)
+    if err != nil { return nil, err }
+    membership, err := this.RecoverMembership(roleId)
+    if err != nil { return nil, err }
+    learnersIndex, learners, err := this.RecoverLearners(roleId)
+    if err != nil { return nil, err }
+    revocations, err := this.RecoverRevocations(roleId)
+    if err != nil { return nil, err }
+    appliedIndex, err := this.RecoverAppliedIndex(roleId)
+    if err != nil { return nil, err }
+    compactedIndex, compactedStamp, sessions, err := this.RecoverCompaction(roleId)
+    if err != nil { return nil, err }
+
+    state := NodeState {
+        Values: values,
+        AcceptedProposals: acceptedProposals,
+        CorruptIndices: corruptIndices,
+        MinProposalId: minProposalId,
+        ProposalCounter: proposalCounter,
+        Membership: membership,
+        Learners: learners,
+        LearnersIndex: learnersIndex,
+        Revocations: revocations,
+        AppliedIndex: appliedIndex,
+        CompactedIndex: compactedIndex,
+        CompactedStamp: compactedStamp,
+        Sessions: sessions,
+    }
+
+    proposalIds := make([]proposal.Id, 0, len(acceptedProposals)+1)
+    proposalIds = append(append(proposalIds, acceptedProposals...), minProposalId)
+    for _, proposalId := range proposalIds {
+        if proposalId.RoleId != roleId || proposalId.Sequence <= 0 { continue }
+        used := proposalId.Sequence >> proposal.RoleIdBits
+        if used > state.ProposalCounter {
+            state.ProposalCounter = used
+        }
+    }
+    if state.ProposalCounter != proposalCounter {
+        fmt.Println("[ RECOVERY", roleId, "] Raised proposal counter from", proposalCounter, "to", state.ProposalCounter)
+    }
+
+    fmt.Println("[ RECOVERY", roleId, "] Recovered", len(values), "log entries,", len(corruptIndices),
+                "corrupt; minimum proposal", minProposalId)
+    return &state, nil
+}
@@ -0,0 +1,71 @@
+package recovery
+
+import (
+    "bytes"
+    "testing"
+    "github/paxoscluster/proposal"
+)
+
+// Builds a manager over files held in memory; a second manager over the same storage sees the
+// files as a restarted process would
+func constructMemoryManager(t *testing.T, storage *MemoryStorage) *Manager {
+    disk, err := ConstructManagerWithStorage(".", storage)
+    if err != nil { t.Fatal(err) }
+    return disk
+}
+
+func TestRecoverRestoresState(t *testing.T) {
+    storage := ConstructMemoryStorage()
+    disk := constructMemoryManager(t, storage)
+    own := proposal.Id{RoleId: 1, Sequence: 7<<proposal.RoleIdBits | 1}
+    other := proposal.Id{RoleId: 2, Sequence: 9<<proposal.RoleIdBits | 2}
+
+    values := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+    ids := []proposal.Id{proposal.Chosen(), proposal.Chosen(), own}
+    if err := disk.WriteLog(1, values, ids); err != nil { t.Fatal(err) }
+    if err := disk.UpdateLogRecord(1, 3, []byte("d"), other); err != nil { t.Fatal(err) }
+    if err := disk.UpdateMinProposalId(1, other); err != nil { t.Fatal(err) }
+    if err := disk.UpdateProposalCounter(1, 2); err != nil { t.Fatal(err) }
+    if err := disk.UpdateAppliedIndex(1, 1); err != nil { t.Fatal(err) }
+
+    state, err := constructMemoryManager(t, storage).Recover(1)
+    if err != nil { t.Fatal(err) }
+    expected := append(values, []byte("d"))
+    if len(state.Values) != len(expected) {
+        t.Fatalf("Recovered %d entries, expected %d", len(state.Values), len(expected))
+    }
+    for index, value := range expected {
+        if !bytes.Equal(state.Values[index], value) {
+            t.Errorf("Entry %d recovered as %q, expected %q", index, state.Values[index], value)
+        }
+    }
+    if state.AcceptedProposals[2] != own || state.AcceptedProposals[3] != other {
+        t.Errorf("Recovered accepted proposals %v", state.AcceptedProposals)
+    }
+    if state.MinProposalId != other {
+        t.Errorf("Recovered minimum proposal %v, expected %v", state.MinProposalId, other)
+    }
+    if state.AppliedIndex != 1 {
+        t.Errorf("Recovered applied index %d, expected 1", state.AppliedIndex)
+    }
+    // The counter was recorded behind a proposal the role had already accepted
+    if state.ProposalCounter != 7 {
+        t.Errorf("Recovered proposal counter %d, expected it raised to 7", state.ProposalCounter)
+    }
+}
+
+func TestRecoverRefusesTruncatedState(t *testing.T) {
+    storage := ConstructMemoryStorage()
+    storage.Write("1/minproposalid.csv", nil)
+    _, err := constructMemoryManager(t, storage).Recover(1)
+    if err == nil {
+        t.Errorf("Recovered a truncated proposal file")
+    }
+
+    storage.Reset()
+    storage.Write("1/proposalcounter.csv", []byte("\n"))
+    _, err = constructMemoryManager(t, storage).Recover(1)
+    if err == nil {
+        t.Errorf("Recovered a truncated proposal counter")
+    }
+}
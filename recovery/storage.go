@@ -0,0 +1,247 @@
+package recovery
+
+import (
+    "os"
+    "sync"
+    "time"
+    "io/ioutil"
+    "path/filepath"
+    "github/paxoscluster/metrics"
+)
+
+var storageStats = metrics.Group("storage")
+
+// Backing store for node state files, addressed by names relative to the storage root
+type Storage interface {
+    // Returns the contents of the named file; a missing file yields an error satisfying os.IsNotExist
+    Read(name string) ([]byte, error)
+    // Replaces the contents of the named file, creating it and its directory as necessary
+    Write(name string, data []byte) error
+    // Deletes the named file; deleting a missing file succeeds
+    Remove(name string) error
+}
+
+// Storage whose writes may not be durable when Write returns
+type SyncedStorage interface {
+    Storage
+    // Returns once every write completed before the call is durable
+    Sync() error
+}
+
+// Stores files in a directory on the local filesystem, syncing them as the policy selects:
+// "always" syncs each write before it returns, "group" defers syncs to Sync, which writers
+// waiting together share, and any other policy leaves writes to the operating system
+type FileStorage struct {
+    directory string
+    policy string
+    group *groupSync
+}
+
+func ConstructFileStorage(directory string, policy string, delay time.Duration) *FileStorage {
+    newStorage := FileStorage{directory, policy, constructGroupSync(delay)}
+    return &newStorage
+}
+
+func (this *FileStorage) Read(name string) ([]byte, error) {
+    return ioutil.ReadFile(filepath.Join(this.directory, name))
+}
+
+func (this *FileStorage) Write(name string, data []byte) error {
+    fileName := filepath.Join(this.directory, name)
+    err := os.MkdirAll(filepath.Dir(fileName), 0700)
+    if err != nil { return err }
+    created, err := writeFile(fileName, data, this.policy == "always")
+    if err != nil { return err }
+    if this.policy == "group" {
+        this.group.dirty(fileName)
+        if created {
+            this.group.dirty(filepath.Dir(fileName))
+        }
+    }
+    return nil
+}
+
+func (this *FileStorage) Remove(name string) error {
+    err := os.Remove(filepath.Join(this.directory, name))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+func (this *FileStorage) Sync() error {
+    if this.policy != "group" {
+        return nil
+    }
+    return this.group.sync()
+}
+
+// Stores files in memory, for simulations and fuzzing which need durable state without a disk
+type MemoryStorage struct {
+    files map[string][]byte
+    exclude sync.Mutex
+}
+
+func ConstructMemoryStorage() *MemoryStorage {
+    newMemoryStorage := MemoryStorage{files: make(map[string][]byte)}
+    return &newMemoryStorage
+}
+
+// Discards every file
+func (this *MemoryStorage) Reset() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.files = make(map[string][]byte)
+}
+
+func (this *MemoryStorage) Read(name string) ([]byte, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    data, exists := this.files[name]
+    if !exists {
+        return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+    }
+    return append([]byte{}, data...), nil
+}
+
+func (this *MemoryStorage) Write(name string, data []byte) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.files[name] = append([]byte{}, data...)
+    return nil
+}
+
+func (this *MemoryStorage) Remove(name string) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    delete(this.files, name)
+    return nil
+}
+
+// Replaces the contents of a file by writing them to a temporary file beside it and renaming
+// that over it, so a crash leaves either the old contents or the new, never a truncated file.
+// If requested, the temporary file is synced before the rename and, when the file is new, the
+// directory after it. Returns whether the file was created
+func writeFile(fileName string, data []byte, sync bool) (bool, error) {
+    _, err := os.Stat(fileName)
+    created := os.IsNotExist(err)
+    file, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp")
+    if err != nil { return false, err }
+    _, err = file.Write(data)
+    if err == nil && sync {
+        storageStats.Add("syncs", 1)
+        err = file.Sync()
+    }
+    closeErr := file.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(file.Name(), fileName)
+    }
+    if err != nil {
+        os.Remove(file.Name())
+        return false, err
+    }
+    if created && sync {
+        err = syncPath(filepath.Dir(fileName))
+    }
+    return created, err
+}
+
+// Syncs a file or directory to disk
+func syncPath(name string) error {
+    file, err := os.Open(name)
+    if err != nil { return err }
+    storageStats.Add("syncs", 1)
+    err = file.Sync()
+    closeErr := file.Close()
+    if err != nil { return err }
+    return closeErr
+}
+
+// Group commit: files written since the last sync are synced together by whichever caller
+// of sync finds none in progress, after waiting up to delay for more writes to join. Callers
+// arriving during a sync wait for the next, as their writes may have missed it
+type groupSync struct {
+    delay time.Duration
+    pending map[string]bool
+    // Batches are numbered; writes join the open batch, and completed counts those synced
+    batch uint64
+    completed uint64
+    syncing bool
+    // A failed sync may have dropped buffered writes, so the failure is reported to every
+    // later caller rather than retried
+    failure error
+    done *sync.Cond
+    exclude sync.Mutex
+}
+
+func constructGroupSync(delay time.Duration) *groupSync {
+    newGroupSync := groupSync {
+        delay: delay,
+        pending: make(map[string]bool),
+    }
+    newGroupSync.done = sync.NewCond(&newGroupSync.exclude)
+    return &newGroupSync
+}
+
+// Adds a written file to the open batch
+func (this *groupSync) dirty(fileName string) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.pending[fileName] = true
+}
+
+// Returns once the open batch, holding every write made before the call, has been synced
+func (this *groupSync) sync() error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    target := this.batch
+    for this.completed <= target && this.failure == nil {
+        if this.syncing {
+            this.done.Wait()
+            continue
+        }
+
+        this.syncing = true
+        if this.delay > 0 {
+            this.exclude.Unlock()
+            time.Sleep(this.delay)
+            this.exclude.Lock()
+        }
+        files := this.pending
+        this.pending = make(map[string]bool)
+        this.batch++
+        this.exclude.Unlock()
+        err := syncFiles(files)
+        this.exclude.Lock()
+
+        this.completed = this.batch
+        if err != nil && this.failure == nil {
+            this.failure = err
+        }
+        this.syncing = false
+        this.done.Broadcast()
+    }
+    return this.failure
+}
+
+// Syncs each file, and each directory gaining a file, to disk; files removed since they were
+// written need no sync
+func syncFiles(files map[string]bool) error {
+    storageStats.Add("groupSyncs", 1)
+    for fileName := range files {
+        err := syncPath(fileName)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil { return err }
+    }
+    return nil
+}
@@ -0,0 +1,84 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "time"
+)
+
+// Wait before retrying an entry whose value could not be read back from disk
+const readRetryInterval = time.Second
+
+// Delivers chosen entries to the apply hooks strictly in index order, each exactly once. The
+// hooks run outside the log lock, so a slow application holds up later entries but never
+// consensus; synchronous callbacks are delivered the next entry once they return
+func (this *Log) applyLoop() {
+    for {
+        this.exclude.Lock()
+        for this.deliveredIndex+1 >= this.firstUnchosenIndex {
+            this.committed.Wait()
+        }
+        this.deliveredIndex++
+        index := this.deliveredIndex
+        // Chunked values are applied once their final chunk is chosen
+        entry, complete, err := this.assemble(index)
+        if err != nil {
+            this.deliveredIndex--
+            this.exclude.Unlock()
+            fmt.Println("[ LOG", this.roleId, "] Retrying entry", index, "for the application:", err)
+            time.Sleep(readRetryInterval)
+            continue
+        }
+        duplicate := complete && !this.sessions.record(entry.Metadata)
+        this.exclude.Unlock()
+
+        if duplicate {
+            fmt.Println("[ LOG", this.roleId, "] Skipping repeated command", entry.Metadata.Sequence, "of client", entry.Metadata.ClientId)
+            sessionStats.Add("duplicates", 1)
+        }
+        if !complete || duplicate {
+            this.acknowledge(index)
+            continue
+        }
+        fmt.Println("[ LOG", this.roleId, "] Emitting finalized value", string(entry.Value))
+        this.events.Deliver(entry, func() { this.acknowledge(index) })
+    }
+}
+
+// Records that the application has applied the entry at index, advancing the applied index
+// past every entry applied without a gap
+func (this *Log) acknowledge(index int) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.acknowledged[index] = true
+    applied := this.appliedIndex
+    for this.acknowledged[applied+1] {
+        delete(this.acknowledged, applied+1)
+        applied++
+    }
+    this.advanceApplied(applied)
+}
+
+// Raises the applied index, recording it durably and waking readers waiting for it. exclude
+// MUST be locked, except while the log is constructed
+func (this *Log) advanceApplied(index int) {
+    if index <= this.appliedIndex { return }
+    this.appliedIndex = index
+    if index != this.recoveredAppliedIndex {
+        err := this.disk.UpdateAppliedIndex(this.roleId, index)
+        if err != nil {
+            fmt.Println("[ LOG", this.roleId, "] Failed to write applied index", index, "to disk")
+        }
+    }
+    this.committed.Broadcast()
+}
+
+// Returns the last entry the application had acknowledged applying when the node last
+// stopped, or -1 if none. Every chosen entry is replayed on recovery; applications keeping
+// durable state may skip those at or below this index
+func (this *Log) GetRecoveredAppliedIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.recoveredAppliedIndex
+}
@@ -0,0 +1,153 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "expvar"
+    "container/list"
+    "github/paxoscluster/metrics"
+)
+
+// Hit rate is hits/lookups over the node's lifetime
+var cacheStats = metrics.Group("entryCache")
+
+func init() {
+    cacheStats.Set("hitRate", expvar.Func(func() interface{} {
+        return metrics.Ratio(cacheStats, "hits", "lookups")
+    }))
+}
+
+// Committed values held in memory, bounded to about capacity bytes by evicting the least
+// recently used; evicted values are read back from disk when next needed. Entries not yet
+// chosen are always held, outside the cache. A capacity of zero holds every value untracked
+type entryCache struct {
+    capacity int
+    size int
+    // Most recently used first
+    order *list.List
+    elements map[int]*list.Element
+}
+
+type cachedEntry struct {
+    index int
+    size int
+}
+
+func constructEntryCache() entryCache {
+    return entryCache{order: list.New(), elements: make(map[int]*list.Element)}
+}
+
+// Marks a held value as most recently used, reporting whether it is held
+func (this *entryCache) touch(index int) bool {
+    element, held := this.elements[index]
+    if held {
+        this.order.MoveToFront(element)
+    }
+    return held
+}
+
+// Holds a value as most recently used, returning the indices evicted to make room
+func (this *entryCache) add(index int, size int) []int {
+    if this.touch(index) {
+        return nil
+    }
+    this.elements[index] = this.order.PushFront(cachedEntry{index, size})
+    this.size += size
+    return this.trim()
+}
+
+// Stops holding a value
+func (this *entryCache) remove(index int) {
+    element, held := this.elements[index]
+    if !held { return }
+    this.order.Remove(element)
+    delete(this.elements, index)
+    this.size -= element.Value.(cachedEntry).size
+}
+
+// Evicts the least recently used values until the cache fits its capacity, keeping at least
+// the most recent
+func (this *entryCache) trim() []int {
+    var evicted []int = nil
+    for this.size > this.capacity && this.order.Len() > 1 {
+        entry := this.order.Back().Value.(cachedEntry)
+        this.remove(entry.index)
+        evicted = append(evicted, entry.index)
+    }
+    return evicted
+}
+
+// Bounds the memory held by committed values to about size bytes, reading evicted values back
+// from disk as they are needed; zero, the default, holds every value
+func (this *Log) LimitCache(size uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.cache.capacity = int(size)
+    if size == 0 {
+        this.cache = constructEntryCache()
+        return
+    }
+    for index := this.compactedIndex+1; index < this.firstUnchosenIndex; index++ {
+        this.cacheValue(index)
+    }
+    this.evict(this.cache.trim())
+}
+
+// Reports whether an entry's value belongs in the cache: it is committed and not compacted,
+// and the cache is bounded. exclude MUST be locked
+func (this *Log) cacheable(index int) bool {
+    return this.cache.capacity != 0 && index > this.compactedIndex && index < this.firstUnchosenIndex
+}
+
+// Holds a newly committed value in the cache. exclude MUST be locked
+func (this *Log) cacheValue(index int) {
+    if !this.cacheable(index) { return }
+    this.evict(this.cache.add(index, len(this.values[index])))
+}
+
+// Drops evicted values from memory. exclude MUST be locked
+func (this *Log) evict(indices []int) {
+    for _, index := range indices {
+        this.values[index] = nil
+    }
+    cacheStats.Add("evictions", int64(len(indices)))
+}
+
+// Reports whether the value of an entry is in memory. exclude MUST be locked
+func (this *Log) resident(index int) bool {
+    return !this.cacheable(index) || this.cache.elements[index] != nil
+}
+
+// Returns the value of the entry at index, reading it and the values after it in its segment
+// back from disk if it was evicted. A value which cannot be read is left evicted, so a later
+// lookup retries the read. exclude MUST be locked
+func (this *Log) valueAt(index int) ([]byte, error) {
+    if !this.cacheable(index) {
+        return this.values[index], nil
+    }
+    cacheStats.Add("lookups", 1)
+    if this.cache.touch(index) {
+        cacheStats.Add("hits", 1)
+        return this.values[index], nil
+    }
+
+    cacheStats.Add("misses", 1)
+    first, values, verified, err := this.disk.ReadLogSegment(this.roleId, index)
+    if err == nil && (index < first || index-first >= len(values) || !verified[index-first]) {
+        err = fmt.Errorf("record is missing or corrupt")
+    }
+    if err != nil {
+        cacheStats.Add("readErrors", 1)
+        return nil, fmt.Errorf("Failed to read committed entry %d from disk: %v", index, err)
+    }
+    value := values[index-first]
+    for offset := len(values)-1; offset > index-first; offset-- {
+        if verified[offset] && this.cacheable(first+offset) && !this.resident(first+offset) {
+            this.values[first+offset] = values[offset]
+            this.cacheValue(first+offset)
+        }
+    }
+    this.values[index] = value
+    this.cacheValue(index)
+    return value, nil
+}
@@ -0,0 +1,126 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "bytes"
+    "strings"
+)
+
+// Marks a log entry holding one piece of a value too large for a single entry
+var chunkMarker = []byte("\x00chunk ")
+
+// Splits a value into linked chunks of at most size bytes, each proposed as its own log
+// entry; values within the limit, or a size of zero, yield the value unchanged
+func SplitValue(value []byte, valueId string, size int) [][]byte {
+    if size <= 0 || len(value) <= size {
+        return [][]byte{value}
+    }
+
+    count := (len(value)+size-1)/size
+    chunks := make([][]byte, 0, count)
+    for sequence := 0; sequence < count; sequence++ {
+        end := (sequence+1)*size
+        if end > len(value) {
+            end = len(value)
+        }
+        header := fmt.Sprintf("%s%s %d %d\n", chunkMarker, valueId, sequence, count)
+        chunks = append(chunks, append([]byte(header), value[sequence*size:end]...))
+    }
+    return chunks
+}
+
+// Reports whether a value begins with the marker of a chunk; clients may not propose such
+// values, which would be taken as a piece of another value, or held back forever
+func IsChunk(value []byte) bool {
+    return bytes.HasPrefix(value, chunkMarker)
+}
+
+// Reassembles chunked values as their entries are chosen. Value identifiers name the role and
+// launch of the proposer splitting them; once a role's proposer is seen relaunched, its
+// earlier launch can no longer complete the values it left pending, so they are dropped along
+// with any of their chunks chosen afterward
+type assembler struct {
+    pending map[string]*chunkedValue
+    // Latest launch seen of each role, and those since replaced
+    launches map[string]string
+    retired map[string]bool
+}
+
+// Chunks of a value received so far; they are kept by sequence rather than in a slice of the
+// declared count, which a malformed header could make arbitrarily large
+type chunkedValue struct {
+    count int
+    chunks map[int][]byte
+}
+
+func constructAssembler() assembler {
+    return assembler{make(map[string]*chunkedValue), make(map[string]string), make(map[string]bool)}
+}
+
+// Records the launch naming a value, retiring the role's previous launch; returns false if the
+// value belongs to a retired launch
+func (this *assembler) observeLaunch(valueId string) bool {
+    parts := strings.SplitN(valueId, ".", 3)
+    if len(parts) != 3 {
+        return true
+    }
+    role, launch := parts[0], parts[0]+"."+parts[1]
+    if this.retired[launch] {
+        return false
+    }
+    current, seen := this.launches[role]
+    if seen && current != launch {
+        this.retired[current] = true
+        for pendingId := range this.pending {
+            if strings.HasPrefix(pendingId, current+".") {
+                delete(this.pending, pendingId)
+            }
+        }
+    }
+    this.launches[role] = launch
+    return true
+}
+
+// Accepts the next chosen entry; returns the complete value once all of its chunks are present
+func (this *assembler) add(entry []byte) ([]byte, bool) {
+    if !bytes.HasPrefix(entry, chunkMarker) {
+        return entry, true
+    }
+
+    separator := bytes.IndexByte(entry, '\n')
+    if separator < 0 {
+        return entry, true
+    }
+    var valueId string
+    var sequence, count int
+    _, err := fmt.Sscanf(string(entry[len(chunkMarker):separator]), "%s %d %d", &valueId, &sequence, &count)
+    if err != nil || sequence < 0 || sequence >= count {
+        return entry, true
+    }
+
+    if !this.observeLaunch(valueId) {
+        return nil, false
+    }
+
+    chunked, exists := this.pending[valueId]
+    if exists && chunked.count != count {
+        return entry, true
+    }
+    if !exists {
+        chunked = &chunkedValue{count, make(map[int][]byte)}
+        this.pending[valueId] = chunked
+    }
+    if len(entry) > separator+1 {
+        chunked.chunks[sequence] = entry[separator+1:]
+    }
+
+    if len(chunked.chunks) < count {
+        return nil, false
+    }
+    delete(this.pending, valueId)
+    chunks := make([][]byte, count)
+    for sequence, chunk := range chunked.chunks {
+        chunks[sequence] = chunk
+    }
+    return bytes.Join(chunks, nil), true
+}
@@ -0,0 +1,85 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "github/paxoscluster/journal"
+)
+
+// Discards the entries through index, which a snapshot of the application now covers, so
+// that storage stays bounded: the disk's collector deletes the log segments lying within the
+// prefix. The index is lowered so no chunked value is split. Compacted entries are no longer
+// replayed on recovery, nor served to peers, learners, or readers; the session table and the
+// agreed timestamp in force at the index are kept. Returns the last entry compacted
+func (this *Log) Compact(index int) (int, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if index > this.appliedIndex {
+        return this.compactedIndex, fmt.Errorf("Entry %d is not applied; applied through %d", index, this.appliedIndex)
+    }
+
+    // Stops before the first chunk of any value still incomplete at the index
+    settled := this.compactedIndex
+    chunks := constructAssembler()
+    for current := this.compactedIndex+1; current <= index; current++ {
+        value, err := this.valueAt(current)
+        if err != nil { return this.compactedIndex, err }
+        chunks.add(value)
+        if len(chunks.pending) == 0 {
+            settled = current
+        }
+    }
+    if settled <= this.compactedIndex {
+        return this.compactedIndex, nil
+    }
+
+    sessions := make(sessionTable)
+    for clientId, sequence := range this.compactedSessions {
+        sessions[clientId] = sequence
+    }
+    chunks = constructAssembler()
+    stamp := this.compactedStamp
+    for current := this.compactedIndex+1; current <= settled; current++ {
+        value, err := this.valueAt(current)
+        if err != nil { return this.compactedIndex, err }
+        value, complete := chunks.add(value)
+        if complete && len(value) != 0 {
+            _, metadata := SplitMetadata(value)
+            sessions.record(metadata)
+        }
+        if agreed, stamped := this.stamps[current]; stamped {
+            stamp = agreed
+        }
+    }
+
+    err := this.disk.CompactLog(this.roleId, settled, stamp, sessions)
+    if err != nil { return this.compactedIndex, err }
+    for current := this.compactedIndex+1; current <= settled; current++ {
+        this.values[current] = nil
+        this.cache.remove(current)
+        delete(this.stamps, current)
+    }
+    this.compactedIndex = settled
+    this.compactedStamp = stamp
+    this.compactedSessions = sessions
+    this.journal.Record(this.roleId, journal.Compacted, "Compacted entries through %d", settled)
+    fmt.Println("[ LOG", this.roleId, "] Compacted entries through", settled)
+    return settled, nil
+}
+
+// Returns the last entry discarded by compaction, or -1 if none
+func (this *Log) GetCompactedIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.compactedIndex
+}
+
+// Reports whether the entry at index has been discarded by compaction; compacted entries are
+// chosen, but their values are gone, so they must not be served
+func (this *Log) IsCompacted(index int) bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return index <= this.compactedIndex
+}
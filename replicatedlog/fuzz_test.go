@@ -0,0 +1,39 @@
+package replicatedlog
+
+import (
+    "bytes"
+    "testing"
+    "github/paxoscluster/hooks"
+)
+
+// Reads data as a snapshot stream, as received from a peer or an archive, which may be
+// refused but must not panic
+func FuzzSnapshot(f *testing.F) {
+    var snapshot bytes.Buffer
+    err := WriteSnapshot(&snapshot, &Snapshot{Index: 1, Membership: map[uint64]string{1: "localhost:10000"}, Entries: [][]byte{[]byte("a"), []byte("b")}})
+    if err != nil { f.Fatal(err) }
+    f.Add(snapshot.Bytes())
+    f.Add([]byte(snapshotMagic))
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        ReadSnapshot(bytes.NewReader(data))
+    })
+}
+
+// Splits data at each 0xffff into chosen entries, which are reassembled from their chunks and
+// split from their metadata as they are applied
+func FuzzValues(f *testing.F) {
+    chunks := SplitValue([]byte("abcdefgh"), "1.1.1", 3)
+    chunks = append(chunks, WrapMetadata([]byte("c"), hooks.Metadata{ClientId: "client", Sequence: 1}))
+    f.Add(bytes.Join(chunks, []byte{0xff, 0xff}))
+    f.Add([]byte("plain"))
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        chunks := constructAssembler()
+        for _, entry := range bytes.Split(data, []byte{0xff, 0xff}) {
+            value, complete := chunks.add(entry)
+            if !complete { continue }
+            SplitMetadata(value)
+        }
+    })
+}
@@ -0,0 +1,86 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "bytes"
+    "strings"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/proposal"
+)
+
+// Shadow of the log state which must only move forward, kept when invariants are checked
+type invariants struct {
+    promised proposal.Id
+    chosen map[int][]byte
+}
+
+// Checks core Paxos invariants after every change to the log: the promise never regresses,
+// no proposal is accepted below the promise, and a chosen value never changes. A violation
+// dumps the log and stops the node
+func (this *Log) EnableInvariants() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.invariants = &invariants {
+        promised: this.minProposalId,
+        chosen: make(map[int][]byte),
+    }
+    this.checkInvariants("EnableInvariants")
+}
+
+// Verifies the log against its shadow, then advances the shadow; exclude MUST be locked
+func (this *Log) checkInvariants(operation string) {
+    if this.invariants == nil { return }
+
+    if this.invariants.promised.IsGreaterThan(this.minProposalId) {
+        this.violation(operation, "promise regressed from %v to %v", this.invariants.promised, this.minProposalId)
+    }
+    this.invariants.promised = this.minProposalId
+
+    for index, proposalId := range this.acceptedProposals {
+        if proposalId != proposal.Chosen() || !this.resident(index) { continue }
+        if index <= this.compactedIndex {
+            delete(this.invariants.chosen, index)
+            continue
+        }
+        chosen, known := this.invariants.chosen[index]
+        if known && !bytes.Equal(chosen, this.values[index]) {
+            this.violation(operation, "chosen value of entry %d changed from %q to %q", index, chosen, this.values[index])
+        }
+        if !known {
+            this.invariants.chosen[index] = append([]byte{}, this.values[index]...)
+        }
+    }
+    for index := range this.invariants.chosen {
+        if index <= this.compactedIndex { continue }
+        if index >= len(this.acceptedProposals) || this.acceptedProposals[index] != proposal.Chosen() {
+            this.violation(operation, "entry %d is no longer chosen", index)
+        }
+    }
+}
+
+// Verifies a proposal being accepted is not below the promise; exclude MUST be locked
+func (this *Log) checkAccepted(index int, proposalId proposal.Id) {
+    if this.invariants == nil || proposalId == proposal.Chosen() { return }
+    if this.minProposalId.IsGreaterThan(proposalId) {
+        this.violation("SetEntryAt", "accepted proposal %v for entry %d below promise %v", proposalId, index, this.minProposalId)
+    }
+}
+
+// Dumps the log and stops the node; exclude MUST be locked
+func (this *Log) violation(operation string, format string, args ...interface{}) {
+    message := fmt.Sprintf("[ LOG %d ] Invariant violated in %s: %s", this.roleId, operation, fmt.Sprintf(format, args...))
+
+    var dump strings.Builder
+    fmt.Fprintln(&dump, message)
+    fmt.Fprintln(&dump, "  minProposalId:", this.minProposalId)
+    fmt.Fprintln(&dump, "  firstUnchosenIndex:", this.firstUnchosenIndex)
+    fmt.Fprintln(&dump, "  appliedIndex:", this.appliedIndex)
+    fmt.Fprintln(&dump, "  corrupt:", this.corrupt)
+    for index, proposalId := range this.acceptedProposals {
+        fmt.Fprintf(&dump, "  entry %d: proposal %v value %q\n", index, proposalId, this.values[index])
+    }
+    fmt.Print(dump.String())
+
+    panic(guard.Fatal{Message: message})
+}
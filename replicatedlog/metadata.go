@@ -0,0 +1,48 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "github/paxoscluster/hooks"
+)
+
+// Marks a log entry whose value is preceded by a line of metadata
+var metadataMarker = []byte("\x00meta ")
+
+// Prefixes a value with its metadata, before it is split into chunks
+func WrapMetadata(value []byte, metadata hooks.Metadata) []byte {
+    header := fmt.Sprintf("%s%d %q %q %d %d\n", metadataMarker, metadata.Hlc().Wall, metadata.ClientId, metadata.TraceId, metadata.Logical, metadata.Sequence)
+    return append([]byte(header), value...)
+}
+
+// Reports whether a value begins with the marker of metadata; clients may not propose such
+// values, whose header would be taken for the metadata of the value
+func HasMetadata(value []byte) bool {
+    return bytes.HasPrefix(value, metadataMarker)
+}
+
+// Separates a complete value from its metadata; values proposed without metadata, or whose
+// header is malformed, are returned unchanged with none. Headers written before timestamps
+// carried a logical counter lack it, and those written before commands were numbered lack a
+// sequence
+func SplitMetadata(value []byte) ([]byte, hooks.Metadata) {
+    var metadata hooks.Metadata
+    if !bytes.HasPrefix(value, metadataMarker) {
+        return value, metadata
+    }
+
+    separator := bytes.IndexByte(value, '\n')
+    if separator < 0 {
+        return value, metadata
+    }
+    var timestamp int64
+    parsed, _ := fmt.Sscanf(string(value[len(metadataMarker):separator]), "%d %q %q %d %d", &timestamp, &metadata.ClientId, &metadata.TraceId, &metadata.Logical, &metadata.Sequence)
+    if parsed < 3 {
+        return value, hooks.Metadata{}
+    }
+    if timestamp != 0 {
+        metadata.Timestamp = time.Unix(0, timestamp)
+    }
+    return value[separator+1:], metadata
+}
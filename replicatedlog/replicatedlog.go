@@ -3,46 +3,97 @@ package replicatedlog
 import (
     "fmt"
     "sync"
+    "context"
+    "hash/crc32"
+    "github/paxoscluster/clock"
     "github/paxoscluster/proposal"
     "github/paxoscluster/recovery"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/journal"
 )
 
 type Log struct {
     roleId uint64
-    values []string
+    values [][]byte
     acceptedProposals []proposal.Id
     minProposalId proposal.Id
     firstUnchosenIndex int
+    // Last entry handed to the apply loop's callbacks, and last entry they acknowledged
+    // applying along with every entry before it; acknowledged holds those acknowledged early
+    deliveredIndex int
+    appliedIndex int
+    acknowledged map[int]bool
+    recoveredAppliedIndex int
+    corrupt map[int]bool
     disk *recovery.Manager
+    chunks assembler
+    stamps map[int]clock.Timestamp
+    lastStamp clock.Timestamp
+    sessions sessionTable
+    // Last entry discarded by compaction, with the agreed timestamp and sessions in force there
+    compactedIndex int
+    compactedStamp clock.Timestamp
+    compactedSessions sessionTable
+    cache entryCache
+    events *hooks.Hooks
+    journal *journal.Journal
+    committed *sync.Cond
+    invariants *invariants
+    transitions *trace.TransitionRecorder
     exclude sync.Mutex
 }
 
 type LogEntry struct {
     Index int
-    Value string
+    Value []byte
     AcceptedProposalId proposal.Id
 }
 
-// Creates a new replicated log instance, using data from cold storage files
-func ConstructLog(roleId uint64, disk *recovery.Manager) (*Log, error) {
-    values, acceptedProposals, err := disk.RecoverLog(roleId)
-    if err != nil { return nil, err }
-
-    minProposalId, err := disk.RecoverMinProposalId(roleId)
-    if err != nil { return nil, err }
+// Creates a new replicated log instance from state recovered from cold storage files
+func ConstructLog(roleId uint64, state *recovery.NodeState, disk *recovery.Manager, events *hooks.Hooks) *Log {
+    corrupt := make(map[int]bool)
+    for _, index := range state.CorruptIndices {
+        corrupt[index] = true
+    }
 
     newLog := Log {
         roleId: roleId,
-        values: values,
-        acceptedProposals: acceptedProposals,
-        minProposalId: minProposalId,
+        values: state.Values,
+        acceptedProposals: state.AcceptedProposals,
+        minProposalId: state.MinProposalId,
         firstUnchosenIndex: 0,
+        deliveredIndex: -1,
+        appliedIndex: -1,
+        acknowledged: make(map[int]bool),
+        recoveredAppliedIndex: state.AppliedIndex,
+        corrupt: corrupt,
         disk: disk,
+        chunks: constructAssembler(),
+        stamps: make(map[int]clock.Timestamp),
+        lastStamp: state.CompactedStamp,
+        sessions: make(sessionTable),
+        compactedIndex: state.CompactedIndex,
+        compactedStamp: state.CompactedStamp,
+        compactedSessions: make(sessionTable),
+        cache: constructEntryCache(),
+        events: events,
     }
+    for clientId, sequence := range state.Sessions {
+        newLog.sessions[clientId] = sequence
+        newLog.compactedSessions[clientId] = sequence
+    }
+    newLog.committed = sync.NewCond(&newLog.exclude)
 
-
+    newLog.replay()
     newLog.updateFirstUnchosenIndex()
-    return &newLog, nil
+    go newLog.applyLoop()
+    return &newLog
+}
+
+// Records compactions of the log; must be set before the log is shared
+func (this *Log) SetJournal(events *journal.Journal) {
+    this.journal = events
 }
 
 // Returns the minimum proposal for this log; all lesser proposals should be rejected
@@ -64,7 +115,9 @@ func (this *Log) UpdateMinProposalId(proposalId proposal.Id) proposal.Id {
         if err != nil {
             fmt.Println("[ LOG", this.roleId, "] Failed to write minProposalId update to disk")
         }
+        this.transitions.Record(this.roleId, trace.PromiseAction, -1, proposalId.Sequence, nil)
     }
+    this.checkInvariants("UpdateMinProposalId")
 
     return this.minProposalId
 }
@@ -77,16 +130,62 @@ func (this *Log) GetFirstUnchosenIndex() int {
     return this.firstUnchosenIndex
 }
 
+// Returns the index of the last entry in the contiguous chosen prefix of the log, or -1 if none
+func (this *Log) GetCommitIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.firstUnchosenIndex-1
+}
+
+// Returns the index of the last entry the application has applied, along with every entry
+// before it, or -1 if none
+func (this *Log) GetAppliedIndex() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.appliedIndex
+}
+
+// Blocks until the entry at the specified index has been applied locally, or the context ends
+func (this *Log) WaitForIndex(ctx context.Context, index int) error {
+    // Wakes the wait below when the context ends
+    finished := make(chan bool)
+    defer close(finished)
+    go func() {
+        select {
+        case <- ctx.Done():
+            this.exclude.Lock()
+            this.committed.Broadcast()
+            this.exclude.Unlock()
+        case <- finished:
+        }
+    }()
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    for this.appliedIndex < index {
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        this.committed.Wait()
+    }
+    return nil
+}
+
 // Updates the location of the first unchosen index; exclude MUST be locked before calling
 func (this *Log) updateFirstUnchosenIndex() {
     limit := len(this.acceptedProposals)
+    defer this.committed.Broadcast()
 
     for idx := this.firstUnchosenIndex; idx < limit; idx++ {
         if this.acceptedProposals[idx] != proposal.Chosen() {
             this.firstUnchosenIndex = idx
             return
         } else {
-            this.emit(idx)
+            this.events.Commit(idx, this.values[idx])
+            this.firstUnchosenIndex = idx+1
+            this.cacheValue(idx)
         }
     }
 
@@ -98,6 +197,13 @@ func (this *Log) NoMoreAcceptedPast(index int) bool {
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
+    // Corrupt entries may have held accepted proposals
+    for corruptIndex := range this.corrupt {
+        if corruptIndex > index {
+            return false
+        }
+    }
+
     if index+1 >= len(this.acceptedProposals) {
         return true
     }
@@ -119,6 +225,7 @@ func (this *Log) MarkAsAccepted(proposalId proposal.Id, upto int) {
     for idx := this.firstUnchosenIndex; idx < len(this.acceptedProposals) && idx < upto; idx++ {
         if this.acceptedProposals[idx] == proposalId {
             this.acceptedProposals[idx] = proposal.Chosen()
+            this.exportAccepted(trace.ChooseAction, idx, this.values[idx], proposal.Chosen())
             err := this.disk.UpdateLogRecord(this.roleId, idx, this.values[idx], proposal.Chosen())
             if err != nil {
                 fmt.Println("[ LOG", this.roleId, "] Failed to write", proposalId, idx, "choice to disk")
@@ -127,29 +234,33 @@ func (this *Log) MarkAsAccepted(proposalId proposal.Id, upto int) {
     }
 
     this.updateFirstUnchosenIndex()
+    this.checkInvariants("MarkAsAccepted")
 }
 
-// Returns details of the log entry at the specified index
-func (this *Log) GetEntryAt(index int) LogEntry {
+// Returns details of the log entry at the specified index, failing if its value was evicted
+// from memory and cannot be read back from disk
+func (this *Log) GetEntryAt(index int) (LogEntry, error) {
     this.exclude.Lock()
     defer this.exclude.Unlock()
 
     entry := LogEntry {
         Index: index,
-        Value: "",
+        Value: nil,
         AcceptedProposalId: proposal.Default(),
     }
 
     if index < len(this.values) && index < len(this.acceptedProposals) {
-        entry.Value = this.values[index]
+        value, err := this.valueAt(index)
+        if err != nil { return entry, err }
+        entry.Value = value
         entry.AcceptedProposalId = this.acceptedProposals[index]
     } 
 
-    return entry
+    return entry, nil
 }
 
 // Sets the value of the log entry at the specified index
-func (this *Log) SetEntryAt(index int, value string, proposalId proposal.Id) {
+func (this *Log) SetEntryAt(index int, value []byte, proposalId proposal.Id) {
     this.exclude.Lock()
     defer this.exclude.Unlock()
     
@@ -157,20 +268,26 @@ func (this *Log) SetEntryAt(index int, value string, proposalId proposal.Id) {
     if index >= len(this.values) || index >= len(this.acceptedProposals) {
         valuesDiff := index-len(this.values)+1
         proposalsDiff := index-len(this.acceptedProposals)+1
-        this.values = append(this.values, make([]string, valuesDiff)...)
+        this.values = append(this.values, make([][]byte, valuesDiff)...)
         this.acceptedProposals = append(this.acceptedProposals, make([]proposal.Id, proposalsDiff)...)
     } 
 
     if this.acceptedProposals[index] != proposal.Chosen() &&
         (proposalId.IsGreaterThan(this.minProposalId) ||
         proposalId == this.minProposalId) {
+        this.checkAccepted(index, proposalId)
         this.values[index] = value 
         this.acceptedProposals[index] = proposalId
-        fmt.Println("[ LOG", this.roleId, "] Values:", this.values)
+        this.exportAccepted(trace.AcceptAction, index, value, proposalId)
+        if proposalId == proposal.Chosen() && this.corrupt[index] {
+            fmt.Println("[ LOG", this.roleId, "] Repaired corrupt entry", index)
+            delete(this.corrupt, index)
+        }
+        fmt.Println("[ LOG", this.roleId, "] Values:", fmt.Sprintf("%q", this.values))
         fmt.Println("[ LOG", this.roleId, "] Proposals:", this.acceptedProposals)
         err := this.disk.UpdateLogRecord(this.roleId, index, value, proposalId)
         if err != nil {
-            fmt.Println("[ LOG", this.roleId, "] Failed to write", proposalId, index, value, "to disk")
+            fmt.Println("[ LOG", this.roleId, "] Failed to write", proposalId, index, string(value), "to disk")
         }
     }
 
@@ -178,9 +295,178 @@ func (this *Log) SetEntryAt(index int, value string, proposalId proposal.Id) {
     if proposalId == proposal.Chosen() && this.firstUnchosenIndex == index {
         this.updateFirstUnchosenIndex()
     }
+    this.checkInvariants("SetEntryAt")
+}
+
+// Accepts a value proposed without a prepare phase, under the owned proposal of a Mencius slot
+// or the fast proposal, which lie below the promise; the acceptor instead guards such slots by
+// revoking them. Fails if the entry is chosen or holds a value accepted under any other proposal
+func (this *Log) SetOwnedEntryAt(index int, value []byte, proposalId proposal.Id) bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if index < len(this.acceptedProposals) {
+        accepted := this.acceptedProposals[index]
+        if accepted != proposal.Default() && accepted != proposalId {
+            return false
+        }
+    } else {
+        valuesDiff := index-len(this.values)+1
+        proposalsDiff := index-len(this.acceptedProposals)+1
+        this.values = append(this.values, make([][]byte, valuesDiff)...)
+        this.acceptedProposals = append(this.acceptedProposals, make([]proposal.Id, proposalsDiff)...)
+    }
+
+    this.values[index] = value
+    this.acceptedProposals[index] = proposalId
+    this.exportAccepted(trace.AcceptOwnedAction, index, value, proposalId)
+    err := this.disk.UpdateLogRecord(this.roleId, index, value, proposalId)
+    if err != nil {
+        fmt.Println("[ LOG", this.roleId, "] Failed to write", proposalId, index, string(value), "to disk")
+    }
+    this.checkInvariants("SetOwnedEntryAt")
+    return true
+}
+
+// Returns the number of entries in the log, chosen or not
+func (this *Log) GetLength() int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return len(this.acceptedProposals)
 }
 
-// Emits chosen value to the registered callback function (currently just print to console)
-func (this *Log) emit(index int) {
-    fmt.Println("[ LOG", this.roleId, "] Emitting finalized value", this.values[index])
+// Reports whether the entry at the specified index failed checksum verification on recovery;
+// corrupt entries must not be served until replaced by a chosen value
+func (this *Log) IsCorrupt(index int) bool {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.corrupt[index]
+}
+
+// Returns the indices of all entries which failed checksum verification
+func (this *Log) GetCorruptIndices() []int {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    var indices []int = nil
+    for index := range this.corrupt {
+        indices = append(indices, index)
+    }
+    return indices
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Returns the CRC32C of a value, sent alongside values transferred between nodes
+func Checksum(value []byte) uint32 {
+    return crc32.Checksum(value, castagnoli)
+}
+
+// Returns the complete value finalized by the chosen entry at index, if any, separated from
+// its metadata. Empty values are never replicated by clients, and only fill skipped slots. A
+// value which cannot be read leaves the assembled chunks as they were
+func (this *Log) assemble(index int) (hooks.Entry, bool, error) {
+    chunk, err := this.valueAt(index)
+    if err != nil { return hooks.Entry{}, false, err }
+    value, complete := this.chunks.add(chunk)
+    if !complete || len(value) == 0 {
+        return hooks.Entry{}, false, nil
+    }
+    value, metadata := SplitMetadata(value)
+    metadata = this.agreeStamp(index, metadata)
+    return hooks.Entry{Index: index, Value: value, Metadata: metadata}, true, nil
+}
+
+// Settles the timestamp of the complete value at index. Values are stamped before they are
+// ordered, so a stamp not after the one before it is raised just past it; every node applies
+// the same rule to the same log, and so agrees on the stamps. exclude MUST be locked
+func (this *Log) agreeStamp(index int, metadata hooks.Metadata) hooks.Metadata {
+    stamp := metadata.Hlc()
+    if stamp == (clock.Timestamp{}) {
+        return metadata
+    }
+    if !this.lastStamp.Before(stamp) {
+        stamp = this.lastStamp.Next()
+        metadata.Timestamp, metadata.Logical = stamp.Time(), stamp.Logical
+    }
+    this.lastStamp = stamp
+    this.stamps[index] = stamp
+    return metadata
+}
+
+// Returns the timestamp agreed for the last stamped value applied, zero if none
+func (this *Log) GetLastStamp() clock.Timestamp {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.lastStamp
+}
+
+// Separates the value of a committed entry from its metadata, reporting the timestamp agreed
+// for it rather than the one its proposer stamped. Chunks of large values are returned as stored
+func (this *Log) SplitEntry(entry LogEntry) CommittedEntry {
+    value, metadata := SplitMetadata(entry.Value)
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if stamp, stamped := this.stamps[entry.Index]; stamped {
+        metadata.Timestamp, metadata.Logical = stamp.Time(), stamp.Logical
+    }
+    return CommittedEntry{Index: entry.Index, Value: value, Metadata: metadata}
+}
+
+// Emits the chosen prefix recovered from disk, delivering it to the apply hooks as a single
+// batch so it can be replayed in parallel
+func (this *Log) replay() {
+    var entries []hooks.Entry = nil
+    index := 0
+    for ; index < len(this.acceptedProposals) && this.acceptedProposals[index] == proposal.Chosen(); index++ {
+        if index <= this.compactedIndex { continue }
+        this.events.Commit(index, this.values[index])
+        // Every value is held in memory until the cache is limited, so none needs reading
+        entry, complete, _ := this.assemble(index)
+        if complete && this.sessions.record(entry.Metadata) {
+            entries = append(entries, entry)
+        }
+    }
+    this.firstUnchosenIndex = index
+    this.deliveredIndex = index-1
+
+    fmt.Println("[ LOG", this.roleId, "] Replaying", len(entries), "recovered values")
+    this.events.ApplyRecovered(entries)
+    this.advanceApplied(index-1)
+}
+
+// Returns the entries from index from up to but excluding index to. If verifyCommitted is set,
+// fails unless every entry in the range has been chosen; otherwise entries may hold accepted
+// values which are not yet chosen. Corrupt and compacted entries are never returned
+func (this *Log) ReadEntries(from int, to int, verifyCommitted bool) ([]LogEntry, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if from < 0 || to < from {
+        return nil, fmt.Errorf("Invalid range %d to %d", from, to)
+    }
+    if to > len(this.acceptedProposals) {
+        return nil, fmt.Errorf("Range %d to %d exceeds log length %d", from, to, len(this.acceptedProposals))
+    }
+    if verifyCommitted && to > this.firstUnchosenIndex {
+        return nil, fmt.Errorf("Range %d to %d is not committed; first unchosen index is %d", from, to, this.firstUnchosenIndex)
+    }
+    if from <= this.compactedIndex && to > from {
+        return nil, fmt.Errorf("Entries through %d are compacted", this.compactedIndex)
+    }
+
+    entries := make([]LogEntry, 0, to-from)
+    for index := from; index < to; index++ {
+        if this.corrupt[index] {
+            return nil, fmt.Errorf("Entry %d is corrupt", index)
+        }
+        value, err := this.valueAt(index)
+        if err != nil { return nil, err }
+        entries = append(entries, LogEntry{index, value, this.acceptedProposals[index]})
+    }
+    return entries, nil
 }
@@ -0,0 +1,48 @@
+package replicatedlog
+
+import (
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/metrics"
+)
+
+var sessionStats = metrics.Group("sessions")
+
+// Highest command applied for each client. Clients number their commands from 1, have one
+// outstanding at a time, and retry a command under its number; a command numbered at or below
+// its client's entry repeats one already applied, possibly chosen under an earlier leader, and
+// is not applied again. The table is a function of the log alone, so every replica agrees on it
+type sessionTable map[string]uint64
+
+// Records a complete command, reporting false if it repeats one already recorded. Commands
+// without a client or a number are never duplicates
+func (this sessionTable) record(metadata hooks.Metadata) bool {
+    if len(metadata.ClientId) == 0 || metadata.Sequence == 0 {
+        return true
+    }
+    if metadata.Sequence <= this[metadata.ClientId] {
+        return false
+    }
+    this[metadata.ClientId] = metadata.Sequence
+    return true
+}
+
+// Builds the session table of a committed prefix of the log
+func buildSessions(values [][]byte) sessionTable {
+    sessions := make(sessionTable)
+    chunks := constructAssembler()
+    for _, value := range values {
+        value, complete := chunks.add(value)
+        if !complete || len(value) == 0 { continue }
+        _, metadata := SplitMetadata(value)
+        sessions.record(metadata)
+    }
+    return sessions
+}
+
+// Returns the highest command number applied for a client, zero if none
+func (this *Log) GetSessionSequence(clientId string) uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.sessions[clientId]
+}
@@ -0,0 +1,92 @@
+package replicatedlog
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+    "hash/crc32"
+    "encoding/gob"
+    "github/paxoscluster/proposal"
+)
+
+// Identifies a snapshot stream and its format version
+const snapshotMagic = "PXSNAP1\n"
+
+// Consistent copy of the committed prefix of the log. Index is the last entry included, or
+// -1 if none; MinProposalId is the highest proposal promised when the snapshot was taken.
+// Sessions holds the highest command applied for each client within the entries, so a node
+// restored from the snapshot goes on suppressing retries of commands it holds
+type Snapshot struct {
+    Index int
+    Membership map[uint64]string
+    MinProposalId proposal.Id
+    Entries [][]byte
+    Sessions map[string]uint64
+    Checksum uint32
+}
+
+// Captures every committed entry as of a single instant
+func (this *Log) Snapshot() (*Snapshot, error) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    entries := make([][]byte, this.firstUnchosenIndex)
+    for index := range entries {
+        value, err := this.valueAt(index)
+        if err != nil { return nil, err }
+        entries[index] = value
+    }
+    newSnapshot := Snapshot {
+        Index: this.firstUnchosenIndex-1,
+        MinProposalId: this.minProposalId,
+        Entries: entries,
+        Sessions: buildSessions(entries),
+    }
+    return &newSnapshot, nil
+}
+
+// Serializes a snapshot, sealing its entries with a checksum
+func WriteSnapshot(writer io.Writer, snapshot *Snapshot) error {
+    snapshot.Checksum = snapshotChecksum(snapshot.Entries)
+    buffered := bufio.NewWriter(writer)
+    _, err := buffered.WriteString(snapshotMagic)
+    if err != nil { return err }
+    err = gob.NewEncoder(buffered).Encode(snapshot)
+    if err != nil { return err }
+    return buffered.Flush()
+}
+
+// Deserializes a snapshot, verifying its format and checksum
+func ReadSnapshot(reader io.Reader) (*Snapshot, error) {
+    buffered := bufio.NewReader(reader)
+    magic := make([]byte, len(snapshotMagic))
+    _, err := io.ReadFull(buffered, magic)
+    if err != nil { return nil, err }
+    if string(magic) != snapshotMagic {
+        return nil, fmt.Errorf("Not a snapshot, or unsupported snapshot version")
+    }
+
+    var snapshot Snapshot
+    err = gob.NewDecoder(buffered).Decode(&snapshot)
+    if err != nil { return nil, err }
+    if snapshotChecksum(snapshot.Entries) != snapshot.Checksum {
+        return nil, fmt.Errorf("Snapshot checksum mismatch")
+    }
+    if snapshot.Index != len(snapshot.Entries)-1 {
+        return nil, fmt.Errorf("Snapshot index %d does not match %d entries", snapshot.Index, len(snapshot.Entries))
+    }
+    if snapshot.Sessions == nil {
+        // Written before sessions were recorded
+        snapshot.Sessions = buildSessions(snapshot.Entries)
+    }
+    return &snapshot, nil
+}
+
+func snapshotChecksum(entries [][]byte) uint32 {
+    checksum := uint32(0)
+    for _, entry := range entries {
+        checksum = crc32.Update(checksum, castagnoli, entry)
+        checksum = crc32.Update(checksum, castagnoli, []byte{0})
+    }
+    return checksum
+}
@@ -0,0 +1,64 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "github/paxoscluster/hooks"
+)
+
+// Log entry whose value has been chosen; Metadata is filled in only by readers which split it
+// from the value
+type CommittedEntry struct {
+    Index int
+    Value []byte
+    Metadata hooks.Metadata
+}
+
+// Streams committed entries in index order starting at fromIndex, including entries
+// committed before the call. Delivery waits on the receiver, so a slow subscriber falls
+// behind without holding up the log. Entries are delivered as stored; chunks of large values
+// arrive individually. Calling cancel stops delivery and closes the channel; so does reaching
+// an entry discarded by compaction, or one which cannot be read back from disk
+func (this *Log) Subscribe(fromIndex int) (<-chan CommittedEntry, func()) {
+    entries := make(chan CommittedEntry)
+    done := make(chan bool)
+    cancelled := false
+
+    cancel := func() {
+        this.exclude.Lock()
+        defer this.exclude.Unlock()
+        if !cancelled {
+            cancelled = true
+            close(done)
+            this.committed.Broadcast()
+        }
+    }
+
+    go func() {
+        defer close(entries)
+        for index := fromIndex; ; index++ {
+            this.exclude.Lock()
+            for index >= this.firstUnchosenIndex && !cancelled {
+                this.committed.Wait()
+            }
+            if cancelled || index <= this.compactedIndex {
+                this.exclude.Unlock()
+                return
+            }
+            value, err := this.valueAt(index)
+            this.exclude.Unlock()
+            if err != nil {
+                fmt.Println("[ LOG", this.roleId, "] Ending subscription:", err)
+                return
+            }
+            entry := CommittedEntry{Index: index, Value: value}
+
+            select {
+            case entries <- entry:
+            case <- done:
+                return
+            }
+        }
+    }()
+
+    return entries, cancel
+}
@@ -0,0 +1,36 @@
+package replicatedlog
+
+import (
+    "fmt"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/proposal"
+)
+
+// Exports every change to the promise and to the entries of the log as a transition, opening
+// with the state recovered: the promise, then each entry accepted or chosen and not compacted
+// or corrupt. Must be set before the log is shared
+func (this *Log) SetTransitions(transitions *trace.TransitionRecorder) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.transitions = transitions
+    this.transitions.Record(this.roleId, trace.RecoverAction, -1, this.minProposalId.Sequence, nil)
+    for index, proposalId := range this.acceptedProposals {
+        if proposalId == proposal.Default() || index <= this.compactedIndex || this.corrupt[index] { continue }
+        value, err := this.valueAt(index)
+        if err != nil {
+            fmt.Println("[ LOG", this.roleId, "] Not exporting entry", index, ":", err)
+            continue
+        }
+        this.transitions.Record(this.roleId, trace.RecoverAction, index, proposalId.Sequence, value)
+    }
+}
+
+// Exports a value accepted, or learned chosen, in the entry at index. exclude MUST be locked
+func (this *Log) exportAccepted(action string, index int, value []byte, proposalId proposal.Id) {
+    if this.transitions == nil { return }
+    if proposalId == proposal.Chosen() {
+        action = trace.ChooseAction
+    }
+    this.transitions.Record(this.roleId, action, index, proposalId.Sequence, value)
+}
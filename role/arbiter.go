@@ -0,0 +1,80 @@
+package role
+
+import (
+    "fmt"
+    "net/rpc"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/config"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Running arbiter; its log holds the proposals it has accepted
+type Arbiter struct {
+    RoleId uint64
+    Address string
+    Log *replicatedlog.Log
+}
+
+// Initializes an arbiter, a member which takes part in consensus only as an acceptor: it votes
+// in prepare and accept rounds, answering heartbeats without sending its own, so it never leads,
+// serves no clients, and applies nothing. Its roleId must be the arbiter named in the
+// configuration of every member
+func LaunchArbiter(settings *config.Config, disk *recovery.Manager) (*Arbiter, error) {
+    roleId := settings.RoleId
+    if roleId == 0 || roleId != settings.Arbiter.RoleId {
+        return nil, fmt.Errorf("RoleId %d is not the configured arbiter %d", roleId, settings.Arbiter.RoleId)
+    }
+    address, exists := settings.Peers[roleId]
+    if !exists {
+        return nil, fmt.Errorf("RoleId %d not found in peers table", roleId)
+    }
+
+    state, err := disk.Recover(roleId)
+    if err != nil { return nil, err }
+    log := replicatedlog.ConstructLog(roleId, state, disk, nil)
+    log.LimitCache(settings.Storage.CacheSize)
+    if settings.Debug.Invariants {
+        log.EnableInvariants()
+    }
+
+    handler := rpc.NewServer()
+    err = handler.Register(acceptor.Construct(roleId, log))
+    if err != nil { return nil, err }
+    err = handler.RegisterName("ProposerRole", &arbiterProposer{roleId, log})
+    if err != nil { return nil, err }
+    err = clusterpeers.Serve(roleId, address, settings, handler)
+    if err != nil { return nil, err }
+
+    newArbiter := Arbiter {
+        RoleId: roleId,
+        Address: address,
+        Log: log,
+    }
+    return &newArbiter, nil
+}
+
+// Answers the heartbeats of members without following them, as an arbiter never leads
+type arbiterProposer struct {
+    roleId uint64
+    log *replicatedlog.Log
+}
+
+func (this *arbiterProposer) Heartbeat(req *uint64, reply *uint64) (err error) {
+    defer guard.Recover("ARBITER", this.roleId, "ProposerRole.Heartbeat", &err)
+    *reply = this.roleId
+    return nil
+}
+
+func (this *arbiterProposer) HeartbeatState(req *uint64, reply *clusterpeers.FollowerState) (err error) {
+    defer guard.Recover("ARBITER", this.roleId, "ProposerRole.HeartbeatState", &err)
+    *reply = clusterpeers.FollowerState {
+        RoleId: this.roleId,
+        CommitIndex: this.log.GetCommitIndex(),
+        AppliedIndex: this.log.GetAppliedIndex(),
+        CorruptEntries: len(this.log.GetCorruptIndices()),
+    }
+    return nil
+}
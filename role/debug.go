@@ -0,0 +1,53 @@
+package role
+
+import (
+    "fmt"
+    "time"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/config"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/diagnostics"
+)
+
+// State of a node published on the debug listener
+type debugState struct {
+    State string
+    Proposer proposer.DebugState
+    CommitIndex int
+    AppliedIndex int
+    // Committed entries waiting on the apply loop
+    ApplyBacklog int
+    StorageLatency time.Duration
+    StorageDegraded bool
+    // Links between members found to carry traffic in one direction only
+    AsymmetricLinks []clusterpeers.AsymmetricLink
+}
+
+// Publishes the node's state and serves it with the process's counters and profiles on the
+// debug listener, authorized by tokens granting PermissionDebug, alongside the liveness and
+// readiness probes
+func (this *Node) serveDebug(settings *config.Config) error {
+    if len(settings.Client.Tokens) == 0 {
+        return fmt.Errorf("Debug listener requires a tokens file")
+    }
+    diagnostics.PublishNode(this.RoleId, func() interface{} {
+        state := debugState {
+            State: this.Proposer.GetState().String(),
+            Proposer: this.Proposer.GetDebugState(),
+            CommitIndex: this.Log.GetCommitIndex(),
+            AppliedIndex: this.Log.GetAppliedIndex(),
+        }
+        state.ApplyBacklog = state.CommitIndex-state.AppliedIndex
+        state.StorageLatency, state.StorageDegraded = this.Proposer.GetStorageHealth()
+        state.AsymmetricLinks = this.Proposer.GetAsymmetricLinks()
+        return state
+    })
+
+    tlsConfig, err := settings.TLS.Build()
+    if err != nil { return err }
+    return diagnostics.Serve(settings.Debug.Address, tlsConfig, func(token string) error {
+        _, err := this.authorizer.Authorize(token, admin.PermissionDebug)
+        return err
+    }, this.Proposer.CheckReadiness)
+}
@@ -0,0 +1,69 @@
+//go:build faultinjection
+// +build faultinjection
+
+package role
+
+import (
+    "time"
+    "net/rpc"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Fault to inject into messages this node sends; roles are matched by Partition and Heal in
+// either direction, by Cut and Delay only from From to To
+type FaultReq struct {
+    From uint64
+    To uint64
+    Delay time.Duration
+    Rate float64
+}
+
+// Exposes the transport's fault injector to operators rehearsing failures in staging
+type FaultRole struct{}
+
+func (this *FaultRole) Partition(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Partition(req.From, req.To)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Cut(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Cut(req.From, req.To)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Heal(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Heal(req.From, req.To)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Delay(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Delay(req.From, req.To, req.Delay)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Drop(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Drop(req.Rate)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Reorder(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Reorder(req.Delay)
+    *reply = true
+    return nil
+}
+
+func (this *FaultRole) Reset(req *FaultReq, reply *bool) error {
+    clusterpeers.Faults.Reset()
+    *reply = true
+    return nil
+}
+
+// Serves fault injection to peers and operators; compiled only with the faultinjection tag
+func registerFaults(handler *rpc.Server) error {
+    return handler.Register(&FaultRole{})
+}
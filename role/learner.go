@@ -0,0 +1,87 @@
+package role
+
+import (
+    "fmt"
+    "sync"
+    "context"
+    "net/rpc"
+    "github/paxoscluster/config"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/learner"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Running learner; its log holds the committed entries streamed by the leader
+type Learner struct {
+    RoleId uint64
+    Address string
+    Log *replicatedlog.Log
+    migratedTo map[uint64]string
+    sealed chan struct{}
+    seal sync.Once
+}
+
+// Initializes a learner, which takes no part in consensus but receives every committed entry
+// from the leader, firing the given hooks as entries commit. The learner must be listed in
+// the learners table of every peer, unless it is the target of a migration
+func LaunchLearner(settings *config.Config, disk *recovery.Manager, events *hooks.Hooks) (*Learner, error) {
+    roleId := settings.RoleId
+    address, exists := settings.Learners[roleId]
+    if !exists {
+        return nil, fmt.Errorf("RoleId %d not found in learners table", roleId)
+    }
+    newLearner := Learner {
+        RoleId: roleId,
+        Address: address,
+        sealed: make(chan struct{}),
+    }
+
+    // Registered ahead of recovery, so a seal streamed before a restart is found again
+    if events == nil {
+        events = hooks.Construct()
+    }
+    events.OnApplyEntry(newLearner.observeSeal)
+
+    state, err := disk.Recover(roleId)
+    if err != nil { return nil, err }
+    log := replicatedlog.ConstructLog(roleId, state, disk, events)
+    log.LimitCache(settings.Storage.CacheSize)
+    if settings.Debug.Invariants {
+        log.EnableInvariants()
+    }
+
+    handler := rpc.NewServer()
+    err = handler.Register(learner.Construct(roleId, log))
+    if err != nil { return nil, err }
+    err = clusterpeers.Serve(roleId, address, settings, handler)
+    if err != nil { return nil, err }
+
+    newLearner.Log = log
+    return &newLearner, nil
+}
+
+// Records the first seal streamed to this learner
+func (this *Learner) observeSeal(entry hooks.Entry) {
+    members, sealed := proposer.ParseSeal(entry.Value)
+    if !sealed { return }
+    this.seal.Do(func() {
+        fmt.Println("[ LEARNER", this.RoleId, "] Received seal at entry", entry.Index, "; group migrating to", members)
+        this.migratedTo = members
+        close(this.sealed)
+    })
+}
+
+// Waits until the log streamed to this learner is sealed by a migration, returning the
+// members of the new group. A learner named among them should stop, then relaunch as a voter
+// with Launch, using those members as its peers table and the same storage
+func (this *Learner) AwaitMigration(ctx context.Context) (map[uint64]string, error) {
+    select {
+    case <- this.sealed:
+        return this.migratedTo, nil
+    case <- ctx.Done():
+        return nil, ctx.Err()
+    }
+}
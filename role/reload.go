@@ -0,0 +1,75 @@
+package role
+
+import (
+    "os"
+    "fmt"
+    "sync"
+    "syscall"
+    "os/signal"
+    "github/paxoscluster/config"
+    "github/paxoscluster/learner"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Applies reloaded tunables to the roles of a running node, one reload at a time
+type reloader struct {
+    roleId uint64
+    settings *config.Config
+    proposer *proposer.ProposerRole
+    cluster *clusterpeers.Cluster
+    streamer *learner.Streamer
+    exclude sync.Mutex
+}
+
+// Takes the tunables of next, failing without effect if any other setting differs from
+// those the node runs with
+func (this *reloader) reload(next *config.Config) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    reloaded, err := this.settings.CheckReload(next)
+    if err != nil { return err }
+    this.proposer.Reload(reloaded)
+    this.cluster.SetTimeouts(reloaded.Timeouts)
+    this.streamer.SetTimeouts(reloaded.Timeouts)
+    this.settings = reloaded
+    fmt.Println("[ NODE", this.roleId, "] Reloaded settings; timeouts", reloaded.Timeouts)
+    return nil
+}
+
+// Reloads the tunables from the file the node's settings were loaded from
+func (this *reloader) reloadFile() error {
+    this.exclude.Lock()
+    fileName := this.settings.File
+    this.exclude.Unlock()
+
+    if len(fileName) == 0 {
+        return fmt.Errorf("Settings were not loaded from a file")
+    }
+    next, err := config.Load(fileName)
+    if err != nil { return err }
+    return this.reload(next)
+}
+
+// Takes the timeouts, rate limits, admission wait, catch-up batch size and rate, and retry
+// policy of the given settings without restarting; fails without effect if any other setting
+// differs from those the node runs with
+func (this *Node) Reload(settings *config.Config) error {
+    return this.reloader.reload(settings)
+}
+
+// Reloads the node's settings from the file they were loaded from whenever the process
+// receives SIGHUP; failures are logged and the node keeps its settings
+func (this *Node) ReloadOnHangup() {
+    hangup := make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
+    go func() {
+        for range hangup {
+            err := this.reloader.reloadFile()
+            if err != nil {
+                fmt.Println("[ NODE", this.RoleId, "] Failed to reload settings:", err)
+            }
+        }
+    }()
+}
@@ -1,46 +1,290 @@
 package role
 
 import (
-    "time"
+    "fmt"
+    "path/filepath"
     "net/rpc"
+    "github/paxoscluster/config"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/learner"
     "github/paxoscluster/proposer"
     "github/paxoscluster/acceptor"
     "github/paxoscluster/replicatedlog"
     "github/paxoscluster/clusterpeers"
     "github/paxoscluster/recovery"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/gateway"
+    "github/paxoscluster/archive"
+    "github/paxoscluster/trace"
+    "github/paxoscluster/journal"
+    "github/paxoscluster/clock"
+    "github/paxoscluster/txn"
+    "github/paxoscluster/routing"
 )
 
-// Initialize proposer and acceptor roles
+// Initialize proposer and acceptor roles using the peers file and default settings
 func LaunchNode(assignedId uint64, disk *recovery.Manager) (string, error) {
-    cluster, roleId, address, err := clusterpeers.ConstructCluster(assignedId, disk)
-    if err != nil { return address, err }
-    log, err := replicatedlog.ConstructLog(roleId, disk)
-    if err != nil { return address, err }
+    addresses, err := disk.RetrieveAddresses()
+    if err != nil { return "", err }
+
+    settings := config.Default()
+    settings.RoleId = assignedId
+    settings.Peers = addresses
+    return LaunchConfiguredNode(settings, disk)
+}
+
+// Initialize proposer and acceptor roles
+func LaunchConfiguredNode(settings *config.Config, disk *recovery.Manager) (string, error) {
+    node, err := Launch(settings, disk, hooks.Construct())
+    if err != nil { return "", err }
+    return node.Address, nil
+}
+
+// Running node, exposing its roles to applications embedding the cluster
+type Node struct {
+    RoleId uint64
+    Address string
+    Log *replicatedlog.Log
+    Proposer *proposer.ProposerRole
+    Cluster *clusterpeers.Cluster
+    // Recent consensus events, nil if disabled
+    Journal *journal.Journal
+    clients *rpc.Server
+    authorizer *admin.Authorizer
+    reloader *reloader
+}
+
+// Initialize proposer and acceptor roles, firing the given hooks as the node operates
+func Launch(settings *config.Config, disk *recovery.Manager, events *hooks.Hooks) (*Node, error) {
+    // Role changes and seals are observed as they are applied
+    if events == nil {
+        events = hooks.Construct()
+    }
+    cluster, roleId, address, err := clusterpeers.ConstructCluster(settings, events)
+    if err != nil { return nil, err }
+
+    // State written before the keyring was given is sealed once, before it is recovered
+    if settings.Storage.MigratePlaintext {
+        err = disk.RotateKeys(roleId)
+        if err != nil { return nil, err }
+    }
+
+    // Restores this node to its state before it stopped, before it rejoins the cluster
+    state, err := disk.Recover(roleId)
+    if err != nil { return nil, err }
+    timeline := journal.ConstructJournal(int(settings.Debug.Journal), settings.Clock)
+    timeline.Record(roleId, journal.Recovered, "Recovered %d log entries, compacted through %d, applied through %d",
+                  len(state.Values), state.CompactedIndex, state.AppliedIndex)
+    cluster.SetJournal(timeline)
+    // Role changes are replayed with the log, past those already persisted
+    cluster.RecoverRoles(state.LearnersIndex, state.Learners, func(index int, learners []uint64) error {
+        return disk.UpdateLearners(roleId, index, learners)
+    })
+    log := replicatedlog.ConstructLog(roleId, state, disk, events)
+    log.SetJournal(timeline)
+    log.LimitCache(settings.Storage.CacheSize)
+    if settings.Debug.Invariants {
+        log.EnableInvariants()
+    }
+    if settings.Trace.Transitions {
+        transitions, err := trace.ConstructTransitionRecorder(filepath.Join(settings.Trace.Directory, fmt.Sprintf("transitions-%d.jsonl", roleId)))
+        if err != nil { return nil, err }
+        log.SetTransitions(transitions)
+    }
+    if state.Membership != nil {
+        fmt.Println("[ RECOVERY", roleId, "] WARNING: using membership", state.Membership, "forced by unsafe reconfiguration")
+        err = cluster.RestrictMembership(state.Membership)
+        if err != nil { return nil, err }
+    }
 
     acceptorRole := acceptor.Construct(roleId, log)
-    proposerRole := proposer.Construct(roleId, log, cluster)
+    cluster.SetLocalAcceptor(acceptorRole)
+    proposerRole, err := proposer.Construct(roleId, log, cluster, settings, events, disk, state.ProposalCounter)
+    if err != nil { return nil, err }
+    acceptorRole.SetProgress(proposerRole.ObserveProgress)
+    proposerRole.SetStorageHealth(disk.GetStorageHealth)
+    proposerRole.SetJournal(timeline)
+    if owners := proposerRole.GetOwners(); owners != nil {
+        acceptorRole.SetOwnership(owners, state.Revocations, disk)
+        acceptorRole.SetClaims(proposerRole.ObserveClaim)
+    }
+    if proposerRole.UsesFastRounds() {
+        acceptorRole.SetFastRounds(state.Revocations, disk)
+    }
+    if settings.Storage.MaxPending != 0 {
+        acceptorRole.SetAdmission(settings.Storage.MaxPending, disk.GetPendingUpdates, disk.GetStorageHealth)
+    }
+    if len(settings.Trace.Directory) != 0 {
+        // The trace opens with the recovered state, from which replay starts
+        tracer, err := trace.ConstructRecorder(filepath.Join(settings.Trace.Directory, fmt.Sprintf("trace-%d.jsonl", roleId)))
+        if err != nil { return nil, err }
+        tracer.Record(roleId, trace.RecoverMethod, state, nil, nil)
+        acceptorRole.SetTracer(tracer)
+        proposerRole.SetTracer(tracer)
+    }
 
     handler := rpc.NewServer()
     err = handler.Register(acceptorRole)
-    if err != nil { return address, err }
-    err = handler.Register(proposerRole)
-    if err != nil { return address, err }
+    if err != nil { return nil, err }
+    clients := handler
+    var authorizer *admin.Authorizer = nil
+    if len(settings.Client.Tokens) != 0 {
+        authorizer = admin.ConstructAuthorizer(settings.Client.Tokens)
+    }
+    streamer := learner.ConstructStreamer(roleId, log, cluster, proposerRole, settings)
+    tunables := &reloader{roleId: roleId, settings: settings, proposer: proposerRole, cluster: cluster, streamer: streamer}
+    if len(settings.Client.Address) == 0 {
+        // Without a client listener, clients share the peer listener unauthorized
+        err = handler.Register(proposerRole)
+        if err != nil { return nil, err }
+        err = handler.Register(admin.ConstructClientRole(proposerRole, log, nil))
+        if err != nil { return nil, err }
+        // The gateway is reachable from browsers, so its callers are always authorized
+        err = serveGateway(settings, admin.ConstructClientRole(proposerRole, log, authorizer))
+        if err != nil { return nil, err }
+    } else {
+        // Peers reach only the heartbeat; clients are served on their own listener
+        err = handler.RegisterName("ProposerRole", &peerProposer{proposerRole})
+        if err != nil { return nil, err }
+        clients, err = serveClients(roleId, settings, proposerRole, log, cluster, disk, streamer, authorizer, timeline, tunables)
+        if err != nil { return nil, err }
+    }
+    var gossip *clusterpeers.Gossip = nil
+    if settings.Gossip.Enabled {
+        gossip = clusterpeers.ConstructGossip(cluster, settings.Gossip)
+        err = handler.RegisterName("GossipRole", gossip)
+        if err != nil { return nil, err }
+    }
+    err = registerFaults(handler)
+    if err != nil { return nil, err }
     err = cluster.Listen(handler)
-    if err != nil { return address, err }
+    if err != nil { return nil, err }
 
-    // Connects to peers
-    go cluster.Connect()
+    // Connects to peers, then replaces any log entries found corrupt on recovery
+    go func() {
+        cluster.Connect()
+        cluster.RepairLog(log)
+    }()
 
-    // Dispatches heartbeat signal
+    // Dispatches heartbeat signal, or with gossip follows the highest live member it reports.
+    // Heartbeats are withheld while yielding leadership for degraded storage, and by learners
+    heartbeatClock := clock.OrReal(settings.Clock)
+    if gossip != nil {
+        go gossip.Run()
+    }
     go func() {
         for {
-            go cluster.BroadcastHeartbeat(roleId)
-            time.Sleep(time.Second)
+            if gossip == nil {
+                if !proposerRole.IsYielding() && cluster.IsVoter(roleId) {
+                    go cluster.BroadcastHeartbeat(roleId)
+                }
+            } else if leaderId := gossip.GetHighestLive(); leaderId != roleId {
+                var reply uint64
+                proposerRole.Heartbeat(&leaderId, &reply)
+            }
+            heartbeatClock.Sleep(proposerRole.GetTimeouts().Heartbeat)
         }
     }()
 
     // Begins leader election
     go proposer.Run(proposerRole)
 
-    return address, nil
+    // Streams committed entries to learners while leader
+    if len(settings.Learners) != 0 {
+        go streamer.Run()
+    }
+
+    // Ships sealed segments of the committed log to the archive if configured
+    store := archive.ConstructStore(settings.Archive)
+    if store != nil {
+        archiver := archive.ConstructArchiver(roleId, log, store, settings.Archive.Prefix, int(settings.Archive.SegmentSize))
+        go archiver.Run(settings.Archive.Interval)
+    }
+
+    newNode := Node {
+        RoleId: roleId,
+        Address: address,
+        Log: log,
+        Proposer: proposerRole,
+        Cluster: cluster,
+        Journal: timeline,
+        clients: clients,
+        authorizer: authorizer,
+        reloader: tunables,
+    }
+    if len(settings.Debug.Address) != 0 {
+        err = newNode.serveDebug(settings)
+        if err != nil { return nil, err }
+    }
+    return &newNode, nil
+}
+
+// Serves the outcomes of a transaction participant to coordinators, on the listener serving
+// clients; the participant must be registered with the node's apply hooks. Followers may lag
+// the log, so only the leader answers
+func (this *Node) ServeParticipant(participant *txn.Participant) error {
+    admit := func(token string) error {
+        _, err := this.authorizer.Authorize(token, admin.PermissionRead)
+        if err != nil { return err }
+        err = this.Proposer.CheckReady()
+        if err != nil { return err }
+        return this.Proposer.CheckStaleness(0)
+    }
+    return this.clients.RegisterName("Participant", txn.ConstructService(participant, admit))
+}
+
+// Serves the routing table of a meta group to routers, on the listener serving clients; the
+// directory must be registered with the node's apply hooks. Only the leader answers
+func (this *Node) ServeDirectory(directory *routing.Directory) error {
+    admit := func(token string) error {
+        _, err := this.authorizer.Authorize(token, admin.PermissionRead)
+        if err != nil { return err }
+        err = this.Proposer.CheckReady()
+        if err != nil { return err }
+        return this.Proposer.CheckStaleness(0)
+    }
+    return this.clients.RegisterName("Routing", routing.ConstructService(directory, admit))
+}
+
+// Exposes only the proposer methods invoked by peers
+type peerProposer struct {
+    proposer *proposer.ProposerRole
+}
+
+func (this *peerProposer) Heartbeat(req *uint64, reply *uint64) error {
+    return this.proposer.Heartbeat(req, reply)
+}
+
+func (this *peerProposer) HeartbeatState(req *uint64, reply *clusterpeers.FollowerState) error {
+    return this.proposer.HeartbeatState(req, reply)
+}
+
+// Listens for client and administrative requests authorized by bearer tokens
+func serveClients(roleId uint64, settings *config.Config, proposerRole *proposer.ProposerRole, log *replicatedlog.Log,
+                  cluster *clusterpeers.Cluster, disk *recovery.Manager, streamer *learner.Streamer,
+                  authorizer *admin.Authorizer, timeline *journal.Journal, tunables *reloader) (*rpc.Server, error) {
+    audit, err := admin.ConstructAuditLog(filepath.Join(settings.Storage.Directory, fmt.Sprintf("%d", roleId), "audit.csv"))
+    if err != nil { return nil, err }
+    handler := rpc.NewServer()
+    clientRole := admin.ConstructClientRole(proposerRole, log, authorizer)
+    err = handler.Register(clientRole)
+    if err != nil { return nil, err }
+    err = serveGateway(settings, clientRole)
+    if err != nil { return nil, err }
+    adminRole := admin.ConstructAdminRole(roleId, proposerRole, cluster, disk, streamer, authorizer, audit, timeline)
+    adminRole.SetReload(tunables.reloadFile)
+    err = handler.Register(adminRole)
+    if err != nil { return nil, err }
+    return handler, clusterpeers.ServeClients(roleId, settings.Client.Address, settings, handler)
+}
+
+// Starts the HTTP gateway if configured
+func serveGateway(settings *config.Config, clientRole *admin.ClientRole) error {
+    if len(settings.Gateway.Address) == 0 { return nil }
+    if len(settings.Client.Tokens) == 0 {
+        return fmt.Errorf("Gateway requires a tokens file")
+    }
+    tlsConfig, err := settings.TLS.Build()
+    if err != nil { return err }
+    return gateway.Serve(settings.Gateway.Address, settings.Gateway.Origins, clientRole, tlsConfig)
 }
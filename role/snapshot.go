@@ -0,0 +1,45 @@
+package role
+
+import (
+    "io"
+    "fmt"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Writes a consistent snapshot of the committed log and current membership, for backups
+func (this *Node) ExportSnapshot(writer io.Writer) error {
+    if compacted := this.Log.GetCompactedIndex(); compacted >= 0 {
+        return fmt.Errorf("Entries through %d are compacted", compacted)
+    }
+    snapshot, err := this.Log.Snapshot()
+    if err != nil { return err }
+    snapshot.Membership = this.Cluster.GetMembership()
+    return replicatedlog.WriteSnapshot(writer, snapshot)
+}
+
+// Seeds the storage of a node which has never run with the entries of a snapshot, so it
+// starts with them chosen; must be called before the node is launched. Returns the snapshot,
+// whose membership may be used to configure a cloned cluster
+func ImportSnapshot(reader io.Reader, roleId uint64, disk *recovery.Manager) (*replicatedlog.Snapshot, error) {
+    snapshot, err := replicatedlog.ReadSnapshot(reader)
+    if err != nil { return nil, err }
+
+    existing, _, _, err := disk.RecoverLog(roleId)
+    if err != nil { return nil, err }
+    if len(existing) != 0 {
+        return nil, fmt.Errorf("Role %d already has %d log entries; snapshots seed only empty nodes", roleId, len(existing))
+    }
+
+    ids := make([]proposal.Id, len(snapshot.Entries))
+    for index := range ids {
+        ids[index] = proposal.Chosen()
+    }
+    // Entries are imported as chosen, so no promises need carrying over
+    err = disk.WriteLog(roleId, snapshot.Entries, ids)
+    if err != nil { return nil, err }
+
+    fmt.Println("[ RECOVERY", roleId, "] Imported snapshot through index", snapshot.Index)
+    return snapshot, nil
+}
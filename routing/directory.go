@@ -0,0 +1,138 @@
+package routing
+
+import (
+    "fmt"
+    "sync"
+    "bytes"
+    "encoding/json"
+)
+
+// Marks a log entry of the meta group holding a change to the routing table
+var changeMarker = []byte("\x00route ")
+
+// Change to the routing table recorded in the meta group's log, applied only to the table
+// at Version so that changes made from a stale table are ignored. An assignment hands a key
+// range to a group; a group change sets the members of a group, removing it if none
+type change struct {
+    Kind string
+    Version uint64
+    Range Range
+    Group string
+    Members map[uint64]string
+}
+
+const (
+    assignKind = "assign"
+    groupKind = "group"
+)
+
+func encodeChange(entry change) []byte {
+    encoded, _ := json.Marshal(entry)
+    return append(append([]byte{}, changeMarker...), encoded...)
+}
+
+// Parses a change; reports false for other values
+func decodeChange(value []byte) (change, bool) {
+    var entry change
+    if !bytes.HasPrefix(value, changeMarker) {
+        return entry, false
+    }
+    err := json.Unmarshal(value[len(changeMarker):], &entry)
+    return entry, err == nil
+}
+
+// Checks a change before it is proposed, as invalid changes are ignored when applied
+func validateChange(entry change) error {
+    switch entry.Kind {
+    case assignKind:
+        if len(entry.Range.End) != 0 && entry.Range.End <= entry.Range.Start {
+            return fmt.Errorf("Empty key range [%q, %q)", entry.Range.Start, entry.Range.End)
+        }
+    case groupKind:
+        if len(entry.Group) == 0 {
+            return fmt.Errorf("Group requires a name")
+        }
+    default:
+        return fmt.Errorf("Unknown routing change %s", entry.Kind)
+    }
+    return nil
+}
+
+// Routing table held by each node of the meta group, built by applying the changes in its
+// log; register Apply with Hooks.OnApply. Every replica applies the same changes in the same
+// order, so all agree on the table at each version
+type Directory struct {
+    table *Table
+    exclude sync.Mutex
+}
+
+// Constructor for Directory
+func ConstructDirectory() *Directory {
+    newDirectory := Directory {
+        table: ConstructTable(),
+    }
+    return &newDirectory
+}
+
+// Applies a committed value; values other than routing changes are ignored
+func (this *Directory) Apply(index int, value []byte) {
+    entry, isChange := decodeChange(value)
+    if !isChange { return }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if entry.Version != this.table.Version || validateChange(entry) != nil { return }
+
+    switch entry.Kind {
+    case assignKind:
+        if len(entry.Range.Group) != 0 && this.table.Groups[entry.Range.Group] == nil { return }
+        this.table.assign(entry.Range)
+    case groupKind:
+        if len(entry.Members) == 0 {
+            // A group still serving keys is kept
+            for _, current := range this.table.Ranges {
+                if current.Group == entry.Group { return }
+            }
+            delete(this.table.Groups, entry.Group)
+        } else {
+            this.table.Groups[entry.Group] = copyMembers(entry.Members)
+        }
+    }
+    this.table.Version++
+    fmt.Println("[ ROUTING ] Applied", entry.Kind, "change at entry", index, "; table now at version", this.table.Version)
+}
+
+// Returns a copy of the table as of the entries applied locally
+func (this *Directory) GetTable() *Table {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.table.clone()
+}
+
+// Request for the routing table
+type TableReq struct {
+    Token string
+}
+
+// Serves the routing table of a directory to routers
+type Service struct {
+    directory *Directory
+    admit func(token string) error
+}
+
+// Constructor for Service; admit checks each request, refusing unauthorized tokens, or any
+// request while the node may not have applied the latest changes. It may be nil
+func ConstructService(directory *Directory, admit func(token string) error) *Service {
+    newService := Service{directory, admit}
+    return &newService
+}
+
+func (this *Service) Table(req *TableReq, reply *Table) error {
+    if this.admit != nil {
+        err := this.admit(req.Token)
+        if err != nil { return err }
+    }
+    *reply = *this.directory.GetTable()
+    return nil
+}
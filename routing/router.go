@@ -0,0 +1,218 @@
+package routing
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "github/paxoscluster/config"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/pxsclient"
+)
+
+// Client of a sharded deployment, sending each key's values to the group serving it. The
+// routing table is fetched from the meta group and cached; the cache is refreshed once older
+// than MaxAge, and invalidated when a group refuses a value because it migrated, or when a
+// key falls outside every cached range
+type Router struct {
+    meta *pxsclient.Client
+    settings *config.Config
+    token string
+    table *Table
+    fetched time.Time
+    groups map[string]*pxsclient.Client
+    MaxAge time.Duration
+    Timeout time.Duration
+    PollInterval time.Duration
+    exclude sync.Mutex
+}
+
+// Constructor for Router over a client of the meta group; settings and token are used to
+// reach the groups named in the routing table
+func ConstructRouter(meta *pxsclient.Client, settings *config.Config, token string) *Router {
+    newRouter := Router {
+        meta: meta,
+        settings: settings,
+        token: token,
+        table: nil,
+        groups: make(map[string]*pxsclient.Client),
+        MaxAge: 30*time.Second,
+        Timeout: 10*time.Second,
+        PollInterval: 100*time.Millisecond,
+    }
+    return &newRouter
+}
+
+// Replicates a value in the group serving a key. A group refusing the value because it
+// migrated has not chosen it, so the value is sent again once the table names the new group
+func (this *Router) Propose(key string, value []byte) error {
+    deadline := time.Now().Add(this.Timeout)
+    for {
+        _, client, err := this.Route(key)
+        if err == nil {
+            err = client.Propose(value)
+            if !proposer.IsMigrated(err) { return err }
+            this.Invalidate()
+        }
+        if time.Now().After(deadline) {
+            return err
+        }
+        time.Sleep(this.PollInterval)
+    }
+}
+
+// Returns the group serving a key and a client of it
+func (this *Router) Route(key string) (string, *pxsclient.Client, error) {
+    table, err := this.getTable(false)
+    if err != nil { return "", nil, err }
+    served, exists := table.Lookup(key)
+    if !exists {
+        // The key may have been assigned since the table was cached
+        table, err = this.getTable(true)
+        if err != nil { return "", nil, err }
+        served, exists = table.Lookup(key)
+        if !exists {
+            return "", nil, fmt.Errorf("No group serves key %q", key)
+        }
+    }
+    return served.Group, this.getClient(table, served.Group), nil
+}
+
+// Returns the routing table, using the cached copy unless it is stale
+func (this *Router) GetTable() (*Table, error) {
+    table, err := this.getTable(false)
+    if err != nil { return nil, err }
+    return table.clone(), nil
+}
+
+// Discards the cached routing table, so the next request fetches it again
+func (this *Router) Invalidate() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.table = nil
+}
+
+// Hands the keys from start up to but excluding end to a group, an empty end extending to
+// every greater key; an empty group leaves the keys unserved. Groups must be set before
+// they are assigned keys
+func (this *Router) Assign(start string, end string, group string) error {
+    assigned := Range{Start: start, End: end, Group: group}
+    return this.update(change{Kind: assignKind, Range: assigned}, func(table *Table) (bool, error) {
+        if len(group) != 0 && table.Groups[group] == nil {
+            return false, fmt.Errorf("Unknown group %s", group)
+        }
+        if len(group) == 0 {
+            for _, current := range table.Ranges {
+                if (len(end) == 0 || current.Start < end) && (len(current.End) == 0 || current.End > start) { return false, nil }
+            }
+            return true, nil
+        }
+        // Adjacent ranges of a group are merged, so one range holds all its keys
+        served, exists := table.Lookup(start)
+        return exists && served.Group == group && (len(served.End) == 0 || (len(end) != 0 && served.End >= end)), nil
+    })
+}
+
+// Sets the client addresses of a group's members, as when it is created or migrates to new
+// members; no members removes a group serving no keys
+func (this *Router) SetGroup(group string, members map[uint64]string) error {
+    return this.update(change{Kind: groupKind, Group: group, Members: members}, func(table *Table) (bool, error) {
+        current, exists := table.Groups[group]
+        if len(members) == 0 {
+            for _, served := range table.Ranges {
+                if served.Group == group {
+                    return false, fmt.Errorf("Group %s still serves keys from %q", group, served.Start)
+                }
+            }
+            return !exists, nil
+        }
+        return exists && sameMembers(current, members), nil
+    })
+}
+
+// Proposes a change to the meta group until the table reflects it. Each attempt is made from
+// the latest table and applies only to it, so a change raced by another is made again from
+// the table that results. applied reports whether the table already reflects the change
+func (this *Router) update(proposed change, applied func(*Table) (bool, error)) error {
+    err := validateChange(proposed)
+    if err != nil { return err }
+    deadline := time.Now().Add(this.Timeout)
+    for {
+        table, err := this.getTable(true)
+        if err == nil {
+            var done bool
+            done, err = applied(table)
+            if done || err != nil { return err }
+            proposed.Version = table.Version
+            err = this.meta.Propose(encodeChange(proposed))
+            if err == nil {
+                err = this.awaitVersion(table.Version+1, deadline)
+            }
+        }
+        if time.Now().After(deadline) {
+            if err == nil {
+                err = fmt.Errorf("Timed out updating the routing table")
+            }
+            return err
+        }
+    }
+}
+
+// Polls the meta group until its table reaches a version, or the deadline passes
+func (this *Router) awaitVersion(version uint64, deadline time.Time) error {
+    for {
+        table, err := this.getTable(true)
+        if err == nil && table.Version >= version { return nil }
+        if time.Now().After(deadline) { return err }
+        time.Sleep(this.PollInterval)
+    }
+}
+
+// Returns the cached table, fetching it from the meta group if refresh is set or the cache
+// is empty or stale
+func (this *Router) getTable(refresh bool) (*Table, error) {
+    this.exclude.Lock()
+    table := this.table
+    fresh := table != nil && time.Since(this.fetched) < this.MaxAge
+    this.exclude.Unlock()
+    if fresh && !refresh { return table, nil }
+
+    fetched := ConstructTable()
+    err := this.meta.Invoke("Routing.Table", &TableReq{Token: this.meta.GetToken()}, fetched)
+    if err != nil { return nil, err }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    // A concurrent fetch may have cached a later version
+    if this.table != nil && this.table.Version > fetched.Version {
+        return this.table, nil
+    }
+    for group, client := range this.groups {
+        if members, exists := fetched.Groups[group]; !exists || !sameMembers(members, this.getMembers(group)) {
+            client.Close()
+            delete(this.groups, group)
+        }
+    }
+    this.table = fetched
+    this.fetched = time.Now()
+    return fetched, nil
+}
+
+// Returns the members of a group in the cached table. exclude MUST be locked
+func (this *Router) getMembers(group string) map[uint64]string {
+    if this.table == nil { return nil }
+    return this.table.Groups[group]
+}
+
+// Returns the client of a group named in a table, constructing it on first use
+func (this *Router) getClient(table *Table, group string) *pxsclient.Client {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    client, exists := this.groups[group]
+    if !exists {
+        client = pxsclient.Construct(copyMembers(table.Groups[group]), this.settings, this.token)
+        this.groups[group] = client
+    }
+    return client
+}
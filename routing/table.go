@@ -0,0 +1,116 @@
+package routing
+
+import (
+    "sort"
+)
+
+// Keys from Start up to but excluding End, served by one group; an empty End extends to
+// every greater key
+type Range struct {
+    Start string
+    End string
+    Group string
+}
+
+// Reports whether the range holds a key
+func (this Range) Contains(key string) bool {
+    return key >= this.Start && (len(this.End) == 0 || key < this.End)
+}
+
+// Routing table of a sharded deployment: the groups serving each key range, in order of
+// Start and never overlapping, and the client addresses of each group's members. Version
+// counts the changes applied, so any two replicas of the meta group holding the same version
+// hold the same table
+type Table struct {
+    Version uint64
+    Ranges []Range
+    Groups map[string]map[uint64]string
+}
+
+// Constructor for an empty Table
+func ConstructTable() *Table {
+    newTable := Table {
+        Version: 0,
+        Ranges: nil,
+        Groups: make(map[string]map[uint64]string),
+    }
+    return &newTable
+}
+
+// Returns the range holding a key, or false if no group serves it
+func (this *Table) Lookup(key string) (Range, bool) {
+    position := sort.Search(len(this.Ranges), func(i int) bool {
+        return len(this.Ranges[i].End) == 0 || this.Ranges[i].End > key
+    })
+    if position < len(this.Ranges) && this.Ranges[position].Contains(key) {
+        return this.Ranges[position], true
+    }
+    return Range{}, false
+}
+
+// Returns a copy sharing nothing with this table
+func (this *Table) clone() *Table {
+    copied := ConstructTable()
+    copied.Version = this.Version
+    copied.Ranges = append([]Range{}, this.Ranges...)
+    for group, members := range this.Groups {
+        copied.Groups[group] = copyMembers(members)
+    }
+    return copied
+}
+
+// Hands the keys of a range to its group, carving them out of the ranges holding them; an
+// empty group leaves the keys unserved
+func (this *Table) assign(assigned Range) {
+    var ranges []Range = nil
+    for _, current := range this.Ranges {
+        // Part of the current range ahead of the assigned one
+        if current.Start < assigned.Start {
+            head := current
+            if len(head.End) == 0 || head.End > assigned.Start {
+                head.End = assigned.Start
+            }
+            ranges = append(ranges, head)
+        }
+        // Part of the current range past the assigned one
+        if len(assigned.End) != 0 && (len(current.End) == 0 || current.End > assigned.End) {
+            tail := current
+            if tail.Start < assigned.End {
+                tail.Start = assigned.End
+            }
+            ranges = append(ranges, tail)
+        }
+    }
+    if len(assigned.Group) != 0 {
+        ranges = append(ranges, assigned)
+    }
+    sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+    // Adjacent ranges of one group are merged
+    this.Ranges = nil
+    for _, current := range ranges {
+        last := len(this.Ranges)-1
+        if last >= 0 && this.Ranges[last].Group == current.Group && this.Ranges[last].End == current.Start {
+            this.Ranges[last].End = current.End
+            continue
+        }
+        this.Ranges = append(this.Ranges, current)
+    }
+}
+
+func copyMembers(members map[uint64]string) map[uint64]string {
+    copied := make(map[uint64]string, len(members))
+    for roleId, address := range members {
+        copied[roleId] = address
+    }
+    return copied
+}
+
+// Reports whether two groups have the same members at the same addresses
+func sameMembers(first map[uint64]string, second map[uint64]string) bool {
+    if len(first) != len(second) { return false }
+    for roleId, address := range first {
+        if other, exists := second[roleId]; !exists || other != address { return false }
+    }
+    return true
+}
@@ -3,44 +3,89 @@ package main
 import (
     "os"
     "fmt"
-    "net/rpc"
+    "strconv"
     "github/paxoscluster/role"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/config"
     "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+    "github/paxoscluster/admin"
+    "github/paxoscluster/archive"
 )
 
 func main() {
     nodeAddress := ""
+    settings := config.Default()
 
-    disk, err := recovery.ConstructManager()
-    if err != nil {
-        fmt.Println(err)
-        return
-    }
+    if len(os.Args) > 2 && os.Args[1] == "-config" {
+        loaded, err := config.Load(os.Args[2])
+        if err != nil {
+            fmt.Println(err)
+            return
+        }
+        settings = loaded
 
-    if len(os.Args) > 1 {
-        roles := []uint64{1,2,3,4,5}
-        var addresses []string = nil
-        for _, roleId := range roles {
-            address, err := role.LaunchNode(roleId, disk)
-            if err != nil {
-                fmt.Println(err)
-                return
-            }
-            addresses = append(addresses, address)
+        disk, err := recovery.ConstructManager(settings.Storage)
+        if err != nil {
+            fmt.Println(err)
+            return
+        }
+
+        // Rebuilds this node from the archive of a role, then exits: -restore <roleId> <index>
+        if len(os.Args) > 5 && os.Args[3] == "-restore" {
+            err = restore(settings, disk, os.Args[4], os.Args[5])
+            if err != nil { fmt.Println(err) }
+            return
         }
 
-        nodeAddress = addresses[len(addresses)-1]
+        node, err := role.Launch(settings, disk, hooks.Construct())
+        if err != nil {
+            fmt.Println(err)
+            return
+        }
+        // Tunables are reloaded from the file on SIGHUP
+        node.ReloadOnHangup()
+
+        nodeAddress = node.Address
     } else {
-        address, err := role.LaunchNode(0, disk)
+        disk, err := recovery.ConstructManager(settings.Storage)
         if err != nil {
             fmt.Println(err)
             return
         }
 
-        nodeAddress = address
+        if len(os.Args) > 1 {
+            roles := []uint64{1,2,3,4,5}
+            var addresses []string = nil
+            for _, roleId := range roles {
+                address, err := role.LaunchNode(roleId, disk)
+                if err != nil {
+                    fmt.Println(err)
+                    return
+                }
+                addresses = append(addresses, address)
+            }
+
+            nodeAddress = addresses[len(addresses)-1]
+        } else {
+            address, err := role.LaunchNode(0, disk)
+            if err != nil {
+                fmt.Println(err)
+                return
+            }
+
+            nodeAddress = address
+        }
+    }
+
+    // Clients present the token in PXS_TOKEN when a separate client listener is configured
+    serviceMethod := "ProposerRole.Replicate"
+    if len(settings.Client.Address) != 0 {
+        nodeAddress = settings.Client.Address
+        serviceMethod = "ClientRole.Replicate"
     }
 
-    cxn, err := rpc.Dial("tcp", nodeAddress)
+    cxn, err := clusterpeers.Dial(nodeAddress, settings)
     if err != nil {
         fmt.Println(err)
         return
@@ -49,8 +94,25 @@ func main() {
     for {
         var input string
         fmt.Scanln(&input)
-        var output string
-        err = cxn.Call("ProposerRole.Replicate", &input, &output)
+        value := []byte(input)
+        var output []byte
+        if serviceMethod == "ClientRole.Replicate" {
+            req := admin.ReplicateReq{Token: os.Getenv("PXS_TOKEN"), Value: value}
+            err = cxn.Call(serviceMethod, &req, &output)
+        } else {
+            err = cxn.Call(serviceMethod, &value, &output)
+        }
         if err != nil { fmt.Println(err) }
     }
 }
+
+// Restores the configured node's log through the given index of a role's archive
+func restore(settings *config.Config, disk *recovery.Manager, sourceRoleId string, index string) error {
+    store := archive.ConstructStore(settings.Archive)
+    if store == nil { return fmt.Errorf("No archive configured") }
+    source, err := strconv.ParseUint(sourceRoleId, 10, 64)
+    if err != nil { return err }
+    upto, err := strconv.Atoi(index)
+    if err != nil { return err }
+    return archive.Restore(store, settings.Archive.Prefix, source, upto, settings.RoleId, disk)
+}
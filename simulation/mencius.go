@@ -0,0 +1,116 @@
+package simulation
+
+import (
+    "fmt"
+    "time"
+    "github/paxoscluster/acceptor"
+)
+
+// Proposes the node's next pending value in its next own slot at or after its first unchosen
+// slot, without a prepare phase
+func (this *world) beginOwnedRound(member *node) {
+    if !member.up || len(member.pending) == 0 { return }
+
+    from := member.log.GetFirstUnchosenIndex()
+    if from < member.next {
+        from = member.next
+    }
+    index := this.owners.Next(member.roleId, from)
+    member.next = index+1
+    this.proposeOwned(member, index)
+}
+
+// Broadcasts the node's pending value for its own slot, again on each timeout until it is
+// chosen there or the slot is revoked
+func (this *world) proposeOwned(member *node, index int) {
+    this.rounds++
+    member.round = round {
+        number: this.rounds,
+        index: index,
+        value: member.pending[0],
+        accepts: make(map[uint64]bool),
+        refusals: make(map[uint64]bool),
+    }
+
+    number := member.round.number
+    entries := []acceptor.OwnedEntry{{Index: index, Value: member.round.value}}
+    request := acceptor.OwnedReq{RoleId: member.roleId, Entries: entries, Round: number}
+    for _, peer := range this.nodes {
+        peer := peer
+        this.send(peer, func() {
+            var reply acceptor.OwnedResp
+            err := peer.acceptor.AcceptOwned(&request, &reply)
+            if err != nil { return }
+            if reply.AcceptedAll(len(entries)) {
+                this.observeAccepted(index, entries[0].Value, peer.roleId, this.quorum)
+            }
+            this.send(member, func() { this.receiveOwned(member, number, reply) })
+        })
+    }
+
+    timeout := this.settings.RetryTimeout + time.Duration(this.random.Int63n(int64(this.settings.RetryTimeout)))
+    this.after(timeout, func() {
+        if member.up && member.round.number == number {
+            this.proposeOwned(member, index)
+        }
+    })
+}
+
+func (this *world) receiveOwned(member *node, number uint64, reply acceptor.OwnedResp) {
+    current := &member.round
+    if current.number != number { return }
+
+    if reply.AcceptedAll(1) {
+        current.accepts[reply.RoleId] = true
+    } else {
+        current.refusals[reply.RoleId] = true
+    }
+    if len(current.accepts) >= this.quorum {
+        this.result.OwnedChosen++
+        this.choose(member, current)
+    } else if len(current.refusals) > this.settings.Nodes - this.quorum {
+        // Revoked: the value is proposed again in a later slot
+        this.beginOwnedRound(member)
+    }
+}
+
+// Periodically revokes the node's first unchosen slot from its owner while later slots are in
+// use, choosing whatever the owner may have had accepted there, or a no-op; stops when the
+// node crashes
+func (this *world) scheduleRevoke(member *node) {
+    log := member.log
+    timeout := this.settings.MaxDelay + time.Duration(this.random.Int63n(int64(this.settings.RetryTimeout)))
+    this.after(timeout, func() {
+        if !member.up || member.log != log { return }
+        first := member.log.GetFirstUnchosenIndex()
+        if member.revoking.number == 0 && this.owners.Owner(first) != member.roleId && first < member.log.GetLength()-1 {
+            this.beginRevoke(member, first)
+        }
+        this.scheduleRevoke(member)
+    })
+}
+
+func (this *world) beginRevoke(member *node, index int) {
+    id, err := member.proposals.GenerateNextProposalId()
+    if err != nil {
+        this.result.Violation = fmt.Errorf("Node %d failed to generate proposal: %v", member.roleId, err)
+        return
+    }
+    this.rounds++
+    member.revoking = round {
+        number: this.rounds,
+        index: index,
+        id: id,
+        promises: make(map[uint64]bool),
+        accepts: make(map[uint64]bool),
+    }
+    number := member.revoking.number
+    this.prepare(member, &member.revoking)
+
+    // A revoking round which stalls is abandoned, and the slot revoked again later
+    this.after(this.settings.RetryTimeout, func() {
+        if member.revoking.number == number {
+            member.revoking = round{}
+        }
+    })
+}
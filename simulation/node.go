@@ -0,0 +1,298 @@
+package simulation
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "github/paxoscluster/acceptor"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Simulated node running the real acceptor and log over durable state kept in memory
+type node struct {
+    roleId uint64
+    up bool
+    log *replicatedlog.Log
+    acceptor *acceptor.AcceptorRole
+    proposals *proposal.Manager
+    // Values this node's client is waiting to have chosen, retried across crashes
+    pending [][]byte
+    // Highest promise seen from this acceptor, which must survive crashes
+    promised proposal.Id
+    round round
+    // In Mencius mode, a full round revoking another node's slot, and the next slot this node
+    // may claim; the real proposer's local acceptor takes each claim first, so a restarted node
+    // never claims a slot again
+    revoking round
+    next int
+}
+
+// Single attempt by a node's proposer to choose a value for one slot, in a fast round, an
+// owned round, or a full round
+type round struct {
+    number uint64
+    index int
+    fast bool
+    id proposal.Id
+    value []byte
+    adopted bool
+    promises map[uint64]bool
+    replies []*acceptor.PrepareResp
+    accepts map[uint64]bool
+    refusals map[uint64]bool
+}
+
+// Starts a node from its durable state, as after a crash
+func (this *world) start(member *node) {
+    state, err := this.disk.Recover(member.roleId)
+    if err == nil {
+        member.proposals, err = proposal.ConstructManager(member.roleId, state.ProposalCounter, this.disk)
+    }
+    if err != nil {
+        this.result.Violation = fmt.Errorf("Node %d failed to recover: %v", member.roleId, err)
+        return
+    }
+    member.log = replicatedlog.ConstructLog(member.roleId, state, this.disk, nil)
+    member.log.EnableInvariants()
+    member.acceptor = acceptor.Construct(member.roleId, member.log)
+    if this.settings.Fast {
+        member.acceptor.SetFastRounds(state.Revocations, this.disk)
+    }
+    if this.owners != nil {
+        member.acceptor.SetOwnership(this.owners, state.Revocations, this.disk)
+    }
+    member.round = round{}
+    member.revoking = round{}
+    member.up = true
+    if this.owners != nil {
+        this.scheduleRevoke(member)
+    }
+}
+
+// Returns the node's round with the given number, or nil if it has ended
+func (this *node) numbered(number uint64) *round {
+    if this.round.number == number { return &this.round }
+    if this.revoking.number == number { return &this.revoking }
+    return nil
+}
+
+// Stops a node, losing all but its durable state, and restarts it later
+func (this *world) crash(member *node) {
+    member.up = false
+    this.result.Crashes++
+    this.after(this.settings.RestartDelay, func() {
+        this.start(member)
+        this.check(member)
+        this.beginRound(member)
+    })
+}
+
+// Starts a round proposing the node's next pending value at its first unchosen slot
+func (this *world) beginRound(member *node) {
+    if this.settings.Fast {
+        this.beginFastRound(member)
+    } else if this.owners != nil {
+        this.beginOwnedRound(member)
+    } else {
+        this.beginFullRound(member)
+    }
+}
+
+// Broadcasts the node's next pending value for its first unchosen slot without a prepare
+// phase; a round which neither reaches a fast quorum nor collides is recovered in a full round
+func (this *world) beginFastRound(member *node) {
+    if !member.up || len(member.pending) == 0 { return }
+
+    this.rounds++
+    member.round = round {
+        number: this.rounds,
+        index: member.log.GetFirstUnchosenIndex(),
+        fast: true,
+        value: member.pending[0],
+        accepts: make(map[uint64]bool),
+        refusals: make(map[uint64]bool),
+    }
+
+    number := member.round.number
+    request := acceptor.FastReq{Index: member.round.index, Value: member.round.value, Round: number}
+    for _, peer := range this.nodes {
+        peer := peer
+        this.send(peer, func() {
+            var reply acceptor.FastResp
+            err := peer.acceptor.AcceptFast(&request, &reply)
+            if err != nil { return }
+            if reply.Accepted {
+                this.observeAccepted(request.Index, request.Value, peer.roleId, this.fastQuorum)
+            }
+            this.send(member, func() { this.receiveFast(member, number, reply) })
+        })
+    }
+
+    timeout := this.settings.RetryTimeout + time.Duration(this.random.Int63n(int64(this.settings.RetryTimeout)))
+    this.after(timeout, func() {
+        if member.up && member.round.number == number {
+            this.result.Recovered++
+            this.beginFullRound(member)
+        }
+    })
+}
+
+func (this *world) receiveFast(member *node, number uint64, reply acceptor.FastResp) {
+    current := &member.round
+    if current.number != number { return }
+
+    if reply.Accepted {
+        current.accepts[reply.RoleId] = true
+    } else {
+        current.refusals[reply.RoleId] = true
+    }
+    if len(current.accepts) >= this.fastQuorum {
+        this.result.FastChosen++
+        this.choose(member, current)
+    } else if len(current.refusals) > this.settings.Nodes - this.fastQuorum {
+        // Collided: too many acceptors refused for a fast quorum to accept the value
+        this.result.Recovered++
+        this.beginFullRound(member)
+    }
+}
+
+// Starts a round proposing the node's next pending value at its first unchosen slot, with a
+// prepare phase
+func (this *world) beginFullRound(member *node) {
+    if !member.up || len(member.pending) == 0 { return }
+
+    id, err := member.proposals.GenerateNextProposalId()
+    if err != nil {
+        this.result.Violation = fmt.Errorf("Node %d failed to generate proposal: %v", member.roleId, err)
+        return
+    }
+    this.rounds++
+    member.round = round {
+        number: this.rounds,
+        index: member.log.GetFirstUnchosenIndex(),
+        id: id,
+        value: member.pending[0],
+        promises: make(map[uint64]bool),
+        accepts: make(map[uint64]bool),
+    }
+    number := member.round.number
+    this.prepare(member, &member.round)
+
+    // Retries after a randomized timeout so duelling proposers eventually separate
+    timeout := this.settings.RetryTimeout + time.Duration(this.random.Int63n(int64(this.settings.RetryTimeout)))
+    this.after(timeout, func() {
+        if member.up && member.round.number == number {
+            this.beginFullRound(member)
+        }
+    })
+}
+
+// Broadcasts the prepare of a full round
+func (this *world) prepare(member *node, current *round) {
+    number := current.number
+    request := acceptor.PrepareReq{ProposalId: current.id, Index: current.index}
+    for _, peer := range this.nodes {
+        peer := peer
+        this.send(peer, func() {
+            var reply acceptor.PrepareResp
+            err := peer.acceptor.Prepare(&request, &reply)
+            if err != nil { return }
+            this.send(member, func() { this.receivePromise(member, number, reply) })
+        })
+    }
+}
+
+func (this *world) receivePromise(member *node, number uint64, reply acceptor.PrepareResp) {
+    current := member.numbered(number)
+    if current == nil || !reply.PromiseAccepted || current.promises[reply.RoleId] { return }
+    if len(current.promises) >= this.quorum { return }
+
+    current.promises[reply.RoleId] = true
+    current.replies = append(current.replies, &reply)
+    if len(current.promises) < this.quorum { return }
+
+    // The proposer's rule, recovering any value a fast quorum may have chosen
+    preferred := current.value
+    current.value = proposer.RecoverValue(current.replies, preferred, this.fastQuorum, this.settings.Nodes)
+    current.adopted = !bytes.Equal(current.value, preferred)
+
+    request := acceptor.ProposalReq {
+        ProposalId: current.id,
+        Index: current.index,
+        Value: current.value,
+        FirstUnchosenIndex: member.log.GetFirstUnchosenIndex(),
+    }
+    for _, peer := range this.nodes {
+        peer := peer
+        this.send(peer, func() {
+            var reply acceptor.ProposalResp
+            err := peer.acceptor.Accept(&request, &reply)
+            if err != nil { return }
+            this.send(member, func() { this.receiveAccept(member, number, reply) })
+        })
+    }
+}
+
+func (this *world) receiveAccept(member *node, number uint64, reply acceptor.ProposalResp) {
+    current := member.numbered(number)
+    if current == nil || len(current.accepts) >= this.quorum { return }
+    if reply.AcceptedId.IsGreaterThan(current.id) { return }
+
+    current.accepts[reply.RoleId] = true
+    if len(current.accepts) < this.quorum { return }
+    this.choose(member, current)
+}
+
+// Records the value of a round as chosen: the proposer learns it directly, notifies every
+// node, and moves on to its next value
+func (this *world) choose(member *node, current *round) {
+    this.observeChosen(current.index, current.value, fmt.Sprintf("proposer %d", member.roleId))
+    member.log.SetEntryAt(current.index, current.value, proposal.Chosen())
+    info := acceptor.SuccessNotify {
+        Index: current.index,
+        Value: current.value,
+        Checksum: replicatedlog.Checksum(current.value),
+    }
+    for _, peer := range this.nodes {
+        peer := peer
+        this.send(peer, func() {
+            var reply int
+            peer.acceptor.Success(&info, &reply)
+        })
+    }
+
+    if current == &member.revoking {
+        this.result.Revoked++
+        member.revoking = round{}
+        return
+    }
+    if !current.adopted {
+        member.pending = member.pending[1:]
+    }
+    member.round = round{}
+    this.beginRound(member)
+}
+
+// Checks a node's state against the safety invariants: every slot it holds chosen agrees with
+// the value chosen cluster-wide, and its promise never regresses, even across crashes
+func (this *world) check(member *node) {
+    for index := 0; index < member.log.GetFirstUnchosenIndex() || index < len(this.chosen); index++ {
+        entry, err := member.log.GetEntryAt(index)
+        if err != nil {
+            this.result.Violation = fmt.Errorf("Node %d cannot read entry %d at %v: %v", member.roleId, index, this.now, err)
+            return
+        }
+        if entry.AcceptedProposalId == proposal.Chosen() {
+            this.observeChosen(index, entry.Value, fmt.Sprintf("node %d", member.roleId))
+        }
+    }
+
+    promised := member.log.GetMinProposalId()
+    if member.promised.IsGreaterThan(promised) {
+        this.result.Violation = fmt.Errorf("Node %d promise regressed from %v to %v at %v",
+                                           member.roleId, member.promised, promised, this.now)
+    }
+    member.promised = promised
+}
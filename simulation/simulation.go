@@ -0,0 +1,271 @@
+package simulation
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "math/rand"
+    "container/heap"
+    "github/paxoscluster/guard"
+    "github/paxoscluster/proposal"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Parameters of a randomized schedule. Each node proposes Values values of its own, so
+// proposers contend for every slot; messages between nodes are delayed, dropped, duplicated,
+// and reordered, and nodes crash and recover from their durable state
+type Config struct {
+    Seed int64
+    Nodes int
+    Values int
+    MinDelay time.Duration
+    MaxDelay time.Duration
+    DropRate float64
+    DuplicateRate float64
+    // Probability that a node crashes after handling any message
+    CrashRate float64
+    RestartDelay time.Duration
+    // Time a proposer waits on a round before starting another
+    RetryTimeout time.Duration
+    // Virtual time after which the schedule ends, whether or not every value was chosen
+    MaxTime time.Duration
+    // Proposers first try each value in a fast round, recovering the slot in a full round if
+    // it collides with another proposer's value or times out
+    Fast bool
+    // Each node proposes in its own slots without a prepare phase, and the others revoke a
+    // slot left unchosen once later slots are in use. Ignored with Fast, which it excludes
+    Mencius bool
+}
+
+// Returns settings exercising every kind of fault
+func DefaultConfig() Config {
+    return Config {
+        Seed: 1,
+        Nodes: 3,
+        Values: 3,
+        MinDelay: time.Millisecond,
+        MaxDelay: 20*time.Millisecond,
+        DropRate: 0.1,
+        DuplicateRate: 0.1,
+        CrashRate: 0.01,
+        RestartDelay: 100*time.Millisecond,
+        RetryTimeout: 100*time.Millisecond,
+        MaxTime: time.Minute,
+    }
+}
+
+// Outcome of a schedule; Violation is set if a safety invariant failed
+type Result struct {
+    Seed int64
+    Chosen [][]byte
+    Complete bool
+    Events int
+    Crashes int
+    // Values chosen in fast rounds, and fast rounds which fell back to a full round
+    FastChosen int
+    Recovered int
+    // Values chosen by their owners in Mencius mode, and owned slots chosen instead by a
+    // revoking full round
+    OwnedChosen int
+    Revoked int
+    Time time.Duration
+    Violation error
+}
+
+// Runs schedules against in-memory nodes, reusing one store of durable state between runs
+type Simulator struct {
+    storage *recovery.MemoryStorage
+    disk *recovery.Manager
+}
+
+func ConstructSimulator() (*Simulator, error) {
+    storage := recovery.ConstructMemoryStorage()
+    // The directory is checked for existence but never written
+    disk, err := recovery.ConstructManagerWithStorage(".", storage)
+    if err != nil { return nil, err }
+
+    newSimulator := Simulator{storage, disk}
+    return &newSimulator, nil
+}
+
+// Runs a single schedule; the same settings always produce the same schedule
+func (this *Simulator) Run(settings Config) *Result {
+    this.storage.Reset()
+    this.disk.Invalidate()
+    world := world {
+        settings: settings,
+        random: rand.New(rand.NewSource(settings.Seed)),
+        disk: this.disk,
+        quorum: settings.Nodes/2 + 1,
+        accepted: make(map[int]map[string]map[uint64]bool),
+        result: &Result{Seed: settings.Seed},
+    }
+    world.fastQuorum = int(clusterpeers.FastQuorumSize(uint64(settings.Nodes), uint64(world.quorum)))
+    var members []uint64 = nil
+    for roleId := 1; roleId <= settings.Nodes; roleId++ {
+        members = append(members, uint64(roleId))
+    }
+    if settings.Mencius && !settings.Fast {
+        world.owners = proposal.ConstructOwners(members)
+    }
+    for roleId := 1; roleId <= settings.Nodes; roleId++ {
+        var values [][]byte = nil
+        for count := 0; count < settings.Values; count++ {
+            values = append(values, []byte(fmt.Sprintf("%d.%d", roleId, count)))
+        }
+        newNode := node{roleId: uint64(roleId), pending: values}
+        world.nodes = append(world.nodes, &newNode)
+    }
+
+    for _, member := range world.nodes {
+        world.start(member)
+    }
+    for _, member := range world.nodes {
+        world.beginRound(member)
+    }
+    world.loop()
+    return world.result
+}
+
+// Action scheduled at a point in virtual time
+type event struct {
+    at time.Duration
+    sequence int
+    fire func()
+}
+
+// Events ordered by time, then by the order in which they were scheduled
+type eventQueue []*event
+
+func (this eventQueue) Len() int { return len(this) }
+func (this eventQueue) Less(i, j int) bool {
+    if this[i].at != this[j].at { return this[i].at < this[j].at }
+    return this[i].sequence < this[j].sequence
+}
+func (this eventQueue) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this *eventQueue) Push(item interface{}) { *this = append(*this, item.(*event)) }
+func (this *eventQueue) Pop() interface{} {
+    old := *this
+    item := old[len(old)-1]
+    *this = old[:len(old)-1]
+    return item
+}
+
+// State of a single schedule, driven from one goroutine in virtual time
+type world struct {
+    settings Config
+    random *rand.Rand
+    disk *recovery.Manager
+    nodes []*node
+    quorum int
+    fastQuorum int
+    // Slot ownership in Mencius mode, or nil
+    owners *proposal.Owners
+    // Acceptors of each value in each slot in rounds without a prepare phase, so values such
+    // rounds chose are observed even if no proposer learns them
+    accepted map[int]map[string]map[uint64]bool
+    now time.Duration
+    queue eventQueue
+    scheduled int
+    rounds uint64
+    chosen [][]byte
+    result *Result
+}
+
+// Runs the action after the given virtual delay
+func (this *world) after(delay time.Duration, fire func()) {
+    this.scheduled++
+    heap.Push(&this.queue, &event{this.now + delay, this.scheduled, fire})
+}
+
+// Fires events in order until every value is chosen, time runs out, or an invariant fails
+func (this *world) loop() {
+    for this.queue.Len() != 0 && this.result.Violation == nil {
+        next := heap.Pop(&this.queue).(*event)
+        if next.at > this.settings.MaxTime { break }
+        this.now = next.at
+        this.result.Events++
+        next.fire()
+
+        complete := true
+        for _, member := range this.nodes {
+            complete = complete && len(member.pending) == 0
+        }
+        if complete {
+            this.result.Complete = true
+            break
+        }
+    }
+    this.result.Time = this.now
+    this.result.Chosen = this.chosen
+}
+
+// Delivers a message to a node after a random delay, unless it is dropped; it may also be
+// duplicated. Messages arriving while the node is down are lost
+func (this *world) send(to *node, deliver func()) {
+    copies := 1
+    if this.random.Float64() < this.settings.DropRate {
+        copies = 0
+    } else if this.random.Float64() < this.settings.DuplicateRate {
+        copies = 2
+    }
+
+    for ; copies > 0; copies-- {
+        spread := int64(this.settings.MaxDelay - this.settings.MinDelay)
+        delay := this.settings.MinDelay
+        if spread > 0 {
+            delay += time.Duration(this.random.Int63n(spread))
+        }
+        this.after(delay, func() {
+            if !to.up { return }
+            this.deliver(deliver)
+            this.check(to)
+            if this.random.Float64() < this.settings.CrashRate {
+                this.crash(to)
+            }
+        })
+    }
+}
+
+// Handles a message, reporting invariants broken within the log as violations of the schedule
+func (this *world) deliver(handle func()) {
+    defer func() {
+        cause := recover()
+        if cause == nil { return }
+        fatal, isFatal := cause.(guard.Fatal)
+        if !isFatal { panic(cause) }
+        this.result.Violation = fatal
+    }()
+    handle()
+}
+
+// Records a value learned chosen for a slot, failing if another value was chosen there
+func (this *world) observeChosen(index int, value []byte, source string) {
+    for len(this.chosen) <= index {
+        this.chosen = append(this.chosen, nil)
+    }
+    if this.chosen[index] == nil {
+        this.chosen[index] = append([]byte{}, value...)
+    } else if !bytes.Equal(this.chosen[index], value) {
+        this.result.Violation = fmt.Errorf("Slot %d chose %q, but %s learned %q at %v",
+                                           index, this.chosen[index], source, value, this.now)
+    }
+}
+
+// Records a value accepted in a round without a prepare phase, observing it chosen once
+// quorumSize acceptors accepted it
+func (this *world) observeAccepted(index int, value []byte, roleId uint64, quorumSize int) {
+    if this.accepted[index] == nil {
+        this.accepted[index] = make(map[string]map[uint64]bool)
+    }
+    acceptors := this.accepted[index][string(value)]
+    if acceptors == nil {
+        acceptors = make(map[uint64]bool)
+        this.accepted[index][string(value)] = acceptors
+    }
+    acceptors[roleId] = true
+    if len(acceptors) >= quorumSize {
+        this.observeChosen(index, value, fmt.Sprintf("a quorum of %d acceptors", quorumSize))
+    }
+}
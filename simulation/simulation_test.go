@@ -0,0 +1,114 @@
+package simulation
+
+import (
+    "time"
+    "testing"
+)
+
+// Nodes are killed after handling any message, often in the middle of a round, and restart
+// from nothing but their durable state; no schedule may choose two values for a slot or let a
+// promise regress, and each must still choose every value
+func TestCrashMidRound(t *testing.T) {
+    simulator, err := ConstructSimulator()
+    if err != nil { t.Fatal(err) }
+
+    crashes := 0
+    for seed := int64(1); seed <= 40; seed++ {
+        settings := DefaultConfig()
+        settings.Seed = seed
+        settings.CrashRate = 0.05
+        settings.RestartDelay = 50*time.Millisecond
+        result := simulator.Run(settings)
+        if result.Violation != nil {
+            t.Fatalf("Seed %d: %v", seed, result.Violation)
+        }
+        if !result.Complete {
+            t.Errorf("Seed %d: only %d values chosen by %v", seed, len(result.Chosen), result.Time)
+        }
+        crashes += result.Crashes
+    }
+    if crashes == 0 {
+        t.Errorf("No node crashed")
+    }
+}
+
+// Every node proposes in a fast round at once, so values collide in most slots and are
+// recovered in full rounds, while proposers crash between broadcasting a fast round and
+// hearing the replies; a full round must never lose a value a fast quorum chose
+func TestFastRounds(t *testing.T) {
+    simulator, err := ConstructSimulator()
+    if err != nil { t.Fatal(err) }
+
+    for _, nodes := range []int{3, 5} {
+        crashes, fastChosen, recovered := 0, 0, 0
+        for seed := int64(1); seed <= 40; seed++ {
+            settings := DefaultConfig()
+            settings.Seed = seed
+            settings.Nodes = nodes
+            settings.Fast = true
+            settings.CrashRate = 0.05
+            settings.RestartDelay = 50*time.Millisecond
+            result := simulator.Run(settings)
+            if result.Violation != nil {
+                t.Fatalf("%d nodes, seed %d: %v", nodes, seed, result.Violation)
+            }
+            if !result.Complete {
+                t.Errorf("%d nodes, seed %d: only %d values chosen by %v", nodes, seed, len(result.Chosen), result.Time)
+            }
+            crashes += result.Crashes
+            fastChosen += result.FastChosen
+            recovered += result.Recovered
+        }
+        if crashes == 0 || fastChosen == 0 || recovered == 0 {
+            t.Errorf("%d nodes: %d crashes, %d values chosen in fast rounds, %d fast rounds recovered",
+                     nodes, crashes, fastChosen, recovered)
+        }
+    }
+}
+
+// Each node proposes in its own slots while the others revoke any slot left behind, so owners
+// race revoking prepares for the same slot, and crash with their own slots half accepted; a
+// revoking round must never lose a value its owner had chosen
+func TestMencius(t *testing.T) {
+    simulator, err := ConstructSimulator()
+    if err != nil { t.Fatal(err) }
+
+    for _, nodes := range []int{3, 5} {
+        crashes, ownedChosen, revoked := 0, 0, 0
+        for seed := int64(1); seed <= 100; seed++ {
+            settings := DefaultConfig()
+            settings.Seed = seed
+            settings.Nodes = nodes
+            settings.Mencius = true
+            settings.CrashRate = 0.02
+            result := simulator.Run(settings)
+            if result.Violation != nil {
+                t.Fatalf("%d nodes, seed %d: %v", nodes, seed, result.Violation)
+            }
+            if !result.Complete {
+                t.Errorf("%d nodes, seed %d: only %d values chosen by %v", nodes, seed, len(result.Chosen), result.Time)
+            }
+            crashes += result.Crashes
+            ownedChosen += result.OwnedChosen
+            revoked += result.Revoked
+        }
+        if crashes == 0 || ownedChosen == 0 || revoked == 0 {
+            t.Errorf("%d nodes: %d crashes, %d values chosen by their owners, %d slots revoked",
+                     nodes, crashes, ownedChosen, revoked)
+        }
+    }
+}
+
+func TestScheduleReplays(t *testing.T) {
+    simulator, err := ConstructSimulator()
+    if err != nil { t.Fatal(err) }
+
+    settings := DefaultConfig()
+    settings.Seed = 7
+    first := simulator.Run(settings)
+    second := simulator.Run(settings)
+    if first.Events != second.Events || first.Crashes != second.Crashes || len(first.Chosen) != len(second.Chosen) {
+        t.Errorf("Seed %d ran %d events with %d crashes, then %d events with %d crashes",
+                 settings.Seed, first.Events, first.Crashes, second.Events, second.Crashes)
+    }
+}
@@ -0,0 +1,160 @@
+package spec
+
+import (
+    "fmt"
+    "bytes"
+    "github/paxoscluster/trace"
+)
+
+// Transition breaking an invariant of the Paxos specification
+type Violation struct {
+    Invariant string
+    Transition trace.Transition
+    Message string
+}
+
+func (this Violation) String() string {
+    return fmt.Sprintf("%s violated by %s of role %d at step %d: %s", this.Invariant, this.Transition.Action,
+                       this.Transition.RoleId, this.Transition.Step, this.Message)
+}
+
+// Abstract state of one acceptor, as in the specification: the highest ballot promised, and
+// the ballot and value last accepted in each slot, with the slots it learned chosen
+type acceptorState struct {
+    maxBal int64
+    maxVBal map[int]int64
+    maxVal map[int][]byte
+    chosen map[int][]byte
+}
+
+func constructAcceptorState() *acceptorState {
+    newAcceptorState := acceptorState {
+        maxBal: 0,
+        maxVBal: make(map[int]int64),
+        maxVal: make(map[int][]byte),
+        chosen: make(map[int][]byte),
+    }
+    return &newAcceptorState
+}
+
+// Replays the transitions exported by each role, in their own order, checking the invariants
+// of multi-Paxos:
+//   PromiseMonotonic: a promise never falls, across restarts too
+//   AcceptAbovePromise: nothing is accepted below the promise, except under owned and fast
+//     ballots, which skip the prepare phase
+//   AcceptedMonotonic: a slot's accepted ballot never falls
+//   ChosenStable: a slot chosen on a role keeps its value there
+//   Agreement: no two roles learn different values chosen in a slot
+//   Validity: a chosen value was accepted by some role; checked only if complete, as the
+//     transitions must then cover every role since the cluster first launched
+func Check(exports [][]trace.Transition, complete bool) []Violation {
+    var violations []Violation = nil
+    chosen := make(map[int]trace.Transition)
+    accepted := make(map[int][][]byte)
+    var learned []trace.Transition = nil
+
+    for _, transitions := range exports {
+        states := make(map[uint64]*acceptorState)
+        for _, transition := range transitions {
+            state, exists := states[transition.RoleId]
+            if !exists {
+                state = constructAcceptorState()
+                states[transition.RoleId] = state
+            }
+            violation := step(state, transition)
+            if violation != nil {
+                violations = append(violations, *violation)
+            }
+
+            switch {
+            case transition.Action == trace.ChooseAction || (transition.Action == trace.RecoverAction && transition.Ballot == -1):
+                learned = append(learned, transition)
+                first, known := chosen[transition.Slot]
+                if !known {
+                    chosen[transition.Slot] = transition
+                } else if !bytes.Equal(first.Value, transition.Value) {
+                    violations = append(violations, Violation{"Agreement", transition, fmt.Sprintf("slot %d chose %q, but role %d learned %q",
+                                                              transition.Slot, transition.Value, first.RoleId, first.Value)})
+                }
+            case transition.Action != trace.PromiseAction && transition.Slot >= 0:
+                accepted[transition.Slot] = append(accepted[transition.Slot], transition.Value)
+            }
+        }
+    }
+
+    if !complete {
+        return violations
+    }
+    for _, transition := range learned {
+        found := false
+        for _, value := range accepted[transition.Slot] {
+            if bytes.Equal(value, transition.Value) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            violations = append(violations, Violation{"Validity", transition, fmt.Sprintf("slot %d chose %q, which no role accepted",
+                                                      transition.Slot, transition.Value)})
+        }
+    }
+    return violations
+}
+
+// Applies a transition to the state of its role, returning the invariant it breaks, if any
+func step(state *acceptorState, transition trace.Transition) *Violation {
+    slot := transition.Slot
+    violated := func(invariant string, format string, args ...interface{}) *Violation {
+        return &Violation{invariant, transition, fmt.Sprintf(format, args...)}
+    }
+
+    switch transition.Action {
+    case trace.RecoverAction:
+        if slot < 0 {
+            if transition.Ballot < state.maxBal {
+                return violated("PromiseMonotonic", "recovered promise %d below %d promised before", transition.Ballot, state.maxBal)
+            }
+            state.maxBal = transition.Ballot
+            return nil
+        }
+        if transition.Ballot == -1 {
+            return choose(state, transition)
+        }
+        previous, exists := state.maxVBal[slot]
+        state.maxVBal[slot], state.maxVal[slot] = transition.Ballot, transition.Value
+        if exists && transition.Ballot < previous {
+            return violated("AcceptedMonotonic", "slot %d recovered ballot %d below %d accepted before", slot, transition.Ballot, previous)
+        }
+    case trace.PromiseAction:
+        if transition.Ballot <= state.maxBal {
+            return violated("PromiseMonotonic", "promise %d does not exceed %d", transition.Ballot, state.maxBal)
+        }
+        state.maxBal = transition.Ballot
+    case trace.AcceptAction, trace.AcceptOwnedAction:
+        if _, isChosen := state.chosen[slot]; isChosen {
+            return violated("ChosenStable", "slot %d accepted ballot %d after it was chosen", slot, transition.Ballot)
+        }
+        previous, exists := state.maxVBal[slot]
+        state.maxVBal[slot], state.maxVal[slot] = transition.Ballot, transition.Value
+        if transition.Action == trace.AcceptAction && transition.Ballot < state.maxBal {
+            return violated("AcceptAbovePromise", "slot %d accepted ballot %d below promise %d", slot, transition.Ballot, state.maxBal)
+        }
+        if exists && transition.Ballot < previous {
+            return violated("AcceptedMonotonic", "slot %d accepted ballot %d below %d accepted before", slot, transition.Ballot, previous)
+        }
+    case trace.ChooseAction:
+        return choose(state, transition)
+    default:
+        return violated("Action", "unknown action")
+    }
+    return nil
+}
+
+func choose(state *acceptorState, transition trace.Transition) *Violation {
+    previous, isChosen := state.chosen[transition.Slot]
+    if isChosen && !bytes.Equal(previous, transition.Value) {
+        return &Violation{"ChosenStable", transition, fmt.Sprintf("slot %d chosen as %q, then %q", transition.Slot, previous, transition.Value)}
+    }
+    state.chosen[transition.Slot] = transition.Value
+    return nil
+}
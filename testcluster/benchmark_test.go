@@ -0,0 +1,73 @@
+package testcluster
+
+import (
+    "sort"
+    "time"
+    "context"
+    "testing"
+)
+
+// Cluster shared by every benchmark, since each is run several times to size b.N and nodes
+// cannot be torn down between runs
+var benchmarked *Cluster = nil
+
+func benchmarkCluster(b *testing.B) *Cluster {
+    if benchmarked == nil {
+        cluster, err := Launch(3, nil)
+        if err != nil { b.Fatal(err) }
+        ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+        defer cancel()
+        err = cluster.WaitForConvergence(ctx)
+        if err != nil { b.Fatal(err) }
+        benchmarked = cluster
+    }
+    return benchmarked
+}
+
+// Proposes values one at a time through the leader, reporting percentiles of the time each
+// takes to commit
+func BenchmarkCommitLatency(b *testing.B) {
+    cluster := benchmarkCluster(b)
+    ctx := context.Background()
+    value := make([]byte, 64)
+    latencies := make([]time.Duration, 0, b.N)
+    b.SetBytes(int64(len(value)))
+    b.ResetTimer()
+    for count := 0; count < b.N; count++ {
+        proposed := time.Now()
+        err := cluster.Propose(ctx, value)
+        if err != nil { b.Fatal(err) }
+        latencies = append(latencies, time.Since(proposed))
+    }
+    b.StopTimer()
+
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+    percentile := func(fraction float64) float64 {
+        return float64(latencies[int(fraction*float64(len(latencies)-1))].Nanoseconds())
+    }
+    b.ReportMetric(percentile(0.5), "p50-ns")
+    b.ReportMetric(percentile(0.99), "p99-ns")
+}
+
+// Proposes values from many concurrent clients, whose proposals the leader batches, reporting
+// values committed per second
+func BenchmarkCommitThroughput(b *testing.B) {
+    cluster := benchmarkCluster(b)
+    ctx := context.Background()
+    b.SetBytes(64)
+    b.SetParallelism(8)
+    b.ResetTimer()
+    started := time.Now()
+    b.RunParallel(func(clients *testing.PB) {
+        value := make([]byte, 64)
+        for clients.Next() {
+            err := cluster.Propose(ctx, value)
+            if err != nil {
+                b.Error(err)
+                return
+            }
+        }
+    })
+    b.StopTimer()
+    b.ReportMetric(float64(b.N)/time.Since(started).Seconds(), "commits/s")
+}
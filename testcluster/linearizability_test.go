@@ -0,0 +1,162 @@
+package testcluster
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "context"
+    "testing"
+    "math/rand"
+    "encoding/json"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/replicatedlog"
+    "github/paxoscluster/linearizability"
+)
+
+// Operation proposed to the log; Id identifies it across retries
+type command struct {
+    Id string
+    Op string
+    Key string
+    Value string
+}
+
+// Key-value state machine fed by a node's committed entries, recording the result of each
+// command it applies
+type store struct {
+    values map[string]string
+    results map[string]string
+    exclude sync.Mutex
+}
+
+func subscribeStore(log *replicatedlog.Log) (*store, func()) {
+    newStore := store {
+        values: make(map[string]string),
+        results: make(map[string]string),
+    }
+    entries, cancel := log.Subscribe(0)
+    go func() {
+        for entry := range entries {
+            value, _ := replicatedlog.SplitMetadata(entry.Value)
+            newStore.apply(value)
+        }
+    }()
+    return &newStore, cancel
+}
+
+// Applies a committed command once, even if it was chosen more than once
+func (this *store) apply(value []byte) {
+    var request command
+    if json.Unmarshal(value, &request) != nil { return }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    if _, seen := this.results[request.Id]; seen { return }
+    if request.Op == "put" {
+        this.values[request.Key] = request.Value
+    }
+    this.results[request.Id] = this.values[request.Key]
+}
+
+func (this *store) result(id string) (string, bool) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    result, applied := this.results[id]
+    return result, applied
+}
+
+// Waits for a command to be applied by any node; every node applies the same sequence of
+// commands, so any node's result is the command's result
+func await(stores []*store, id string, timeout time.Duration) (string, bool) {
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        for _, kv := range stores {
+            if result, applied := kv.result(id); applied {
+                return result, true
+            }
+        }
+        time.Sleep(5*time.Millisecond)
+    }
+    return "", false
+}
+
+// Issues random gets and puts; an operation whose outcome is unknown is left pending
+func runClient(clientId int, count int, cluster *Cluster, stores []*store, history *linearizability.History) {
+    random := rand.New(rand.NewSource(int64(clientId)))
+    for number := 0; number < count; number++ {
+        input := linearizability.KVInput{Op: "get", Key: fmt.Sprintf("key%d", random.Intn(2))}
+        if random.Intn(2) == 0 {
+            input.Op = "put"
+            input.Value = fmt.Sprintf("%d.%d", clientId, number)
+        }
+        request := command{fmt.Sprintf("%d.%d", clientId, number), input.Op, input.Key, input.Value}
+        data, _ := json.Marshal(&request)
+
+        id := history.Invoke(clientId, input)
+        for attempt := 0; attempt < 20; attempt++ {
+            ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+            err := cluster.Propose(ctx, data)
+            cancel()
+            // Requests refused by a follower were never proposed, so are safely retried
+            if proposer.IsNotLeader(err) {
+                time.Sleep(50*time.Millisecond)
+                continue
+            }
+            if err != nil { break }
+
+            if value, applied := await(stores, request.Id, 5*time.Second); applied {
+                history.Complete(id, linearizability.KVOutput{Value: value})
+            }
+            break
+        }
+    }
+}
+
+// Concurrent clients read and write through the leader while it is killed and restarted; the
+// history they observe must be linearizable
+func TestLinearizableHistory(t *testing.T) {
+    cluster := launch(t, 3)
+    defer cluster.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    err := cluster.WaitForConvergence(ctx)
+    if err != nil { t.Fatal(err) }
+
+    var stores []*store = nil
+    for _, roleId := range cluster.Live() {
+        kv, stop := subscribeStore(cluster.Node(roleId).Log)
+        defer stop()
+        stores = append(stores, kv)
+    }
+
+    history := linearizability.ConstructHistory()
+    var clients sync.WaitGroup
+    for clientId := 0; clientId < 4; clientId++ {
+        clients.Add(1)
+        go func(clientId int) {
+            defer clients.Done()
+            runClient(clientId, 15, cluster, stores, history)
+        }(clientId)
+    }
+
+    time.Sleep(200*time.Millisecond)
+    leaderId, err := cluster.WaitForLeader(ctx)
+    if err != nil { t.Fatal(err) }
+    err = cluster.Kill(leaderId)
+    if err != nil { t.Fatal(err) }
+    time.Sleep(time.Second)
+    err = cluster.Restart(leaderId)
+    if err != nil { t.Fatal(err) }
+    clients.Wait()
+
+    operations := history.Operations()
+    completed := 0
+    for _, operation := range operations {
+        if !operation.Pending { completed++ }
+    }
+    if completed == 0 {
+        t.Fatalf("None of %d operations completed", len(operations))
+    }
+    err = linearizability.Check(linearizability.KVModel{}, operations)
+    if err != nil { t.Fatal(err) }
+}
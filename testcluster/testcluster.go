@@ -0,0 +1,280 @@
+package testcluster
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "sort"
+    "sync"
+    "time"
+    "context"
+    "github/paxoscluster/hooks"
+    "github/paxoscluster/config"
+    "github/paxoscluster/proposer"
+    "github/paxoscluster/recovery"
+    "github/paxoscluster/role"
+    "github/paxoscluster/clusterpeers"
+)
+
+// Cluster of nodes running in this process on ephemeral loopback ports, for tests which need
+// real nodes. Failures are injected with clusterpeers.Faults, which every cluster in a process
+// shares, so only one should run at a time. Nodes cannot be torn down within a process: a
+// killed node is cut off from every peer, as a crashed one would be, and a restarted one is
+// reconnected with the state it held, as after an outage rather than a crash
+type Cluster struct {
+    directory string
+    nodes map[uint64]*role.Node
+    // Nodes killed, and pairs of nodes partitioned from each other
+    down map[uint64]bool
+    cut map[[2]uint64]bool
+    exclude sync.Mutex
+}
+
+// Launches count nodes with roleIds 1 to count, storing their state in a temporary directory;
+// configure, if not nil, adjusts each node's settings before it is launched
+func Launch(count int, configure func(*config.Config)) (*Cluster, error) {
+    directory, err := os.MkdirTemp("", "testcluster")
+    if err != nil { return nil, err }
+
+    peers := make(map[uint64]string)
+    for roleId := uint64(1); roleId <= uint64(count); roleId++ {
+        address, err := reservePort()
+        if err != nil { return nil, err }
+        peers[roleId] = address
+    }
+
+    newCluster := Cluster {
+        directory: directory,
+        nodes: make(map[uint64]*role.Node),
+        down: make(map[uint64]bool),
+        cut: make(map[[2]uint64]bool),
+    }
+    clusterpeers.Faults.Reset()
+    for roleId := uint64(1); roleId <= uint64(count); roleId++ {
+        settings := config.Default()
+        settings.RoleId = roleId
+        for peerId, address := range peers {
+            settings.Peers[peerId] = address
+        }
+        settings.Storage.Directory = directory
+        if configure != nil {
+            configure(settings)
+        }
+        disk, err := recovery.ConstructManager(settings.Storage)
+        if err == nil {
+            newCluster.nodes[roleId], err = role.Launch(settings, disk, nil)
+        }
+        if err != nil {
+            newCluster.Close()
+            return nil, fmt.Errorf("Failed to launch role %d: %v", roleId, err)
+        }
+    }
+    return &newCluster, nil
+}
+
+// Returns a loopback address on a port free when it was checked
+func reservePort() (string, error) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { return "", err }
+    defer listener.Close()
+    return listener.Addr().String(), nil
+}
+
+// Returns the directory holding every node's state
+func (this *Cluster) GetDirectory() string {
+    return this.directory
+}
+
+// Returns the node with the given roleId, or nil if there is none
+func (this *Cluster) Node(roleId uint64) *role.Node {
+    return this.nodes[roleId]
+}
+
+// Returns the roleIds of the nodes not killed, or restarted since, in order
+func (this *Cluster) Live() []uint64 {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    live := make([]uint64, 0, len(this.nodes))
+    for roleId := range this.nodes {
+        if !this.down[roleId] {
+            live = append(live, roleId)
+        }
+    }
+    sort.Slice(live, func(i, j int) bool { return live[i] < live[j] })
+    return live
+}
+
+// Cuts a node off from every peer
+func (this *Cluster) Kill(roleId uint64) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if this.nodes[roleId] == nil {
+        return fmt.Errorf("Role %d is not in the cluster", roleId)
+    }
+    this.down[roleId] = true
+    this.apply()
+    return nil
+}
+
+// Reconnects a killed node to the peers it is not partitioned from
+func (this *Cluster) Restart(roleId uint64) error {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    if !this.down[roleId] {
+        return fmt.Errorf("Role %d is not killed", roleId)
+    }
+    delete(this.down, roleId)
+    this.apply()
+    return nil
+}
+
+// Separates the given nodes from the rest of the cluster; nodes within the group still reach
+// each other
+func (this *Cluster) Partition(group ...uint64) {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    inside := make(map[uint64]bool)
+    for _, roleId := range group {
+        inside[roleId] = true
+    }
+    for a := range this.nodes {
+        for b := range this.nodes {
+            if a < b && inside[a] != inside[b] {
+                this.cut[[2]uint64{a, b}] = true
+            }
+        }
+    }
+    this.apply()
+}
+
+// Removes every partition; killed nodes stay cut off until restarted
+func (this *Cluster) Heal() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    this.cut = make(map[[2]uint64]bool)
+    this.apply()
+}
+
+// Brings the injected faults in line with the killed nodes and partitions. exclude MUST be locked
+func (this *Cluster) apply() {
+    for a := range this.nodes {
+        for b := range this.nodes {
+            if a >= b { continue }
+            if this.down[a] || this.down[b] || this.cut[[2]uint64{a, b}] {
+                clusterpeers.Faults.Partition(a, b)
+            } else {
+                clusterpeers.Faults.Heal(a, b)
+            }
+        }
+    }
+}
+
+// Reports whether two live nodes reach each other. exclude MUST be locked
+func (this *Cluster) connected(a uint64, b uint64) bool {
+    if a > b {
+        a, b = b, a
+    }
+    return a == b || !this.cut[[2]uint64{a, b}]
+}
+
+// Waits until every live node reachable from a leader follows it, returning the leader; fails
+// once the context is done
+func (this *Cluster) WaitForLeader(ctx context.Context) (uint64, error) {
+    for {
+        leaderId := this.agreedLeader()
+        if leaderId != 0 {
+            return leaderId, nil
+        }
+        select {
+        case <- ctx.Done():
+            return 0, fmt.Errorf("No leader agreed on: %v", ctx.Err())
+        case <- time.After(10*time.Millisecond):
+        }
+    }
+}
+
+// Returns the leader followed by every live node in its partition, itself included, if that
+// partition holds a quorum; zero if there is none
+func (this *Cluster) agreedLeader() uint64 {
+    live := this.Live()
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for _, leaderId := range live {
+        if !this.nodes[leaderId].Proposer.IsLeader() { continue }
+        followers := uint64(0)
+        agreed := true
+        for _, roleId := range live {
+            if !this.connected(leaderId, roleId) { continue }
+            followed, _ := this.nodes[roleId].Proposer.GetLeader()
+            if followed != leaderId {
+                agreed = false
+                break
+            }
+            followers++
+        }
+        if agreed && followers >= this.nodes[leaderId].Cluster.GetQuorumSize() {
+            return leaderId
+        }
+    }
+    return 0
+}
+
+// Waits until the cluster converges: a leader is agreed, and every live node reachable from it
+// is ready by ProposerRole.CheckReadiness, having applied every entry the leader reported chosen
+// to it. Followers learn the leader's last chosen value only with its next round, so tests which
+// compare logs should compare them through the lowest commit index. Fails once the context is done
+func (this *Cluster) WaitForConvergence(ctx context.Context) error {
+    for {
+        leaderId, err := this.WaitForLeader(ctx)
+        if err != nil { return err }
+        err = this.checkConverged(leaderId)
+        if err == nil {
+            return nil
+        }
+        select {
+        case <- ctx.Done():
+            return fmt.Errorf("Cluster did not converge on leader %d: %v", leaderId, err)
+        case <- time.After(10*time.Millisecond):
+        }
+    }
+}
+
+func (this *Cluster) checkConverged(leaderId uint64) error {
+    live := this.Live()
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for _, roleId := range live {
+        if !this.connected(leaderId, roleId) { continue }
+        err := this.nodes[roleId].Proposer.CheckReadiness()
+        if err != nil {
+            return fmt.Errorf("Role %d is not ready: %v", roleId, err)
+        }
+    }
+    return nil
+}
+
+// Replicates a value through the agreed leader
+func (this *Cluster) Propose(ctx context.Context, value []byte) error {
+    leaderId, err := this.WaitForLeader(ctx)
+    if err != nil { return err }
+    return this.nodes[leaderId].Proposer.ReplicateWithContext(ctx, value, proposer.Interactive, hooks.Metadata{})
+}
+
+// Cuts off every node, so nodes left running in the process stay silent until the next cluster
+// is launched. The nodes' state is left in the directory for inspection
+func (this *Cluster) Close() {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    for roleId := range this.nodes {
+        this.down[roleId] = true
+    }
+    this.apply()
+}
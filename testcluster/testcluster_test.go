@@ -0,0 +1,153 @@
+package testcluster
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "context"
+    "testing"
+    "github/paxoscluster/config"
+    "github/paxoscluster/replicatedlog"
+)
+
+// Shortens timeouts so failures are noticed within a test
+func fastTimeouts(settings *config.Config) {
+    settings.Timeouts = config.Timeouts{Heartbeat: 50*time.Millisecond, Election: 300*time.Millisecond, Rpc: 250*time.Millisecond}
+}
+
+func launch(t *testing.T, count int) *Cluster {
+    cluster, err := Launch(count, fastTimeouts)
+    if err != nil { t.Fatal(err) }
+    return cluster
+}
+
+func propose(t *testing.T, ctx context.Context, cluster *Cluster, prefix string, count int) {
+    for number := 0; number < count; number++ {
+        err := cluster.Propose(ctx, []byte(fmt.Sprintf("%s-%d", prefix, number)))
+        if err != nil { t.Fatalf("Failed to propose %s-%d: %v", prefix, number, err) }
+    }
+}
+
+// Waits until every live node has chosen at least count entries, then checks that the nodes
+// agree on every entry chosen by all of them, returning the values of those entries
+func checkLogs(t *testing.T, ctx context.Context, cluster *Cluster, count int) [][]byte {
+    live := cluster.Live()
+    for {
+        lowest := -1
+        for _, roleId := range live {
+            if chosen := cluster.Node(roleId).Log.GetCommitIndex()+1; lowest == -1 || chosen < lowest {
+                lowest = chosen
+            }
+        }
+        if lowest >= count {
+            break
+        }
+        select {
+        case <- ctx.Done():
+            t.Fatalf("Nodes chose only %d of %d entries", lowest, count)
+        case <- time.After(10*time.Millisecond):
+        }
+    }
+
+    var values [][]byte = nil
+    for _, roleId := range live {
+        entries, err := cluster.Node(roleId).Log.ReadEntries(0, count, true)
+        if err != nil { t.Fatalf("Role %d: %v", roleId, err) }
+        for index, entry := range entries {
+            value, _ := replicatedlog.SplitMetadata(entry.Value)
+            if values == nil || len(values) <= index {
+                values = append(values, value)
+            } else if !bytes.Equal(values[index], value) {
+                t.Errorf("Role %d chose %q for entry %d, where another chose %q", roleId, value, index, values[index])
+            }
+        }
+    }
+    return values
+}
+
+// Checks every value with the prefix was chosen
+func checkChosen(t *testing.T, values [][]byte, prefix string, count int) {
+    for number := 0; number < count; number++ {
+        expected := []byte(fmt.Sprintf("%s-%d", prefix, number))
+        found := false
+        for _, value := range values {
+            found = found || bytes.Equal(value, expected)
+        }
+        if !found {
+            t.Errorf("Value %s was not chosen", expected)
+        }
+    }
+}
+
+func TestLeaderFailure(t *testing.T) {
+    cluster := launch(t, 3)
+    defer cluster.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+
+    err := cluster.WaitForConvergence(ctx)
+    if err != nil { t.Fatal(err) }
+    propose(t, ctx, cluster, "before", 3)
+
+    leaderId, err := cluster.WaitForLeader(ctx)
+    if err != nil { t.Fatal(err) }
+    err = cluster.Kill(leaderId)
+    if err != nil { t.Fatal(err) }
+    successor, err := cluster.WaitForLeader(ctx)
+    if err != nil { t.Fatal(err) }
+    if successor == leaderId {
+        t.Fatalf("Killed leader %d still leads", leaderId)
+    }
+    propose(t, ctx, cluster, "during", 3)
+
+    err = cluster.Restart(leaderId)
+    if err != nil { t.Fatal(err) }
+    err = cluster.WaitForConvergence(ctx)
+    if err != nil { t.Fatal(err) }
+    propose(t, ctx, cluster, "after", 2)
+
+    // The last value may not yet be known chosen by followers
+    values := checkLogs(t, ctx, cluster, 7)
+    checkChosen(t, values, "before", 3)
+    checkChosen(t, values, "during", 3)
+    checkChosen(t, values, "after", 1)
+}
+
+func TestMinorityPartition(t *testing.T) {
+    cluster := launch(t, 5)
+    defer cluster.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+
+    leaderId, err := cluster.WaitForLeader(ctx)
+    if err != nil { t.Fatal(err) }
+    propose(t, ctx, cluster, "before", 2)
+
+    // The leader is isolated with one follower; the other three elect a leader and carry on
+    var minority []uint64 = []uint64{leaderId}
+    for _, roleId := range cluster.Live() {
+        if roleId != leaderId && len(minority) < 2 {
+            minority = append(minority, roleId)
+        }
+    }
+    cluster.Partition(minority...)
+    deadline, stop := context.WithTimeout(ctx, 10*time.Second)
+    defer stop()
+    for {
+        successor, err := cluster.WaitForLeader(deadline)
+        if err != nil { t.Fatal(err) }
+        if successor != minority[0] && successor != minority[1] { break }
+        time.Sleep(10*time.Millisecond)
+    }
+    propose(t, ctx, cluster, "partitioned", 3)
+
+    cluster.Heal()
+    err = cluster.WaitForConvergence(ctx)
+    if err != nil { t.Fatal(err) }
+    propose(t, ctx, cluster, "healed", 2)
+
+    values := checkLogs(t, ctx, cluster, 6)
+    checkChosen(t, values, "before", 2)
+    checkChosen(t, values, "partitioned", 3)
+    checkChosen(t, values, "healed", 1)
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "sync"
+    "time"
+    "bufio"
+    "encoding/json"
+)
+
+// Method name of the record holding the state a node recovered before its first message
+const RecoverMethod = "Recover"
+
+// Method name of records of a proposer learning a value chosen by its own round
+const LearnMethod = "ProposerRole.Learn"
+
+// Consensus message handled by a role, with the reply it produced
+type Record struct {
+    Time time.Time `json:"time"`
+    RoleId uint64 `json:"roleId"`
+    Method string `json:"method"`
+    Request json.RawMessage `json:"request"`
+    Reply json.RawMessage `json:"reply,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+// Appends every consensus message handled by a node to a trace file. Messages are handled one
+// at a time while traced, so the trace holds them in the order they changed the node's state
+type Recorder struct {
+    file *os.File
+    writer *bufio.Writer
+    encoder *json.Encoder
+    order sync.Mutex
+    exclude sync.Mutex
+}
+
+// Creates a recorder appending to the named file
+func ConstructRecorder(fileName string) (*Recorder, error) {
+    file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil { return nil, err }
+
+    writer := bufio.NewWriter(file)
+    newRecorder := Recorder {
+        file: file,
+        writer: writer,
+        encoder: json.NewEncoder(writer),
+    }
+    return &newRecorder, nil
+}
+
+// Runs a message handler, recording its request and reply; a nil recorder only runs it
+func (this *Recorder) Trace(roleId uint64, method string, request interface{}, reply interface{}, handle func() error) error {
+    if this == nil {
+        return handle()
+    }
+
+    this.order.Lock()
+    defer this.order.Unlock()
+    err := handle()
+    this.Record(roleId, method, request, reply, err)
+    return err
+}
+
+// Appends a record to the trace, flushing it so the trace survives a crash
+func (this *Recorder) Record(roleId uint64, method string, request interface{}, reply interface{}, err error) {
+    if this == nil { return }
+
+    record := Record {
+        Time: time.Now(),
+        RoleId: roleId,
+        Method: method,
+    }
+    record.Request, _ = json.Marshal(request)
+    if reply != nil {
+        record.Reply, _ = json.Marshal(reply)
+    }
+    if err != nil {
+        record.Error = err.Error()
+    }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    encodeErr := this.encoder.Encode(&record)
+    if encodeErr == nil {
+        encodeErr = this.writer.Flush()
+    }
+    if encodeErr != nil {
+        fmt.Println("[ TRACE", roleId, "] Failed to record", method, ":", encodeErr)
+    }
+}
+
+// Reads every record of a trace file in order
+func ReadRecords(reader io.Reader) ([]Record, error) {
+    var records []Record = nil
+    decoder := json.NewDecoder(reader)
+    for {
+        var record Record
+        err := decoder.Decode(&record)
+        if err == io.EOF { return records, nil }
+        if err != nil { return records, err }
+        records = append(records, record)
+    }
+}
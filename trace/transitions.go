@@ -0,0 +1,99 @@
+package trace
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "sync"
+    "bufio"
+    "encoding/json"
+)
+
+// Actions of a transition, named after those of a multi-Paxos specification
+const (
+    // State held when the node started: the promise, with Slot -1, then each slot accepted or chosen
+    RecoverAction = "Recover"
+    // The acceptor raised its promise to Ballot
+    PromiseAction = "Promise"
+    // The acceptor accepted Value in Slot under Ballot
+    AcceptAction = "Accept"
+    // The acceptor accepted Value in Slot under an owned or fast Ballot, without a prepare
+    AcceptOwnedAction = "AcceptOwned"
+    // The node learned that Value was chosen in Slot
+    ChooseAction = "Choose"
+)
+
+// Change to a node's Paxos state, abstracted from its log: a promise raises the acceptor's
+// maxBal, an accept sets the maxVBal and maxVal of a slot, and a choice fixes the slot's
+// value. Ballots are proposal sequences, ordered as proposals are; a ballot of -1 marks a
+// recovered slot already chosen. Step orders the transitions of one node
+type Transition struct {
+    RoleId uint64 `json:"roleId"`
+    Step uint64 `json:"step"`
+    Action string `json:"action"`
+    Slot int `json:"slot"`
+    Ballot int64 `json:"ballot"`
+    Value []byte `json:"value,omitempty"`
+}
+
+// Appends the state transitions of a node to a file, for checking offline against the
+// invariants of a Paxos specification with pxsspec
+type TransitionRecorder struct {
+    file *os.File
+    writer *bufio.Writer
+    encoder *json.Encoder
+    step uint64
+    exclude sync.Mutex
+}
+
+// Creates a recorder appending to the named file
+func ConstructTransitionRecorder(fileName string) (*TransitionRecorder, error) {
+    file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil { return nil, err }
+
+    writer := bufio.NewWriter(file)
+    newTransitionRecorder := TransitionRecorder {
+        file: file,
+        writer: writer,
+        encoder: json.NewEncoder(writer),
+    }
+    return &newTransitionRecorder, nil
+}
+
+// Appends a transition, numbering it and flushing it so the export survives a crash; a nil
+// recorder drops it
+func (this *TransitionRecorder) Record(roleId uint64, action string, slot int, ballot int64, value []byte) {
+    if this == nil { return }
+
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+    transition := Transition {
+        RoleId: roleId,
+        Step: this.step,
+        Action: action,
+        Slot: slot,
+        Ballot: ballot,
+        Value: value,
+    }
+    this.step++
+    err := this.encoder.Encode(&transition)
+    if err == nil {
+        err = this.writer.Flush()
+    }
+    if err != nil {
+        fmt.Println("[ TRACE", roleId, "] Failed to record", action, "of slot", slot, ":", err)
+    }
+}
+
+// Reads every transition of an export in order
+func ReadTransitions(reader io.Reader) ([]Transition, error) {
+    var transitions []Transition = nil
+    decoder := json.NewDecoder(reader)
+    for {
+        var transition Transition
+        err := decoder.Decode(&transition)
+        if err == io.EOF { return transitions, nil }
+        if err != nil { return transitions, err }
+        transitions = append(transitions, transition)
+    }
+}
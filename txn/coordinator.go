@@ -0,0 +1,178 @@
+package txn
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "crypto/rand"
+    "encoding/hex"
+    "github/paxoscluster/pxsclient"
+)
+
+// Writes of a transaction to one group: the keys they touch, which no other transaction may
+// hold prepared, and the payload applied by the group if the transaction commits
+type Write struct {
+    Keys []string
+    Payload []byte
+}
+
+// Runs two-phase commit across groups, each a separate cluster serving a Participant. Both
+// phases are records in the participants' logs, so no step depends on the coordinator
+// surviving: the decision is final once applied in the home group, the first participant by
+// name, and any coordinator can finish an interrupted transaction with Resolve
+type Coordinator struct {
+    groups map[string]*pxsclient.Client
+    Timeout time.Duration
+    PollInterval time.Duration
+}
+
+// Constructor for Coordinator over the clients of the named groups
+func ConstructCoordinator(groups map[string]*pxsclient.Client) *Coordinator {
+    newCoordinator := Coordinator {
+        groups: groups,
+        Timeout: 10*time.Second,
+        PollInterval: 20*time.Millisecond,
+    }
+    return &newCoordinator
+}
+
+// Commits writes to several groups atomically; reports whether the transaction committed. It
+// aborts if any group refuses it or fails to vote within the timeout. An error after the
+// decision leaves some groups holding the transaction prepared until Resolve is run
+func (this *Coordinator) Commit(writes map[string]Write) (bool, error) {
+    if len(writes) == 0 {
+        return true, nil
+    }
+    groups := make([]string, 0, len(writes))
+    for group, write := range writes {
+        if this.groups[group] == nil {
+            return false, fmt.Errorf("Unknown group %s", group)
+        }
+        if len(write.Payload) == 0 {
+            return false, fmt.Errorf("Empty payload for group %s", group)
+        }
+        groups = append(groups, group)
+    }
+    sort.Strings(groups)
+
+    id, err := newTransactionId()
+    if err != nil { return false, err }
+    deadline := time.Now().Add(this.Timeout)
+
+    // Prepare phase; a group which cannot be reached is left to vote, and is outvoted below
+    this.parallel(groups, func(group string) error {
+        header := record{Kind: prepareKind, Id: id, Home: groups[0], Groups: groups, Keys: writes[group].Keys}
+        return this.propose(group, encodeRecord(header, writes[group].Payload), deadline)
+    })
+    commit := true
+    for _, group := range groups {
+        outcome, err := this.await(group, id, Outcome.Voted, deadline)
+        if err != nil || outcome != Prepared {
+            commit = false
+            break
+        }
+    }
+    return this.decide(id, groups, commit)
+}
+
+// Finishes a transaction found in doubt in a group, aborting it unless its home group already
+// decided to commit it; reports whether it committed
+func (this *Coordinator) Resolve(group string, id string) (bool, error) {
+    status, err := this.outcome(group, id)
+    if err != nil { return false, err }
+    if status.Outcome.Decided() || status.Outcome == Refused {
+        return status.Outcome == Committed, nil
+    }
+    if status.Outcome == Unknown {
+        return false, fmt.Errorf("Transaction %s is not known to group %s", id, group)
+    }
+    return this.decide(id, status.Groups, false)
+}
+
+// Decides a transaction in its home group, then carries the home group's decision to the
+// rest; a competing decision applied first in the home group prevails
+func (this *Coordinator) decide(id string, groups []string, commit bool) (bool, error) {
+    home := groups[0]
+    deadline := time.Now().Add(this.Timeout)
+    err := this.propose(home, encodeRecord(record{Kind: decideKind, Id: id, Commit: commit}, nil), deadline)
+    if err != nil { return false, err }
+    outcome, err := this.await(home, id, Outcome.Decided, deadline)
+    if err != nil { return false, err }
+    committed := outcome == Committed
+    fmt.Println("[ TXN ] Transaction", id, "decided", outcome, "in home group", home)
+
+    err = this.parallel(groups[1:], func(group string) error {
+        return this.propose(group, encodeRecord(record{Kind: decideKind, Id: id, Commit: committed}, nil), deadline)
+    })
+    return committed, err
+}
+
+// Runs an operation on each group concurrently, returning the first failure
+func (this *Coordinator) parallel(groups []string, operation func(string) error) error {
+    failures := make(chan error, len(groups))
+    var running sync.WaitGroup
+    for _, group := range groups {
+        running.Add(1)
+        go func(group string) {
+            defer running.Done()
+            failures <- operation(group)
+        }(group)
+    }
+    running.Wait()
+    close(failures)
+
+    for err := range failures {
+        if err != nil { return err }
+    }
+    return nil
+}
+
+// Replicates a record in a group. Records are idempotent, so the proposal is retried after
+// any failure until the deadline
+func (this *Coordinator) propose(group string, value []byte, deadline time.Time) error {
+    for {
+        err := this.groups[group].Propose(value)
+        if err == nil || time.Now().After(deadline) {
+            return err
+        }
+        time.Sleep(this.PollInterval)
+    }
+}
+
+// Polls a group until the outcome of a transaction satisfies done, or the deadline passes
+func (this *Coordinator) await(group string, id string, done func(Outcome) bool, deadline time.Time) (Outcome, error) {
+    for {
+        status, err := this.outcome(group, id)
+        if err == nil && done(status.Outcome) {
+            return status.Outcome, nil
+        }
+        if time.Now().After(deadline) {
+            if err == nil {
+                err = fmt.Errorf("Timed out waiting for transaction %s in group %s", id, group)
+            }
+            return Unknown, err
+        }
+        time.Sleep(this.PollInterval)
+    }
+}
+
+// Queries the outcome of a transaction in a group
+func (this *Coordinator) outcome(group string, id string) (OutcomeResp, error) {
+    client := this.groups[group]
+    if client == nil {
+        return OutcomeResp{}, fmt.Errorf("Unknown group %s", group)
+    }
+    req := OutcomeReq{Token: client.GetToken(), Id: id}
+    var reply OutcomeResp
+    err := client.Invoke("Participant.Outcome", &req, &reply)
+    return reply, err
+}
+
+// Returns a random transaction ID
+func newTransactionId() (string, error) {
+    id := make([]byte, 16)
+    _, err := rand.Read(id)
+    if err != nil { return "", err }
+    return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,201 @@
+package txn
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+)
+
+// State of a transaction in one participant group
+type Outcome int
+
+const (
+    // No record of the transaction has been applied
+    Unknown Outcome = iota
+    // Prepared, holding locks on its keys; the group votes to commit
+    Prepared
+    // Refused on prepare, as another prepared transaction held one of its keys
+    Refused
+    Committed
+    Aborted
+)
+
+func (this Outcome) String() string {
+    switch this {
+    case Prepared:
+        return "prepared"
+    case Refused:
+        return "refused"
+    case Committed:
+        return "committed"
+    case Aborted:
+        return "aborted"
+    }
+    return "unknown"
+}
+
+// Reports whether the group has voted on the transaction
+func (this Outcome) Voted() bool {
+    return this != Unknown
+}
+
+// Reports whether the transaction's outcome in the group is final
+func (this Outcome) Decided() bool {
+    return this == Committed || this == Aborted
+}
+
+// Transaction prepared in a group and awaiting its outcome
+type pending struct {
+    header record
+    payload []byte
+}
+
+// Participant side of transactions in one group. Its vote on each transaction is a
+// deterministic function of the log, so every replica of the group reaches the same vote
+// without further coordination: a prepare is refused if another prepared transaction holds
+// one of its keys, and otherwise locks them until a decision is applied. The first decision
+// applied for a transaction is final
+type Participant struct {
+    apply func(index int, value []byte)
+    locks map[string]string
+    prepared map[string]pending
+    outcomes map[string]Outcome
+    exclude sync.Mutex
+}
+
+// Constructor for Participant; apply receives application values, and the payloads of
+// committed transactions at the index of their commit decision, in log order
+func ConstructParticipant(apply func(index int, value []byte)) *Participant {
+    newParticipant := Participant {
+        apply: apply,
+        locks: make(map[string]string),
+        prepared: make(map[string]pending),
+        outcomes: make(map[string]Outcome),
+    }
+    return &newParticipant
+}
+
+// Applies a committed value; register with Hooks.OnApply
+func (this *Participant) Apply(index int, value []byte) {
+    header, payload, isRecord := decodeRecord(value)
+    if !isRecord {
+        this.apply(index, value)
+        return
+    }
+
+    this.exclude.Lock()
+    var committed []byte = nil
+    switch header.Kind {
+    case prepareKind:
+        this.prepare(header, payload)
+    case decideKind:
+        committed = this.decide(header)
+    }
+    this.exclude.Unlock()
+
+    if committed != nil {
+        this.apply(index, committed)
+    }
+}
+
+// Votes on a transaction; repeated prepares are ignored. exclude MUST be locked
+func (this *Participant) prepare(header record, payload []byte) {
+    if this.outcomes[header.Id].Voted() { return }
+    for _, key := range header.Keys {
+        if _, locked := this.locks[key]; locked {
+            fmt.Println("[ TXN ] Refused transaction", header.Id, "conflicting on key", key)
+            this.outcomes[header.Id] = Refused
+            return
+        }
+    }
+    for _, key := range header.Keys {
+        this.locks[key] = header.Id
+    }
+    this.prepared[header.Id] = pending{header, payload}
+    this.outcomes[header.Id] = Prepared
+}
+
+// Applies the first decision for a transaction, releasing its locks; returns the payload if it
+// commits. A decision ahead of the prepare aborts it, so a late prepare is refused. exclude
+// MUST be locked
+func (this *Participant) decide(header record) []byte {
+    if this.outcomes[header.Id].Decided() { return nil }
+    transaction, isPrepared := this.prepared[header.Id]
+    if isPrepared {
+        for _, key := range transaction.header.Keys {
+            delete(this.locks, key)
+        }
+        delete(this.prepared, header.Id)
+    }
+    if header.Commit && isPrepared {
+        this.outcomes[header.Id] = Committed
+        // Payloads are never empty, so nil reports that none is applied
+        return append([]byte{}, transaction.payload...)
+    }
+    this.outcomes[header.Id] = Aborted
+    return nil
+}
+
+// Returns the outcome of a transaction in this group as of the entries applied locally
+func (this *Participant) GetOutcome(id string) Outcome {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    return this.outcomes[id]
+}
+
+// Returns the transactions prepared but not yet decided, in order of ID; each holds locks until
+// its coordinator, or Coordinator.Resolve, decides it
+func (this *Participant) GetInDoubt() []string {
+    this.exclude.Lock()
+    defer this.exclude.Unlock()
+
+    ids := make([]string, 0, len(this.prepared))
+    for id := range this.prepared {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    return ids
+}
+
+// Request for the outcome of a transaction in a group
+type OutcomeReq struct {
+    Token string
+    Id string
+}
+
+// Outcome of a transaction in a group; Home and Groups are known only while it is prepared
+type OutcomeResp struct {
+    Outcome Outcome
+    Home string
+    Groups []string
+}
+
+// Serves the outcomes of a participant to coordinators
+type Service struct {
+    participant *Participant
+    admit func(token string) error
+}
+
+// Constructor for Service; admit checks each request, refusing unauthorized tokens, or any
+// request while the node may not have applied the latest records. It may be nil
+func ConstructService(participant *Participant, admit func(token string) error) *Service {
+    newService := Service{participant, admit}
+    return &newService
+}
+
+func (this *Service) Outcome(req *OutcomeReq, reply *OutcomeResp) error {
+    if this.admit != nil {
+        err := this.admit(req.Token)
+        if err != nil { return err }
+    }
+
+    this.participant.exclude.Lock()
+    defer this.participant.exclude.Unlock()
+    reply.Outcome = this.participant.outcomes[req.Id]
+    if transaction, isPrepared := this.participant.prepared[req.Id]; isPrepared {
+        reply.Home = transaction.header.Home
+        reply.Groups = transaction.header.Groups
+    }
+    return nil
+}
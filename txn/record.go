@@ -0,0 +1,51 @@
+package txn
+
+import (
+    "bytes"
+    "encoding/json"
+)
+
+// Marks a log entry holding a transaction record rather than an application value
+var recordMarker = []byte("\x00txn ")
+
+// Step of a transaction recorded in a participant group's log: a prepare carries the keys the
+// transaction writes in the group and the payload applied if it commits; a decision carries
+// the outcome. Home is the group whose log holds the commit point, and Groups every participant
+type record struct {
+    Kind string
+    Id string
+    Home string
+    Groups []string
+    Keys []string
+    Commit bool
+}
+
+const (
+    prepareKind = "prepare"
+    decideKind = "decide"
+)
+
+// Serializes a record, followed by the payload it carries
+func encodeRecord(header record, payload []byte) []byte {
+    encoded, _ := json.Marshal(header)
+    value := append(append([]byte{}, recordMarker...), encoded...)
+    value = append(value, '\n')
+    return append(value, payload...)
+}
+
+// Parses a record and its payload; reports false for application values
+func decodeRecord(value []byte) (record, []byte, bool) {
+    var header record
+    if !bytes.HasPrefix(value, recordMarker) {
+        return header, nil, false
+    }
+    separator := bytes.IndexByte(value, '\n')
+    if separator < 0 {
+        return header, nil, false
+    }
+    err := json.Unmarshal(value[len(recordMarker):separator], &header)
+    if err != nil {
+        return header, nil, false
+    }
+    return header, value[separator+1:], true
+}